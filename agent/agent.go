@@ -10,18 +10,28 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/subutai-io/agent/agent/alert"
+	"github.com/subutai-io/agent/agent/api"
 	"github.com/subutai-io/agent/agent/connect"
 	"github.com/subutai-io/agent/agent/container"
 	"github.com/subutai-io/agent/agent/discovery"
 	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/agent/migration"
 	"github.com/subutai-io/agent/agent/monitor"
+	"github.com/subutai-io/agent/agent/outbox"
+	"github.com/subutai-io/agent/agent/policy"
+	"github.com/subutai-io/agent/agent/snapshot"
+	"github.com/subutai-io/agent/agent/stream"
+	"github.com/subutai-io/agent/agent/throttle"
 	"github.com/subutai-io/agent/agent/utils"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/audit"
 	"github.com/subutai-io/agent/lib/gpg"
 	"github.com/subutai-io/agent/lib/net"
 	"github.com/subutai-io/agent/log"
@@ -43,12 +53,14 @@ type heartbeat struct {
 	Instance   string                `json:"instance"`
 	Containers []container.Container `json:"containers,omitempty"`
 	Alert      []alert.Load          `json:"alert,omitempty"`
+	Resolved   []alert.Load          `json:"resolved,omitempty"`
 }
 
 var (
 	lastHeartbeat        []byte
 	mutex                sync.Mutex
-	fingerprint          string
+	// fingerprint of Resource Host key is set by connection monitor and read by other goroutines
+	fingerprint          atomic.Value
 	hostname, _          = os.Hostname()
 	client               *http.Client
 	instanceType         string
@@ -58,6 +70,12 @@ var (
 	canRestoreContainers = true
 )
 
+// hostFingerprint returns fingerprint of Resource Host key, empty until it is known
+func hostFingerprint() string {
+	id, _ := fingerprint.Load().(string)
+	return id
+}
+
 func initAgent() {
 	// move .gnupg dir to app home
 	err := os.Setenv("GNUPGHOME", path.Join(config.Agent.DataPrefix, ".gnupg"))
@@ -95,6 +113,27 @@ func setupHttpServer() {
 	mux["/ping"] = pingHandler
 	mux["/heartbeat"] = heartbeatHandler
 	go srv.ListenAndServe()
+
+	// container data and keys are received over TLS only, while Management server talks to plain HTTP port above,
+	// so migration endpoint has its own listener
+	migrate := http.NewServeMux()
+	migrate.HandleFunc("/migrate/begin", migration.BeginHandler)
+	migrate.HandleFunc("/migrate/upload", migration.UploadHandler)
+	migrate.HandleFunc("/migrate/restore", migration.RestoreHandler)
+	migrate.HandleFunc("/migrate/status", migration.StatusHandler)
+	migrate.HandleFunc("/migrate/abort", migration.AbortHandler)
+	go func() {
+		// certificate may be not generated yet, waiting for it must not delay start of the agent
+		tlsSrv := &http.Server{
+			Addr:              ":" + migration.Port,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			Handler:           migrate,
+			TLSConfig:         utils.ServerTLSConfig(),
+		}
+		log.Check(log.WarnLevel, "Serving migration endpoint", tlsSrv.ListenAndServeTLS("", ""))
+	}()
 }
 
 //<<<HTTP server
@@ -105,11 +144,16 @@ func Start() {
 
 	setupHttpServer()
 
+	go api.Serve()
 	go discovery.Monitor()
 	go monitor.Collect()
 	go connectionMonitor()
 	go alert.Processing()
 	go restoreContainers()
+	go snapshot.Schedule()
+	go throttle.Run()
+	go outbox.Deliver(deliver)
+	go stream.Run(client.Transport.(*http.Transport).TLSClientConfig, hostFingerprint, handleRequests)
 
 	for {
 		if sendHeartbeat() {
@@ -155,8 +199,8 @@ func connectionMonitor() {
 			continue
 		}
 
-		if fingerprint == "" || config.Management.GpgUser == "" {
-			fingerprint = gpg.GetFingerprint("rh@subutai.io")
+		if hostFingerprint() == "" || config.Management.GpgUser == "" {
+			fingerprint.Store(gpg.GetFingerprint("rh@subutai.io"))
 			connect.Request(config.Agent.GpgUser, config.Management.Secret)
 		} else {
 			doCheckConnection()
@@ -167,7 +211,7 @@ func connectionMonitor() {
 }
 
 func doCheckConnection() {
-	resp, err := client.Get("https://" + path.Join(config.Management.Host) + ":8444/rest/v1/agent/check/" + fingerprint)
+	resp, err := client.Get("https://" + path.Join(config.Management.Host) + ":8444/rest/v1/agent/check/" + hostFingerprint())
 	if err == nil {
 		defer utils.Close(resp)
 	}
@@ -198,11 +242,12 @@ func sendHeartbeat() bool {
 		Type:       "HEARTBEAT",
 		Hostname:   hostname,
 		Address:    net.GetIp(),
-		ID:         fingerprint,
+		ID:         hostFingerprint(),
 		Arch:       instanceArch,
 		Instance:   instanceType,
 		Containers: alert.Quota(pool),
 		Alert:      alert.Current(pool),
+		Resolved:   alert.Cleared(pool),
 	}}
 	jbeat, err := json.Marshal(&res)
 	log.Check(log.WarnLevel, "Marshaling heartbeat JSON", err)
@@ -213,17 +258,17 @@ func sendHeartbeat() bool {
 	lastHeartbeat = jbeat
 
 	if encryptedMessage, err := gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, jbeat); err == nil {
-		message, err := json.Marshal(map[string]string{"hostId": fingerprint, "response": string(encryptedMessage)})
+		message, err := json.Marshal(map[string]string{"hostId": hostFingerprint(), "response": string(encryptedMessage)})
 		log.Check(log.WarnLevel, "Marshal response json", err)
 
-		resp, err := client.PostForm("https://"+path.Join(config.Management.Host)+":8444/rest/v1/agent/heartbeat", url.Values{"heartbeat": {string(message)}})
-		if !log.Check(log.WarnLevel, "Sending heartbeat: "+string(jbeat), err) {
-			defer utils.Close(resp)
-
-			if resp.StatusCode == http.StatusAccepted {
-				return true
-			}
+		start := time.Now()
+		err = deliver("HEARTBEAT", message)
+		monitor.Record("agent_heartbeat_latency", int(time.Since(start)/time.Millisecond))
+		if err == nil {
+			return true
 		}
+		log.Warn("Failed to send heartbeat, saving it to outbox")
+		log.Check(log.WarnLevel, "Saving heartbeat to outbox", outbox.Push("HEARTBEAT", "heartbeat", true, message))
 	}
 	go discovery.ImportManagementKey()
 	lastHeartbeat = []byte{}
@@ -233,8 +278,9 @@ func sendHeartbeat() bool {
 func execute(rsp executer.EncRequest) {
 	var req executer.Request
 	var md, contName, pub, keyring string
+	host := rsp.HostID == hostFingerprint()
 
-	if rsp.HostID == fingerprint {
+	if host {
 		md = gpg.DecryptWrapper(rsp.Request)
 	} else {
 		contName = nameByID(rsp.HostID)
@@ -257,22 +303,38 @@ func execute(rsp executer.EncRequest) {
 		return
 	}
 
+	action := "execute"
 	//create channels for stdout and stderr
 	sOut := make(chan executer.ResponseOptions)
-	if rsp.HostID == fingerprint {
+	if executer.IsControl(req.Request) {
+		action = strings.ToLower(req.Request.Type)
+		if ok, reason := policy.CheckControl(req.Request, contName); !ok {
+			log.Warn("Policy violation: " + req.Request.Type + " request " + req.Request.CommandID + " on " + rsp.HostID + ": " + reason)
+			action = "deny"
+			go executer.Deny(req.Request, reason, sOut)
+		} else {
+			go executer.Control(contName, req.Request, sOut)
+		}
+	} else if ok, reason := policy.Check(req.Request, contName); !ok {
+		log.Warn("Policy violation: command "+req.Request.CommandID+" \""+req.Request.Command+" "+strings.Join(req.Request.Args, " ")+"\" as "+req.Request.RunAs+" on "+rsp.HostID+": "+reason)
+		action = "deny"
+		go executer.Deny(req.Request, reason, sOut)
+	} else if host {
 		go executer.ExecHost(req.Request, sOut)
 	} else {
 		go executer.AttachContainer(contName, req.Request, sOut)
 	}
 
+	var last executer.ResponseOptions
 	for sOut != nil {
 		if elem, ok := <-sOut; ok {
+			last = elem
 			resp := executer.Response{ResponseOpts: elem}
 			jsonR, err := json.Marshal(resp)
 			log.Check(log.WarnLevel, "Marshal response", err)
 
 			var payload []byte
-			if rsp.HostID == fingerprint {
+			if host {
 				payload, err = gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, jsonR)
 			} else {
 				payload, err = gpg.EncryptWrapper(contName, config.Management.GpgUser, jsonR, pub, keyring)
@@ -280,35 +342,62 @@ func execute(rsp executer.EncRequest) {
 			if err == nil && len(payload) > 0 {
 				message, err := json.Marshal(map[string]string{"hostId": elem.ID, "response": string(payload)})
 				log.Check(log.WarnLevel, "Marshal response json "+elem.CommandID, err)
-				go sendResponse(message, time.Now().Add(time.Second*time.Duration(req.Request.Timeout)))
+				// response which cannot be queued is sent at once, so it is not lost while database is unavailable
+				err = outbox.Push("RESPONSE", elem.CommandID+":"+strconv.Itoa(elem.ResponseNumber), false, message)
+				if log.Check(log.WarnLevel, "Saving response "+elem.CommandID+" to outbox", err) && deliver("RESPONSE", message) != nil {
+					log.Warn("Failed to send response " + elem.CommandID + ", it is lost")
+				}
 			}
 		} else {
 			sOut = nil
 		}
 	}
+
+	command := strings.TrimSpace(req.Request.Command + " " + strings.Join(req.Request.Args, " "))
+	if req.Request.Target != "" {
+		command = req.Request.Target
+	}
+	user := "management"
+	if req.Request.RunAs != "" {
+		user += " as " + req.Request.RunAs
+	}
+	log.Check(log.WarnLevel, "Writing audit record", audit.Write(audit.Entry{
+		Source:    "agent",
+		User:      user,
+		Action:    action,
+		Container: contName,
+		CommandID: req.Request.CommandID,
+		Command:   command,
+		Result:    last.Type,
+		ExitCode:  last.ExitCode,
+	}))
 	go sendHeartbeat()
 }
 
-func sendResponse(msg []byte, deadline time.Time) {
-	resp, err := client.PostForm("https://"+path.Join(config.Management.Host)+":8444/rest/v1/agent/response", url.Values{"response": {string(msg)}})
-	if !log.Check(log.WarnLevel, "Sending response "+string(msg), err) {
-		defer utils.Close(resp)
-		if resp.StatusCode == http.StatusAccepted {
-			return
-		}
+// deliver sends response or heartbeat to Management server over stream if it is connected or by REST request otherwise
+func deliver(kind string, msg []byte) error {
+	if stream.Send(kind, msg) == nil {
+		return nil
 	}
 
-	if deadline.After(time.Now()) {
-		time.Sleep(time.Second * 5)
-		go sendResponse(msg, deadline)
+	endpoint, field := "response", "response"
+	if kind == "HEARTBEAT" {
+		endpoint, field = "heartbeat", "heartbeat"
 	}
+	resp, err := client.PostForm("https://"+path.Join(config.Management.Host)+":8444/rest/v1/agent/"+endpoint, url.Values{field: {string(msg)}})
+	if log.Check(log.DebugLevel, "Sending "+endpoint+" "+string(msg), err) {
+		return err
+	}
+	defer utils.Close(resp)
 
+	if resp.StatusCode != http.StatusAccepted {
+		return outbox.Rejected{Status: resp.StatusCode}
+	}
+	return nil
 }
 
 func command() {
-	var rsp []executer.EncRequest
-
-	resp, err := client.Get("https://" + path.Join(config.Management.Host) + ":8444/rest/v1/agent/requests/" + fingerprint)
+	resp, err := client.Get("https://" + path.Join(config.Management.Host) + ":8444/rest/v1/agent/requests/" + hostFingerprint())
 
 	if err == nil {
 		defer utils.Close(resp)
@@ -323,11 +412,19 @@ func command() {
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if !log.Check(log.WarnLevel, "Reading body", err) {
-		log.Check(log.WarnLevel, "Unmarshal payload", json.Unmarshal(data, &rsp))
+		handleRequests(data)
+	}
+}
 
-		for _, request := range rsp {
-			go execute(request)
-		}
+// handleRequests executes list of encrypted requests received from Management server
+func handleRequests(data []byte) {
+	var rsp []executer.EncRequest
+
+	if log.Check(log.WarnLevel, "Unmarshal payload", json.Unmarshal(data, &rsp)) {
+		return
+	}
+	for _, request := range rsp {
+		go execute(request)
 	}
 }
 
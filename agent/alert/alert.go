@@ -2,9 +2,8 @@
 package alert
 
 import (
-	"bufio"
 	"io/ioutil"
-	"os"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/subutai-io/agent/agent/container"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/cgroup"
 	cont "github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/fs"
 )
@@ -19,53 +19,82 @@ import (
 type values struct {
 	Current int `json:"current,omitempty"`
 	Quota   int `json:"quota,omitempty"`
+	// Severity is a level of active alert, warning or critical
+	Severity string `json:"severity,omitempty"`
+	// State is either firing or resolved, Since is the time of this state in Unix seconds
+	State string `json:"state,omitempty"`
+	Since int64  `json:"since,omitempty"`
+	// ready is set if usage is measured, rates are not known until the sampler has enough history
+	ready bool
 }
 
 //Load describes container usage stats. If alert active for this container the Management server receives this data.
 type Load struct {
-	Container string  `json:"id,omitempty"`
-	CPU       *values `json:"cpu,omitempty"`
-	RAM       *values `json:"ram,omitempty"`
-	Disk      *values `json:"hdd,omitempty"`
+	Container  string             `json:"id,omitempty"`
+	CPU        *values            `json:"cpu,omitempty"`
+	RAM        *values            `json:"ram,omitempty"`
+	Disk       *values            `json:"hdd,omitempty"`
+	IO         *values            `json:"io,omitempty"`
+	Network    *values            `json:"network,omitempty"`
+	Partitions map[string]*values `json:"partitions,omitempty"`
 }
 
-var (
-	cpu   = make(map[string][]int)
-	stats = make(map[string]Load)
-)
-
-func read(path string) (int, error) {
-	out, err := ioutil.ReadFile(path)
-	if err != nil {
-		return -1, err
+// resources returns usage values by alert resource name, which is a suffix of "subutai.alert.*" threshold item
+func (l Load) resources() map[string]*values {
+	list := make(map[string]*values)
+	for resource, v := range map[string]*values{"cpu": l.CPU, "ram": l.RAM, "disk": l.Disk, "io": l.IO, "network": l.Network} {
+		if v != nil {
+			list[resource] = v
+		}
 	}
-	return strconv.Atoi(strings.TrimSpace(string(out)))
-}
-
-func ramMax() (int, error) {
-	file, err := os.Open("/sys/fs/cgroup/memory/memory.stat")
-	if err != nil {
-		return 0, err
+	for partition, v := range l.Partitions {
+		list["disk."+partition] = v
 	}
-	defer file.Close()
+	return list
+}
 
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		line := strings.Fields(scanner.Text())
-		if len(line) > 1 && line[0] == "hierarchical_memory_limit" {
-			return strconv.Atoi(strings.TrimSpace(line[1]))
+func (l *Load) set(resource string, v *values) {
+	switch resource {
+	case "cpu":
+		l.CPU = v
+	case "ram":
+		l.RAM = v
+	case "disk":
+		l.Disk = v
+	case "io":
+		l.IO = v
+	case "network":
+		l.Network = v
+	default:
+		if strings.HasPrefix(resource, "disk.") {
+			if l.Partitions == nil {
+				l.Partitions = make(map[string]*values)
+			}
+			l.Partitions[strings.TrimPrefix(resource, "disk.")] = v
 		}
 	}
-	return 0, nil
 }
 
-func ramQuota(cont string) []int {
-	u, err := read("/sys/fs/cgroup/memory/lxc/" + cont + "/memory.usage_in_bytes")
-	if err != nil {
-		return nil
-	}
+type ioSample struct {
+	cgroup.IOStat
+	time time.Time
+}
 
-	l, err := read("/sys/fs/cgroup/memory/lxc/" + cont + "/memory.limit_in_bytes")
+type netSample struct {
+	rx, tx int
+	time   time.Time
+}
+
+var (
+	cpu = make(map[string][]int)
+	io  = make(map[string]ioSample)
+	nic = make(map[string]netSample)
+	// stats is the latest usage of containers, it is replaced by Processing and read by heartbeat under states mutex
+	stats = make(map[string]Load)
+)
+
+func ramQuota(cont string) []int {
+	u, l, err := cgroup.Memory(cont)
 	if err != nil {
 		return nil
 	}
@@ -74,62 +103,127 @@ func ramQuota(cont string) []int {
 	if l != 0 {
 		ramUsage[0] = u * 100 / l
 	}
-
-	if mlimit, err := ramMax(); err == nil && l == mlimit {
-		ramUsage[1] = 0
-	}
 	return ramUsage
 }
 
 func quotaCPU(name string) int {
-	cfsPeriod := 100000
-	cfsQuotaUs, err := ioutil.ReadFile("/sys/fs/cgroup/cpu,cpuacct/lxc/" + name + "/cpu.cfs_quota_us")
-	if err != nil {
+	quota, period, err := cgroup.CPUQuota(name)
+	if err != nil || period == 0 {
 		return -1
 	}
-
-	quota, err := strconv.Atoi(strings.TrimSpace(string(cfsQuotaUs)))
-	if err != nil {
-		return -1
+	if quota < 0 {
+		return 0
 	}
 
-	return quota * 100 / cfsPeriod / runtime.NumCPU()
+	return quota * 100 / period / runtime.NumCPU()
 }
 
-func cpuLoad(cont string) []int {
-	avgload := []int{0, quotaCPU(cont)}
+//returns CPU load averaged over the last 4 samples and quota, ready is false until there are enough samples
+func cpuLoad(cont string) (avgload []int, ready bool) {
+	avgload = []int{0, quotaCPU(cont)}
 	if len(cpu[cont]) == 0 {
 		cpu[cont] = []int{0, 0, 0, 0, 0}
 	}
-	ticks, err := ioutil.ReadFile("/sys/fs/cgroup/cpuacct/lxc/" + cont + "/cpuacct.stat")
+	usertick, systick, err := cgroup.CPUTime(cont)
 	if err != nil {
-		return avgload
+		return avgload, false
 	}
 
-	tick := strings.Fields(string(ticks))
-	if len(tick) != 4 {
-		return avgload
+	cpu[cont] = append([]int{usertick + systick}, cpu[cont][0:4]...)
+	if cpu[cont][4] == 0 {
+		return avgload, false
 	}
+	avgload[0] = (cpu[cont][0] - cpu[cont][4]) / runtime.NumCPU() / 20
+	if avgload[1] != 0 {
+		avgload[0] = avgload[0] * 100 / avgload[1]
+	}
+	return avgload, true
+}
 
-	usertick, err := strconv.Atoi(tick[1])
+//returns disk I/O in % of the most loaded throttling limit and that limit in MB/s or IOPS, ready is false on the first sample
+func ioLoad(name string) (load []int, ready bool) {
+	load = []int{0, 0}
+	stat, err := cont.IO(name)
 	if err != nil {
-		return avgload
+		return load, false
+	}
+	prev, ok := io[name]
+	io[name] = ioSample{IOStat: stat, time: time.Now()}
+	seconds := int(io[name].time.Sub(prev.time).Seconds())
+	if !ok || seconds == 0 {
+		return load, false
 	}
 
-	systick, err := strconv.Atoi(tick[3])
-	if err != nil {
-		return avgload
+	rates := map[string]int{
+		"rbps":  (stat.ReadBytes - prev.ReadBytes) / seconds,
+		"wbps":  (stat.WriteBytes - prev.WriteBytes) / seconds,
+		"riops": (stat.ReadOps - prev.ReadOps) / seconds,
+		"wiops": (stat.WriteOps - prev.WriteOps) / seconds,
+	}
+	for kind, rate := range rates {
+		limit, err := cont.QuotaIO(name, kind)
+		if err != nil || limit == 0 {
+			continue
+		}
+		unit := 1
+		if strings.HasSuffix(kind, "bps") {
+			unit = 1024 * 1024
+		}
+		if usage := rate * 100 / (limit * unit); usage >= load[0] {
+			load = []int{usage, limit}
+		}
 	}
+	return load, true
+}
 
-	cpu[cont] = append([]int{usertick + systick}, cpu[cont][0:4]...)
-	if cpu[cont][4] == 0 {
-		return avgload
+//returns network traffic in % of rate limit and the limit in Kbps, the busiest direction of host side veth is used.
+//Ready is false on the first sample of limited container.
+func netLoad(name string) (load []int, ready bool) {
+	load = []int{0, 0}
+	veth := cont.GetConfigItem(config.Agent.LxcPrefix+name+"/config", "lxc.network.veth.pair")
+	if len(veth) == 0 {
+		return load, true
 	}
-	avgload[0] = (cpu[cont][0] - cpu[cont][4]) / runtime.NumCPU() / 20
-	if avgload[1] != 0 {
-		avgload[0] = avgload[0] * 100 / avgload[1]
+	sample := netSample{
+		rx:   counter(path.Join("/sys/class/net", veth, "statistics/rx_bytes")),
+		tx:   counter(path.Join("/sys/class/net", veth, "statistics/tx_bytes")),
+		time: time.Now(),
+	}
+	prev, ok := nic[name]
+	nic[name] = sample
+	load[1], _ = strconv.Atoi(cont.GetConfigItem(config.Agent.LxcPrefix+name+"/config", "subutai.network.ratelimit"))
+	seconds := int(sample.time.Sub(prev.time).Seconds())
+	if load[1] <= 0 {
+		return load, true
 	}
-	return avgload
+	if !ok || seconds == 0 {
+		return load, false
+	}
+
+	rate := sample.rx - prev.rx
+	if sample.tx-prev.tx > rate {
+		rate = sample.tx - prev.tx
+	}
+	load[0] = rate * 8 / 1000 / seconds * 100 / load[1]
+	return load, true
+}
+
+func counter(file string) int {
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return value
+}
+
+//returns partition usage in % of its refquota and the refquota in GB
+func partitionUsage(dataset string) []int {
+	d, err := fs.GetDataset(dataset)
+	if err != nil || d.RefQuota == 0 {
+		return []int{0, 0}
+	}
+	return []int{d.Referenced * 100 / d.RefQuota, d.RefQuota / fs.GB}
 }
 
 //returns disk usage in % and quota in GB
@@ -152,15 +246,32 @@ func diskUsage(path string) []int {
 	return []int{diskUsage, quota / (1024 * 1024 * 1024)}
 }
 
-//Processing works as a daemon, collecting information about containers stats and preparing list of active alerts.
+//Processing works as a daemon, collecting information about containers stats, checking it against alert thresholds
+//and keeping states of alerts. Raised and cleared alerts are sent to notification channels set in agent configuration.
 func Processing() {
+	loadStates()
+	startNotifiers()
 	for {
-		stats = alertLoad()
+		load := alertLoad()
+		states.Lock()
+		stats = load
+		states.Unlock()
 		for k := range cpu {
-			if _, ok := stats[k]; !ok {
+			if _, ok := load[k]; !ok {
 				delete(cpu, k)
 			}
 		}
+		for k := range io {
+			if _, ok := load[k]; !ok {
+				delete(io, k)
+			}
+		}
+		for k := range nic {
+			if _, ok := load[k]; !ok {
+				delete(nic, k)
+			}
+		}
+		evaluate(load, time.Now())
 		time.Sleep(time.Second * 30)
 	}
 }
@@ -168,26 +279,27 @@ func Processing() {
 func alertLoad() (load map[string]Load) {
 	load = make(map[string]Load)
 
-	files, err := ioutil.ReadDir("/sys/fs/cgroup/cpu/lxc/")
-	if err != nil {
-		return
-	}
-
-	for _, con := range files {
-		if !con.IsDir() {
-			continue
-		}
-
-		cpuValues := cpuLoad(con.Name())
-		ramValues := ramQuota(con.Name())
-		diskValues := diskUsage(con.Name())
+	for _, con := range cgroup.Containers() {
+		cpuValues, cpuReady := cpuLoad(con)
+		ramValues := ramQuota(con)
+		diskValues := diskUsage(con)
+		ioValues, ioReady := ioLoad(con)
+		netValues, netReady := netLoad(con)
 
 		if len(cpuValues) > 1 && len(ramValues) > 1 && len(diskValues) > 1 {
-			load[con.Name()] = Load{
-				CPU:  &values{Current: cpuValues[0], Quota: cpuValues[1]},
-				RAM:  &values{Current: ramValues[0], Quota: ramValues[1]},
-				Disk: &values{Current: diskValues[0], Quota: diskValues[1]},
+			item := Load{
+				CPU:        &values{Current: cpuValues[0], Quota: cpuValues[1], ready: cpuReady},
+				RAM:        &values{Current: ramValues[0], Quota: ramValues[1], ready: true},
+				Disk:       &values{Current: diskValues[0], Quota: diskValues[1], ready: true},
+				IO:         &values{Current: ioValues[0], Quota: ioValues[1], ready: ioReady},
+				Network:    &values{Current: netValues[0], Quota: netValues[1], ready: netReady},
+				Partitions: make(map[string]*values),
 			}
+			for _, partition := range cont.Partitions {
+				partitionValues := partitionUsage(con + "/" + partition)
+				item.Partitions[partition] = &values{Current: partitionValues[0], Quota: partitionValues[1], ready: true}
+			}
+			load[con] = item
 		}
 	}
 
@@ -195,22 +307,31 @@ func alertLoad() (load map[string]Load) {
 }
 
 //Current return the list of active alerts. It will be used in heartbeat to notify the Management server.
+//Thresholds are set by "subutai.alert.<resource>" container config items as "<warning>" or "<warning>:<critical>" usage in percents.
 func Current(list []container.Container) []Load {
+	return reports(list, Firing)
+}
+
+//Cleared returns the list of recently resolved alerts. It is sent in heartbeat separately from active alerts,
+//since presence of resource in Current list means active alert for Management server.
+func Cleared(list []container.Container) []Load {
+	return reports(list, Resolved)
+}
+
+func reports(list []container.Container, state string) []Load {
+	states.Lock()
+	defer states.Unlock()
+
 	var loadList []Load
 	for _, v := range list {
 		var item Load
-
-		threshold, err := strconv.Atoi(cont.GetConfigItem(config.Agent.LxcPrefix+v.Name+"/config", "subutai.alert.cpu"))
-		if threshold > 0 && stats[v.Name].CPU != nil && stats[v.Name].CPU.Current > threshold && err == nil {
-			item.CPU = &values{Current: stats[v.Name].CPU.Current, Quota: stats[v.Name].CPU.Quota}
-		}
-
-		threshold, err = strconv.Atoi(cont.GetConfigItem(config.Agent.LxcPrefix+v.Name+"/config", "subutai.alert.ram"))
-		if threshold > 0 && stats[v.Name].RAM != nil && stats[v.Name].RAM.Current > threshold && err == nil {
-			item.RAM = &values{Current: stats[v.Name].RAM.Current, Quota: stats[v.Name].RAM.Quota}
+		for resource, s := range states.list[v.Name] {
+			if report := s.report(); report != nil && report.State == state {
+				item.set(resource, report)
+			}
 		}
 
-		if item.CPU != nil || item.RAM != nil {
+		if len(item.resources()) > 0 {
 			item.Container = v.ID
 			loadList = append(loadList, item)
 		}
@@ -219,11 +340,24 @@ func Current(list []container.Container) []Load {
 }
 
 func Quota(list []container.Container) (output []container.Container) {
+	states.Lock()
+	load := stats
+	states.Unlock()
 	for _, v := range list {
-		if c, ok := stats[v.Name]; ok {
+		if c, ok := load[v.Name]; ok {
 			v.Quota.CPU = c.CPU.Quota
 			v.Quota.RAM = c.RAM.Quota
-			v.Quota.Disk = stats[v.Name].Disk.Quota
+			v.Quota.Disk = c.Disk.Quota
+		}
+		quota, reservation := cont.DiskLimits(v.Name)
+		v.Quota.DiskReservation = reservation["disk"]
+		for _, partition := range cont.Partitions {
+			if quota[partition] > 0 || reservation[partition] > 0 {
+				if v.Quota.Partitions == nil {
+					v.Quota.Partitions = make(map[string]container.PartitionQuota)
+				}
+				v.Quota.Partitions[partition] = container.PartitionQuota{Quota: quota[partition], Reservation: reservation[partition]}
+			}
 		}
 		output = append(output, v)
 	}
@@ -0,0 +1,194 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/syslog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// Event is a raise or clear of container resource alert sent to local notification channels.
+// Severity of resolved alert is the severity it had before clearing.
+type Event struct {
+	Host      string `json:"host"`
+	Container string `json:"container"`
+	Resource  string `json:"resource"`
+	State     string `json:"state"`
+	Severity  string `json:"severity"`
+	Current   int    `json:"current"`
+	Quota     int    `json:"quota"`
+	Time      int64  `json:"time"`
+}
+
+// Notifier delivers alert events to a notification channel
+type Notifier interface {
+	Name() string
+	Notify(e Event) error
+}
+
+// channel queues events of notifier, so slow delivery doesn't block alert processing and events are kept in order
+type channel struct {
+	Notifier
+	queue chan Event
+}
+
+var channels []channel
+
+// enabledNotifiers returns notification channels enabled in configuration file
+func enabledNotifiers() (list []Notifier) {
+	timeout := time.Duration(config.Notify.Timeout) * time.Second
+	for _, url := range config.Notify.Webhook {
+		if url = strings.TrimSpace(url); len(url) > 0 {
+			list = append(list, &webhook{url: url, retries: config.Notify.Retries, client: &http.Client{Timeout: timeout}})
+		}
+	}
+	if config.Notify.Syslog {
+		list = append(list, &sysLog{})
+	}
+	if len(config.Notify.Exec) > 0 {
+		list = append(list, &hook{path: config.Notify.Exec, timeout: timeout})
+	}
+	return
+}
+
+// startNotifiers runs delivery of queued events for every enabled channel
+func startNotifiers() {
+	for _, n := range enabledNotifiers() {
+		c := channel{Notifier: n, queue: make(chan Event, 100)}
+		go func() {
+			for e := range c.queue {
+				log.Check(log.WarnLevel, "Sending "+e.Resource+" alert of "+e.Container+" to "+c.Name(), c.Notify(e))
+			}
+		}()
+		channels = append(channels, c)
+	}
+}
+
+// notify queues event to every channel, event is dropped if channel queue is full
+func notify(e Event) {
+	e.Host, _ = os.Hostname()
+	for _, c := range channels {
+		select {
+		case c.queue <- e:
+		default:
+			log.Warn("Notification queue of " + c.Name() + " is full, dropping " + e.Resource + " alert of " + e.Container)
+		}
+	}
+}
+
+// retryDelay grows with every failed attempt of webhook delivery
+var retryDelay = 5 * time.Second
+
+// webhook posts event as JSON to HTTP endpoint, failed delivery is retried with growing delay
+type webhook struct {
+	url     string
+	retries int
+	client  *http.Client
+}
+
+func (w *webhook) Name() string {
+	return "webhook " + w.url
+}
+
+func (w *webhook) Notify(e Event) (err error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * retryDelay)
+		}
+		var resp *http.Response
+		if resp, err = w.client.Post(w.url, "application/json", bytes.NewReader(payload)); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = errors.New("Webhook responded with " + resp.Status)
+	}
+	return err
+}
+
+// sysLog writes event to local syslog as "key=value" fields, priority follows severity of the alert
+type sysLog struct{}
+
+func (s *sysLog) Name() string {
+	return "syslog"
+}
+
+func (s *sysLog) Notify(e Event) error {
+	priority := syslog.LOG_WARNING
+	if e.State == Resolved {
+		priority = syslog.LOG_NOTICE
+	} else if e.Severity == Critical {
+		priority = syslog.LOG_CRIT
+	}
+	w, err := syslog.New(priority|syslog.LOG_DAEMON, "subutai-alert")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	_, err = w.Write([]byte("container=" + strconv.Quote(e.Container) + " resource=" + strconv.Quote(e.Resource) +
+		" state=" + e.State + " severity=" + e.Severity + " current=" + strconv.Itoa(e.Current) +
+		" quota=" + strconv.Itoa(e.Quota) + " time=" + strconv.FormatInt(e.Time, 10)))
+	return err
+}
+
+// hook runs local executable with event fields in SUBUTAI_ALERT_* environment variables and JSON event on stdin
+type hook struct {
+	path    string
+	timeout time.Duration
+}
+
+func (h *hook) Name() string {
+	return "hook " + h.path
+}
+
+func (h *hook) Notify(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(h.path)
+	// hook runs in its own process group, so processes started by it are killed by timeout too
+	// and don't keep its output open
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"SUBUTAI_ALERT_HOST="+e.Host,
+		"SUBUTAI_ALERT_CONTAINER="+e.Container,
+		"SUBUTAI_ALERT_RESOURCE="+e.Resource,
+		"SUBUTAI_ALERT_STATE="+e.State,
+		"SUBUTAI_ALERT_SEVERITY="+e.Severity,
+		"SUBUTAI_ALERT_CURRENT="+strconv.Itoa(e.Current),
+		"SUBUTAI_ALERT_QUOTA="+strconv.Itoa(e.Quota),
+		"SUBUTAI_ALERT_TIME="+strconv.FormatInt(e.Time, 10),
+	)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(h.timeout, func() {
+		log.Check(log.DebugLevel, "Killing alert hook by timeout", syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL))
+	})
+	defer timer.Stop()
+	if err = cmd.Wait(); err != nil {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+var event = Event{Host: "rh1", Container: "foo", Resource: "ram", State: Firing, Severity: Critical, Current: 95, Quota: 100, Time: 1514905445}
+
+func TestWebhook(t *testing.T) {
+	var received []Event
+	status := http.StatusServiceUnavailable
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&e) != nil {
+			t.Errorf("invalid webhook request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		received = append(received, e)
+		w.WriteHeader(status)
+		status = http.StatusNoContent
+	}))
+	defer ts.Close()
+
+	w := &webhook{url: ts.URL, client: ts.Client()}
+	if err := w.Notify(event); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("failed delivery returned %v", err)
+	}
+	if err := w.Notify(event); err != nil || len(received) != 2 || received[1] != event {
+		t.Errorf("delivery returned %v, received %+v", err, received)
+	}
+
+	// failed delivery is retried
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = 10 * time.Millisecond
+	status = http.StatusInternalServerError
+	received = nil
+	w.retries = 1
+	if err := w.Notify(event); err != nil || len(received) != 2 {
+		t.Errorf("retried delivery returned %v after %d attempts", err, len(received))
+	}
+}
+
+func TestHook(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hook-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	script := path.Join(tmp, "hook.sh")
+	out := path.Join(tmp, "out")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\n"+
+		"echo \"$SUBUTAI_ALERT_CONTAINER $SUBUTAI_ALERT_RESOURCE $SUBUTAI_ALERT_STATE $SUBUTAI_ALERT_SEVERITY "+
+		"$SUBUTAI_ALERT_CURRENT $SUBUTAI_ALERT_QUOTA\" > "+out+"\ncat >> "+out+"\n"+
+		"[ \"$SUBUTAI_ALERT_CURRENT\" -lt 100 ] || { echo overflow; exit 1; }\n"+
+		"[ \"$SUBUTAI_ALERT_QUOTA\" -gt 0 ] || sleep 5\n"), 0755)
+
+	h := &hook{path: script, timeout: time.Second}
+	if err := h.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(out)
+	lines := strings.SplitN(string(data), "\n", 2)
+	var e Event
+	if lines[0] != "foo ram firing critical 95 100" || json.Unmarshal([]byte(lines[1]), &e) != nil || e != event {
+		t.Errorf("hook received %q", data)
+	}
+
+	failed := event
+	failed.Current = 100
+	if err := h.Notify(failed); err == nil || !strings.Contains(err.Error(), "overflow") {
+		t.Errorf("failed hook returned %v", err)
+	}
+
+	// hook is killed after timeout
+	slow := event
+	slow.Quota = 0
+	start := time.Now()
+	if err := h.Notify(slow); err == nil || time.Since(start) > 3*time.Second {
+		t.Errorf("slow hook returned %v after %v", err, time.Since(start))
+	}
+}
+
+type recorder struct {
+	events chan Event
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Notify(e Event) error {
+	r.events <- e
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	defer func(c []channel) { channels = c }(channels)
+	r := &recorder{events: make(chan Event)}
+	c := channel{Notifier: r, queue: make(chan Event, 1)}
+	channels = []channel{c}
+
+	// notifier blocked on delivery of the first event holds the second one in queue and drops the third
+	go func() {
+		for e := range c.queue {
+			c.Notify(e)
+		}
+	}()
+	for _, name := range []string{"a", "b", "c"} {
+		e := event
+		e.Container = name
+		notify(e)
+		if name == "a" {
+			// wait till the first event is taken from queue
+			for len(c.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	host, _ := os.Hostname()
+	for _, want := range []string{"a", "b"} {
+		if e := <-r.events; e.Container != want || e.Host != host {
+			t.Errorf("delivered alert of %s from %s, want %s from %s", e.Container, e.Host, want, host)
+		}
+	}
+	select {
+	case e := <-r.events:
+		t.Errorf("alert of %s is not dropped from full queue", e.Container)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(c.queue)
+}
@@ -0,0 +1,267 @@
+package alert
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	cont "github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// Severity levels of alerts
+const (
+	Warning  = "warning"
+	Critical = "critical"
+)
+
+// States of reported alerts
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+// resolvedTTL is how long cleared alert is reported to Management as resolved
+const resolvedTTL = 5 * time.Minute
+
+// threshold is a warning and critical usage level in percents, zero level is not checked
+type threshold struct {
+	warning  int
+	critical int
+}
+
+// parseThreshold parses value of "subutai.alert.*" container config item, either "<warning>" or "<warning>:<critical>"
+func parseThreshold(value string) (t threshold, ok bool) {
+	levels := strings.Split(value, ":")
+	if len(levels) > 2 {
+		return t, false
+	}
+	var err error
+	if t.warning, err = strconv.Atoi(levels[0]); err != nil || t.warning < 0 {
+		return threshold{}, false
+	}
+	if len(levels) == 2 {
+		if t.critical, err = strconv.Atoi(levels[1]); err != nil || t.critical < t.warning {
+			return threshold{}, false
+		}
+	}
+	return t, true
+}
+
+func (t threshold) level(severity string) int {
+	if severity == Critical {
+		return t.critical
+	}
+	return t.warning
+}
+
+// severity returns the highest severity exceeded by usage.
+// Levels of active and lower severities are decreased by hysteresis, so alert is not cleared by usage jitter around the level.
+func (t threshold) severity(usage int, active string, hysteresis int) string {
+	for _, severity := range []string{Critical, Warning} {
+		level := t.level(severity)
+		if level <= 0 {
+			continue
+		}
+		if rank(severity) <= rank(active) {
+			level -= hysteresis
+		}
+		if usage > level {
+			return severity
+		}
+	}
+	return ""
+}
+
+func rank(severity string) int {
+	switch severity {
+	case Warning:
+		return 1
+	case Critical:
+		return 2
+	}
+	return 0
+}
+
+// state is an alert state machine of container resource.
+// Usage over a level should be sustained for a window before severity is raised, while pending severity is kept in Pending.
+// Alert is lowered or cleared at once, cleared alert keeps Resolved time to be reported for a while.
+type state struct {
+	Severity string
+	Pending  string
+	// Since is a time when pending severity was exceeded or the alert was raised
+	Since    time.Time
+	Resolved time.Time
+	Current  int
+	Quota    int
+}
+
+// step moves state by resource usage measured at the moment, it returns true if severity of the alert has changed
+func (s *state) step(usage values, t threshold, now time.Time, window time.Duration, hysteresis int) bool {
+	s.Current, s.Quota = usage.Current, usage.Quota
+
+	target := t.severity(usage.Current, s.Severity, hysteresis)
+	switch {
+	case target == s.Severity:
+		s.Pending = ""
+		return false
+	case rank(target) < rank(s.Severity):
+		s.Severity, s.Pending, s.Since = target, "", now
+		if len(target) == 0 {
+			s.Resolved = now
+		}
+		return true
+	case target != s.Pending:
+		s.Pending, s.Since = target, now
+	}
+	if now.Sub(s.Since) < window {
+		return false
+	}
+	s.Severity, s.Pending, s.Since, s.Resolved = target, "", now, time.Time{}
+	return true
+}
+
+// idle checks if state has nothing to report or wait for
+func (s *state) idle(now time.Time) bool {
+	return len(s.Severity) == 0 && len(s.Pending) == 0 && now.Sub(s.Resolved) > resolvedTTL
+}
+
+// report returns alert values for Management, nil if there is nothing to report
+func (s *state) report() *values {
+	switch {
+	case len(s.Severity) > 0:
+		return &values{Current: s.Current, Quota: s.Quota, Severity: s.Severity, State: Firing, Since: s.Since.Unix()}
+	case !s.Resolved.IsZero():
+		return &values{Current: s.Current, Quota: s.Quota, State: Resolved, Since: s.Resolved.Unix()}
+	}
+	return nil
+}
+
+func (s *state) options() map[string]string {
+	return map[string]string{
+		"severity": s.Severity,
+		"pending":  s.Pending,
+		"since":    strconv.FormatInt(s.Since.Unix(), 10),
+		"resolved": strconv.FormatInt(s.Resolved.Unix(), 10),
+		"current":  strconv.Itoa(s.Current),
+		"quota":    strconv.Itoa(s.Quota),
+	}
+}
+
+func unixTime(value string) time.Time {
+	sec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// states of alerts by container name and resource, e.g. states["foo"]["disk.rootfs"]
+var states = struct {
+	sync.Mutex
+	list map[string]map[string]*state
+}{list: make(map[string]map[string]*state)}
+
+// loadStates restores alert states saved before agent restart, so active alerts are not raised again
+func loadStates() {
+	list, err := db.INSTANCE.AlertList()
+	if log.Check(log.WarnLevel, "Loading alert states", err) {
+		return
+	}
+
+	states.Lock()
+	defer states.Unlock()
+	for key, item := range list {
+		name, resource := splitKey(key)
+		if states.list[name] == nil {
+			states.list[name] = make(map[string]*state)
+		}
+		current, _ := strconv.Atoi(item["current"])
+		quota, _ := strconv.Atoi(item["quota"])
+		states.list[name][resource] = &state{
+			Severity: item["severity"],
+			Pending:  item["pending"],
+			Since:    unixTime(item["since"]),
+			Resolved: unixTime(item["resolved"]),
+			Current:  current,
+			Quota:    quota,
+		}
+	}
+}
+
+// evaluate checks resource usage of containers against their thresholds and saves changed alert states
+func evaluate(stats map[string]Load, now time.Time) {
+	window := time.Duration(config.Alert.Window) * time.Minute
+
+	states.Lock()
+	defer states.Unlock()
+	// alerts of stopped or destroyed containers are resolved, so notification channels don't keep them firing
+	for name, list := range states.list {
+		if _, ok := stats[name]; !ok {
+			for resource, s := range list {
+				if len(s.Severity) > 0 {
+					log.Info("Alert for " + resource + " of " + name + " resolved, container is not running")
+					notify(Event{Container: name, Resource: resource, State: Resolved, Severity: s.Severity,
+						Current: s.Current, Quota: s.Quota, Time: now.Unix()})
+				}
+				log.Check(log.WarnLevel, "Removing alert state", db.INSTANCE.AlertDel(name+"/"+resource))
+			}
+			delete(states.list, name)
+		}
+	}
+
+	for name, load := range stats {
+		for resource, usage := range load.resources() {
+			// restored alert is kept as is until sampler has real data
+			if !usage.ready {
+				continue
+			}
+			t, _ := parseThreshold(cont.GetConfigItem(config.Agent.LxcPrefix+name+"/config", "subutai.alert."+resource))
+			s := states.list[name][resource]
+			if s == nil {
+				if len(t.severity(usage.Current, "", 0)) == 0 {
+					continue
+				}
+				if states.list[name] == nil {
+					states.list[name] = make(map[string]*state)
+				}
+				s = new(state)
+				states.list[name][resource] = s
+			}
+
+			pending, severity := s.Pending, s.Severity
+			if s.step(*usage, t, now, window, config.Alert.Hysteresis) {
+				e := Event{Container: name, Resource: resource, State: Firing, Severity: s.Severity,
+					Current: s.Current, Quota: s.Quota, Time: now.Unix()}
+				if len(s.Severity) > 0 {
+					log.Info("Alert " + s.Severity + " for " + resource + " of " + name + ", usage " + strconv.Itoa(s.Current) + "%")
+				} else {
+					log.Info("Alert for " + resource + " of " + name + " resolved")
+					e.State, e.Severity = Resolved, severity
+				}
+				notify(e)
+			} else if s.Pending == pending && !s.idle(now) {
+				continue
+			}
+
+			key := name + "/" + resource
+			if s.idle(now) {
+				log.Check(log.WarnLevel, "Removing alert state", db.INSTANCE.AlertDel(key))
+				delete(states.list[name], resource)
+				continue
+			}
+			log.Check(log.WarnLevel, "Saving alert state", db.INSTANCE.AlertSet(key, s.options()))
+		}
+	}
+}
+
+// splitKey splits alert state key to container name and resource, e.g. "foo/disk.rootfs"
+func splitKey(key string) (name, resource string) {
+	if i := strings.LastIndex(key, "/"); i != -1 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
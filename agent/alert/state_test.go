@@ -0,0 +1,131 @@
+package alert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		value string
+		want  threshold
+		ok    bool
+	}{
+		{"80", threshold{warning: 80}, true},
+		{"0", threshold{}, true},
+		{"80:95", threshold{warning: 80, critical: 95}, true},
+		{"90:90", threshold{warning: 90, critical: 90}, true},
+		{"", threshold{}, false},
+		{"high", threshold{}, false},
+		{"-5", threshold{}, false},
+		{"95:80", threshold{}, false},
+		{"80:", threshold{}, false},
+		{"60:80:95", threshold{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseThreshold(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseThreshold(%q) = %+v, %v, want %+v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStateStep(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	levels := threshold{warning: 80, critical: 95}
+
+	type sample struct {
+		after    time.Duration
+		usage    int
+		changed  bool
+		severity string
+		pending  string
+	}
+	tests := []struct {
+		name    string
+		window  time.Duration
+		samples []sample
+	}{
+		{
+			name:   "short spike is not raised",
+			window: 5 * time.Minute,
+			samples: []sample{
+				{0, 85, false, "", Warning},
+				{time.Minute, 50, false, "", ""},
+				{6 * time.Minute, 50, false, "", ""},
+			},
+		},
+		{
+			name:   "sustained usage raises warning after window",
+			window: 5 * time.Minute,
+			samples: []sample{
+				{0, 85, false, "", Warning},
+				{4 * time.Minute, 90, false, "", Warning},
+				{5 * time.Minute, 90, true, Warning, ""},
+			},
+		},
+		{
+			name: "no window raises at once",
+			samples: []sample{
+				{0, 96, true, Critical, ""},
+			},
+		},
+		{
+			name:   "escalation to critical waits for window again",
+			window: 5 * time.Minute,
+			samples: []sample{
+				{0, 85, false, "", Warning},
+				{5 * time.Minute, 85, true, Warning, ""},
+				{6 * time.Minute, 97, false, Warning, Critical},
+				{11 * time.Minute, 97, true, Critical, ""},
+			},
+		},
+		{
+			name:   "hysteresis keeps alert while usage jitters under the level",
+			window: 5 * time.Minute,
+			samples: []sample{
+				{0, 85, false, "", Warning},
+				{5 * time.Minute, 85, true, Warning, ""},
+				{6 * time.Minute, 78, false, Warning, ""},
+				{7 * time.Minute, 74, true, "", ""},
+			},
+		},
+		{
+			name:   "critical is lowered to warning at once",
+			window: 5 * time.Minute,
+			samples: []sample{
+				{0, 99, false, "", Critical},
+				{5 * time.Minute, 99, true, Critical, ""},
+				{6 * time.Minute, 85, true, Warning, ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		s := &state{}
+		for i, smp := range tt.samples {
+			changed := s.step(values{Current: smp.usage, Quota: 100, ready: true}, levels, start.Add(smp.after), tt.window, 5)
+			if changed != smp.changed || s.Severity != smp.severity || s.Pending != smp.pending {
+				t.Errorf("%s: sample %d: changed %v, severity %q, pending %q, want %v, %q, %q",
+					tt.name, i, changed, s.Severity, s.Pending, smp.changed, smp.severity, smp.pending)
+			}
+		}
+	}
+}
+
+func TestStateResolved(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := &state{Severity: Warning, Since: now}
+	if !s.step(values{Current: 10, Quota: 100}, threshold{warning: 80}, now, time.Minute, 5) {
+		t.Fatal("alert is not cleared")
+	}
+	if r := s.report(); r == nil || r.State != Resolved || r.Since != now.Unix() {
+		t.Errorf("cleared alert report = %+v, want resolved since %d", r, now.Unix())
+	}
+	if s.idle(now.Add(time.Minute)) {
+		t.Error("resolved alert is idle before it was reported for resolvedTTL")
+	}
+	if !s.idle(now.Add(resolvedTTL + time.Second)) {
+		t.Error("resolved alert is not idle after resolvedTTL")
+	}
+}
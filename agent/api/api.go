@@ -0,0 +1,324 @@
+// Package api serves CLI operations to local clients over Unix socket accessible by root only
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/subutai-io/agent/cli"
+	"github.com/subutai-io/agent/lib/audit"
+	"github.com/subutai-io/agent/log"
+)
+
+// Socket is a location of the API socket
+const Socket = "/var/run/subutai.sock"
+
+// endpoint describes CLI operation exposed as POST /v1/<name> with JSON parameters.
+// Parameters are named after CLI arguments and long option names, e.g. {"template": "master", "name": "foo", "env": "..."}.
+type endpoint struct {
+	// mutating operations are recorded in audit log
+	mutating bool
+	params   func() interface{}
+	run      func(p interface{}) (cli.Output, error)
+}
+
+type cloneParams struct {
+	Template string `json:"template"`
+	Name     string `json:"name"`
+	Env      string `json:"env"`
+	Ipaddr   string `json:"ipaddr"`
+	Token    string `json:"token"`
+	Secret   string `json:"secret"`
+}
+
+type nameParams struct {
+	Name     string `json:"name"`
+	Template bool   `json:"template"`
+}
+
+type quotaParams struct {
+	Name      string `json:"name"`
+	Resource  string `json:"resource"`
+	Set       string `json:"set"`
+	Threshold string `json:"threshold"`
+	Reserve   string `json:"reserve"`
+}
+
+type mapParams struct {
+	Protocol   string `json:"protocol"`
+	Internal   string `json:"internal"`
+	External   string `json:"external"`
+	Domain     string `json:"domain"`
+	Cert       string `json:"cert"`
+	Policy     string `json:"policy"`
+	List       bool   `json:"list"`
+	Remove     bool   `json:"remove"`
+	Sslbackend bool   `json:"sslbackend"`
+}
+
+type proxyAddParams struct {
+	Vlan   string `json:"vlan"`
+	Domain string `json:"domain"`
+	Host   string `json:"host"`
+	Policy string `json:"policy"`
+	File   string `json:"file"`
+}
+
+type proxyDelParams struct {
+	Vlan   string `json:"vlan"`
+	Domain bool   `json:"domain"`
+	Host   string `json:"host"`
+}
+
+type tunnelParams struct {
+	Socket  string `json:"socket"`
+	Timeout string `json:"timeout"`
+}
+
+type listParams struct {
+	Name      string `json:"name"`
+	Container bool   `json:"container"`
+	Template  bool   `json:"template"`
+	Info      bool   `json:"info"`
+	Parent    bool   `json:"parent"`
+}
+
+// required returns usage error if any of values is empty
+func required(names string, values ...string) error {
+	for _, v := range values {
+		if len(v) == 0 {
+			return &log.ExitError{Code: log.ExitUsage, Message: "Missing required parameters: " + names}
+		}
+	}
+	return nil
+}
+
+var endpoints = map[string]endpoint{
+	"clone": {true, func() interface{} { return &cloneParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*cloneParams)
+		if err := required("template, name", v.Template, v.Name); err != nil {
+			return cli.Output{}, err
+		}
+		return cli.Output{}, cli.LxcClone(v.Template, v.Name, v.Env, v.Ipaddr, v.Secret, v.Token)
+	}},
+	"destroy": {true, func() interface{} { return &nameParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*nameParams)
+		if err := required("name", v.Name); err != nil {
+			return cli.Output{}, err
+		}
+		if v.Template {
+			return cli.Output{}, cli.LxcDestroyTemplate(v.Name)
+		}
+		return cli.Output{}, cli.LxcDestroy(v.Name, false)
+	}},
+	"start": {true, func() interface{} { return &nameParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*nameParams)
+		if err := required("name", v.Name); err != nil {
+			return cli.Output{}, err
+		}
+		return cli.Output{}, cli.LxcStart(v.Name)
+	}},
+	"stop": {true, func() interface{} { return &nameParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*nameParams)
+		if err := required("name", v.Name); err != nil {
+			return cli.Output{}, err
+		}
+		return cli.Output{}, cli.LxcStop(v.Name)
+	}},
+	"quota": {true, func() interface{} { return &quotaParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*quotaParams)
+		if err := required("name, resource", v.Name, v.Resource); err != nil {
+			return cli.Output{}, err
+		}
+		return cli.LxcQuota(v.Name, v.Resource, v.Set, v.Threshold, v.Reserve)
+	}},
+	"map": {true, func() interface{} { return &mapParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*mapParams)
+		return cli.MapPort(v.Protocol, v.Internal, v.External, v.Policy, v.Domain, v.Cert, v.List, v.Remove, v.Sslbackend)
+	}},
+	"proxy/add": {true, func() interface{} { return &proxyAddParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*proxyAddParams)
+		return cli.Output{}, cli.ProxyAdd(v.Vlan, v.Domain, v.Host, v.Policy, v.File)
+	}},
+	"proxy/del": {true, func() interface{} { return &proxyDelParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*proxyDelParams)
+		return cli.Output{}, cli.ProxyDel(v.Vlan, v.Host, v.Domain)
+	}},
+	"tunnel/add": {true, func() interface{} { return &tunnelParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*tunnelParams)
+		return cli.TunAdd(v.Socket, v.Timeout)
+	}},
+	"tunnel/del": {true, func() interface{} { return &tunnelParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*tunnelParams)
+		if err := required("socket", v.Socket); err != nil {
+			return cli.Output{}, err
+		}
+		return cli.Output{}, cli.TunDel(v.Socket)
+	}},
+	"tunnel/list": {false, func() interface{} { return &tunnelParams{} }, func(p interface{}) (cli.Output, error) {
+		return cli.TunList()
+	}},
+	"list": {false, func() interface{} { return &listParams{} }, func(p interface{}) (cli.Output, error) {
+		v := p.(*listParams)
+		return cli.LxcList(v.Name, v.Container, v.Template, v.Info, v.Parent)
+	}},
+}
+
+// Serve listens on the API socket. Connections of processes not running as root are rejected.
+func Serve() {
+	if err := os.Remove(Socket); err != nil && !os.IsNotExist(err) {
+		log.Warn("Removing stale API socket, ", err)
+		return
+	}
+	l, err := net.Listen("unix", Socket)
+	if log.Check(log.WarnLevel, "Listening on API socket", err) {
+		return
+	}
+	if log.Check(log.WarnLevel, "Setting API socket permissions", os.Chmod(Socket, 0600)) {
+		l.Close()
+		return
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/v1/", handler)
+	log.Check(log.WarnLevel, "Serving API", http.Serve(rootListener{l}, router))
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	e, ok := endpoints[name]
+	if !ok {
+		http.Error(w, "Unknown operation "+name, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost && (r.Method != http.MethodGet || e.mutating) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := e.params()
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil && len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, params)
+	}
+	if err != nil {
+		http.Error(w, "Invalid parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := cli.NewResult(e.run(params))
+
+	if e.mutating {
+		code := "0"
+		if res.Error != nil {
+			code = strconv.Itoa(res.Error.Code)
+		}
+		var container string
+		if v, ok := params.(*nameParams); ok {
+			container = v.Name
+		} else if v, ok := params.(*cloneParams); ok {
+			container = v.Name
+			v.Secret, v.Token = "", ""
+		} else if v, ok := params.(*quotaParams); ok {
+			container = v.Name
+		}
+		command, _ := json.Marshal(params)
+		log.Check(log.WarnLevel, "Writing audit record", audit.Write(audit.Entry{
+			Source:    "api",
+			User:      "root",
+			Action:    name,
+			Container: container,
+			Command:   string(command),
+			ExitCode:  code,
+		}))
+	}
+
+	status := http.StatusOK
+	if res.Error != nil {
+		status = http.StatusInternalServerError
+		if res.Error.Code == log.ExitUsage {
+			status = http.StatusBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	log.Check(log.DebugLevel, "Writing API response", json.NewEncoder(w).Encode(res))
+}
+
+// rootListener accepts connections from processes running as root only
+type rootListener struct {
+	net.Listener
+}
+
+func (l rootListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if uid, err := peerUID(conn); err == nil && uid == 0 {
+			return conn, nil
+		}
+		log.Warn("Rejecting API connection of non-root process")
+		conn.Close()
+	}
+}
+
+func peerUID(conn net.Conn) (uint32, error) {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("Not a unix socket connection")
+	}
+	raw, err := unix.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
+
+// Request executes CLI operation by the daemon, params are named after CLI arguments and long option names.
+func Request(name string, params map[string]interface{}) (cli.Result, error) {
+	var res cli.Result
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", Socket)
+		},
+	}}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return res, err
+	}
+	resp, err := client.Post("http://subutai/v1/"+name, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return res, errors.New(resp.Status + ": " + strings.TrimSpace(string(data)))
+	}
+	return res, nil
+}
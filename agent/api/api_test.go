@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/cli"
+	"github.com/subutai-io/agent/log"
+)
+
+type testParams struct {
+	Name string `json:"name"`
+}
+
+// testEndpoint adds read-only endpoint with operation returning name parameter, so no audit record is written
+func testEndpoint(t *testing.T, run func(name string) (cli.Output, error)) {
+	endpoints["test"] = endpoint{false, func() interface{} { return &testParams{} }, func(p interface{}) (cli.Output, error) {
+		return run(p.(*testParams).Name)
+	}}
+}
+
+func call(method, name, body string) (*httptest.ResponseRecorder, cli.Result) {
+	rw := httptest.NewRecorder()
+	handler(rw, httptest.NewRequest(method, "/v1/"+name, strings.NewReader(body)))
+	var res cli.Result
+	json.Unmarshal(rw.Body.Bytes(), &res)
+	return rw, res
+}
+
+func TestHandler(t *testing.T) {
+	defer delete(endpoints, "test")
+	testEndpoint(t, func(name string) (cli.Output, error) {
+		if err := required("name", name); err != nil {
+			return cli.Output{}, err
+		}
+		if name == "missing" {
+			return cli.Output{}, errors.New("Container missing not found")
+		}
+		return cli.Output{Result: name}, nil
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		op     string
+		body   string
+		status int
+		code   int
+	}{
+		{"unknown operation", http.MethodPost, "foo", "", http.StatusNotFound, 0},
+		// mutating operations are rejected before their parameters are even read
+		{"GET of mutating operation", http.MethodGet, "clone", "", http.StatusMethodNotAllowed, 0},
+		{"invalid parameters", http.MethodPost, "test", "{", http.StatusBadRequest, 0},
+		{"unknown parameter type", http.MethodPost, "test", `{"name": 1}`, http.StatusBadRequest, 0},
+		{"missing parameter", http.MethodPost, "test", "", http.StatusBadRequest, log.ExitUsage},
+		{"failed operation", http.MethodPost, "test", `{"name": "missing"}`, http.StatusInternalServerError, log.ExitFailure},
+		{"GET of read-only operation", http.MethodGet, "test", `{"name": "foo"}`, http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		rw, res := call(tt.method, tt.op, tt.body)
+		if rw.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rw.Code, tt.status)
+		}
+		if tt.code != 0 && (res.Error == nil || res.Error.Code != tt.code) {
+			t.Errorf("%s: error %+v, want exit code %d", tt.name, res.Error, tt.code)
+		}
+	}
+	if _, res := call(http.MethodPost, "test", `{"name": "foo"}`); res.Result != "foo" || res.Error != nil {
+		t.Errorf("result %+v, want foo", res)
+	}
+}
+
+func TestHandlerConcurrent(t *testing.T) {
+	defer delete(endpoints, "test")
+	// every call waits for the other one, so calls executed one by one would never finish
+	var started sync.WaitGroup
+	started.Add(2)
+	testEndpoint(t, func(name string) (cli.Output, error) {
+		started.Done()
+		started.Wait()
+		return cli.Output{Result: name}, nil
+	})
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			call(http.MethodPost, "test", `{"name": "foo"}`)
+			done <- true
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("API calls are not executed concurrently")
+		}
+	}
+}
@@ -46,6 +46,15 @@ type Quota struct {
 	CPU  int `json:"cpu,omitempty"`
 	RAM  int `json:"ram,omitempty"`
 	Disk int `json:"disk,omitempty"`
+	//DiskReservation is a disk space guaranteed to container in GB
+	DiskReservation int                       `json:"diskReservation,omitempty"`
+	Partitions      map[string]PartitionQuota `json:"partitions,omitempty"`
+}
+
+//PartitionQuota describes quota and reservation of container partition (rootfs, home, var, opt) in GB.
+type PartitionQuota struct {
+	Quota       int `json:"quota,omitempty"`
+	Reservation int `json:"reservation,omitempty"`
 }
 
 func init() {
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -48,6 +49,16 @@ type RequestOptions struct {
 	RunAs       string            `json:"runAs"`
 	Timeout     int               `json:"timeout"`
 	IsDaemon    int               `json:"isDaemon"`
+	Target      string            `json:"targetCommandId,omitempty"`
+	Signal      int               `json:"signal,omitempty"`
+	StdIn       string            `json:"stdIn,omitempty"`
+	Pty         int               `json:"pty,omitempty"`
+	Rows        uint16            `json:"rows,omitempty"`
+	Cols        uint16            `json:"cols,omitempty"`
+	CPULimit    int               `json:"cpuLimit,omitempty"`
+	MemoryLimit int               `json:"memoryLimit,omitempty"`
+	IOLimit     int               `json:"ioLimit,omitempty"`
+	MaxOutput   int64             `json:"maxOutput,omitempty"`
 }
 
 // Response is a encapsulation for ResponseOptions required by the Management server.
@@ -70,6 +81,8 @@ type ResponseOptions struct {
 
 // ExecHost executes request inside Resource host
 // and sends output as response.
+// Command with resource limits is placed into transient control group, breach of limits is reported
+// with EXECUTE_OOM and EXECUTE_OUTPUT_LIMIT response types.
 func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 	defer close(outCh)
 
@@ -78,6 +91,19 @@ func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 	if cmd == nil {
 		return
 	}
+
+	var cg *limits
+	if limited(req) {
+		var err error
+		if cg, err = newLimits(req); log.Check(log.WarnLevel, "Creating control group for command "+req.CommandID, err) {
+			response := genericResponse(req)
+			response.StdErr = "Failed to apply resource limits: " + err.Error()
+			response.ExitCode = "1"
+			outCh <- response
+			return
+		}
+		defer cg.remove()
+	}
 	rop, wop, err := os.Pipe()
 	if err != nil {
 		return
@@ -92,15 +118,25 @@ func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 
 	cmd.Stdout = wop
 	cmd.Stderr = wep
+	if len(req.StdIn) > 0 {
+		cmd.Stdin = strings.NewReader(req.StdIn)
+	}
 	if req.IsDaemon == 1 {
 		cmd.SysProcAttr = &syscall.SysProcAttr{}
-		cmd.SysProcAttr.Setpgid = true
 		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
 	}
+	// separate process group allows to signal all processes started by the command
+	cmd.SysProcAttr.Setpgid = true
+	if cg != nil {
+		cg.wrap(cmd)
+	}
 
 	err = cmd.Start()
 
-	log.Check(log.WarnLevel, "Executing command: "+req.CommandID+" "+req.Command+" "+strings.Join(req.Args, " "), err)
+	var response = genericResponse(req)
+	if !log.Check(log.WarnLevel, "Executing command: "+req.CommandID+" "+req.Command+" "+strings.Join(req.Args, " "), err) {
+		register(req, "", cmd.Process.Pid, true, nil)
+	}
 
 	log.Check(log.DebugLevel, "Closing standard output", wop.Close())
 	log.Check(log.DebugLevel, "Closing error output", wep.Close())
@@ -110,7 +146,20 @@ func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 	go outputReader(rop, stdout)
 	go outputReader(rep, stderr)
 
-	var response = genericResponse(req)
+	var overflow int32
+	if req.MaxOutput > 0 && cmd.Process != nil {
+		var total int64
+		exceed := func() {
+			if atomic.CompareAndSwapInt32(&overflow, 0, 1) {
+				log.Check(log.DebugLevel, "Killing process by output limit", syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL))
+			}
+		}
+		limitedOut, limitedErr := make(chan string), make(chan string)
+		go outputLimiter(stdout, limitedOut, &total, req.MaxOutput, exceed)
+		go outputLimiter(stderr, limitedErr, &total, req.MaxOutput, exceed)
+		stdout, stderr = limitedOut, limitedErr
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -119,11 +168,23 @@ func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 	}()
 
 	done := make(chan error)
+	terminated := false
 
-	go func() { done <- cmd.Wait() }()
+	go func() {
+		err := cmd.Wait()
+		terminated = unregister(req.CommandID)
+		done <- err
+	}()
 	select {
 	case <-done:
 		wg.Wait()
+		if terminated {
+			response.Type = "EXECUTE_TERMINATED"
+		} else if atomic.LoadInt32(&overflow) == 1 {
+			response.Type = "EXECUTE_OUTPUT_LIMIT"
+		} else if cg != nil && cg.oom(cmd.ProcessState) {
+			response.Type = "EXECUTE_OOM"
+		}
 		response.ExitCode = "0"
 		if req.IsDaemon != 1 && cmd.ProcessState != nil {
 			response.ExitCode = strconv.Itoa(cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus())
@@ -135,10 +196,13 @@ func ExecHost(req RequestOptions, outCh chan<- ResponseOptions) {
 			outCh <- response
 			<-done
 		} else {
-			log.Check(log.DebugLevel, "Killing process by timeout", cmd.Process.Kill())
-			response.Type = "EXECUTE_TIMEOUT"
-			_, err = cmd.Process.Wait()
+			log.Check(log.DebugLevel, "Killing process by timeout", syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL))
+			err = <-done
 			log.Check(log.DebugLevel, "Killing process to finish", err)
+			response.Type = "EXECUTE_TIMEOUT"
+			if terminated {
+				response.Type = "EXECUTE_TERMINATED"
+			}
 			if cmd.ProcessState != nil {
 				response.ExitCode = strconv.Itoa(cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus())
 			} else {
@@ -235,6 +299,17 @@ func buildCmd(r *RequestOptions) *exec.Cmd {
 	return cmd
 }
 
+// Deny answers request rejected by local policy with EXECUTE_DENIED response without executing it.
+func Deny(req RequestOptions, reason string, outCh chan<- ResponseOptions) {
+	defer close(outCh)
+
+	response := genericResponse(req)
+	response.Type = "EXECUTE_DENIED"
+	response.StdErr = "Request is not permitted: " + reason
+	response.ExitCode = "1"
+	outCh <- response
+}
+
 //prepare basic response
 func genericResponse(req RequestOptions) ResponseOptions {
 	return ResponseOptions{
@@ -256,6 +331,10 @@ func AttachContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	}
 	defer lxc.Release(c)
 
+	if req.Pty == 1 {
+		return session(c, name, req, outCh)
+	}
+
 	rop, wop, err := os.Pipe()
 	if err != nil {
 		return err
@@ -276,6 +355,14 @@ func AttachContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	opts.EnvToKeep = []string{"TERM", "USER", "LS_COLORS"}
 	opts.ClearEnv = true
 
+	var rip, wip *os.File
+	if len(req.StdIn) > 0 {
+		if rip, wip, err = os.Pipe(); err != nil {
+			return err
+		}
+		opts.StdinFd = rip.Fd()
+	}
+
 	var exitCode int
 	var cmd bytes.Buffer
 
@@ -291,12 +378,39 @@ func AttachContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	}
 
 	log.Debug("Executing command in container " + name + ":" + cmd.String())
-	go func() {
-		exitCode, err = c.RunCommandStatus([]string{"timeout", strconv.Itoa(req.Timeout), "/bin/bash", "-c", cmd.String()}, opts)
-		log.Check(log.DebugLevel, "Executing command inside container", err)
-		log.Check(log.DebugLevel, "Closing standard output", wop.Close())
-		log.Check(log.DebugLevel, "Closing error output", wep.Close())
-	}()
+	pid, err := c.RunCommandNoWait([]string{"timeout", strconv.Itoa(req.Timeout), "/bin/bash", "-c", cmd.String()}, opts)
+	log.Check(log.DebugLevel, "Closing standard output", wop.Close())
+	log.Check(log.DebugLevel, "Closing error output", wep.Close())
+	if rip != nil {
+		log.Check(log.DebugLevel, "Closing standard input", rip.Close())
+		go func() {
+			_, err := wip.WriteString(req.StdIn)
+			log.Check(log.DebugLevel, "Writing standard input", err)
+			log.Check(log.DebugLevel, "Closing standard input", wip.Close())
+		}()
+	}
+
+	done := make(chan bool)
+	if log.Check(log.WarnLevel, "Executing command inside container", err) {
+		exitCode = -1
+		close(done)
+	} else {
+		register(req, name, pid, false, nil)
+		go func() {
+			defer close(done)
+			p, err := os.FindProcess(pid)
+			if log.Check(log.DebugLevel, "Finding attached process", err) {
+				exitCode = -1
+				return
+			}
+			state, err := p.Wait()
+			if log.Check(log.DebugLevel, "Waiting for attached process", err) {
+				exitCode = -1
+				return
+			}
+			exitCode = state.Sys().(syscall.WaitStatus).ExitStatus()
+		}()
+	}
 
 	stdout := make(chan string)
 	stderr := make(chan string)
@@ -304,16 +418,15 @@ func AttachContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	go outputReader(rep, stderr)
 
 	var response = genericResponse(req)
+	response.Pid = pid
 	outputSender(stdout, stderr, outCh, &response)
-	if exitCode == 0 {
-		response.Type = "EXECUTE_RESPONSE"
-		response.ExitCode = strconv.Itoa(exitCode)
-	} else {
-		if exitCode/256 == 124 {
-			response.Type = "EXECUTE_TIMEOUT"
-		}
-		response.ExitCode = strconv.Itoa(exitCode / 256)
+	<-done
+	if unregister(req.CommandID) {
+		response.Type = "EXECUTE_TERMINATED"
+	} else if exitCode == 124 {
+		response.Type = "EXECUTE_TIMEOUT"
 	}
+	response.ExitCode = strconv.Itoa(exitCode)
 
 	outCh <- response
 
@@ -0,0 +1,137 @@
+package executer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/subutai-io/agent/lib/cgroup"
+	"github.com/subutai-io/agent/log"
+)
+
+// limited returns true if request contains resource limits
+func limited(req RequestOptions) bool {
+	return req.CPULimit > 0 || req.MemoryLimit > 0 || req.IOLimit > 0
+}
+
+// limits is a transient control group created for single host command to enforce requested limits
+type limits struct {
+	*cgroup.Group
+	memory int
+}
+
+// newLimits creates control group for the command under "subutai" group:
+//	CPU limit is a percent of single core set as CFS quota
+//	memory limit is in megabytes, swap is limited too if swap accounting is enabled
+//	IO limit is in bytes per second for reading and writing on each block device
+func newLimits(req RequestOptions) (*limits, error) {
+	l := &limits{Group: cgroup.NewGroup("exec-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, req.CommandID))}
+
+	var settings []cgroup.Setting
+	if req.CPULimit > 0 {
+		settings = append(settings, cgroup.CPUBandwidth(req.CPULimit*1000, 100000)...)
+	}
+	if req.MemoryLimit > 0 {
+		l.memory = req.MemoryLimit * 1024 * 1024
+		settings = append(settings, cgroup.MemoryLimit(l.memory))
+	}
+	if req.IOLimit > 0 {
+		for _, dev := range blockDevices() {
+			settings = append(settings, cgroup.IOLimit(dev, "rbps", req.IOLimit), cgroup.IOLimit(dev, "wbps", req.IOLimit))
+		}
+	}
+	for _, s := range settings {
+		if err := l.Set(s); err != nil {
+			l.remove()
+			return nil, err
+		}
+	}
+	if l.memory > 0 {
+		log.Check(log.DebugLevel, "Limiting swap usage", l.Set(cgroup.NoSwap(l.memory)))
+	}
+	return l, nil
+}
+
+// joinScript writes pid of the shell to every file passed before "--" and replaces the shell with the rest of arguments
+const joinScript = `while [ "$1" != -- ]; do echo $$ > "$1" || exit 125; shift; done; shift; exec "$@"`
+
+// wrap makes command join the control group before it is executed, so neither the command nor processes it forks
+// run without limits. Shell running as root joins the group and executes the command with requested credentials.
+// If the group can't be joined, the command is not executed and exit code is 125.
+func (l *limits) wrap(cmd *exec.Cmd) {
+	args := append([]string{"/bin/sh", "-c", joinScript, "sh"}, l.Procs()...)
+	args = append(args, "--")
+	if c := cmd.SysProcAttr.Credential; c != nil && (c.Uid != 0 || c.Gid != 0) {
+		args = append(args, "setpriv", "--reuid="+strconv.Itoa(int(c.Uid)), "--regid="+strconv.Itoa(int(c.Gid)), "--clear-groups", "--")
+		cmd.SysProcAttr.Credential = nil
+	}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/bin/sh"
+}
+
+// oom returns true if command was killed by OOM killer because of memory limit
+func (l *limits) oom(state *os.ProcessState) bool {
+	if l.memory == 0 {
+		return false
+	}
+	if kills, ok := l.OOMKills(); ok {
+		return kills > 0
+	}
+	// older kernels do not count OOM kills, guess by SIGKILL after reaching the limit
+	if state == nil {
+		return false
+	}
+	status := state.Sys().(syscall.WaitStatus)
+	if !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		return false
+	}
+	peak, err := l.MemoryPeak()
+	return err == nil && peak >= l.memory
+}
+
+func (l *limits) remove() {
+	log.Check(log.WarnLevel, "Removing control group of command", l.Remove())
+}
+
+// blockDevices returns "major:minor" numbers of physical block devices
+func blockDevices() (list []string) {
+	devices, err := ioutil.ReadDir("/sys/block")
+	if log.Check(log.WarnLevel, "Reading block devices", err) {
+		return
+	}
+	for _, dev := range devices {
+		if strings.HasPrefix(dev.Name(), "loop") || strings.HasPrefix(dev.Name(), "ram") {
+			continue
+		}
+		if num, err := ioutil.ReadFile(filepath.Join("/sys/block", dev.Name(), "dev")); err == nil {
+			list = append(list, strings.TrimSpace(string(num)))
+		}
+	}
+	return
+}
+
+// outputLimiter passes command output through until total size reaches the limit,
+// then calls exceed for every discarded chunk and drops the rest, so the command is not blocked on full pipe.
+func outputLimiter(in <-chan string, out chan<- string, total *int64, limit int64, exceed func()) {
+	for buf := range in {
+		if n := atomic.AddInt64(total, int64(len(buf))); n > limit {
+			exceed()
+			if keep := int64(len(buf)) - (n - limit); keep > 0 {
+				out <- buf[:keep]
+			}
+			continue
+		}
+		out <- buf
+	}
+	close(out)
+}
@@ -0,0 +1,95 @@
+package executer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/subutai-io/agent/lib/cgroup"
+)
+
+func TestOutputLimiter(t *testing.T) {
+	tests := []struct {
+		chunks  []string
+		limit   int64
+		want    string
+		exceeds int
+	}{
+		{[]string{"abc", "def"}, 10, "abcdef", 0},
+		{[]string{"abc", "def"}, 6, "abcdef", 0},
+		{[]string{"abc", "def", "gh"}, 5, "abcde", 2},
+		{[]string{"abcdef"}, 0, "", 1},
+	}
+	for _, tt := range tests {
+		in, out := make(chan string), make(chan string)
+		var total int64
+		exceeds := 0
+		go outputLimiter(in, out, &total, tt.limit, func() { exceeds++ })
+		go func() {
+			for _, c := range tt.chunks {
+				in <- c
+			}
+			close(in)
+		}()
+		got := ""
+		for buf := range out {
+			got += buf
+		}
+		if got != tt.want || exceeds != tt.exceeds {
+			t.Errorf("%v with limit %d: output %q, exceeded %d times, want %q, %d", tt.chunks, tt.limit, got, exceeds, tt.want, tt.exceeds)
+		}
+	}
+}
+
+func TestJoinScript(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "procs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	procs := []string{path.Join(tmp, "cpu.procs"), path.Join(tmp, "memory.procs")}
+
+	// command replaces the shell, so pid written to the groups is pid of the command
+	args := append(append([]string{"-c", joinScript, "sh"}, procs...), "--", "sh", "-c", "echo $$")
+	out, err := exec.Command("/bin/sh", args...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range procs {
+		if pid, _ := ioutil.ReadFile(p); string(pid) != string(out) {
+			t.Errorf("%s contains %q, want pid of command %q", p, pid, out)
+		}
+	}
+
+	err = exec.Command("/bin/sh", "-c", joinScript, "sh", path.Join(tmp, "missing", "cgroup.procs"), "--", "true").Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.Sys().(syscall.WaitStatus).ExitStatus() != 125 {
+		t.Errorf("command is executed out of group which can't be joined, error %v", err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	l := &limits{Group: cgroup.NewGroup("exec-test")}
+
+	cmd := exec.Command("/bin/bash", "-c", "id")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 1000, Gid: 100}}
+	l.wrap(cmd)
+	if cmd.Path != "/bin/sh" || cmd.SysProcAttr.Credential != nil {
+		t.Errorf("wrapped command is not run by root shell: %s, %+v", cmd.Path, cmd.SysProcAttr.Credential)
+	}
+	want := "/bin/sh -c " + joinScript + " sh -- setpriv --reuid=1000 --regid=100 --clear-groups -- /bin/bash -c id"
+	if got := strings.Join(cmd.Args, " "); got != want {
+		t.Errorf("wrapped command %q, want %q", got, want)
+	}
+
+	cmd = exec.Command("/bin/bash", "-c", "id")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{}}
+	l.wrap(cmd)
+	if want := []string{"/bin/sh", "-c", joinScript, "sh", "--", "/bin/bash", "-c", "id"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("command of root is wrapped with %v", cmd.Args)
+	}
+}
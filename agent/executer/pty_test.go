@@ -0,0 +1,62 @@
+package executer
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPty(t *testing.T) {
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skip("pseudo-terminals are not available: ", err)
+	}
+	defer master.Close()
+
+	if err = resizePty(master, 30, 100); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	if err = cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	slave.Close()
+
+	var out []byte
+	buf := make([]byte, 256)
+	// read deadline stops reading even if the terminal is kept open
+	master.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, err := master.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if got := strings.TrimSpace(string(out)); got != "30 100" {
+		t.Errorf("terminal size = %q, want \"30 100\"", got)
+	}
+}
+
+func TestExecHostStdin(t *testing.T) {
+	usr, err := user.Current()
+	if err != nil || os.Geteuid() != 0 {
+		t.Skip("commands are executed with credentials of requested user, which requires root")
+	}
+
+	outCh := make(chan ResponseOptions)
+	go ExecHost(RequestOptions{CommandID: "stdin", Command: "tr a-z A-Z", RunAs: usr.Username, Timeout: 10, StdIn: "hello\n"}, outCh)
+
+	var stdout, code string
+	for r := range outCh {
+		stdout += r.StdOut
+		code = r.ExitCode
+	}
+	if stdout != "HELLO\n" || code != "0" {
+		t.Errorf("output %q, exit code %s, want \"HELLO\\n\", 0", stdout, code)
+	}
+}
@@ -0,0 +1,184 @@
+package executer
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/log"
+)
+
+// process describes running command registered for process control requests
+type process struct {
+	req       RequestOptions
+	container string
+	pid       int
+	group     bool
+	started   time.Time
+	cancelled bool
+	// terminal of interactive session
+	pty *os.File
+}
+
+// ProcessInfo describes running command in response to STATUS request.
+type ProcessInfo struct {
+	CommandID string   `json:"commandId"`
+	Pid       int      `json:"pid"`
+	Container string   `json:"container,omitempty"`
+	Command   string   `json:"command"`
+	Args      []string `json:"args,omitempty"`
+	IsDaemon  int      `json:"isDaemon"`
+	Started   string   `json:"started"`
+}
+
+var (
+	processes = make(map[string]*process)
+	registry  sync.Mutex
+	// delay between SIGTERM and SIGKILL on terminate request
+	killTimeout = 10 * time.Second
+)
+
+// register adds running command to registry. If group is set, signals are sent to the whole process group.
+// Terminal of interactive session receives input and resize requests.
+func register(req RequestOptions, container string, pid int, group bool, pty *os.File) {
+	registry.Lock()
+	defer registry.Unlock()
+	processes[req.CommandID] = &process{req: req, container: container, pid: pid, group: group, started: time.Now(), pty: pty}
+}
+
+// unregister removes finished command from registry and returns true if the command was terminated by request
+func unregister(commandID string) bool {
+	registry.Lock()
+	defer registry.Unlock()
+	p, ok := processes[commandID]
+	delete(processes, commandID)
+	return ok && p.cancelled
+}
+
+func (p *process) signal(sig syscall.Signal) error {
+	if p.group {
+		return syscall.Kill(-p.pid, sig)
+	}
+	return syscall.Kill(p.pid, sig)
+}
+
+// lookup returns registered command visible for request target: commands of the container or all commands for Resource Host
+func lookup(commandID, container string) *process {
+	if p, ok := processes[commandID]; ok && (container == "" || p.container == container) {
+		return p
+	}
+	return nil
+}
+
+// Registered returns request of running command visible for request target, false if there is no such command
+func Registered(commandID, container string) (RequestOptions, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	if p := lookup(commandID, container); p != nil {
+		return p.req, true
+	}
+	return RequestOptions{}, false
+}
+
+// IsControl returns true if request controls running command instead of starting new one.
+func IsControl(req RequestOptions) bool {
+	return req.Type == "TERMINATE" || req.Type == "SIGNAL" || req.Type == "STATUS" || req.Type == "INPUT" || req.Type == "RESIZE"
+}
+
+// Control handles process control requests for commands running on Resource Host or inside container:
+//	TERMINATE, stops command with SIGTERM followed by SIGKILL, final response of the command has type EXECUTE_TERMINATED
+//	SIGNAL, sends signal number from "signal" field to the command
+//	STATUS, returns JSON list of running commands including daemons, or single command if "targetCommandId" is set
+//	INPUT, writes "stdIn" field to terminal of interactive session
+//	RESIZE, sets terminal size of interactive session to "rows" and "cols"
+func Control(container string, req RequestOptions, outCh chan<- ResponseOptions) {
+	defer close(outCh)
+
+	response := genericResponse(req)
+	response.ExitCode = "0"
+
+	// terminal input is written after the registry is unlocked, so a full terminal doesn't block other requests
+	var input *os.File
+	defer func() {
+		if input != nil {
+			input.SetWriteDeadline(time.Now().Add(killTimeout))
+			if _, err := input.WriteString(req.StdIn); log.Check(log.WarnLevel, "INPUT command "+req.Target, err) {
+				response.ExitCode = "1"
+				response.StdErr = err.Error()
+			}
+		}
+		outCh <- response
+	}()
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if req.Type == "STATUS" {
+		list := []ProcessInfo{}
+		for id, p := range processes {
+			if (req.Target == "" || req.Target == id) && (container == "" || p.container == container) {
+				list = append(list, ProcessInfo{
+					CommandID: id,
+					Pid:       p.pid,
+					Container: p.container,
+					Command:   p.req.Command,
+					Args:      p.req.Args,
+					IsDaemon:  p.req.IsDaemon,
+					Started:   p.started.Format(time.RFC3339),
+				})
+			}
+		}
+		out, err := json.Marshal(list)
+		log.Check(log.WarnLevel, "Marshal process list", err)
+		response.StdOut = string(out)
+		return
+	}
+
+	p := lookup(req.Target, container)
+	if p == nil {
+		response.ExitCode = "1"
+		response.StdErr = "Command " + req.Target + " is not running"
+		return
+	}
+	response.Pid = p.pid
+
+	if (req.Type == "INPUT" || req.Type == "RESIZE") && p.pty == nil {
+		response.ExitCode = "1"
+		response.StdErr = "Command " + req.Target + " is not an interactive session"
+		return
+	}
+
+	var err error
+	switch req.Type {
+	case "INPUT":
+		input = p.pty
+	case "RESIZE":
+		err = resizePty(p.pty, req.Rows, req.Cols)
+	case "SIGNAL":
+		if req.Signal <= 0 {
+			response.ExitCode = "1"
+			response.StdErr = "Invalid signal " + strconv.Itoa(req.Signal)
+			return
+		}
+		err = p.signal(syscall.Signal(req.Signal))
+	case "TERMINATE":
+		p.cancelled = true
+		err = p.signal(syscall.SIGTERM)
+		go func(id string) {
+			time.Sleep(killTimeout)
+			registry.Lock()
+			defer registry.Unlock()
+			if p, ok := processes[id]; ok {
+				log.Check(log.DebugLevel, "Killing command "+id, p.signal(syscall.SIGKILL))
+			}
+		}(req.Target)
+	}
+
+	if log.Check(log.WarnLevel, req.Type+" command "+req.Target, err) {
+		response.ExitCode = "1"
+		response.StdErr = err.Error()
+	}
+}
@@ -0,0 +1,86 @@
+package executer
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func control(container string, req RequestOptions) ResponseOptions {
+	outCh := make(chan ResponseOptions, 1)
+	Control(container, req, outCh)
+	return <-outCh
+}
+
+func TestControl(t *testing.T) {
+	defer func(d time.Duration) { killTimeout = d }(killTimeout)
+	killTimeout = 100 * time.Millisecond
+
+	// shell ignoring SIGTERM is stopped by SIGKILL after killTimeout
+	cmd := exec.Command("sh", "-c", "trap '' TERM; while true; do sleep 0.01; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	register(RequestOptions{CommandID: "cmd", Command: "sh"}, "", cmd.Process.Pid, false, nil)
+
+	tests := []struct {
+		name      string
+		container string
+		req       RequestOptions
+		code      string
+		out       string
+	}{
+		{"status", "", RequestOptions{Type: "STATUS"}, "0", `"commandId":"cmd"`},
+		{"status of container", "foo", RequestOptions{Type: "STATUS"}, "0", "[]"},
+		{"status of other command", "", RequestOptions{Type: "STATUS", Target: "other"}, "0", "[]"},
+		{"signal to other container", "foo", RequestOptions{Type: "SIGNAL", Target: "cmd", Signal: 10}, "1", "is not running"},
+		{"invalid signal", "", RequestOptions{Type: "SIGNAL", Target: "cmd"}, "1", "Invalid signal"},
+		{"input of non-interactive command", "", RequestOptions{Type: "INPUT", Target: "cmd", StdIn: "x"}, "1", "not an interactive session"},
+		{"terminate", "", RequestOptions{Type: "TERMINATE", Target: "cmd"}, "0", ""},
+	}
+	for _, tt := range tests {
+		res := control(tt.container, tt.req)
+		out := res.StdOut + res.StdErr
+		if res.ExitCode != tt.code || !strings.Contains(out, tt.out) {
+			t.Errorf("%s: exit code %s, output %q, want %s, %q", tt.name, res.ExitCode, out, tt.code, tt.out)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("terminated command is still running")
+	}
+	if !unregister("cmd") {
+		t.Error("terminated command is not reported as cancelled")
+	}
+	if unregister("cmd") {
+		t.Error("command is unregistered twice")
+	}
+}
+
+func TestControlStatus(t *testing.T) {
+	register(RequestOptions{CommandID: "a", Command: "top", IsDaemon: 1}, "foo", 100, false, nil)
+	register(RequestOptions{CommandID: "b", Command: "ls"}, "bar", 101, false, nil)
+	defer unregister("a")
+	defer unregister("b")
+
+	var list []ProcessInfo
+	if err := json.Unmarshal([]byte(control("foo", RequestOptions{Type: "STATUS"}).StdOut), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].CommandID != "a" || list[0].Pid != 100 || list[0].IsDaemon != 1 {
+		t.Errorf("status of container foo = %+v", list)
+	}
+	if req, ok := Registered("b", ""); !ok || req.Command != "ls" {
+		t.Error("command of container is not visible for Resource Host")
+	}
+	if _, ok := Registered("b", "foo"); ok {
+		t.Error("command of container bar is visible for container foo")
+	}
+}
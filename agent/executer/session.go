@@ -0,0 +1,101 @@
+package executer
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/subutai-io/agent/agent/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// session runs interactive command inside container attached to pseudo-terminal.
+// Terminal output is sent as soon as it is read; input and window size are passed with INPUT and RESIZE requests.
+// If command is not specified, login shell is started.
+func session(c *lxc.Container, name string, req RequestOptions, outCh chan<- ResponseOptions) error {
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	if req.Rows > 0 && req.Cols > 0 {
+		log.Check(log.DebugLevel, "Setting terminal size", resizePty(master, req.Rows, req.Cols))
+	}
+
+	opts := lxc.DefaultAttachOptions
+	opts.UID, opts.GID = container.Credentials(req.RunAs, name)
+	opts.StdinFd = slave.Fd()
+	opts.StdoutFd = slave.Fd()
+	opts.StderrFd = slave.Fd()
+	opts.Cwd = req.WorkingDir
+	opts.ClearEnv = true
+	opts.Env = []string{"TERM=xterm", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+
+	command := []string{"/bin/bash", "-l"}
+	if len(req.Command) > 0 {
+		command = append([]string{req.Command}, req.Args...)
+	}
+
+	// setsid makes the terminal controlling one for the session
+	args := append([]string{"timeout", strconv.Itoa(req.Timeout), "setsid", "-c"}, command...)
+	log.Debug("Starting interactive session in container " + name)
+	pid, err := c.RunCommandNoWait(args, opts)
+	log.Check(log.DebugLevel, "Closing terminal slave", slave.Close())
+
+	var response = genericResponse(req)
+	response.Pid = pid
+
+	exitCode := -1
+	done := make(chan bool)
+	if log.Check(log.WarnLevel, "Starting interactive session inside container", err) {
+		close(done)
+	} else {
+		register(req, name, pid, false, master)
+		go func() {
+			defer close(done)
+			p, err := os.FindProcess(pid)
+			if log.Check(log.DebugLevel, "Finding session process", err) {
+				return
+			}
+			state, err := p.Wait()
+			if !log.Check(log.DebugLevel, "Waiting for session process", err) {
+				exitCode = state.Sys().(syscall.WaitStatus).ExitStatus()
+			}
+			// background processes may keep terminal open, stop reading shortly after session exit
+			master.SetReadDeadline(time.Now().Add(time.Second))
+		}()
+
+		buf := make([]byte, 4096)
+		for {
+			// reading fails with EIO once all processes holding the terminal exit
+			n, err := master.Read(buf)
+			if n > 0 {
+				response.StdOut = string(buf[:n])
+				ok := send(outCh, &response)
+				response.StdOut = ""
+				response.ResponseNumber++
+				if !ok {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	<-done
+	if unregister(req.CommandID) {
+		response.Type = "EXECUTE_TERMINATED"
+	} else if exitCode == 124 {
+		response.Type = "EXECUTE_TIMEOUT"
+	}
+	response.ExitCode = strconv.Itoa(exitCode)
+	outCh <- response
+
+	return nil
+}
@@ -0,0 +1,409 @@
+// Package migration receives Subutai containers migrated from other Resource Hosts.
+//
+// Container is transferred as a chain of backup archives (see "backup" command) uploaded in chunks to agent HTTPS server
+// and restored on the receiving side by "restore" command. Both sides authenticate each other with HMAC signatures
+// based on shared secret from [migration] section of agent configuration; receiving is disabled if secret is empty.
+// Signed requests include fingerprint of receiver TLS certificate, so they can't be relayed through another TLS endpoint,
+// and a nonce, so they can't be replayed.
+package migration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/agent/utils"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// Job describes state of backup archive restore on receiving Resource Host
+type Job struct {
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+}
+
+// Port of agent HTTPS server receiving migrated containers
+const Port = "7071"
+
+// maxBody is a size limit of request body, it is larger than archive chunk sent by migrate command
+const maxBody = 16 * 1024 * 1024
+
+var (
+	jobs = make(map[string]*Job)
+	// received are containers which migration has begun, but the final archive is not restored yet,
+	// they may be destroyed by sender if migration fails
+	received = make(map[string]bool)
+	mutex    sync.Mutex
+	// maximum allowed difference between sender and receiver clocks
+	skew = 5 * time.Minute
+	// request parameters covered by signature
+	params = []string{"File", "Offset", "Start", "Final", "Nonce", "Certificate"}
+	// nonces of accepted requests by their arrival time, kept while request timestamp is valid
+	nonces = make(map[string]time.Time)
+)
+
+// Signature returns HMAC-SHA256 of message parts signed with migration secret
+func Signature(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(config.Migration.Secret))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fingerprint returns SHA-256 fingerprint of DER encoded certificate
+func fingerprint(der []byte) string {
+	return digest(der)
+}
+
+// certificate returns fingerprint of Resource Host certificate used by agent HTTPS server
+func certificate() string {
+	if block, _ := pem.Decode([]byte(utils.PublicCert())); block != nil {
+		return fingerprint(block.Bytes)
+	}
+	return ""
+}
+
+func nonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// fresh records nonce of authenticated request, it returns false if the nonce was already used
+func fresh(n string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for k, t := range nonces {
+		if time.Since(t) > 2*skew {
+			delete(nonces, k)
+		}
+	}
+	if _, ok := nonces[n]; ok || len(n) == 0 {
+		return false
+	}
+	nonces[n] = time.Now()
+	return true
+}
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// requestSignature signs request path, container name, timestamp, parameters and body
+func requestSignature(url string, header http.Header, body []byte) string {
+	parts := []string{url, header.Get("X-Subutai-Container"), header.Get("X-Subutai-Timestamp")}
+	for _, p := range params {
+		parts = append(parts, header.Get("X-Subutai-"+p))
+	}
+	return Signature(append(parts, digest(body))...)
+}
+
+// Request sends signed request to migration endpoint of remote agent and verifies that response is signed by the same secret.
+// Headers are passed as "X-Subutai-" prefixed HTTP headers.
+func Request(remote, action, name string, headers map[string]string, body []byte) ([]byte, error) {
+	if len(config.Migration.Secret) == 0 {
+		return nil, errors.New("Migration secret is not configured")
+	}
+	if !strings.Contains(remote, ":") {
+		remote += ":" + Port
+	}
+
+	// certificate of Resource Host is self-signed, it is authenticated by including its fingerprint to signed request
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", remote, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	peer := conn.ConnectionState().PeerCertificates
+	if len(peer) == 0 {
+		return nil, errors.New("No certificate presented by " + remote)
+	}
+
+	url := "/migrate/" + action
+	req, err := http.NewRequest(http.MethodPost, "https://"+remote+url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	n, err := nonce()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set("X-Subutai-"+k, v)
+	}
+	req.Header.Set("X-Subutai-Container", name)
+	req.Header.Set("X-Subutai-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set("X-Subutai-Nonce", n)
+	req.Header.Set("X-Subutai-Certificate", fingerprint(peer[0].Raw))
+	signature := requestSignature(url, req.Header, body)
+	req.Header.Set("X-Subutai-Signature", signature)
+
+	client := &http.Client{Timeout: time.Minute, Transport: &http.Transport{
+		DialTLS:           func(network, addr string) (net.Conn, error) { return conn, nil },
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(resp.Header.Get("X-Subutai-Signature")), []byte(Signature("ack", signature, strconv.Itoa(resp.StatusCode), digest(out)))) {
+		return nil, errors.New("Failed to authenticate " + remote)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, errors.New(remote + " " + resp.Status + ": " + string(out))
+	}
+	return out, nil
+}
+
+// authenticate reads and verifies signed request returning container name and request body
+func authenticate(rw http.ResponseWriter, request *http.Request) (name string, body []byte, ok bool) {
+	if request.Method != http.MethodPost || len(config.Migration.Secret) == 0 {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	ts, err := strconv.ParseInt(request.Header.Get("X-Subutai-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > skew || time.Until(time.Unix(ts, 0)) > skew {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err = ioutil.ReadAll(http.MaxBytesReader(rw, request.Body, maxBody))
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name = request.Header.Get("X-Subutai-Container")
+	if !hmac.Equal([]byte(request.Header.Get("X-Subutai-Signature")), []byte(requestSignature(request.URL.Path, request.Header, body))) ||
+		request.Header.Get("X-Subutai-Certificate") != certificate() {
+		log.Warn("Rejected migration request from " + request.RemoteAddr)
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !fresh(request.Header.Get("X-Subutai-Nonce")) {
+		log.Warn("Rejected replayed migration request from " + request.RemoteAddr)
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if len(name) == 0 || name == ".." || strings.ContainsAny(name, "/ ") {
+		reply(rw, request, http.StatusBadRequest, []byte("Invalid container name"))
+		return
+	}
+
+	return name, body, true
+}
+
+// reply writes response signed with migration secret
+func reply(rw http.ResponseWriter, request *http.Request, status int, body []byte) {
+	rw.Header().Set("X-Subutai-Signature", Signature("ack", request.Header.Get("X-Subutai-Signature"), strconv.Itoa(status), digest(body)))
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+// archivePath returns location of uploaded backup archive in agent cache directory
+func archivePath(name, file string) (string, error) {
+	file = filepath.Base(file)
+	if !strings.HasPrefix(file, name+"-backup_") || !strings.HasSuffix(file, ".tar.gz") {
+		return "", errors.New("Invalid archive name " + file)
+	}
+	return path.Join(config.Agent.CacheDir, "migrate", file), nil
+}
+
+// BeginHandler checks that container can be received and prepares upload directory.
+func BeginHandler(rw http.ResponseWriter, request *http.Request) {
+	name, _, ok := authenticate(rw, request)
+	if !ok {
+		return
+	}
+
+	if container.LxcInstanceExists(name) {
+		reply(rw, request, http.StatusConflict, []byte("Container "+name+" already exists"))
+		return
+	}
+
+	mutex.Lock()
+	if job, ok := jobs[name]; ok && job.Status == "running" {
+		mutex.Unlock()
+		reply(rw, request, http.StatusConflict, []byte("Migration of "+name+" is in progress"))
+		return
+	}
+	delete(jobs, name)
+	received[name] = true
+	mutex.Unlock()
+
+	if err := os.MkdirAll(path.Join(config.Agent.CacheDir, "migrate"), 0700); err != nil {
+		reply(rw, request, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	reply(rw, request, http.StatusOK, nil)
+}
+
+// UploadHandler appends chunk of backup archive to file. Offset of the chunk must match current file size.
+func UploadHandler(rw http.ResponseWriter, request *http.Request) {
+	name, body, ok := authenticate(rw, request)
+	if !ok {
+		return
+	}
+
+	file, err := archivePath(name, request.Header.Get("X-Subutai-File"))
+	if err != nil {
+		reply(rw, request, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	offset, err := strconv.ParseInt(request.Header.Get("X-Subutai-Offset"), 10, 64)
+	if err != nil {
+		reply(rw, request, http.StatusBadRequest, []byte("Invalid offset"))
+		return
+	}
+
+	if offset == 0 {
+		os.Remove(file)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		reply(rw, request, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.Size() != offset {
+		reply(rw, request, http.StatusConflict, []byte("Unexpected offset "+strconv.FormatInt(offset, 10)))
+		return
+	}
+	if _, err = f.Write(body); err != nil {
+		reply(rw, request, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	reply(rw, request, http.StatusOK, nil)
+}
+
+// RestoreHandler starts restore of uploaded backup archive in background.
+// Header "X-Subutai-Start" set to "true" starts container after restore,
+// "X-Subutai-Final" set to "true" completes migration, so the container can't be aborted anymore.
+func RestoreHandler(rw http.ResponseWriter, request *http.Request) {
+	name, _, ok := authenticate(rw, request)
+	if !ok {
+		return
+	}
+
+	file, err := archivePath(name, request.Header.Get("X-Subutai-File"))
+	if err != nil {
+		reply(rw, request, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if job, ok := jobs[name]; ok && job.Status == "running" {
+		reply(rw, request, http.StatusConflict, []byte("Migration of "+name+" is in progress"))
+		return
+	}
+	job := &Job{Status: "running"}
+	jobs[name] = job
+
+	args := []string{"restore", file}
+	if request.Header.Get("X-Subutai-Start") != "true" {
+		args = []string{"restore", "-s", file}
+	}
+	final := request.Header.Get("X-Subutai-Final") == "true"
+	go func() {
+		out, err := exec.Command("subutai", args...).CombinedOutput()
+		log.Check(log.WarnLevel, "Removing migrated archive "+file, os.Remove(file))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		job.Output = string(out)
+		if log.Check(log.WarnLevel, "Restoring migrated container "+name+": "+job.Output, err) {
+			job.Status = "failed"
+		} else {
+			job.Status = "done"
+			if final {
+				delete(received, name)
+			}
+		}
+	}()
+
+	reply(rw, request, http.StatusAccepted, nil)
+}
+
+// AbortHandler destroys partially received container when migration fails on sending side.
+// Only containers created by migration which is not completed yet are destroyed.
+func AbortHandler(rw http.ResponseWriter, request *http.Request) {
+	name, _, ok := authenticate(rw, request)
+	if !ok {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if job, ok := jobs[name]; ok && job.Status == "running" {
+		reply(rw, request, http.StatusConflict, []byte("Migration of "+name+" is in progress"))
+		return
+	}
+	if !received[name] {
+		reply(rw, request, http.StatusConflict, []byte("Container "+name+" is not being migrated"))
+		return
+	}
+
+	if container.LxcInstanceExists(name) {
+		if out, err := exec.Command("subutai", "destroy", name).CombinedOutput(); err != nil {
+			reply(rw, request, http.StatusInternalServerError, out)
+			return
+		}
+	}
+	delete(received, name)
+	delete(jobs, name)
+	log.Info("Migration of " + name + " aborted by " + request.RemoteAddr)
+	reply(rw, request, http.StatusOK, nil)
+}
+
+// StatusHandler returns state of the last restore job for container.
+func StatusHandler(rw http.ResponseWriter, request *http.Request) {
+	name, _, ok := authenticate(rw, request)
+	if !ok {
+		return
+	}
+
+	mutex.Lock()
+	job, ok := jobs[name]
+	if !ok {
+		job = &Job{Status: "none"}
+	}
+	out, err := json.Marshal(job)
+	mutex.Unlock()
+
+	if err != nil {
+		reply(rw, request, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	reply(rw, request, http.StatusOK, out)
+}
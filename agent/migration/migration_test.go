@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+)
+
+// signed returns migration request signed with the secret, modify changes it after signing
+func signed(name, nonce string, ts time.Time, body []byte, modify func(r *http.Request)) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/migrate/begin", bytes.NewReader(body))
+	r.Header.Set("X-Subutai-Container", name)
+	r.Header.Set("X-Subutai-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	r.Header.Set("X-Subutai-Nonce", nonce)
+	r.Header.Set("X-Subutai-Certificate", certificate())
+	r.Header.Set("X-Subutai-Signature", requestSignature(r.URL.Path, r.Header, body))
+	if modify != nil {
+		modify(r)
+	}
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	defer func(s string) { config.Migration.Secret = s }(config.Migration.Secret)
+	config.Migration.Secret = "secret"
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{"valid", signed("foo", "1", now, []byte("data"), nil), http.StatusOK},
+		{"replayed", signed("foo", "1", now, []byte("data"), nil), http.StatusForbidden},
+		{"empty nonce", signed("foo", "", now, nil, nil), http.StatusForbidden},
+		{"not POST", signed("foo", "2", now, nil, func(r *http.Request) { r.Method = http.MethodGet }), http.StatusForbidden},
+		{"expired", signed("foo", "3", now.Add(-2*skew), nil, nil), http.StatusForbidden},
+		{"from future", signed("foo", "4", now.Add(2*skew), nil, nil), http.StatusForbidden},
+		{"other container", signed("foo", "5", now, nil, func(r *http.Request) {
+			r.Header.Set("X-Subutai-Container", "bar")
+		}), http.StatusForbidden},
+		{"other certificate", signed("foo", "6", now, nil, func(r *http.Request) {
+			r.Header.Set("X-Subutai-Certificate", "relay")
+		}), http.StatusForbidden},
+		{"body too large", signed("foo", "7", now, make([]byte, maxBody+1), nil), http.StatusBadRequest},
+		{"invalid name", signed("..", "8", now, nil, nil), http.StatusBadRequest},
+		{"name with slash", signed("foo/bar", "9", now, nil, nil), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		_, _, ok := authenticate(rw, tt.request)
+		if ok != (tt.status == http.StatusOK) || rw.Code != tt.status {
+			t.Errorf("%s: ok %v, status %d, want %d", tt.name, ok, rw.Code, tt.status)
+		}
+	}
+
+	config.Migration.Secret = ""
+	rw := httptest.NewRecorder()
+	if _, _, ok := authenticate(rw, signed("foo", "10", now, nil, nil)); ok || rw.Code != http.StatusForbidden {
+		t.Errorf("request is accepted with migration disabled, status %d", rw.Code)
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+		err  bool
+	}{
+		{"foo", "foo-backup_20180102-150405.tar.gz", "foo-backup_20180102-150405.tar.gz", false},
+		// directories are dropped, so upload can't escape cache directory
+		{"foo", "../../etc/foo-backup_1.tar.gz", "foo-backup_1.tar.gz", false},
+		{"foo", "bar-backup_1.tar.gz", "", true},
+		{"foo", "foo-backup_1.tar", "", true},
+	}
+	for _, tt := range tests {
+		got, err := archivePath(tt.name, tt.file)
+		if (err != nil) != tt.err {
+			t.Errorf("archivePath(%q, %q): unexpected error %v", tt.name, tt.file, err)
+		} else if !tt.err && got != path.Join(config.Agent.CacheDir, "migrate", tt.want) {
+			t.Errorf("archivePath(%q, %q) = %q", tt.name, tt.file, got)
+		}
+	}
+}
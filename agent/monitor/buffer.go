@@ -0,0 +1,150 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/log"
+)
+
+// buffered sink keeps batches which could not be written in files of on-disk buffer and replays them
+// with original timestamps after the next successful write. Batches rejected by the server are dropped.
+// Oldest batches are dropped when the buffer grows over maxSize bytes or they get older than maxAge.
+type buffered struct {
+	Sink
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	// dropped counts points removed from buffer or rejected by the server since agent start
+	dropped int
+}
+
+func newBuffered(sink Sink, dir string, maxSize int64, maxAge time.Duration) *buffered {
+	log.Check(log.WarnLevel, "Creating metrics buffer "+dir, os.MkdirAll(dir, 0700))
+	return &buffered{Sink: sink, dir: dir, maxSize: maxSize, maxAge: maxAge}
+}
+
+func (b *buffered) Write(points []Point) error {
+	defer b.stat()
+
+	err := b.Sink.Write(points)
+	if _, ok := err.(rejected); ok {
+		log.Warn("Dropping metrics batch rejected by "+b.Name()+", ", err)
+		b.dropped += len(points)
+	} else if err != nil {
+		log.Check(log.WarnLevel, "Buffering metrics batch", b.push(points))
+		return err
+	}
+	if replayErr := b.replay(); err == nil {
+		err = replayErr
+	}
+	return err
+}
+
+// push saves batch to file named by creation time and number of points, e.g. "00000001536139155000000000-120.json"
+func (b *buffered) push(points []Point) error {
+	data, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%026d-%d.json", time.Now().UnixNano(), len(points))
+	if err = ioutil.WriteFile(path.Join(b.dir, name), data, 0600); err != nil {
+		return err
+	}
+	b.trim()
+	return nil
+}
+
+// replay writes buffered batches starting from the oldest one until the server is unreachable,
+// rejected batch is dropped, so it doesn't block newer ones
+func (b *buffered) replay() error {
+	b.trim()
+	for _, f := range b.files() {
+		data, err := ioutil.ReadFile(path.Join(b.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		var points []Point
+		if err = json.Unmarshal(data, &points); err != nil {
+			log.Warn("Dropping corrupted metrics batch " + f.Name())
+			b.drop(f)
+			continue
+		}
+		if err = b.Sink.Write(points); err != nil {
+			if _, ok := err.(rejected); !ok {
+				return err
+			}
+			log.Warn("Dropping metrics batch "+f.Name()+" rejected by "+b.Name()+", ", err)
+			b.drop(f)
+			continue
+		}
+		log.Check(log.WarnLevel, "Removing replayed metrics batch", os.Remove(path.Join(b.dir, f.Name())))
+	}
+	return nil
+}
+
+// trim drops batches older than maxAge, then the oldest ones until buffer fits maxSize
+func (b *buffered) trim() {
+	files := b.files()
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	for _, f := range files {
+		if size <= b.maxSize && time.Since(created(f)) <= b.maxAge {
+			break
+		}
+		size -= f.Size()
+		b.drop(f)
+	}
+}
+
+func (b *buffered) drop(f os.FileInfo) {
+	if err := os.Remove(path.Join(b.dir, f.Name())); !log.Check(log.WarnLevel, "Dropping metrics batch", err) {
+		b.dropped += count(f)
+	}
+}
+
+// files returns buffered batches ordered by creation time, since zero padded names are sorted by ReadDir
+func (b *buffered) files() (list []os.FileInfo) {
+	files, err := ioutil.ReadDir(b.dir)
+	if log.Check(log.WarnLevel, "Reading metrics buffer", err) {
+		return
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			list = append(list, f)
+		}
+	}
+	return
+}
+
+// stat records number of buffered and dropped points
+func (b *buffered) stat() {
+	buffered := 0
+	for _, f := range b.files() {
+		buffered += count(f)
+	}
+	Record("agent_metrics_buffered", buffered)
+	Record("agent_metrics_dropped", b.dropped)
+}
+
+func created(f os.FileInfo) time.Time {
+	nsec, _ := strconv.ParseInt(strings.Split(f.Name(), "-")[0], 10, 64)
+	return time.Unix(0, nsec)
+}
+
+func count(f os.FileInfo) int {
+	parts := strings.SplitN(strings.TrimSuffix(f.Name(), ".json"), "-", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[1])
+	return n
+}
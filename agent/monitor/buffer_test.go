@@ -0,0 +1,156 @@
+package monitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeSink records written batches, batches with value listed in reject are rejected by the server
+type fakeSink struct {
+	down    bool
+	reject  map[int]bool
+	written []int
+}
+
+func (f *fakeSink) Name() string {
+	return "fake"
+}
+
+func (f *fakeSink) Write(points []Point) error {
+	if f.down {
+		return errors.New("connection refused")
+	}
+	if f.reject[points[0].Value] {
+		return rejected{errors.New("field type conflict")}
+	}
+	f.written = append(f.written, points[0].Value)
+	return nil
+}
+
+func batchOf(value, size int) []Point {
+	points := make([]Point, size)
+	for i := range points {
+		points[i] = Point{Name: "test", Value: value, Time: time.Now()}
+	}
+	return points
+}
+
+func tempBuffer(t *testing.T, sink Sink, maxSize int64, maxAge time.Duration) *buffered {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBuffered(sink, dir, maxSize, maxAge)
+}
+
+func TestBufferedReplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		reject   []int
+		buffered []int
+		written  []int
+		dropped  int
+	}{
+		{name: "nothing buffered", written: []int{100}},
+		{name: "buffered batches are replayed in order", buffered: []int{1, 2, 3}, written: []int{100, 1, 2, 3}},
+		{name: "rejected batch does not block newer ones", reject: []int{2}, buffered: []int{1, 2, 3},
+			written: []int{100, 1, 3}, dropped: 2},
+		{name: "rejected new batch is not buffered", reject: []int{100}, buffered: []int{1}, written: []int{1}, dropped: 2},
+	}
+
+	for _, tt := range tests {
+		sink := &fakeSink{down: true, reject: map[int]bool{}}
+		for _, v := range tt.reject {
+			sink.reject[v] = true
+		}
+		b := tempBuffer(t, sink, 1<<20, time.Hour)
+		defer os.RemoveAll(b.dir)
+
+		for _, v := range tt.buffered {
+			if err := b.Write(batchOf(v, 2)); err == nil {
+				t.Fatalf("%s: write to unreachable sink succeeded", tt.name)
+			}
+		}
+		if n := len(b.files()); n != len(tt.buffered) {
+			t.Errorf("%s: %d batches buffered, want %d", tt.name, n, len(tt.buffered))
+		}
+
+		sink.down = false
+		b.Write(batchOf(100, 2))
+		if !equal(sink.written, tt.written) || b.dropped != tt.dropped {
+			t.Errorf("%s: written %v, dropped %d, want %v, %d", tt.name, sink.written, b.dropped, tt.written, tt.dropped)
+		}
+		if n := len(b.files()); n != 0 {
+			t.Errorf("%s: %d batches left in buffer after replay", tt.name, n)
+		}
+	}
+}
+
+func TestBufferedTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		maxAge  time.Duration
+		wait    time.Duration
+		left    int
+		dropped int
+	}{
+		{name: "buffer fits limits", maxSize: 1 << 20, maxAge: time.Hour, left: 3},
+		{name: "oldest batches are dropped over size", maxSize: 1, maxAge: time.Hour, left: 0, dropped: 9},
+		{name: "expired batches are dropped", maxSize: 1 << 20, maxAge: time.Millisecond, wait: 10 * time.Millisecond, dropped: 9},
+	}
+
+	for _, tt := range tests {
+		b := tempBuffer(t, &fakeSink{down: true}, tt.maxSize, tt.maxAge)
+		defer os.RemoveAll(b.dir)
+
+		for v := 1; v <= 3; v++ {
+			if err := b.push(batchOf(v, 3)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(tt.wait)
+		b.trim()
+		if n := len(b.files()); n != tt.left || b.dropped != tt.dropped {
+			t.Errorf("%s: %d batches left, %d points dropped, want %d, %d", tt.name, n, b.dropped, tt.left, tt.dropped)
+		}
+	}
+}
+
+func TestBufferedTrimKeepsNewest(t *testing.T) {
+	b := tempBuffer(t, &fakeSink{down: true}, 1<<20, time.Hour)
+	defer os.RemoveAll(b.dir)
+
+	for v := 1; v <= 3; v++ {
+		if err := b.push(batchOf(v, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := b.files()
+	b.maxSize = files[len(files)-1].Size()
+	b.trim()
+
+	sink := &fakeSink{}
+	b.Sink = sink
+	if err := b.replay(); err != nil {
+		t.Fatal(err)
+	}
+	if !equal(sink.written, []int{3}) || b.dropped != 2 {
+		t.Errorf("replayed %v, dropped %d points, want [3], 2", sink.written, b.dropped)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,4 +1,4 @@
-// Package monitor gathers system statistics information and sends it to time-series database
+// Package monitor gathers system statistics information and sends it to metrics sinks: time-series database and Prometheus endpoint
 package monitor
 
 import (
@@ -7,130 +7,95 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
-	exc "github.com/subutai-io/agent/lib/exec"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/influxdata/influxdb/client/v2"
-
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/cgroup"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
-	"github.com/subutai-io/agent/agent/utils"
 	"github.com/subutai-io/agent/lib/fs"
-	"path"
 	"github.com/subutai-io/agent/lib/common"
 )
 
 var (
-	traff     = []string{"in", "out"}
-	cgtype    = []string{"cpuacct", "memory"}
-	metrics   = []string{"total", "used", "available"}
-	cpu       = []string{"user", "nice", "system", "idle", "iowait"}
-	lxcmemory = map[string]bool{"cache": true, "rss": true, "Cached": true, "MemFree": true}
-	memory    = map[string]bool{"Active": true, "Buffers": true, "Cached": true, "MemFree": true}
+	traff   = []string{"in", "out"}
+	metrics = []string{"total", "used", "available"}
+	cpu     = []string{"user", "nice", "system", "idle", "iowait"}
+	memory  = map[string]bool{"Active": true, "Buffers": true, "Cached": true, "MemFree": true}
 )
 
 // Collect collecting performance statistic from Resource Host and Subutai Containers.
-// It sends this information to InfluxDB server using credentials from configuration file.
+// Every batch is written to all sinks enabled in configuration file, e.g. InfluxDB server and Prometheus endpoint.
 func Collect() {
+	sinks := enabledSinks()
 
 	for {
 
-		common.RunNRecover(doCollect)
+		common.RunNRecover(func() { doCollect(sinks) })
 
 		time.Sleep(time.Second * 30)
 	}
 }
 
-func doCollect() {
+func doCollect(sinks []Sink) {
+	b := new(batch)
+	netStat(b)
+	cgroupStat(b)
+	ioStat(b)
+	diskStat(b)
+	diskFree(b)
+	cpuStat(b)
+	memStat(b)
+	agentStat(b)
 
-	influx, err := utils.InfluxDbClient()
-	if err == nil {
-		defer influx.Close()
+	for _, sink := range sinks {
+		log.Check(log.WarnLevel, "Writing metrics batch to "+sink.Name(), sink.Write(*b))
 	}
+}
 
-	log.Check(log.WarnLevel, "Entering metrics collection routine", err)
-
-	if err == nil {
-
-		_, _, err := influx.Ping(time.Second)
-
-		log.Check(log.WarnLevel, "Pinging InfluxDB server", err)
-
-		if err == nil {
-
-			bp, err := client.NewBatchPoints(client.BatchPointsConfig{Database: config.Influxdb.Db, RetentionPolicy: "hour"})
-
-			log.Check(log.WarnLevel, "Preparing metrics batch", err)
-
-			if err == nil {
-
-				netStat(bp)
-				cgroupStat(bp)
-				zfsStat(bp)
-				diskFree(bp)
-				cpuStat(bp)
-				memStat(bp)
-
-				err = influx.Write(bp)
-
-				log.Check(log.WarnLevel, "Writing metrics batch", err)
+func cgroupStat(b *batch) {
+	for _, lxc := range cgroup.Containers() {
+		if user, system, err := cgroup.CPUTime(lxc); err == nil {
+			for kind, value := range map[string]int{"user": user, "system": system} {
+				b.add("lxc_cpu", map[string]string{"hostname": lxc, "type": kind}, value/runtime.NumCPU())
 			}
 		}
-	}
-}
-
-func parsefile(bp client.BatchPoints, lxc, cgtype, filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(bufio.NewReader(file))
-	for scanner.Scan() {
-		line := strings.Split(scanner.Text(), " ")
-		if value, err := strconv.Atoi(line[1]); err == nil {
-			if cgtype == "memory" && lxcmemory[line[0]] {
-				point, err := client.NewPoint("lxc_"+cgtype,
-					map[string]string{"hostname": lxc, "type": line[0]},
-					map[string]interface{}{"value": value},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
-			} else if cgtype == "cpuacct" {
-				point, err := client.NewPoint("lxc_cpu",
-					map[string]string{"hostname": lxc, "type": line[0]},
-					map[string]interface{}{"value": value / runtime.NumCPU()},
-					time.Now())
-				bp.AddPoint(point)
-				if err == nil {
-					bp.AddPoint(point)
-				}
+		if stat, err := cgroup.MemoryStat(lxc); err == nil {
+			for kind, value := range stat {
+				b.add("lxc_memory", map[string]string{"hostname": lxc, "type": kind}, value)
 			}
 		}
 	}
-
 }
 
-func cgroupStat(bp client.BatchPoints) {
-	for _, item := range cgtype {
-		gpath := "/sys/fs/cgroup/" + item + "/lxc/"
-		files, err := ioutil.ReadDir(gpath)
-		if err == nil {
-			for _, f := range files {
-				if f.IsDir() {
-					parsefile(bp, f.Name(), item, gpath+f.Name()+"/"+item+".stat")
-				}
+func ioStat(b *batch) {
+	// I/O on zfs storage is not charged to container cgroups, it is read from statistics of datasets once for all
+	var datasets map[string]fs.IOStat
+	if fs.Backend() == "zfs" {
+		var err error
+		if datasets, err = fs.DatasetsIO(); log.Check(log.DebugLevel, "Reading I/O statistics of datasets", err) {
+			return
+		}
+	}
+	for _, cont := range container.All() {
+		stat := container.SumIO(datasets, cont)
+		if datasets == nil {
+			var err error
+			if stat, err = cgroup.IO(cont); err != nil {
+				continue
 			}
 		}
+		for kind, value := range map[string]int{"read": stat.ReadBytes, "write": stat.WriteBytes,
+			"rops": stat.ReadOps, "wops": stat.WriteOps} {
+			b.add("lxc_io", map[string]string{"hostname": cont, "type": kind}, value)
+		}
 	}
 }
 
-func netStat(bp client.BatchPoints) {
+func netStat(b *batch) {
 	lxcnic := make(map[string]string)
 	files, err := ioutil.ReadDir(config.Agent.LxcPrefix)
 	if err == nil {
@@ -163,55 +128,23 @@ func netStat(bp client.BatchPoints) {
 			}
 
 			for i := range traffic {
-				point, err := client.NewPoint(metric,
-					map[string]string{"hostname": hostname, "iface": nicname, "type": traff[i]},
-					map[string]interface{}{"value": traffic[i] * 8},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
+				b.add(metric, map[string]string{"hostname": hostname, "iface": nicname, "type": traff[i]}, traffic[i]*8)
 			}
 		}
 	}
 }
 
-func zfsStat(bp client.BatchPoints) {
-	output, err := exc.Execute("zfs", "list", "-r")
-	if log.Check(log.DebugLevel, "Getting zfs stats", err) {
-		return
-	}
-
-	lines := strings.Split(output, "\n")
-
-	var usageMap = make(map[string]string)
-	for _, line := range lines {
-
-		if strings.HasPrefix(line, config.Agent.Dataset) {
-			fields := strings.Fields(line)
-			if len(fields) > 1 {
-				usageMap[fields[0]] = fields[1]
-			}
-		}
-
-	}
-
+func diskStat(b *batch) {
 	for _, cont := range container.All() {
-		for key, val := range usageMap {
-			if key == path.Join(config.Agent.Dataset, cont) {
-				value, _ := fs.ConvertToBytes(val)
-				point, err := client.NewPoint("lxc_disk",
-					map[string]string{"hostname": cont, "mount": "total", "type": "used"},
-					map[string]interface{}{"value": value},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
-			}
+		value, err := fs.DatasetDiskUsage(cont)
+		if log.Check(log.DebugLevel, "Getting disk usage of "+cont, err) {
+			continue
 		}
+		b.add("lxc_disk", map[string]string{"hostname": cont, "mount": "total", "type": "used"}, value)
 	}
 }
 
-func diskFree(bp client.BatchPoints) {
+func diskFree(b *batch) {
 	hostname, err := os.Hostname()
 	log.Check(log.DebugLevel, "Getting hostname of the system", err)
 	out, err := exec.Command("df", "-B1").Output()
@@ -225,19 +158,13 @@ func diskFree(bp client.BatchPoints) {
 			for i := range metrics {
 				value, err := strconv.Atoi(line[i+1])
 				log.Check(log.DebugLevel, "Parsing disk stats", err)
-				point, err := client.NewPoint("host_disk",
-					map[string]string{"hostname": hostname, "mount": line[5], "type": metrics[i]},
-					map[string]interface{}{"value": value},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
+				b.add("host_disk", map[string]string{"hostname": hostname, "mount": line[5], "type": metrics[i]}, value)
 			}
 		}
 	}
 }
 
-func memStat(bp client.BatchPoints) {
+func memStat(b *batch) {
 	hostname, err := os.Hostname()
 	log.Check(log.DebugLevel, "Getting hostname of the system", err)
 	if file, err := os.Open("/proc/meminfo"); err == nil {
@@ -246,19 +173,13 @@ func memStat(bp client.BatchPoints) {
 		for scanner.Scan() {
 			line := strings.Fields(strings.Replace(scanner.Text(), ":", "", -1))
 			if value, err := strconv.Atoi(line[1]); err == nil && memory[line[0]] {
-				point, err := client.NewPoint("host_memory",
-					map[string]string{"hostname": hostname, "type": line[0]},
-					map[string]interface{}{"value": value * 1024},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
+				b.add("host_memory", map[string]string{"hostname": hostname, "type": line[0]}, value*1024)
 			}
 		}
 	}
 }
 
-func cpuStat(bp client.BatchPoints) {
+func cpuStat(b *batch) {
 	hostname, err := os.Hostname()
 	log.Check(log.DebugLevel, "Getting hostname of the system", err)
 	file, err := os.Open("/proc/stat")
@@ -273,13 +194,7 @@ func cpuStat(bp client.BatchPoints) {
 			for i := range cpu {
 				value, err := strconv.Atoi(line[i+1])
 				log.Check(log.DebugLevel, "Parsing network CPU stats from proc", err)
-				point, err := client.NewPoint("host_cpu",
-					map[string]string{"hostname": hostname, "type": cpu[i]},
-					map[string]interface{}{"value": value / runtime.NumCPU()},
-					time.Now())
-				if err == nil {
-					bp.AddPoint(point)
-				}
+				b.add("host_cpu", map[string]string{"hostname": hostname, "type": cpu[i]}, value/runtime.NumCPU())
 			}
 		}
 	}
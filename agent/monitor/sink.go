@@ -0,0 +1,199 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/subutai-io/agent/agent/outbox"
+	"github.com/subutai-io/agent/agent/utils"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// Point is a single metric value, e.g. "lxc_cpu" with tags {"hostname": "foo", "type": "user"}
+type Point struct {
+	Name  string
+	Tags  map[string]string
+	Value int
+	Time  time.Time
+}
+
+type batch []Point
+
+func (b *batch) add(name string, tags map[string]string, value int) {
+	*b = append(*b, Point{Name: name, Tags: tags, Value: value, Time: time.Now()})
+}
+
+// Sink receives every batch of collected metrics
+type Sink interface {
+	Name() string
+	Write(points []Point) error
+}
+
+// enabledSinks returns sinks enabled in configuration file
+func enabledSinks() (sinks []Sink) {
+	if config.Influxdb.Enabled {
+		sinks = append(sinks, newBuffered(&influx{}, path.Join(config.Agent.DataPrefix, "metrics"),
+			int64(config.Influxdb.BufferSize)*1024*1024, time.Duration(config.Influxdb.BufferAge)*time.Minute))
+	}
+	if config.Prometheus.Enabled {
+		sinks = append(sinks, newPrometheus(config.Prometheus.Listen))
+	}
+	return
+}
+
+// internal keeps the latest values of agent internal metrics, which are added to every batch
+var internal = struct {
+	sync.Mutex
+	values map[string]int
+}{values: make(map[string]int)}
+
+// Record sets current value of agent internal metric, e.g. Record("agent_heartbeat_latency", 120)
+func Record(name string, value int) {
+	internal.Lock()
+	defer internal.Unlock()
+	internal.values[name] = value
+}
+
+func agentStat(b *batch) {
+	hostname, err := os.Hostname()
+	log.Check(log.DebugLevel, "Getting hostname of the system", err)
+
+	Record("agent_outbox_depth", outbox.Len())
+	internal.Lock()
+	defer internal.Unlock()
+	for name, value := range internal.values {
+		b.add(name, map[string]string{"hostname": hostname}, value)
+	}
+}
+
+// rejected is an error of batch refused by reachable server, e.g. because of field type conflict.
+// Such batch would be refused again, so it is not buffered for replay.
+type rejected struct {
+	error
+}
+
+// influx writes metrics to InfluxDB server on Management host
+type influx struct{}
+
+func (i *influx) Name() string {
+	return "InfluxDB"
+}
+
+func (i *influx) Write(points []Point) error {
+	var lines bytes.Buffer
+	for _, p := range points {
+		point, err := client.NewPoint(p.Name, p.Tags, map[string]interface{}{"value": p.Value}, p.Time)
+		if err == nil {
+			lines.WriteString(point.String() + "\n")
+		}
+	}
+
+	// batch is posted directly instead of client Write, which hides the status code of response
+	query := url.Values{"db": {config.Influxdb.Db}, "rp": {"hour"}}
+	req, err := http.NewRequest("POST", "https://"+config.Management.Host+":8086/write?"+query.Encode(), &lines)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(config.Influxdb.User, config.Influxdb.Pass)
+	resp, err := utils.GetClient(true, 60).Do(req)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(resp)
+	body, _ := ioutil.ReadAll(resp.Body)
+	return writeStatus(resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// writeStatus converts status of InfluxDB write response to error. Batch refused with 4xx status would be
+// refused again, so it is rejected, while 5xx, timeout and throttling statuses are worth replaying later.
+func writeStatus(code int, body string) error {
+	if code/100 == 2 {
+		return nil
+	}
+	err := fmt.Errorf("InfluxDB responded %d %s: %s", code, http.StatusText(code), body)
+	if code/100 == 4 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests {
+		return rejected{err}
+	}
+	return err
+}
+
+// prometheus serves the latest batch of metrics on /metrics endpoint in Prometheus text format
+type prometheus struct {
+	sync.Mutex
+	page []byte
+}
+
+func newPrometheus(listen string) *prometheus {
+	p := &prometheus{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", p.serve)
+	go func() {
+		log.Check(log.WarnLevel, "Serving Prometheus metrics on "+listen, http.ListenAndServe(listen, mux))
+	}()
+	return p
+}
+
+func (p *prometheus) Name() string {
+	return "Prometheus endpoint"
+}
+
+func (p *prometheus) Write(points []Point) error {
+	groups := make(map[string][]string)
+	for _, point := range points {
+		var labels []string
+		for k, v := range point.Tags {
+			labels = append(labels, k+`="`+escapeLabel(v)+`"`)
+		}
+		line := point.Name
+		if len(labels) > 0 {
+			sort.Strings(labels)
+			line += "{" + strings.Join(labels, ",") + "}"
+		}
+		groups[point.Name] = append(groups[point.Name], line+" "+strconv.Itoa(point.Value))
+	}
+
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var page bytes.Buffer
+	for _, name := range names {
+		page.WriteString("# TYPE " + name + " untyped\n")
+		sort.Strings(groups[name])
+		for _, line := range groups[name] {
+			page.WriteString(line + "\n")
+		}
+	}
+
+	p.Lock()
+	p.page = page.Bytes()
+	p.Unlock()
+	return nil
+}
+
+func (p *prometheus) serve(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	page := p.page
+	p.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(page)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteStatus(t *testing.T) {
+	tests := []struct {
+		code     int
+		err      bool
+		rejected bool
+	}{
+		{204, false, false},
+		{200, false, false},
+		{400, true, true},
+		{404, true, true},
+		{408, true, false},
+		{429, true, false},
+		{500, true, false},
+		{503, true, false},
+	}
+	for _, tt := range tests {
+		err := writeStatus(tt.code, "body")
+		if (err != nil) != tt.err {
+			t.Errorf("writeStatus(%d) = %v, want error %v", tt.code, err, tt.err)
+		}
+		if _, ok := err.(rejected); ok != tt.rejected {
+			t.Errorf("writeStatus(%d) rejected = %v, want %v", tt.code, ok, tt.rejected)
+		}
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	p := &prometheus{}
+	points := []Point{
+		{Name: "lxc_cpu", Tags: map[string]string{"hostname": "foo", "type": "user"}, Value: 20},
+		{Name: "agent_heartbeat_latency", Value: 120},
+		{Name: "lxc_cpu", Tags: map[string]string{"type": "system", "hostname": "bar"}, Value: 5},
+		{Name: "host_disk", Tags: map[string]string{"mount": `C:\ "x"` + "\n"}, Value: 1},
+	}
+	if err := p.Write(points); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	p.serve(rw, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# TYPE agent_heartbeat_latency untyped
+agent_heartbeat_latency 120
+# TYPE host_disk untyped
+host_disk{mount="C:\\ \"x\"\n"} 1
+# TYPE lxc_cpu untyped
+lxc_cpu{hostname="bar",type="system"} 5
+lxc_cpu{hostname="foo",type="user"} 20
+`
+	if got := rw.Body.String(); got != want {
+		t.Errorf("metrics page\n%s\nwant\n%s", got, want)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("content type %q", ct)
+	}
+
+	// every batch replaces previous one
+	p.Write(points[1:2])
+	rw = httptest.NewRecorder()
+	p.serve(rw, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rw.Body.String(); got != "# TYPE agent_heartbeat_latency untyped\nagent_heartbeat_latency 120\n" {
+		t.Errorf("metrics page after next batch\n%s", got)
+	}
+}
@@ -0,0 +1,145 @@
+// Package outbox delivers encrypted responses and heartbeats to Management server through persistent queue in agent database,
+// so messages are not lost when Management server is unavailable or the agent is restarted.
+package outbox
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 10 * time.Minute
+	// messages which were not delivered during this period are dropped
+	maxAge = 7 * 24 * time.Hour
+	// outbox is rechecked at least this often to pick up changes made by "outbox" command
+	pollPeriod = 30 * time.Second
+	// message permanently rejected this many times is moved to dead letters, so it doesn't block following ones
+	maxRejects = 3
+)
+
+// Rejected is an error of message refused by reachable Management server with other than 202 status
+type Rejected struct {
+	Status int
+}
+
+func (r Rejected) Error() string {
+	return "Management server responded " + strconv.Itoa(r.Status) + " " + http.StatusText(r.Status)
+}
+
+// permanent checks if the message would be refused again, 5xx, timeout and throttling statuses are temporary
+func (r Rejected) permanent() bool {
+	return r.Status/100 == 4 && r.Status != http.StatusRequestTimeout && r.Status != http.StatusTooManyRequests
+}
+
+var wakeup = make(chan bool, 1)
+
+// Push stores message of specified kind ("RESPONSE" or "HEARTBEAT") in outbox.
+// Messages with the same key are stored once, e.g. commandId and responseNumber for responses;
+// if replace is set, the new message supersedes queued one, e.g. for heartbeats where only the latest matters.
+// Error means the message was not queued and caller should deliver it by itself.
+func Push(kind, key string, replace bool, payload []byte) error {
+	err := db.INSTANCE.OutboxAdd(kind+":"+key, replace, map[string]string{
+		"kind":     kind,
+		"payload":  string(payload),
+		"created":  strconv.FormatInt(time.Now().Unix(), 10),
+		"attempts": "0",
+		"next":     "0",
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case wakeup <- true:
+	default:
+	}
+	return nil
+}
+
+// Len returns number of messages waiting for delivery, dead letters are not counted
+func Len() (n int) {
+	list, err := db.INSTANCE.OutboxList()
+	log.Check(log.DebugLevel, "Reading outbox", err)
+	for _, item := range list {
+		if len(item["dead"]) == 0 {
+			n++
+		}
+	}
+	return
+}
+
+// Deliver sends queued messages in order using send function which returns nil on successful delivery.
+// Failed delivery blocks following messages and is retried with exponential backoff. Message permanently
+// rejected maxRejects times is kept as dead letter with the reason in "dead" item until it expires.
+func Deliver(send func(kind string, payload []byte) error) {
+	for {
+		wait := minBackoff
+		common.RunNRecover(func() {
+			wait = deliver(send)
+		})
+		if wait > pollPeriod {
+			wait = pollPeriod
+		}
+
+		select {
+		case <-wakeup:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliver sends queued messages until the first failure and returns delay before next attempt
+func deliver(send func(kind string, payload []byte) error) time.Duration {
+	list, err := db.INSTANCE.OutboxList()
+	if log.Check(log.WarnLevel, "Reading outbox", err) {
+		return minBackoff
+	}
+
+	for _, item := range list {
+		created, _ := strconv.ParseInt(item["created"], 10, 64)
+		if time.Since(time.Unix(created, 0)) > maxAge {
+			log.Warn("Dropping expired " + item["kind"] + " " + item["key"] + " from outbox")
+			log.Check(log.WarnLevel, "Removing message from outbox", db.INSTANCE.OutboxDel(item["id"]))
+			continue
+		}
+		if len(item["dead"]) > 0 {
+			continue
+		}
+
+		next, _ := strconv.ParseInt(item["next"], 10, 64)
+		if delay := time.Until(time.Unix(next, 0)); delay > 0 {
+			return delay
+		}
+
+		err := send(item["kind"], []byte(item["payload"]))
+		if err == nil {
+			log.Check(log.WarnLevel, "Removing message from outbox", db.INSTANCE.OutboxDel(item["id"]))
+			continue
+		}
+
+		attempts, _ := strconv.Atoi(item["attempts"])
+		if r, ok := err.(Rejected); ok && r.permanent() && attempts+1 >= maxRejects {
+			log.Warn("Moving " + item["kind"] + " " + item["key"] + " to dead letters of outbox, " + err.Error())
+			log.Check(log.WarnLevel, "Updating outbox message", db.INSTANCE.OutboxUpdate(item["id"], map[string]string{
+				"attempts": strconv.Itoa(attempts + 1),
+				"dead":     err.Error(),
+			}))
+			continue
+		}
+		backoff := time.Duration(math.Min(float64(maxBackoff), float64(minBackoff)*math.Pow(2, float64(attempts))))
+		log.Check(log.WarnLevel, "Updating outbox message", db.INSTANCE.OutboxUpdate(item["id"], map[string]string{
+			"attempts": strconv.Itoa(attempts + 1),
+			"next":     strconv.FormatInt(time.Now().Add(backoff).Unix(), 10),
+		}))
+		return backoff
+	}
+
+	return maxBackoff
+}
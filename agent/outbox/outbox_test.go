@@ -0,0 +1,23 @@
+package outbox
+
+import "testing"
+
+func TestRejectedPermanent(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{400, true},
+		{403, true},
+		{404, true},
+		{408, false},
+		{429, false},
+		{500, false},
+		{503, false},
+	}
+	for _, tt := range tests {
+		if permanent := (Rejected{Status: tt.status}).permanent(); permanent != tt.permanent {
+			t.Errorf("Rejected{%d}.permanent() = %v, want %v", tt.status, permanent, tt.permanent)
+		}
+	}
+}
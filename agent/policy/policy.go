@@ -0,0 +1,173 @@
+// Package policy restricts commands which Management server is allowed to execute on Resource Host and inside containers
+package policy
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/gcfg.v1"
+
+	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/log"
+)
+
+// File is a location of the policy file, it is read on every request so changes take effect without agent restart.
+// If the file does not exist, all requests are permitted.
+//
+// Example:
+//	[policy]
+//	default = deny
+//
+//	[rule "packages"]
+//	action = allow
+//	command = apt-get *
+//	user = root
+//	target = host
+//
+//	[rule "no-shell-in-web"]
+//	action = deny
+//	command = */bash*
+//	target = web-*
+//
+// Command patterns are matched against command line with arguments, "*" matches any sequence of characters.
+// Command line is run by shell, so in allow rules "*" doesn't match shell metacharacters ;&|$`<>(){}\ and newline,
+// e.g. "apt-get *" doesn't allow "apt-get update; reboot". They are permitted only if written in the pattern itself,
+// e.g. "ls * > /tmp/*". In deny rules "*" matches any characters, so chained commands can't escape them.
+// Target is "host", "container" for any container or container name pattern.
+// Empty list of commands, users, directories or targets matches any value.
+// Request is denied if any deny rule matches it, otherwise it is allowed if any allow rule matches,
+// otherwise default action is applied.
+const File = "/etc/subutai/policy.conf"
+
+type rule struct {
+	Action  string
+	Command []string
+	User    []string
+	Dir     []string
+	Target  []string
+}
+
+type policyFile struct {
+	Policy struct {
+		Default string
+	}
+	Rule map[string]*rule
+}
+
+// Check returns true if request is permitted for Resource Host, if container is empty, or for container.
+// Otherwise the reason of denial is returned.
+func Check(req executer.RequestOptions, container string) (bool, string) {
+	if _, err := os.Stat(File); os.IsNotExist(err) {
+		return true, ""
+	}
+
+	var p policyFile
+	// broken policy must not open access to everything
+	if err := gcfg.ReadFileInto(&p, File); log.Check(log.WarnLevel, "Reading policy file "+File, err) {
+		return false, "policy file is invalid"
+	}
+
+	target := "host"
+	if container != "" {
+		target = container
+	}
+	cmdline := strings.TrimSpace(req.Command + " " + strings.Join(req.Args, " "))
+
+	allowed := ""
+	for name, r := range p.Rule {
+		if !r.match(cmdline, req.RunAs, req.WorkingDir, target) {
+			continue
+		}
+		switch strings.ToLower(r.Action) {
+		case "deny":
+			return false, "denied by rule \"" + name + "\""
+		case "allow":
+			allowed = name
+		default:
+			log.Warn("Unknown action \"" + r.Action + "\" in policy rule " + name)
+		}
+	}
+	if allowed != "" {
+		return true, ""
+	}
+	if strings.ToLower(p.Policy.Default) == "allow" {
+		return true, ""
+	}
+	return false, "no rule allows the request"
+}
+
+// CheckControl returns true if process control request is permitted for Resource Host or container.
+// Control of running command, including input to interactive session, is permitted only if the policy
+// allows to start that command now, so control requests can't be used to bypass the policy.
+func CheckControl(req executer.RequestOptions, container string) (bool, string) {
+	if len(req.Target) == 0 {
+		return true, ""
+	}
+	target, ok := executer.Registered(req.Target, container)
+	if !ok {
+		return true, ""
+	}
+	if ok, reason := Check(target, container); !ok {
+		return false, "command " + req.Target + ": " + reason
+	}
+	return true, ""
+}
+
+func (r *rule) match(cmdline, user, dir, target string) bool {
+	command := matchAny(r.Command, cmdline)
+	if strings.ToLower(r.Action) == "allow" {
+		command = matchCommand(r.Command, cmdline)
+	}
+	if !command || !matchAny(r.User, user) || !matchAny(r.Dir, dir) {
+		return false
+	}
+	if len(r.Target) == 0 {
+		return true
+	}
+	for _, t := range r.Target {
+		if t == target || t == "container" && target != "host" || t != "host" && target != "host" && glob(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if glob(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCommand is matchAny for allow rules where "*" doesn't match shell metacharacters
+func matchCommand(patterns []string, cmdline string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if match(p, cmdline, "[^;&|$`<>(){}\\\\\\n]*") {
+			return true
+		}
+	}
+	return false
+}
+
+// glob matches value against pattern where "*" is any sequence of characters, including "/" and spaces
+func glob(pattern, value string) bool {
+	return match(pattern, value, ".*")
+}
+
+// match matches value against pattern where "*" is replaced by wildcard regular expression
+func match(pattern, value, wildcard string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("(?s)^" + strings.Join(parts, wildcard) + "$").MatchString(value)
+}
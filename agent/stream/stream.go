@@ -0,0 +1,201 @@
+// Package stream maintains persistent WebSocket channel to Management server.
+//
+// The channel is used to receive requests, send command responses and heartbeats without polling
+// and per-message HTTPS round trips. It is enabled by "stream" option in [management] section of agent configuration.
+// When Management server does not support the channel, the agent falls back to REST requests.
+package stream
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// Message is a frame transferred over the channel.
+// Management server sends messages of type "REQUEST" with JSON list of encrypted requests as payload,
+// the agent sends "RESPONSE" and "HEARTBEAT" messages with the same payload as REST API and unique id.
+// Management server confirms the message is accepted by "ACK" message with the same id,
+// so frames lost in a broken connection are delivered again.
+type Message struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+}
+
+var (
+	conn  *websocket.Conn
+	mutex sync.Mutex
+	// pending are messages sent over current connection and waiting for acknowledgement by ids
+	pending  = make(map[string]chan error)
+	sequence uint64
+	// acked is set when Management server acknowledges any message on current connection,
+	// if it has never done it, it doesn't support acknowledgements and messages are sent by REST requests
+	acked, ackless bool
+	// ErrNotConnected is returned when message is sent while channel is down
+	ErrNotConnected = errors.New("Stream is not connected")
+	// ErrNotAcked is returned when message is not acknowledged by Management server in time
+	ErrNotAcked = errors.New("Stream message is not acknowledged")
+)
+
+const (
+	writeWait  = 10 * time.Second
+	ackWait    = 10 * time.Second
+	pingPeriod = 30 * time.Second
+	pongWait   = 90 * time.Second
+	// delay before next connection attempt if Management server does not support the channel
+	fallbackPeriod = 10 * time.Minute
+)
+
+// Connected returns true if the channel to Management server is established.
+func Connected() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return conn != nil
+}
+
+// Send writes message to the channel and waits until Management server acknowledges it.
+// Error means the message must be delivered by REST request.
+func Send(kind string, payload []byte) error {
+	mutex.Lock()
+	if conn == nil || ackless {
+		mutex.Unlock()
+		return ErrNotConnected
+	}
+	c := conn
+	sequence++
+	id := strconv.FormatUint(sequence, 10)
+	ack := make(chan error, 1)
+	pending[id] = ack
+	c.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.WriteJSON(Message{ID: id, Type: kind, Payload: string(payload)}); err != nil {
+		delete(pending, id)
+		c.Close()
+		conn = nil
+		mutex.Unlock()
+		return err
+	}
+	mutex.Unlock()
+
+	select {
+	case err := <-ack:
+		return err
+	case <-time.After(ackWait):
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(pending, id)
+	if conn == c && !acked {
+		log.Debug("Management server does not acknowledge stream messages, using REST API to send them")
+		ackless = true
+	}
+	return ErrNotAcked
+}
+
+// Run keeps the channel open while it is enabled in configuration and passes received requests to handler.
+// Function id returns Resource Host fingerprint, empty value postpones connection until host is registered.
+func Run(tlsConfig *tls.Config, id func() string, handler func(payload []byte)) {
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: writeWait}
+
+	for config.Management.Stream {
+		fingerprint := id()
+		if fingerprint == "" || config.Management.Host == "" {
+			time.Sleep(time.Second * 10)
+			continue
+		}
+
+		c, resp, err := dialer.Dial("wss://"+path.Join(config.Management.Host)+":8444/rest/v1/agent/stream/"+fingerprint, nil)
+		if err != nil {
+			if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed ||
+				resp.StatusCode == http.StatusBadRequest) {
+				log.Debug("Management server does not support streaming, using REST API")
+				time.Sleep(fallbackPeriod)
+			} else {
+				log.Debug("Connecting stream: " + err.Error())
+				time.Sleep(time.Second * 10)
+			}
+			continue
+		}
+
+		log.Debug("Stream to Management server established")
+		serve(c, handler)
+		log.Debug("Stream to Management server closed")
+	}
+}
+
+// serve reads messages until connection breaks
+func serve(c *websocket.Conn, handler func(payload []byte)) {
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	mutex.Lock()
+	conn, acked, ackless = c, false, false
+	mutex.Unlock()
+
+	done := make(chan struct{})
+	go keepalive(c, done)
+
+	for {
+		var msg Message
+		if log.Check(log.DebugLevel, "Reading stream message", c.ReadJSON(&msg)) {
+			break
+		}
+		if msg.Type == "REQUEST" {
+			handler([]byte(msg.Payload))
+		} else if msg.Type == "ACK" {
+			mutex.Lock()
+			acked = true
+			if ack, ok := pending[msg.ID]; ok {
+				ack <- nil
+				delete(pending, msg.ID)
+			}
+			mutex.Unlock()
+		} else {
+			log.Debug("Unexpected stream message type " + msg.Type)
+		}
+	}
+
+	close(done)
+	mutex.Lock()
+	if conn == c {
+		conn = nil
+	}
+	// messages which were not acknowledged may be lost with the connection
+	for id, ack := range pending {
+		ack <- ErrNotConnected
+		delete(pending, id)
+	}
+	mutex.Unlock()
+	c.Close()
+}
+
+// keepalive pings Management server to detect broken connections
+func keepalive(c *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			mutex.Lock()
+			err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			mutex.Unlock()
+			if err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
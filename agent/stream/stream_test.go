@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// server starts fake Management server endpoint passing every received message to reply function,
+// which returns acknowledgement to send or false to drop the connection
+func server(t *testing.T, reply func(msg Message) (Message, bool)) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			var msg Message
+			if c.ReadJSON(&msg) != nil {
+				return
+			}
+			ack, ok := reply(msg)
+			if !ok || c.WriteJSON(ack) != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func connect(t *testing.T, c *websocket.Conn) {
+	go serve(c, func([]byte) {})
+	for !Connected() {
+		time.Sleep(time.Millisecond)
+	}
+	t.Cleanup(func() { c.Close() })
+}
+
+func TestSendAcknowledged(t *testing.T) {
+	connect(t, server(t, func(msg Message) (Message, bool) {
+		return Message{ID: msg.ID, Type: "ACK"}, true
+	}))
+	if err := Send("RESPONSE", []byte("payload")); err != nil {
+		t.Errorf("Send() = %v, want acknowledged message", err)
+	}
+}
+
+func TestSendConnectionLost(t *testing.T) {
+	connect(t, server(t, func(msg Message) (Message, bool) {
+		return Message{}, false
+	}))
+	if err := Send("RESPONSE", []byte("payload")); err == nil {
+		t.Error("Send() reports delivery of message lost with connection")
+	}
+}
@@ -0,0 +1,129 @@
+// Package throttle enforces disk I/O limits of Subutai containers on zfs storage. ZFS issues disk I/O from its own
+// kernel threads, which are not charged to container cgroups, so blkio throttling has no effect there. Instead I/O of
+// container datasets is sampled from ZFS statistics and the container is paused while it is over the limit.
+package throttle
+
+import (
+	"time"
+
+	"github.com/subutai-io/agent/lib/cgroup"
+	"github.com/subutai-io/agent/lib/common"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	// period of I/O sampling, container is paused for whole periods
+	period = 250 * time.Millisecond
+	// burst is the time of I/O at the limit rate, which container may save up while it is idle and spend at once
+	burst = time.Second
+)
+
+// bucket is a token bucket of container disk I/O
+type bucket struct {
+	last cgroup.IOStat
+	// debt is I/O done over the limit by kind, in bytes or operations, negative debt is unused credit
+	debt   map[string]float64
+	paused bool
+}
+
+// Run samples disk I/O of containers with limits and pauses or resumes them, it does nothing on other than zfs storage
+func Run() {
+	if fs.Backend() != "zfs" {
+		return
+	}
+
+	// containers paused when agent was stopped are resumed, they'll be paused again if they are still over the limit
+	for _, name := range container.All() {
+		log.Check(log.WarnLevel, "Resuming throttled container "+name, container.Throttle(name, false))
+	}
+
+	buckets := make(map[string]*bucket)
+	last := time.Now()
+	for {
+		time.Sleep(period)
+		now := time.Now()
+		common.RunNRecover(func() { throttle(buckets, now.Sub(last)) })
+		last = now
+	}
+}
+
+// available is false if ZFS doesn't provide statistics of datasets, so the limits are not enforced
+var available = true
+
+func throttle(buckets map[string]*bucket, elapsed time.Duration) {
+	limited := make(map[string]map[string]int)
+	for _, name := range container.All() {
+		if limits := container.IOLimits(name); len(limits) > 0 && container.State(name) == "RUNNING" {
+			limited[name] = limits
+		}
+	}
+
+	for name, b := range buckets {
+		if _, ok := limited[name]; !ok {
+			if b.paused {
+				log.Check(log.WarnLevel, "Resuming throttled container "+name, container.Throttle(name, false))
+			}
+			delete(buckets, name)
+		}
+	}
+	if len(limited) == 0 {
+		return
+	}
+
+	stats, err := fs.DatasetsIO()
+	if log.Check(log.DebugLevel, "Reading I/O statistics of datasets", err) {
+		return
+	}
+	if len(stats) == 0 && available {
+		log.Warn("ZFS statistics of datasets are not available, disk I/O limits of containers are not enforced")
+	}
+	available = len(stats) > 0
+
+	for name, limits := range limited {
+		stat := container.SumIO(stats, name)
+		b, ok := buckets[name]
+		if !ok {
+			buckets[name] = &bucket{last: stat, debt: make(map[string]float64)}
+			continue
+		}
+		if pause := b.step(stat, limits, elapsed); pause != b.paused {
+			if !log.Check(log.WarnLevel, "Throttling disk I/O of container "+name, container.Throttle(name, pause)) {
+				b.paused = pause
+			}
+		}
+	}
+}
+
+// step accounts I/O done since the previous sample against limits and returns if container must be paused.
+// Debt grows by I/O over the limit and is paid off at the limit rate while container is paused,
+// credit of unused I/O is capped by burst, so the average rate is kept within the limit.
+func (b *bucket) step(stat cgroup.IOStat, limits map[string]int, elapsed time.Duration) (pause bool) {
+	used := map[string]int{
+		"rbps":  stat.ReadBytes - b.last.ReadBytes,
+		"wbps":  stat.WriteBytes - b.last.WriteBytes,
+		"riops": stat.ReadOps - b.last.ReadOps,
+		"wiops": stat.WriteOps - b.last.WriteOps,
+	}
+	b.last = stat
+
+	for kind := range b.debt {
+		if _, ok := limits[kind]; !ok {
+			delete(b.debt, kind)
+		}
+	}
+	for kind, limit := range limits {
+		// counters start over when dataset is remounted
+		if used[kind] < 0 {
+			used[kind] = 0
+		}
+		debt := b.debt[kind] + float64(used[kind]) - float64(limit)*elapsed.Seconds()
+		if credit := -float64(limit) * burst.Seconds(); debt < credit {
+			debt = credit
+		}
+		b.debt[kind] = debt
+		pause = pause || debt > 0
+	}
+	return pause
+}
@@ -0,0 +1,45 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/lib/cgroup"
+)
+
+func TestBucketStep(t *testing.T) {
+	limits := map[string]int{"wbps": 1000}
+	b := &bucket{debt: make(map[string]float64)}
+
+	tests := []struct {
+		written int
+		elapsed time.Duration
+		pause   bool
+	}{
+		// idle container saves up credit for a burst of one second at the limit rate
+		{0, 5 * time.Second, false},
+		{1500, time.Second, false},
+		// the burst is spent, I/O over the limit pauses container until the debt is paid off
+		{2000, time.Second, true},
+		{0, 250 * time.Millisecond, true},
+		{0, 250 * time.Millisecond, false},
+		{250, 250 * time.Millisecond, false},
+	}
+	written := 0
+	for i, tt := range tests {
+		written += tt.written
+		if pause := b.step(cgroup.IOStat{WriteBytes: written}, limits, tt.elapsed); pause != tt.pause {
+			t.Errorf("step %d: pause = %v, want %v, debt %v", i, pause, tt.pause, b.debt)
+		}
+	}
+}
+
+func TestBucketStepRemovedLimit(t *testing.T) {
+	b := &bucket{debt: make(map[string]float64)}
+	if !b.step(cgroup.IOStat{ReadOps: 100}, map[string]int{"riops": 10}, time.Second) {
+		t.Fatal("container over the limit is not paused")
+	}
+	if b.step(cgroup.IOStat{ReadOps: 100}, map[string]int{"wbps": 10}, time.Second) {
+		t.Error("container is paused for debt of removed limit")
+	}
+}
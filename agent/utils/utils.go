@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"net/http"
@@ -184,6 +185,19 @@ func newTLSConfig() *tls.Config {
 	return &tls.Config{ClientAuth: tls.NoClientCert, ClientCAs: nil, Certificates: []tls.Certificate{cert}}
 }
 
+// ServerTLSConfig provides TLS configuration of agent HTTPS server with Resource Host certificate.
+func ServerTLSConfig() *tls.Config {
+	tlsconfig := newTLSConfig()
+	for tlsconfig == nil || len(tlsconfig.Certificates[0].Certificate) == 0 {
+		time.Sleep(time.Second * 2)
+		for PublicCert() == "" {
+			x509generate()
+		}
+		tlsconfig = newTLSConfig()
+	}
+	return &tls.Config{Certificates: tlsconfig.Certificates}
+}
+
 //HTTP CLIENT
 
 func GetClient(allowInsecure bool, timeoutSec int) *http.Client {
@@ -221,7 +235,7 @@ func timeoutDialer(connectTimeout time.Duration, rwTimeout time.Duration) func(n
 }
 
 // CheckCDN checks if the Kurjun node available.
-func CheckCDN() {
+func CheckCDN() error {
 
 	address := path.Join(config.CDN.URL) + ":" + config.CDN.SSLport
 	_, err := net.DialTimeout("tcp", address, time.Duration(5)*time.Second)
@@ -232,7 +246,10 @@ func CheckCDN() {
 		c++
 	}
 
-	log.Check(log.ErrorLevel, "Checking CDN accessibility", err)
+	if err != nil {
+		return errors.New("CDN is not accessible: " + err.Error())
+	}
+	return nil
 }
 
 func CleanTemplateName(name string) string {
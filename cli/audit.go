@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/lib/audit"
+	"github.com/subutai-io/agent/log"
+)
+
+// LxcAudit shows records of audit log filtered by container name, command id and time range.
+// Start and end time are in "2006-01-02 15:04:05" format of local time.
+// Option `-v` verifies integrity of the audit log chain instead.
+func LxcAudit(name, commandID, start, end string, verify bool) {
+	if verify {
+		n, err := audit.Verify()
+		log.Check(log.ErrorLevel, "Audit log is corrupted after "+strconv.Itoa(n)+" valid records", err)
+		log.Info("Audit log is intact, " + strconv.Itoa(n) + " records verified")
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if len(start) > 0 {
+		from, err = time.ParseInLocation("2006-01-02 15:04:05", start, time.Local)
+		log.Check(log.ErrorLevel, "Parsing start date", err)
+	}
+	if len(end) > 0 {
+		to, err = time.ParseInLocation("2006-01-02 15:04:05", end, time.Local)
+		log.Check(log.ErrorLevel, "Parsing end date", err)
+	}
+
+	list, err := audit.Read()
+	log.Check(log.ErrorLevel, "Reading audit log", err)
+
+	result := []audit.Entry{}
+	for _, e := range list {
+		t, err := time.Parse(time.RFC3339Nano, e.Time)
+		if err != nil || (len(name) > 0 && e.Container != name) || (len(commandID) > 0 && e.CommandID != commandID) ||
+			(!from.IsZero() && t.Before(from)) || (!to.IsZero() && t.After(to)) {
+			continue
+		}
+		result = append(result, e)
+	}
+
+	printResult(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%-6s %-20s %-6s %-16s %-10s %-20s %-36s %-5s %-22s %s\n", "SEQ", "TIME", "SOURCE", "USER", "ACTION", "CONTAINER", "COMMAND ID", "EXIT", "RESULT", "COMMAND")
+		for _, e := range result {
+			t, _ := time.Parse(time.RFC3339Nano, e.Time)
+			fmt.Fprintf(w, "%-6d %-20s %-6s %-16s %-10s %-20s %-36s %-5s %-22s %s\n", e.Seq, t.Local().Format("2006-01-02 15:04:05"), e.Source, e.User, e.Action, e.Container, e.CommandID, e.ExitCode, e.Result, e.Command)
+		}
+	})
+}
@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/archiver/extractor"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+// backupMeta describes container backup archive contents
+type backupMeta struct {
+	Name     string              `json:"name"`
+	Snapshot string              `json:"snapshot"`
+	Base     string              `json:"base,omitempty"`
+	Created  string              `json:"created"`
+	Metadata map[string]string   `json:"metadata"`
+	Portmap  []map[string]string `json:"portmap,omitempty"`
+}
+
+// backupFiles lists files from container directory which are stored in backup archive
+var backupFiles = []string{"config", "fstab", "packages", "public.pub", "secret.sec"}
+
+// LxcBackup creates portable archive of Subutai container in config.Agent.CacheDir or in specified directory.
+// Unlike export, backup does not stop the container and keeps its network configuration, metadata, port mappings and GPG keys.
+//
+// Archive contains full stream of all container partitions or, if previous backup snapshot exists and `-f` option is not set,
+// incremental stream against this snapshot. Only the latest backup snapshot is kept as a base for the next incremental backup,
+// previous backup snapshots are removed after both full and incremental backups.
+func LxcBackup(name string, full bool, dir string) {
+	if !container.IsContainer(name) {
+		log.Error("Container " + name + " not found")
+	}
+
+	if len(dir) == 0 {
+		dir = config.Agent.CacheDir
+	}
+
+	_, err := backup(name, full, dir)
+	log.Check(log.ErrorLevel, "Creating backup", err)
+}
+
+// backup saves full or incremental backup archive of the container to directory and returns archive path.
+// Backup snapshot is removed if the archive can't be saved, so the previous one stays the base for the next backup.
+func backup(name string, full bool, dir string) (archive string, err error) {
+	now := time.Now()
+	label := "backup-" + now.UTC().Format("20060102-150405")
+	for container.SnapshotExists(name, label) {
+		time.Sleep(time.Second)
+		now = time.Now()
+		label = "backup-" + now.UTC().Format("20060102-150405")
+	}
+
+	labels, err := fs.ListSnapshots(name + "/rootfs")
+	if err != nil {
+		return "", errors.New("Listing snapshots: " + err.Error())
+	}
+	previous, base := backupBase(labels, full)
+
+	if err = container.Snapshot(name, label); err != nil {
+		return "", errors.New("Creating backup snapshot: " + err.Error())
+	}
+	dst := path.Join(dir, name+"-backup_"+strings.TrimPrefix(label, "backup-"))
+	defer func() {
+		log.Check(log.WarnLevel, "Removing temporary directory "+dst, os.RemoveAll(dst))
+		if err != nil {
+			log.Check(log.WarnLevel, "Removing backup snapshot "+label, container.RemoveSnapshot(name, label))
+		}
+	}()
+
+	if err = os.MkdirAll(path.Join(dst, "deltas"), 0755); err != nil {
+		return "", errors.New("Creating backup directory: " + err.Error())
+	}
+
+	for _, vol := range container.Partitions {
+		delta := path.Join(dst, "deltas", vol+".delta")
+		if len(base) == 0 {
+			err = fs.SendFullStream(name+"/"+vol+"@"+label, delta)
+		} else {
+			err = fs.SendStream(name+"/"+vol+"@"+base, name+"/"+vol+"@"+label, delta)
+		}
+		if err != nil {
+			return "", errors.New("Sending stream of " + vol + ": " + err.Error())
+		}
+	}
+
+	src := path.Join(config.Agent.LxcPrefix, name)
+	for _, file := range backupFiles {
+		if !fs.FileExists(path.Join(src, file)) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(src, file))
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(dst, file), data, 0600)
+		}
+		if err != nil {
+			return "", errors.New("Copying " + file + ": " + err.Error())
+		}
+	}
+
+	meta := backupMeta{Name: name, Snapshot: label, Base: base, Created: now.Format(time.RFC3339)}
+	if meta.Metadata, err = db.INSTANCE.ContainerByName(name); err != nil {
+		return "", errors.New("Reading container metadata from db: " + err.Error())
+	}
+	if meta.Portmap, err = db.INSTANCE.GetContainerMapping(name); err != nil {
+		return "", errors.New("Reading container port mappings from db: " + err.Error())
+	}
+
+	data, err := json.Marshal(&meta)
+	if err != nil {
+		return "", errors.New("Encoding backup metadata: " + err.Error())
+	}
+	if err = ioutil.WriteFile(path.Join(dst, "meta.json"), data, 0600); err != nil {
+		return "", errors.New("Writing backup metadata: " + err.Error())
+	}
+
+	archive = dst + ".tar.gz"
+	if err = fs.Pack(dst, archive); err != nil {
+		os.Remove(archive)
+		return "", errors.New("Packing backup archive: " + err.Error())
+	}
+
+	for _, l := range previous {
+		log.Check(log.WarnLevel, "Removing previous backup snapshot "+l, container.RemoveSnapshot(name, l))
+	}
+	if len(base) != 0 {
+		log.Info(name + " incremental backup against " + base + " saved to " + archive)
+	} else {
+		log.Info(name + " full backup saved to " + archive)
+	}
+
+	return archive, nil
+}
+
+// backupBase returns backup snapshots among container snapshot labels ordered by creation time
+// and the latest of them as a base for incremental backup, there is no base for full one
+func backupBase(labels []string, full bool) (previous []string, base string) {
+	for _, l := range labels {
+		if strings.HasPrefix(l, "backup-") {
+			previous = append(previous, l)
+		}
+	}
+	if !full && len(previous) > 0 {
+		base = previous[len(previous)-1]
+	}
+	return
+}
+
+// LxcRestore recreates Subutai container from backup archive made by "backup" command on the same or another Resource Host.
+// If new name is specified, container is restored under this name with new MAC address and GPG key
+// and without IP address, so it doesn't conflict with the original container. Incremental backup restored
+// under new name keeps MAC address given to the container by previous restore.
+//
+// Full backup creates new container. Incremental backup is applied to existing stopped container
+// which has been restored from the previous backup in the chain.
+// Container which was running at backup time is started after restore unless `-s` option is set.
+func LxcRestore(archive, newname string, stopped bool) {
+	if !fs.FileExists(archive) {
+		log.Error("Backup archive " + archive + " not found")
+	}
+
+	tmpdir := path.Join(config.Agent.CacheDir, "restore-"+strings.TrimSuffix(filepath.Base(archive), ".tar.gz"))
+	defer os.RemoveAll(tmpdir)
+
+	log.Info("Unpacking backup " + archive)
+	log.Check(log.ErrorLevel, "Extracting tgz", extractor.NewTgz().Extract(archive, tmpdir))
+
+	data, err := ioutil.ReadFile(path.Join(tmpdir, "meta.json"))
+	log.Check(log.ErrorLevel, "Reading backup metadata", err)
+	var meta backupMeta
+	log.Check(log.ErrorLevel, "Parsing backup metadata", json.Unmarshal(data, &meta))
+
+	name := meta.Name
+	if len(newname) != 0 {
+		name = newname
+	}
+
+	if len(meta.Base) != 0 {
+		if !container.IsContainer(name) {
+			log.Error("Incremental backup requires container " + name + ", please restore backup " + meta.Base + " first")
+		}
+		if !container.SnapshotExists(name, meta.Base) {
+			log.Error("Incremental backup requires snapshot " + name + "@" + meta.Base + ", please restore backup " + meta.Base + " first")
+		}
+		if container.State(name) != "STOPPED" {
+			log.Error("Container " + name + " is running, please stop it before restore")
+		}
+		for _, vol := range container.Partitions {
+			log.Check(log.ErrorLevel, "Receiving stream of "+vol, fs.ReceiveStreamForce(name+"/"+vol, path.Join(tmpdir, "deltas", vol+".delta")))
+		}
+	} else {
+		if container.LxcInstanceExists(name) {
+			log.Error("Container " + name + " already exists")
+		}
+		log.Check(log.ErrorLevel, "Creating dataset", fs.CreateDataset(name))
+		for _, vol := range container.Partitions {
+			log.Check(log.ErrorLevel, "Receiving stream of "+vol, fs.ReceiveStream(name+"/"+vol, path.Join(tmpdir, "deltas", vol+".delta")))
+		}
+	}
+
+	// config from backup is copied over existing one, so MAC address of copy is saved before
+	mac := ""
+	if name != meta.Name && len(meta.Base) != 0 {
+		mac = container.GetProperty(name, "lxc.network.hwaddr")
+	}
+
+	dst := path.Join(config.Agent.LxcPrefix, name)
+	for _, file := range backupFiles {
+		if name != meta.Name && (file == "public.pub" || file == "secret.sec") {
+			continue
+		}
+		if fs.FileExists(path.Join(tmpdir, file)) {
+			log.Check(log.ErrorLevel, "Copying "+file, fs.Copy(path.Join(tmpdir, file), path.Join(dst, file)))
+		}
+	}
+
+	if name != meta.Name {
+		if len(mac) == 0 {
+			mac, err = container.Mac()
+			log.Check(log.ErrorLevel, "Generating random mac", err)
+		}
+		static := len(container.GetProperty(name, "lxc.network.ipv4")) > 0
+		container.SetContainerConf(name, [][]string{
+			{"lxc.network.hwaddr", mac},
+			{"lxc.network.veth.pair", strings.Replace(mac, ":", "", -1)},
+			{"lxc.network.ipv4", ""},
+			{"lxc.network.ipv4.gateway", ""},
+			{"#vlan_id", ""},
+			{"lxc.rootfs", path.Join(config.Agent.LxcPrefix, name, "rootfs")},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "home") + " home none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "opt") + " opt none bind,rw 0 0"},
+			{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, name, "var") + " var none bind,rw 0 0"},
+			{"lxc.utsname", name},
+		})
+		if static {
+			container.SetDynamicNet(name)
+			container.SetDNS(name)
+			log.Info(name + " is restored without IP address of " + meta.Name)
+		}
+		log.Check(log.ErrorLevel, "Generating container key", gpg.GenerateKey(name))
+	}
+
+	metadata := meta.Metadata
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	// port mappings are stored in nested bucket and restored with "map" command
+	delete(metadata, "portmap")
+	if name != meta.Name {
+		delete(metadata, "ip")
+		delete(metadata, "gw")
+		delete(metadata, "vlan")
+	}
+	state := metadata["state"]
+	metadata["state"] = "STOPPED"
+	metadata["interface"] = container.GetProperty(name, "lxc.network.veth.pair")
+	log.Check(log.ErrorLevel, "Writing container metadata to database", db.INSTANCE.ContainerAdd(name, metadata))
+	log.Check(log.WarnLevel, "Writing snapshot metadata to database",
+		db.INSTANCE.SnapshotAdd(name, meta.Snapshot, map[string]string{"created": meta.Created}))
+
+	if len(meta.Base) == 0 && name == meta.Name {
+		for _, v := range meta.Portmap {
+			if v["protocol"] == "https" {
+				log.Warn("Please restore https mapping " + v["domain"] + " " + v["external"] + " manually, certificate is not included in backup")
+				continue
+			}
+			_, err = MapPort(v["protocol"], v["internal"], v["external"], "", v["domain"], "", false, false, false)
+			log.Check(log.ErrorLevel, "Restoring "+v["protocol"]+" mapping "+v["external"], err)
+		}
+	}
+
+	log.Info(name + " restored from " + archive)
+
+	if state == "RUNNING" && !stopped {
+		log.Check(log.ErrorLevel, "Starting "+name, LxcStart(name))
+	}
+}
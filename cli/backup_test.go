@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBackupBase(t *testing.T) {
+	labels := []string{"now", "backup-20180102-150405", "manual", "auto-daily-20180103-000000", "backup-20180104-150405"}
+
+	tests := []struct {
+		labels   []string
+		full     bool
+		previous []string
+		base     string
+	}{
+		{nil, false, nil, ""},
+		{[]string{"now", "manual"}, false, nil, ""},
+		{labels, false, []string{"backup-20180102-150405", "backup-20180104-150405"}, "backup-20180104-150405"},
+		// previous backup snapshots are removed after full backup too
+		{labels, true, []string{"backup-20180102-150405", "backup-20180104-150405"}, ""},
+	}
+	for _, tt := range tests {
+		previous, base := backupBase(tt.labels, tt.full)
+		if !reflect.DeepEqual(previous, tt.previous) || base != tt.base {
+			t.Errorf("backupBase(%v, %v) = %v, %q, want %v, %q", tt.labels, tt.full, previous, base, tt.previous, tt.base)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 
+	"github.com/subutai-io/agent/lib/audit"
+	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
 )
 
@@ -14,9 +19,385 @@ type batchLine struct {
 	Args   []string `json:"args"`
 }
 
+// outputLine is a result of single batch step. Status is one of
+// valid, invalid, done, failed, skipped, rolledback, rollback failed or rollback skipped.
 type outputLine struct {
+	Action   string `json:"action"`
 	Output   string `json:"output"`
 	ExitCode string `json:"exitcode"`
+	Status   string `json:"status"`
+	Undo     string `json:"undo,omitempty"`
+}
+
+// batchStep is a parsed batch line: positional arguments, options with values and boolean switches by long names
+type batchStep struct {
+	action   string
+	command  []string
+	args     []string
+	options  map[string]string
+	switches map[string]bool
+}
+
+func (s *batchStep) arg(i int) string {
+	if i < len(s.args) {
+		return s.args[i]
+	}
+	return ""
+}
+
+// container returns name of the container changed by the step, empty for host-wide steps
+func (s *batchStep) container() string {
+	switch s.action {
+	case "clone":
+		return s.arg(1)
+	case "destroy", "start", "stop", "quota":
+		return s.arg(0)
+	}
+	return ""
+}
+
+// readOnly checks if the step only prints current state
+func (s *batchStep) readOnly() bool {
+	switch s.action {
+	case "quota":
+		return len(s.options["set"]) == 0 && len(s.options["threshold"]) == 0 && len(s.options["reserve"]) == 0
+	case "map":
+		return s.switches["list"]
+	}
+	return false
+}
+
+// audit records applied or rolled back step the same way as CLI command run on its own,
+// since in-process steps bypass audit of command line
+func (s *batchStep) audit(result, exitCode string) {
+	if s.readOnly() {
+		return
+	}
+	log.Check(log.WarnLevel, "Writing audit record",
+		audit.Step(s.command[0], s.container(), s.command, result, exitCode))
+}
+
+// batchAction describes CLI command supported by batch. Options and switches are lists of "long,short" names.
+// Check validates the step against the host state and containers created or destroyed by previous steps.
+// Apply performs the step and registers compensating operation with its description, if the step can be undone.
+// Operations which may fail after partial changes, e.g. clone, register it before the changes, so the step is
+// compensated even if it fails.
+type batchAction struct {
+	options  []string
+	switches []string
+	args     int
+	check    func(s *batchStep, p *batchPlan) error
+	apply    func(s *batchStep, undo func(u func() error, description string)) (Output, error)
+}
+
+// batchPlan tracks containers created and destroyed by validated steps
+type batchPlan struct {
+	created   map[string]bool
+	destroyed map[string]bool
+}
+
+func (p *batchPlan) exists(name string) bool {
+	return (container.IsContainer(name) || p.created[name]) && !p.destroyed[name]
+}
+
+func (p *batchPlan) container(name string) error {
+	if !p.exists(name) {
+		return errors.New("Container " + name + " not found")
+	}
+	return nil
+}
+
+var batchActions = map[string]batchAction{
+	"clone": {
+		options: []string{"env,e", "ipaddr,i", "token,t", "secret,s"},
+		args:    2,
+		check: func(s *batchStep, p *batchPlan) error {
+			if (container.LxcInstanceExists(s.arg(1)) || p.created[s.arg(1)]) && !p.destroyed[s.arg(1)] {
+				return errors.New("Container " + s.arg(1) + " already exists")
+			}
+			p.created[s.arg(1)] = true
+			delete(p.destroyed, s.arg(1))
+			return nil
+		},
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			undo(func() error {
+				if container.LxcInstanceExists(s.arg(1)) {
+					return LxcDestroy(s.arg(1), false)
+				}
+				return nil
+			}, "destroy "+s.arg(1))
+			return Output{}, LxcClone(s.arg(0), s.arg(1), s.options["env"], s.options["ipaddr"], s.options["secret"], s.options["token"])
+		},
+	},
+	"destroy": {
+		args: 1,
+		check: func(s *batchStep, p *batchPlan) error {
+			if err := p.container(s.arg(0)); err != nil {
+				return err
+			}
+			p.destroyed[s.arg(0)] = true
+			return nil
+		},
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			return Output{}, LxcDestroy(s.arg(0), false)
+		},
+	},
+	"start": {
+		args:  1,
+		check: func(s *batchStep, p *batchPlan) error { return p.container(s.arg(0)) },
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			if container.State(s.arg(0)) == "STOPPED" {
+				undo(func() error {
+					if container.State(s.arg(0)) != "STOPPED" {
+						return LxcStop(s.arg(0))
+					}
+					return nil
+				}, "stop "+s.arg(0))
+			}
+			return Output{}, LxcStart(s.arg(0))
+		},
+	},
+	"stop": {
+		args:  1,
+		check: func(s *batchStep, p *batchPlan) error { return p.container(s.arg(0)) },
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			if container.State(s.arg(0)) == "RUNNING" {
+				undo(func() error {
+					if container.State(s.arg(0)) != "RUNNING" {
+						return LxcStart(s.arg(0))
+					}
+					return nil
+				}, "start "+s.arg(0))
+			}
+			return Output{}, LxcStop(s.arg(0))
+		},
+	},
+	"quota": {
+		options: []string{"set,s", "threshold,t", "reserve,r"},
+		args:    2,
+		check: func(s *batchStep, p *batchPlan) error {
+			if err := checkQuota(s.arg(1), s.options["set"], s.options["threshold"], s.options["reserve"]); err != nil {
+				return err
+			}
+			return p.container(s.arg(0))
+		},
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			name, res := s.arg(0), s.arg(1)
+			set, threshold, reserve := s.options["set"], s.options["threshold"], s.options["reserve"]
+			if len(set) == 0 && len(threshold) == 0 && len(reserve) == 0 {
+				return LxcQuota(name, res, "", "", "")
+			}
+			quota, err := quotaValue(name, res, "")
+			if err != nil {
+				return Output{}, err
+			}
+			alert, critical := getQuotaThreshold(name, res)
+			if len(critical) > 0 {
+				alert += ":" + critical
+			}
+			reserved := ""
+			if len(reserve) > 0 {
+				if reserved, err = reserveValue(name, res, ""); err != nil {
+					return Output{}, err
+				}
+			}
+			description := "restore " + res + " quota " + quota + " with threshold " + alert
+			if len(reserve) > 0 {
+				description += " and reservation " + reserved
+			}
+			// values are applied one by one, so all of them are restored if any fails
+			undo(func() error {
+				if len(threshold) > 0 {
+					if err := setQuotaThreshold(name, res, alert); err != nil {
+						return err
+					}
+				}
+				if len(set) > 0 {
+					if _, err := quotaValue(name, res, quota); err != nil {
+						return err
+					}
+				}
+				if len(reserve) > 0 {
+					if _, err := reserveValue(name, res, reserved); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, description+" of "+name)
+			return LxcQuota(name, res, set, threshold, reserve)
+		},
+	},
+	"map": {
+		options:  []string{"internal,i", "external,e", "domain,d", "cert,c", "policy,p"},
+		switches: []string{"list,l", "remove,r", "sslbackend"},
+		check: func(s *batchStep, p *batchPlan) error {
+			switch s.arg(0) {
+			case "tcp", "udp", "http", "https":
+			default:
+				if !s.switches["list"] {
+					return errors.New("Unsupported protocol \"" + s.arg(0) + "\"")
+				}
+			}
+			return nil
+		},
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			protocol, internal, domain := s.arg(0), s.options["internal"], s.options["domain"]
+			out, err := MapPort(protocol, internal, s.options["external"], s.options["policy"], domain, s.options["cert"],
+				s.switches["list"], s.switches["remove"], s.switches["sslbackend"])
+			// new mapping has external port allocated by MapPort
+			m, ok := out.Result.(PortMap)
+			if err != nil || !ok || s.switches["remove"] || len(internal) == 0 {
+				return out, err
+			}
+			external := m.External[strings.LastIndex(m.External, ":")+1:]
+			undo(func() error {
+				_, err := MapPort(protocol, internal, external, "", domain, "", false, true, false)
+				return err
+			}, "remove "+protocol+" mapping "+external+" to "+internal)
+			return out, nil
+		},
+	},
+	"proxy add": {
+		options: []string{"domain,d", "host,h", "policy,p", "file,f"},
+		args:    2,
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			vlan, domain, host := s.arg(1), s.options["domain"], s.options["host"]
+			if err := ProxyAdd(vlan, domain, host, s.options["policy"], s.options["file"]); err != nil {
+				return Output{}, err
+			}
+			if len(domain) > 0 {
+				undo(func() error { return ProxyDel(vlan, "", true) }, "delete domain of vlan "+vlan)
+			} else if len(host) > 0 {
+				undo(func() error { return ProxyDel(vlan, host, false) }, "delete host "+host+" of vlan "+vlan)
+			}
+			return Output{}, nil
+		},
+	},
+	"proxy del": {
+		options:  []string{"host,h"},
+		switches: []string{"domain,d"},
+		args:     2,
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			return Output{}, ProxyDel(s.arg(1), s.options["host"], s.switches["domain"])
+		},
+	},
+	"tunnel add": {
+		switches: []string{"global,g"},
+		args:     2,
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			socket := s.arg(1)
+			if len(strings.Split(socket, ":")) == 1 {
+				socket = socket + ":22"
+			}
+			existing := getTunnel(socket) != nil
+			out, err := TunAdd(s.arg(1), s.arg(2))
+			if err == nil && !existing {
+				undo(func() error { return TunDel(socket) }, "delete tunnel to "+socket)
+			}
+			return out, err
+		},
+	},
+	"tunnel del": {
+		args: 2,
+		apply: func(s *batchStep, undo func(func() error, string)) (Output, error) {
+			return Output{}, TunDel(s.arg(1))
+		},
+	},
+}
+
+// parseStep splits batch line arguments to positional arguments, options and switches of the action
+func parseStep(line batchLine) (*batchStep, *batchAction, error) {
+	s := &batchStep{action: line.Action, command: append([]string{line.Action}, line.Args...), args: line.Args,
+		options: map[string]string{}, switches: map[string]bool{}}
+	name := line.Action
+	if (name == "proxy" || name == "tunnel") && len(line.Args) > 0 {
+		name += " " + line.Args[0]
+		s.action = name
+	}
+	a, ok := batchActions[name]
+	if !ok {
+		return s, nil, nil
+	}
+
+	lookup := func(list []string, flag string) string {
+		for _, names := range list {
+			for _, n := range strings.Split(names, ",") {
+				if flag == n {
+					return strings.Split(names, ",")[0]
+				}
+			}
+		}
+		return ""
+	}
+
+	s.args = nil
+	for i := 0; i < len(line.Args); i++ {
+		arg := line.Args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			s.args = append(s.args, arg)
+			continue
+		}
+		flag := strings.TrimLeft(arg, "-")
+		var value *string
+		if j := strings.Index(flag, "="); j != -1 {
+			v := flag[j+1:]
+			flag, value = flag[:j], &v
+		}
+		if long := lookup(a.switches, flag); len(long) > 0 {
+			s.switches[long] = value == nil || *value == "true"
+		} else if long := lookup(a.options, flag); len(long) > 0 {
+			if value == nil {
+				if i+1 == len(line.Args) {
+					return s, &a, errors.New("Option " + arg + " requires a value")
+				}
+				i++
+				value = &line.Args[i]
+			}
+			s.options[long] = *value
+		} else {
+			return s, &a, errors.New("Unknown option " + arg)
+		}
+	}
+
+	if len(s.args) < a.args {
+		return s, &a, errors.New("Not enough arguments for " + name)
+	}
+	for i := 0; i < a.args; i++ {
+		if len(s.args[i]) == 0 {
+			return s, &a, errors.New("Empty argument for " + name)
+		}
+	}
+	return s, &a, nil
+}
+
+// runStep executes batch step in-process and returns its output with log messages, exit code and compensating operation,
+// which is registered by failed step too, if it was made before the failure
+func runStep(s *batchStep, a *batchAction) (line outputLine, undo func() error) {
+	var buf bytes.Buffer
+	prev := log.Output(&buf)
+	defer log.Output(prev)
+
+	out, err := a.apply(s, func(u func() error, description string) { undo, line.Undo = u, description })
+	line.Output = buf.String() + out.Text()
+	line.ExitCode = "0"
+	if err != nil {
+		e := ExitError(err)
+		line.Output += e.Message
+		line.ExitCode = fmt.Sprint(e.Code)
+	}
+	return
+}
+
+// runCommand executes action not supported in-process by separate subutai process
+func runCommand(line batchLine) outputLine {
+	args := append([]string{line.Action}, line.Args...)
+	out, err := exec.Command("subutai", args...).CombinedOutput()
+	result := outputLine{Output: string(out), ExitCode: "0"}
+	if err != nil {
+		exitcode := strings.Fields(err.Error())
+		result.ExitCode = exitcode[len(exitcode)-1]
+	}
+	return result
 }
 
 // Batch binding provides a mechanism to perform several Subutai commands in the container in batch,
@@ -24,30 +405,108 @@ type outputLine struct {
 // yet it may be invoked manually from the CLI.
 // The response from a batch command returns a JSON array with each element representing the results (response) from each command (request) in the batch:
 // the positions of responses correlate with the request position in the array
-func Batch(data string) {
+//
+// Whole batch is validated before execution and supported commands (clone, destroy, start, stop, quota, map, proxy, tunnel)
+// run in-process. Each applied step registers compensating operation, e.g. cloned container is destroyed, mapping is removed
+// and quota is restored. If a step fails, it and completed steps are rolled back in reverse order, unless continueOnError
+// is set, in which case remaining steps are executed and nothing is rolled back. Commands which cannot be undone are run
+// as is and reported with "rollback skipped" status.
+// Option dryRun only validates the batch and exits with error if any step is invalid.
+func Batch(data string, dryRun, continueOnError bool) {
 	var jsonBlob = []byte(data)
 	var list []batchLine
 	err := json.Unmarshal(jsonBlob, &list)
 	log.Check(log.ErrorLevel, "Unmarshal JSON", err)
 
-	var output []outputLine
-	var cmdout outputLine
+	output := make([]outputLine, len(list))
+	steps := make([]*batchStep, len(list))
+	actions := make([]*batchAction, len(list))
+	plan := &batchPlan{created: map[string]bool{}, destroyed: map[string]bool{}}
+	valid := true
 
-	for _, item := range list {
-		args := append([]string{item.Action}, item.Args...)
-		out, err := exec.Command("subutai", args...).CombinedOutput()
-		cmdout.ExitCode = "0"
-		cmdout.Output = string(out)
+	for i, item := range list {
+		steps[i], actions[i], err = parseStep(item)
+		if err == nil && actions[i] != nil && actions[i].check != nil {
+			err = actions[i].check(steps[i], plan)
+		}
+		output[i] = outputLine{Action: steps[i].action, Status: "valid"}
 		if err != nil {
-			exitcode := strings.Fields(err.Error())
-			cmdout.ExitCode = exitcode[len(exitcode)-1]
-			output = append(output, cmdout)
-			break
+			output[i].Output, output[i].ExitCode, output[i].Status = err.Error(), fmt.Sprint(log.ExitUsage), "invalid"
+			valid = false
+		} else if actions[i] == nil {
+			output[i].Output = "Unsupported in batch, will be executed without rollback"
 		}
-		output = append(output, cmdout)
 	}
 
-	if result, err := json.Marshal(output); err == nil {
-		fmt.Println(string(result))
+	if dryRun || !valid {
+		for i := range output {
+			if !dryRun && output[i].Status == "valid" {
+				output[i].Output, output[i].Status = "", "skipped"
+			}
+		}
+		printBatch(output)
+		if !valid {
+			log.Exit(log.ExitUsage, "Batch validation failed")
+		}
+		return
+	}
+
+	var undo []func() error
+	var failed bool
+	for i, item := range list {
+		if failed {
+			output[i].Status = "skipped"
+			continue
+		}
+
+		var u func() error
+		if actions[i] != nil {
+			output[i], u = runStep(steps[i], actions[i])
+			output[i].Action = steps[i].action
+			steps[i].audit("batch", output[i].ExitCode)
+		} else {
+			output[i] = runCommand(item)
+			output[i].Action = steps[i].action
+		}
+		undo = append(undo, u)
+
+		output[i].Status = "done"
+		if output[i].ExitCode != "0" {
+			output[i].Status = "failed"
+			failed = !continueOnError
+		}
+	}
+
+	if failed {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if output[i].Status != "done" && output[i].Status != "failed" {
+				continue
+			}
+			if undo[i] == nil {
+				log.Warn("Step ", i, " (", output[i].Action, ") cannot be rolled back")
+				output[i].Status = "rollback skipped"
+				steps[i].audit("batch rollback skipped", output[i].ExitCode)
+				continue
+			}
+			if err := undo[i](); err != nil {
+				e := ExitError(err)
+				output[i].Status = "rollback failed"
+				output[i].Output += e.Message
+				steps[i].audit("batch rollback: "+output[i].Undo, fmt.Sprint(e.Code))
+			} else {
+				output[i].Status = "rolledback"
+				steps[i].audit("batch rollback: "+output[i].Undo, "0")
+			}
+		}
 	}
+
+	printBatch(output)
+}
+
+func printBatch(output []outputLine) {
+	printResult(output, func(w io.Writer) {
+		if result, err := json.Marshal(output); err == nil {
+			fmt.Fprintln(w, string(result))
+		}
+	})
 }
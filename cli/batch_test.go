@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+func TestParseStep(t *testing.T) {
+	tests := []struct {
+		line     batchLine
+		action   string
+		args     []string
+		options  map[string]string
+		switches map[string]bool
+		err      bool
+	}{
+		{batchLine{"clone", []string{"debian", "foo", "-e", "env", "--ipaddr=10.10.10.2/24"}},
+			"clone", []string{"debian", "foo"}, map[string]string{"env": "env", "ipaddr": "10.10.10.2/24"}, map[string]bool{}, false},
+		{batchLine{"map", []string{"http", "-i", "10.10.10.2:80", "-l", "--sslbackend=false"}},
+			"map", []string{"http"}, map[string]string{"internal": "10.10.10.2:80"}, map[string]bool{"list": true, "sslbackend": false}, false},
+		// subcommand is a part of the action, but stays the first argument
+		{batchLine{"proxy", []string{"add", "100", "-d", "example.com"}},
+			"proxy add", []string{"add", "100"}, map[string]string{"domain": "example.com"}, map[string]bool{}, false},
+		{batchLine{"clone", []string{"debian", "foo", "-e"}}, "clone", nil, nil, nil, true},
+		{batchLine{"clone", []string{"debian", "foo", "-x", "1"}}, "clone", nil, nil, nil, true},
+		{batchLine{"clone", []string{"debian"}}, "clone", nil, nil, nil, true},
+		{batchLine{"destroy", []string{""}}, "destroy", nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		s, a, err := parseStep(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: unexpected error %v", tt.line, err)
+			continue
+		}
+		if a == nil || s.action != tt.action {
+			t.Errorf("%v: action %q, want %q", tt.line, s.action, tt.action)
+		}
+		if tt.err {
+			continue
+		}
+		if !reflect.DeepEqual(s.args, tt.args) || !reflect.DeepEqual(s.options, tt.options) || !reflect.DeepEqual(s.switches, tt.switches) {
+			t.Errorf("%v: parsed to %v, %v, %v", tt.line, s.args, s.options, s.switches)
+		}
+	}
+
+	// commands not supported in-process are executed as they are
+	if s, a, err := parseStep(batchLine{"info", []string{"ipaddr", "-x"}}); a != nil || err != nil || len(s.command) != 3 {
+		t.Errorf("unsupported command is parsed: %v, %v", s.command, err)
+	}
+}
+
+func TestBatchStep(t *testing.T) {
+	tests := []struct {
+		line      batchLine
+		container string
+		readOnly  bool
+	}{
+		{batchLine{"clone", []string{"debian", "foo"}}, "foo", false},
+		{batchLine{"stop", []string{"foo"}}, "foo", false},
+		{batchLine{"quota", []string{"foo", "cpu"}}, "foo", true},
+		{batchLine{"quota", []string{"foo", "cpu", "-t", "80"}}, "foo", false},
+		{batchLine{"map", []string{"http", "-l"}}, "", true},
+		{batchLine{"map", []string{"http", "-i", "10.10.10.2:80"}}, "", false},
+	}
+	for _, tt := range tests {
+		s, _, err := parseStep(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.container() != tt.container || s.readOnly() != tt.readOnly {
+			t.Errorf("%v: container %q, read-only %v, want %q, %v", tt.line, s.container(), s.readOnly(), tt.container, tt.readOnly)
+		}
+	}
+}
+
+func TestBatchPlan(t *testing.T) {
+	// storage holds container "foo" only
+	f := fs.NewFake()
+	fs.SetDriver(f)
+	for _, d := range []string{"foo", "foo/rootfs"} {
+		if err := f.Create(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		line batchLine
+		err  bool
+	}{
+		{batchLine{"stop", []string{"foo"}}, false},
+		{batchLine{"start", []string{"bar"}}, true},
+		{batchLine{"clone", []string{"debian", "bar"}}, false},
+		{batchLine{"start", []string{"bar"}}, false},
+		{batchLine{"clone", []string{"debian", "bar"}}, true},
+		{batchLine{"destroy", []string{"foo"}}, false},
+		{batchLine{"quota", []string{"foo", "cpu"}}, true},
+		{batchLine{"destroy", []string{"foo"}}, true},
+		// destroyed container may be created again
+		{batchLine{"clone", []string{"debian", "foo"}}, false},
+		{batchLine{"quota", []string{"foo", "cpu"}}, false},
+		{batchLine{"map", []string{"ftp", "-i", "10.10.10.2:21"}}, true},
+	}
+	plan := &batchPlan{created: map[string]bool{}, destroyed: map[string]bool{}}
+	for i, tt := range tests {
+		s, a, err := parseStep(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = a.check(s, plan); (err != nil) != tt.err {
+			t.Errorf("step %d %v: unexpected check result %v", i, tt.line, err)
+		}
+	}
+}
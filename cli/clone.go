@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"net"
 	"strings"
 
@@ -28,14 +29,17 @@ var (
 // This is one of the security checks which makes sure that each container creation request is authorized by registered user.
 //
 // The clone options are not intended for manual use: unless you're confident about what you're doing. Use default clone format without additional options to create Subutai containers.
-func LxcClone(parent, child, envID, addr, consoleSecret, cdnToken string) {
+func LxcClone(parent, child, envID, addr, consoleSecret, cdnToken string) error {
 	child = utils.CleanTemplateName(child)
 
 	if container.LxcInstanceExists(child) {
-		log.Error("Container " + child + " already exists")
+		return errors.New("Container " + child + " already exists")
 	}
 
-	t := getTemplateInfo(parent, cdnToken)
+	t, err := getTemplateInfo(parent, cdnToken)
+	if err != nil {
+		return err
+	}
 
 	log.Debug("Parent template is " + t.Name + "@" + t.Owner[0] + ":" + t.Version)
 
@@ -48,14 +52,22 @@ func LxcClone(parent, child, envID, addr, consoleSecret, cdnToken string) {
 	fullRef := strings.Join([]string{t.Name, t.Owner[0], t.Version}, ":")
 
 	if !container.IsTemplate(fullRef) {
-		LxcImport("id:"+t.Id, cdnToken, false)
+		if err = LxcImport("id:"+t.Id, cdnToken, false); err != nil {
+			return err
+		}
 	}
 
-	log.Check(log.ErrorLevel, "Cloning the container", container.Clone(fullRef, child))
+	if err = container.Clone(fullRef, child); err != nil {
+		return errors.New("Cloning the container: " + err.Error())
+	}
 
-	gpg.GenerateKey(child)
+	if err = gpg.GenerateKey(child); err != nil {
+		return errors.New("Generating container key: " + err.Error())
+	}
 	if len(consoleSecret) != 0 {
-		gpg.ExchageAndEncrypt(child, consoleSecret)
+		if err = gpg.ExchageAndEncrypt(child, consoleSecret); err != nil {
+			return err
+		}
 	}
 
 	if len(envID) != 0 {
@@ -79,13 +91,18 @@ func LxcClone(parent, child, envID, addr, consoleSecret, cdnToken string) {
 	//Security matters workaround. Need to change it in parent templates
 	container.DisableSSHPwd(child)
 
-	LxcStart(child)
+	if err = LxcStart(child); err != nil {
+		return err
+	}
 
 	meta["interface"] = container.GetProperty(child, "lxc.network.veth.pair")
 
-	log.Check(log.ErrorLevel, "Writing container metadata to database", db.INSTANCE.ContainerAdd(child, meta))
+	if err = db.INSTANCE.ContainerAdd(child, meta); err != nil {
+		return errors.New("Writing container metadata to database: " + err.Error())
+	}
 
 	log.Info(child + " with ID " + gpg.GetFingerprint(child) + " successfully cloned")
+	return nil
 }
 
 // addNetConf adds network related configuration values to container config file
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/subutai-io/agent/db"
@@ -23,17 +24,16 @@ import (
 // The destroy command always runs each step in "force" mode to provide reliable deletion results;
 // even if some instance components were already removed, the destroy command will continue to perform all operations
 // once again while ignoring possible underlying errors: i.e. missing configuration files.
-func LxcDestroy(id string, vlan bool) {
+func LxcDestroy(id string, vlan bool) error {
 	var msg string
 	if len(id) == 0 {
-		log.Error("Please specify container/template name or vlan id")
+		return usage("Please specify container/template name or vlan id")
 	}
 
 	if strings.HasPrefix(id, "id:") {
 		for _, c := range container.Containers() {
 			if strings.ToUpper(strings.TrimPrefix(id, "id:")) == gpg.GetFingerprint(c) {
-				LxcDestroy(c, false)
-				return
+				return LxcDestroy(c, false)
 			}
 			msg = id + " not found. Please check if a container name is correct."
 		}
@@ -41,14 +41,18 @@ func LxcDestroy(id string, vlan bool) {
 		list, err := db.INSTANCE.ContainerByKey("vlan", id)
 		if !log.Check(log.WarnLevel, "Reading container metadata from db", err) {
 			for _, c := range list {
-				LxcDestroy(c, false)
+				if err = LxcDestroy(c, false); err != nil {
+					return err
+				}
 			}
 			msg = "Vlan " + id + " is destroyed"
 		}
-		cleanupNet(id)
+		if err = cleanupNet(id); err != nil {
+			return err
+		}
 	} else if id != "everything" {
 		if container.IsTemplate(id) {
-			log.Error("Pass -t flag to destroy template")
+			return usage("Pass -t flag to destroy template")
 		}
 
 		c, err := db.INSTANCE.ContainerByName(id)
@@ -60,21 +64,27 @@ func LxcDestroy(id string, vlan bool) {
 
 			if ip, ok := c["ip"]; ok {
 				if vlan, ok := c["vlan"]; ok {
-					ProxyDel(vlan, ip, false)
+					if err = ProxyDel(vlan, ip, false); err != nil {
+						return err
+					}
 				}
 			}
 
-			removePortMap(id)
+			if err = removePortMap(id); err != nil {
+				return err
+			}
 
 			net.DelIface(c["interface"])
 
-			log.Check(log.ErrorLevel, "Destroying container", container.DestroyContainer(id))
+			if err = container.DestroyContainer(id); err != nil {
+				return errors.New("Destroying container: " + err.Error())
+			}
 
 		} else if container.IsContainer(id) {
 
-			err = container.DestroyContainer(id)
-
-			log.Check(log.ErrorLevel, "Destroying container", err)
+			if err = container.DestroyContainer(id); err != nil {
+				return errors.New("Destroying container: " + err.Error())
+			}
 		}
 
 	}
@@ -83,11 +93,15 @@ func LxcDestroy(id string, vlan bool) {
 		list, err := db.INSTANCE.ContainerList()
 		if !log.Check(log.WarnLevel, "Reading container metadata from db", err) {
 			for _, name := range list {
-				LxcDestroy(name, false)
+				if err = LxcDestroy(name, false); err != nil {
+					return err
+				}
 				c, err := db.INSTANCE.ContainerByName(name)
 				if !log.Check(log.WarnLevel, "Reading container metadata from db", err) {
 					if v, ok := c["vlan"]; ok {
-						cleanupNet(v)
+						if err = cleanupNet(v); err != nil {
+							return err
+						}
 					}
 				}
 			}
@@ -104,17 +118,22 @@ func LxcDestroy(id string, vlan bool) {
 	}
 
 	log.Info(msg)
+	return nil
 }
 
-func LxcDestroyTemplate(name string) {
-	container.DestroyTemplate(name)
+// LxcDestroyTemplate removes a Subutai template
+func LxcDestroyTemplate(name string) error {
+	if err := container.DestroyTemplate(name); err != nil {
+		return errors.New("Destroying template: " + err.Error())
+	}
+	return nil
 }
 
-func cleanupNet(id string) {
+func cleanupNet(id string) error {
 	net.DelIface("gw-" + id)
 	p2p.RemoveByIface("p2p" + id)
 	cleanupNetStat(id)
-	ProxyDel(id, "", true)
+	return ProxyDel(id, "", true)
 }
 
 // cleanupNetStat drops data from database about network trafic for specified VLAN
@@ -127,13 +146,16 @@ func cleanupNetStat(vlan string) {
 	queryInfluxDB(c, `drop series from host_net where iface = 'gw-`+vlan+`'`)
 }
 
-func removePortMap(name string) {
+func removePortMap(name string) error {
 	if portMap, err := db.INSTANCE.GetContainerMapping(name);
 		!log.Check(log.WarnLevel, "Reading container metadata from db", err) {
 		for _, v := range portMap {
-			MapPort(v["protocol"], v["internal"], v["external"], "", v["domain"], "", false, true, false)
+			if _, err = MapPort(v["protocol"], v["internal"], v["external"], "", v["domain"], "", false, true, false); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
 func Prune(what string) {
@@ -169,7 +191,7 @@ func Prune(what string) {
 
 		//remove unused templates
 		for _, t := range unusedTemplates {
-			container.DestroyTemplate(t)
+			log.Check(log.ErrorLevel, "Destroying template "+t, container.DestroyTemplate(t))
 		}
 
 	} else {
@@ -51,7 +51,7 @@ func LxcExport(name, newname, version, prefsize, token, description string, priv
 
 	wasRunning := false
 	if container.State(name) == "RUNNING" {
-		LxcStop(name)
+		log.Check(log.ErrorLevel, "Stopping "+name, LxcStop(name))
 		wasRunning = true
 	}
 
@@ -93,16 +93,17 @@ func LxcExport(name, newname, version, prefsize, token, description string, priv
 			fs.RemoveDataset(name+"/"+vol+"@now", false)
 		}
 		// snapshot each partition
-		fs.CreateSnapshot(name + "/" + vol + "@now")
+		log.Check(log.ErrorLevel, "Creating snapshot of "+vol, fs.CreateSnapshot(name+"/"+vol+"@now"))
 
 		// send incremental delta between parent and child to delta file
-		fs.SendStream(parentRef+"/"+vol+"@now", name+"/"+vol+"@now", dst+"/deltas/"+vol+".delta")
+		log.Check(log.ErrorLevel, "Sending incremental stream of "+vol,
+			fs.SendStream(parentRef+"/"+vol+"@now", name+"/"+vol+"@now", dst+"/deltas/"+vol+".delta"))
 	}
 
 	//copy config files
 	src := path.Join(config.Agent.LxcPrefix, name)
-	fs.Copy(src+"/fstab", dst+"/fstab")
-	fs.Copy(src+"/config", dst+"/config")
+	log.Check(log.ErrorLevel, "Copying fstab", fs.Copy(src+"/fstab", dst+"/fstab"))
+	log.Check(log.ErrorLevel, "Copying config", fs.Copy(src+"/config", dst+"/config"))
 
 	//update template config
 	templateConf := [][]string{
@@ -139,12 +140,12 @@ func LxcExport(name, newname, version, prefsize, token, description string, priv
 
 	//copy template icon if any
 	if _, err := os.Stat(src + "/icon.png"); !os.IsNotExist(err) {
-		fs.Copy(src+"/icon.png", dst+"/icon.png")
+		log.Check(log.ErrorLevel, "Copying icon", fs.Copy(src+"/icon.png", dst+"/icon.png"))
 	}
 
 	// check: write package list to packages
 	if container.State(name) != "RUNNING" {
-		LxcStart(name)
+		log.Check(log.ErrorLevel, "Starting "+name, LxcStart(name))
 	}
 	pkgCmdResult, _ := container.AttachExec(name, []string{"timeout", "60", "dpkg", "-l"})
 	strCmdRes := strings.Join(pkgCmdResult, "\n")
@@ -191,9 +192,9 @@ func LxcExport(name, newname, version, prefsize, token, description string, priv
 	}
 
 	if wasRunning {
-		LxcStart(name)
+		log.Check(log.ErrorLevel, "Starting "+name, LxcStart(name))
 	} else {
-		LxcStop(name)
+		log.Check(log.ErrorLevel, "Stopping "+name, LxcStop(name))
 	}
 
 }
@@ -230,7 +231,9 @@ func upload(path, token string, private bool) ([]byte, error) {
 	defer file.Close()
 
 	//check CDN availability
-	utils.CheckCDN()
+	if err = utils.CheckCDN(); err != nil {
+		return nil, err
+	}
 
 	body := &bytes.Buffer{}
 
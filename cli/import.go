@@ -3,6 +3,7 @@ package cli
 import (
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -63,29 +64,33 @@ func init() {
 
 //TODO add only non empty params to URL
 // getTemplateInfoById retrieves template name from global repository by passed id string
-func getTemplateInfoById(t *templ, id string, token string) {
+func getTemplateInfoById(t *templ, id string, token string) error {
 	//Since only kurjun knows template's ID, we cannot define if we have template already installed in system by ID as we do it by name, so unreachable kurjun in this case is a deadend for us
 	//To omit this issue we should add ID into template config and use this ID as a "primary key" to any request
 	url := config.CDN.Kurjun + "/template/info?id=" + id + "&token=" + token
 
 	kurjun := utils.GetClient(config.CDN.Allowinsecure, 15)
 	response, err := kurjun.Get(url)
-	log.Check(log.ErrorLevel, "Retrieving template info, get: "+url, err)
+	if err != nil {
+		return errors.New("Retrieving template info, get: " + url + ": " + err.Error())
+	}
 	defer utils.Close(response)
 
 	if response.StatusCode == 404 {
-		log.Error("Template " + t.Name + " not found")
+		return errors.New("Template " + t.Name + " not found")
 	}
 	if response.StatusCode != 200 {
-		log.Error("Failed to get template info:  " + response.Status)
+		return errors.New("Failed to get template info:  " + response.Status)
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
-	log.Check(log.ErrorLevel, "Reading template info, get: "+url, err)
+	if err != nil {
+		return errors.New("Reading template info, get: " + url + ": " + err.Error())
+	}
 
 	var meta []metainfo
 	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &meta)) || len(meta) == 0 {
-		log.Error("Failed to parse template info")
+		return errors.New("Failed to parse template info")
 	}
 
 	t.Name = meta[0].Name
@@ -97,10 +102,11 @@ func getTemplateInfoById(t *templ, id string, token string) {
 	t.Signature = meta[0].Signs
 
 	log.Debug("Template identified as " + t.Name + "@" + t.Owner[0] + ":" + t.Version)
+	return nil
 }
 
 //TODO urlEncode the kurjun URL
-func getTemplateInfoByName(t *templ, name string, owner string, version string, token string) {
+func getTemplateInfoByName(t *templ, name string, owner string, version string, token string) error {
 	//Since only kurjun knows template's ID, we cannot define if we have template already installed in system by ID as we do it by name, so unreachable kurjun in this case is a deadend for us
 	//To omit this issue we should add ID into template config and use this ID as a "primary key" to any request
 
@@ -124,22 +130,26 @@ func getTemplateInfoByName(t *templ, name string, owner string, version string,
 
 	kurjun := utils.GetClient(config.CDN.Allowinsecure, 15)
 	response, err := kurjun.Get(url)
-	log.Check(log.ErrorLevel, "Retrieving template info, get: "+url, err)
+	if err != nil {
+		return errors.New("Retrieving template info, get: " + url + ": " + err.Error())
+	}
 	defer utils.Close(response)
 
 	if response.StatusCode == 404 {
-		log.Error("Template " + t.Name + " not found")
+		return errors.New("Template " + t.Name + " not found")
 	}
 	if response.StatusCode != 200 {
-		log.Error("Failed to get template info:  " + response.Status)
+		return errors.New("Failed to get template info:  " + response.Status)
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
-	log.Check(log.ErrorLevel, "Reading template info, get: "+url, err)
+	if err != nil {
+		return errors.New("Reading template info, get: " + url + ": " + err.Error())
+	}
 
 	var meta []metainfo
 	if log.Check(log.WarnLevel, "Parsing response body", json.Unmarshal(body, &meta)) || len(meta) == 0 {
-		log.Error("Failed to parse template info")
+		return errors.New("Failed to parse template info")
 	}
 
 	t.Name = meta[0].Name
@@ -151,6 +161,7 @@ func getTemplateInfoByName(t *templ, name string, owner string, version string,
 	t.Signature = meta[0].Signs
 
 	log.Debug("Template identified as " + t.Name + "@" + t.Owner[0] + ":" + t.Version)
+	return nil
 }
 
 func getTemplateInfoFromCacheById(templateId string) (templ, bool) {
@@ -195,20 +206,21 @@ func getTemplateInfoFromCacheByName(name, owner, version string) (templ, bool) {
 	return templ{}, false
 }
 
-func getTemplateInfo(template string, kurjToken string) templ {
+func getTemplateInfo(template string, kurjToken string) (templ, error) {
 
 	var t templ
+	var err error
 
 	if id := strings.Split(template, "id:"); len(id) > 1 {
 		templateId := id[1]
 
 		if t, found := getTemplateInfoFromCacheById(templateId); found {
-			return t
+			return t, nil
 		}
 
-		utils.CheckCDN()
-
-		getTemplateInfoById(&t, templateId, kurjToken)
+		if err = utils.CheckCDN(); err == nil {
+			err = getTemplateInfoById(&t, templateId, kurjToken)
+		}
 
 	} else {
 
@@ -219,43 +231,48 @@ func getTemplateInfo(template string, kurjToken string) templ {
 			groups := utils.MatchRegexGroups(templateNameNOwnerNVersionRx, template)
 
 			if t, found := getTemplateInfoFromCacheByName(groups["name"], groups["owner"], groups["version"]); found {
-				return t
+				return t, nil
 			}
 
-			utils.CheckCDN()
-
-			getTemplateInfoByName(&t, groups["name"], groups["owner"], groups["version"], kurjToken)
+			if err = utils.CheckCDN(); err == nil {
+				err = getTemplateInfoByName(&t, groups["name"], groups["owner"], groups["version"], kurjToken)
+			}
 		} else if templateNameNOwnerRx.MatchString(template) {
 			groups := utils.MatchRegexGroups(templateNameNOwnerRx, template)
 
 			if t, found := getTemplateInfoFromCacheByName(groups["name"], groups["owner"], ""); found {
-				return t
+				return t, nil
 			}
 
-			utils.CheckCDN()
-
-			getTemplateInfoByName(&t, groups["name"], groups["owner"], "", kurjToken)
+			if err = utils.CheckCDN(); err == nil {
+				err = getTemplateInfoByName(&t, groups["name"], groups["owner"], "", kurjToken)
+			}
 		} else if templateNameRx.MatchString(template) {
 			groups := utils.MatchRegexGroups(templateNameRx, template)
 
 			if t, found := getTemplateInfoFromCacheByName(groups["name"], "", ""); found {
-				return t
+				return t, nil
 			}
 
-			utils.CheckCDN()
-
-			getTemplateInfoByName(&t, groups["name"], "", "", kurjToken)
+			if err = utils.CheckCDN(); err == nil {
+				err = getTemplateInfoByName(&t, groups["name"], "", "", kurjToken)
+			}
 		} else {
-			log.Error("Invalid template name " + template)
+			return t, usage("Invalid template name " + template)
 		}
 
 	}
+	if err != nil {
+		return t, err
+	}
 
-	verifySignature(t)
+	if err = verifySignature(t); err != nil {
+		return t, err
+	}
 
 	log.Info("Version: " + t.Version)
 
-	return t
+	return t, nil
 }
 
 // md5sum returns MD5 hash sum of specified file
@@ -376,23 +393,24 @@ func lockSubutai(file string) (lockfile.Lockfile, error) {
 // "import management" demotes the template, starts its container, transforms the host network, and forwards a few host ports, etc.
 // "subutai import management -t {secret}" is executed by Console to register the container with itself,
 // Console passes special secret token in place of CDN token using -t switch in this operation
-func LxcImport(name, token string, local bool, auxDepList ...string) {
+func LxcImport(name, token string, local bool, auxDepList ...string) error {
 	var err error
 
-	if !fs.IsMountPoint(config.Agent.LxcPrefix) {
-		log.Fatal("Lxc directory " + config.Agent.LxcPrefix + " not mounted")
+	if fs.Backend() == "zfs" && !fs.IsMountPoint(config.Agent.LxcPrefix) {
+		return errors.New("Lxc directory " + config.Agent.LxcPrefix + " not mounted")
 	}
 
 	if container.LxcInstanceExists(name) && name == "management" && len(token) > 1 {
-		gpg.ExchageAndEncrypt("management", token)
-		return
+		return gpg.ExchageAndEncrypt("management", token)
 	}
 
 	var t templ
 	var templateRef string
 
 	if !local {
-		t = getTemplateInfo(name, token)
+		if t, err = getTemplateInfo(name, token); err != nil {
+			return err
+		}
 		templateRef = strings.Join([]string{t.Name, t.Owner[0], t.Version}, ":")
 	} else {
 		//for local import we currently use only name and ignore owner and version!
@@ -425,7 +443,7 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 			t.File = strings.Replace(latestVersionFile, path.Join(config.Agent.CacheDir)+"/", "", 1)
 
 		} else {
-			log.Error("Template " + t.Name + " not found in local cache")
+			return errors.New("Template " + t.Name + " not found in local cache")
 		}
 	}
 
@@ -440,12 +458,11 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 	//for local import this check currently does not work
 	if container.LxcInstanceExists(templateRef) {
 		if t.Name == "management" && !container.IsContainer("management") {
-			template.MngInit(templateRef)
-			return
+			return template.MngInit(templateRef)
 		}
 		//!important used by Console
 		log.Info(t.Name + " instance exists")
-		return
+		return nil
 	}
 
 	var archiveExists = fs.FileExists(path.Join(config.Agent.CacheDir, t.File))
@@ -496,7 +513,7 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 
 		if !downloaded && !downloadWithRetry(t, token, 5) {
 
-			log.Error("Failed to download or verify template " + t.Name)
+			return errors.New("Failed to download or verify template " + t.Name)
 		} else {
 
 			log.Info("File integrity is verified")
@@ -508,7 +525,9 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 	log.Debug(path.Join(config.Agent.CacheDir, t.File) + " to " + templateRef)
 	tgz := extractor.NewTgz()
 	templdir := path.Join(config.Agent.CacheDir, templateRef)
-	log.Check(log.FatalLevel, "Extracting tgz", tgz.Extract(path.Join(config.Agent.CacheDir, t.File), templdir))
+	if err = tgz.Extract(path.Join(config.Agent.CacheDir, t.File), templdir); err != nil {
+		return errors.New("Extracting tgz: " + err.Error())
+	}
 
 	templateName := container.GetConfigItem(templdir+"/config", "subutai.template")
 	templateOwner := container.GetConfigItem(templdir+"/config", "subutai.template.owner")
@@ -530,7 +549,9 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 		// Append the template and parent name to dependency list
 		auxDepList = append(auxDepList, parentRef, templateRef)
 		log.Info("Parent template required: " + parentRef)
-		LxcImport(parentRef, token, local, auxDepList...)
+		if err = LxcImport(parentRef, token, local, auxDepList...); err != nil {
+			return err
+		}
 	}
 
 	//!important used by Console
@@ -541,9 +562,13 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 		fs.RemoveDataset(templateRef, true)
 	}
 
-	template.Install(templateRef)
+	if err = template.Install(templateRef); err != nil {
+		return errors.New("Installing template: " + err.Error())
+	}
 
-	log.Check(log.FatalLevel, "Removing temp dir "+templdir, os.RemoveAll(templdir))
+	if err = os.RemoveAll(templdir); err != nil {
+		return errors.New("Removing temp dir " + templdir + ": " + err.Error())
+	}
 
 	//delete template archive
 	if !local {
@@ -552,11 +577,12 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 	}
 
 	if t.Name == "management" {
-		template.MngInit(templateRef)
-		return
+		return template.MngInit(templateRef)
 	}
 
-	log.Check(log.ErrorLevel, "Setting lxc config", updateContainerConfig(templateRef))
+	if err = updateContainerConfig(templateRef); err != nil {
+		return errors.New("Setting lxc config: " + err.Error())
+	}
 
 	if !local {
 		cacheTemplateInfo(t)
@@ -576,6 +602,7 @@ func LxcImport(name, token string, local bool, auxDepList ...string) {
 
 	}
 
+	return nil
 }
 
 func updateContainerConfig(templateName string) error {
@@ -614,23 +641,27 @@ func getVersion(fileName string) string {
 	return strings.Replace(strings.SplitAfter(fileName, "subutai-template_")[1], "_"+strings.ToLower(runtime.GOARCH)+".tar.gz", "", 1)
 }
 
-func verifySignature(t templ) {
+func verifySignature(t templ) error {
 
 	if len(t.Id) != 0 && len(t.Signature) == 0 {
-		log.Error("Template is not signed")
+		return errors.New("Template is not signed")
 	}
 
 	for owner, signature := range t.Signature {
-		for _, key := range gpg.KurjunUserPK(owner) {
+		keys, err := gpg.KurjunUserPK(owner)
+		if err != nil {
+			return errors.New("Getting owner public key: " + err.Error())
+		}
+		for _, key := range keys {
 			if t.Id == gpg.VerifySignature(key, signature) {
 				log.Info("Template's owner signature verified")
 				log.Debug("Signature belongs to " + owner)
-				return
+				return nil
 			}
 			log.Debug("Signature does not match with template id")
 		}
 	}
-	log.Error("Failed to verify signature")
+	return errors.New("Failed to verify signature")
 }
 
 // Verify if package is already on dependency list
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -14,17 +15,16 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	exc "github.com/subutai-io/agent/lib/exec"
 
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/cgroup"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/lib/gpg"
 	"github.com/subutai-io/agent/lib/net"
 	"github.com/subutai-io/agent/log"
 	"github.com/subutai-io/agent/agent/utils"
-	"path"
 )
 
 type hostStat struct {
@@ -47,10 +47,20 @@ type hostStat struct {
 }
 
 type quotaUsage struct {
-	Container string `json:"container"`
-	CPU       int    `json:"cpu"`
-	Disk      int
-	RAM       int    `json:"ram"`
+	Container       string `json:"container"`
+	CPU             int    `json:"cpu"`
+	Disk            int
+	DiskQuota       int                  `json:"diskQuota"`
+	DiskReservation int                  `json:"diskReservation"`
+	RAM             int                  `json:"ram"`
+	Partitions      map[string]diskUsage `json:"partitions"`
+}
+
+// diskUsage describes container partition usage in percents of its quota, quota and reservation in Gb
+type diskUsage struct {
+	Usage       int `json:"usage"`
+	Quota       int `json:"quota"`
+	Reservation int `json:"reservation"`
 }
 
 func queryDB(cmd string) (res []client.Result, err error) {
@@ -143,20 +153,8 @@ func cpuLoad(h string) interface{} {
 }
 
 func diskLoad() (diskavail, diskused int) {
-	out, err := exc.Execute("zfs", "list", path.Join(config.Agent.Dataset))
-	log.Check(log.ErrorLevel, "Gettings zfs list "+out, err)
-
-	line := strings.Split(out, "\n")
-
-	if len(line) > 1 {
-		fields := strings.Fields(line[1])
-
-		if len(fields) > 2 {
-			diskused, _ = fs.ConvertToBytes(fields[1])
-			diskavail, _ = fs.ConvertToBytes(fields[2])
-		}
-	}
-
+	diskused, diskavail, err := fs.DiskSpace()
+	log.Check(log.ErrorLevel, "Getting storage space", err)
 	return
 }
 
@@ -177,21 +175,9 @@ func cpuQuotaUsage(h string) int {
 	return cpuUsage
 }
 
-func read(path string) (i int) {
-	f, err := os.Open(path)
-	log.Check(log.FatalLevel, "Reading "+path, err)
-	defer f.Close()
-	scanner := bufio.NewScanner(bufio.NewReader(f))
-	for scanner.Scan() {
-		i, err = strconv.Atoi(scanner.Text())
-		log.Check(log.FatalLevel, "Converting string", err)
-	}
-	return
-}
-
 func ramQuotaUsage(h string) int {
-	u := read("/sys/fs/cgroup/memory/lxc/" + h + "/memory.usage_in_bytes")
-	l := read("/sys/fs/cgroup/memory/lxc/" + h + "/memory.limit_in_bytes")
+	u, l, err := cgroup.Memory(h)
+	log.Check(log.FatalLevel, "Reading memory usage of "+h, err)
 
 	ramUsage := 0
 	if l != 0 {
@@ -220,6 +206,15 @@ func diskQuotaUsage(path string) int {
 	return diskUsage
 }
 
+// partitionQuotaUsage returns space referenced by partition in percents of its quota, snapshots are not counted
+func partitionQuotaUsage(path string) int {
+	d, err := fs.GetDataset(path)
+	if err != nil || d.RefQuota == 0 {
+		return 0
+	}
+	return d.Referenced * 100 / d.RefQuota
+}
+
 // quota returns Json string with container's resource quota information
 func quota(h string) string {
 	usage := new(quotaUsage)
@@ -227,6 +222,13 @@ func quota(h string) string {
 	usage.CPU = cpuQuotaUsage(h)
 	usage.RAM = ramQuotaUsage(h)
 	usage.Disk = diskQuotaUsage(h)
+	quotas, reservations := container.DiskLimits(h)
+	usage.DiskQuota, usage.DiskReservation = quotas["disk"], reservations["disk"]
+	usage.Partitions = make(map[string]diskUsage)
+	for _, partition := range container.Partitions {
+		usage.Partitions[partition] = diskUsage{Usage: partitionQuotaUsage(h + "/" + partition),
+			Quota: quotas[partition], Reservation: reservations[partition]}
+	}
 
 	a, err := json.Marshal(usage)
 	if err != nil {
@@ -291,32 +293,42 @@ func grep(str, filename string) string {
 // external IP address to access the container host quotas, its CPU model, RAM size, etc. It's mainly used for internal SS needs.
 func Info(command, host string) {
 	if command == "ipaddr" {
-		fmt.Println(net.GetIp())
+		ip := net.GetIp()
+		printResult(map[string]string{"ip": ip}, func(w io.Writer) { fmt.Fprintln(w, ip) })
 		return
 	} else if command == "ports" {
+		ports := []string{}
 		for k := range usedPorts() {
-			fmt.Println(k)
+			ports = append(ports, k)
 		}
+		printResult(ports, func(w io.Writer) {
+			for _, k := range ports {
+				fmt.Fprintln(w, k)
+			}
+		})
 	} else if command == "os" {
-
-		fmt.Printf("%s\n", getOsName())
+		name := getOsName()
+		printResult(map[string]string{"os": name}, func(w io.Writer) { fmt.Fprintf(w, "%s\n", name) })
 	} else if command == "id" {
 		os.Setenv("GNUPGHOME", config.Agent.GpgHome)
 		defer os.Unsetenv("GNUPGHOME")
-		fmt.Printf("%s\n", gpg.GetFingerprint("rh@subutai.io"))
+		id := gpg.GetFingerprint("rh@subutai.io")
+		printResult(map[string]string{"id": id}, func(w io.Writer) { fmt.Fprintf(w, "%s\n", id) })
 	} else if command == "du" {
 		usage, err := fs.DatasetDiskUsage(host)
 		log.Check(log.ErrorLevel, "Checking disk usage", err)
-		fmt.Println(usage)
+		printResult(map[string]int{"usage": usage}, func(w io.Writer) { fmt.Fprintln(w, usage) })
 	} else if command == "quota" {
 		if len(host) == 0 {
-			log.Error("Usage: subutai info <quota|system> <hostname>")
+			log.Exit(log.ExitUsage, "Usage: subutai info <quota|system> <hostname>")
 		}
-		fmt.Println(quota(host))
+		usage := quota(host)
+		printResult(json.RawMessage(usage), func(w io.Writer) { fmt.Fprintln(w, usage) })
 	} else if command == "system" {
 		host, err := os.Hostname()
 		log.Check(log.DebugLevel, "Getting hostname of the system", err)
-		fmt.Println(sysLoad(host))
+		load := sysLoad(host)
+		printResult(json.RawMessage(load), func(w io.Writer) { fmt.Fprintln(w, load) })
 	}
 }
 
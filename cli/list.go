@@ -1,15 +1,14 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/lib/container"
-	"github.com/subutai-io/agent/log"
 	"gopkg.in/lxc/go-lxc.v2"
 	"sort"
 )
@@ -38,76 +37,101 @@ func printHeader(w io.Writer, c, t, i, p bool) {
 	fmt.Fprintln(w, line)
 }
 
+// Instance describes Subutai container or template in list output
+type Instance struct {
+	Name      string `json:"name"`
+	State     string `json:"state,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Parent    string `json:"parent,omitempty"`
+}
+
 // printList prints list
-func printList(list []string, c, t, i, p bool) {
+func printList(out io.Writer, list []Instance, c, t, i, p bool) {
 	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	w.Init(out, 0, 8, 1, '\t', 0)
 	printHeader(w, c, t, i, p)
 	for _, item := range list {
-		fmt.Fprintln(w, item)
+		line := item.Name
+		if i {
+			line = line + "\t" + item.State + "\t" + item.IP + "\t" + item.Interface
+		}
+		if p {
+			line = line + "\t" + item.Parent
+		}
+		fmt.Fprintln(w, line)
 	}
 	w.Flush()
 }
 
 // LxcList function shows a listing of Subutai instances with information such as IP address, parent template, etc.
-func LxcList(name string, c, t, i, p bool) {
-	var list []string
+func LxcList(name string, c, t, i, p bool) (Output, error) {
+	var names []string
 	if i {
 		if name == "" {
-			for _, item := range container.Containers() {
-				list = append(list, info(item)...)
-			}
+			names = container.Containers()
 		} else {
-			list = append(list, info(name)...)
+			names = []string{name}
 		}
 	} else if c == t {
-		list = append(list, container.All()...)
+		names = container.All()
 	} else if c {
-		list = append(list, container.Containers()...)
+		names = container.Containers()
 	} else if t {
-		list = append(list, container.Templates()...)
+		names = container.Templates()
 	}
-	for j := range list {
-		if list[j] == name {
-			list = []string{name}
-			break
-		} else if name != "" && j == len(list)-1 && !i {
-			list = []string{}
+	if name != "" && !i {
+		var found []string
+		for _, item := range names {
+			if item == name {
+				found = []string{name}
+				break
+			}
 		}
+		names = found
 	}
-	if p {
-		list = addParent(list)
+
+	list := []Instance{}
+	for _, item := range names {
+		entry := Instance{Name: item}
+		if i {
+			var err error
+			if entry, err = info(item); err != nil {
+				return Output{}, err
+			}
+		}
+		if p {
+			entry.Parent = parent(item)
+		}
+		list = append(list, entry)
 	}
-	sort.Strings(list)
-	printList(list, c, t, i, p)
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
 
+	return Output{Result: list, table: func(w io.Writer) { printList(w, list, c, t, i, p) }}, nil
 }
 
-// addParent adds parent to each template in list
-func addParent(list []string) []string {
-	for i := range list {
-		name := strings.Fields(list[i])[0]
-		parent := strings.TrimSpace(container.GetProperty(name, "subutai.parent")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.owner")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.version"))
-		if name == parent {
-			list[i] = list[i] + "\t"
-		} else {
-			list[i] = list[i] + "\t" + parent
-		}
+// parent returns parent template reference, empty for templates without parent
+func parent(name string) string {
+	parent := strings.TrimSpace(container.GetProperty(name, "subutai.parent")) + ":" +
+		strings.TrimSpace(container.GetProperty(name, "subutai.parent.owner")) + ":" +
+		strings.TrimSpace(container.GetProperty(name, "subutai.parent.version"))
+	if name == parent {
+		return ""
 	}
-	return list
+	return parent
 }
 
-// info adds container's IP and NIC to list
-func info(name string) (result []string) {
+// info returns container's state, IP and NIC
+func info(name string) (Instance, error) {
 	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
-	log.Check(log.FatalLevel, "Looking for container "+name, err)
+	if err != nil {
+		return Instance{}, errors.New("Looking for container " + name + ": " + err.Error())
+	}
 	defer lxc.Release(c)
 
 	nic := "eth0"
 	listip, _ := c.IPAddress(nic)
 	ip := strings.Join(listip, " ")
 
-	return append(result, name+"\t"+container.State(name)+"\t"+ip+"\t"+nic)
+	return Instance{Name: name, State: container.State(name), IP: ip, Interface: nic}, nil
 }
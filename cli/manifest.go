@@ -0,0 +1,434 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/log"
+)
+
+// manifest describes desired state of environment, e.g.
+//
+//	containers:
+//	  - name: web1
+//	    template: nginx
+//	    ip: 10.10.1.2/24
+//	    vlan: 100
+//	    hostname: web1
+//	    quota: {cpu: 50, ram: 512, disk: 10}
+//	ports:
+//	  - {protocol: http, internal: "10.10.1.2:80", external: 8080, domain: example.com}
+//	proxies:
+//	  - {vlan: 100, domain: example.com, hosts: ["10.10.1.2:80"]}
+//	vxlan:
+//	  - {name: vxlan-100, remote: 192.168.1.5, vlan: 100, vni: 12345}
+type manifest struct {
+	Containers []manifestContainer `yaml:"containers"`
+	Ports      []manifestPort      `yaml:"ports"`
+	Proxies    []manifestProxy     `yaml:"proxies"`
+	Vxlan      []manifestVxlan     `yaml:"vxlan"`
+}
+
+type manifestContainer struct {
+	Name     string            `yaml:"name"`
+	Template string            `yaml:"template"`
+	IP       string            `yaml:"ip"`
+	Vlan     string            `yaml:"vlan"`
+	Env      string            `yaml:"env"`
+	Hostname string            `yaml:"hostname"`
+	Quota    map[string]string `yaml:"quota"`
+}
+
+type manifestPort struct {
+	Protocol string `yaml:"protocol"`
+	Internal string `yaml:"internal"`
+	External string `yaml:"external"`
+	Domain   string `yaml:"domain"`
+	Cert     string `yaml:"cert"`
+	Policy   string `yaml:"policy"`
+}
+
+type manifestProxy struct {
+	Vlan   string   `yaml:"vlan"`
+	Domain string   `yaml:"domain"`
+	Hosts  []string `yaml:"hosts"`
+	Policy string   `yaml:"policy"`
+	File   string   `yaml:"file"`
+}
+
+type manifestVxlan struct {
+	Name   string `yaml:"name"`
+	Remote string `yaml:"remote"`
+	Vlan   string `yaml:"vlan"`
+	VNI    string `yaml:"vni"`
+}
+
+// ManifestChange describes single operation required to converge environment to its manifest.
+// Operation is one of create, update, replace or delete. Conflict is a container which differs from manifest
+// in template or network and can be converged only by recreation, which destroys its data.
+type ManifestChange struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+	Detail    string `json:"detail,omitempty"`
+	apply     func() error
+}
+
+// readManifest loads and validates environment manifest
+func readManifest(file string) *manifest {
+	data, err := ioutil.ReadFile(file)
+	log.Check(log.ErrorLevel, "Reading manifest "+file, err)
+
+	m := &manifest{}
+	log.Check(log.ErrorLevel, "Parsing manifest "+file, yaml.UnmarshalStrict(data, m))
+
+	names := make(map[string]bool)
+	for _, c := range m.Containers {
+		if len(c.Name) == 0 || len(c.Template) == 0 {
+			log.Error("Container name and template are mandatory in manifest")
+		} else if names[c.Name] {
+			log.Error("Container " + c.Name + " is defined twice in manifest")
+		} else if (len(c.IP) == 0) != (len(c.Vlan) == 0) {
+			log.Error("Container " + c.Name + " should have both ip and vlan or neither of them")
+		}
+		names[c.Name] = true
+		for res := range c.Quota {
+			switch res {
+			case "cpu", "cpuset", "ram", "disk", "network", "rootfs", "home", "var", "opt",
+				"io.rbps", "io.wbps", "io.riops", "io.wiops":
+			default:
+				log.Error("Unsupported quota resource " + res + " of container " + c.Name)
+			}
+		}
+	}
+	for _, p := range m.Ports {
+		if p.Protocol != "tcp" && p.Protocol != "udp" && p.Protocol != "http" && p.Protocol != "https" {
+			log.Error("Unsupported protocol \"" + p.Protocol + "\" in manifest")
+		} else if len(p.Internal) == 0 {
+			log.Error("Internal socket is mandatory for port mapping in manifest")
+		} else if (p.Protocol == "http" || p.Protocol == "https") && len(p.Domain) == 0 {
+			log.Error("Domain is mandatory for " + p.Protocol + " port mapping in manifest")
+		}
+	}
+	for _, p := range m.Proxies {
+		if len(p.Vlan) == 0 || len(p.Domain) == 0 {
+			log.Error("Proxy vlan and domain are mandatory in manifest")
+		}
+	}
+	for _, v := range m.Vxlan {
+		if len(v.Name) == 0 || len(v.Remote) == 0 || len(v.Vlan) == 0 || len(v.VNI) == 0 {
+			log.Error("VXLAN name, remote, vlan and vni are mandatory in manifest")
+		}
+	}
+	return m
+}
+
+// ManifestDiff shows changes required to converge current state of environment to the manifest.
+// Conflicting containers are shown as replaced if recreate is set.
+func ManifestDiff(file string, recreate bool) {
+	changes := manifestChanges(readManifest(file), recreate)
+	printChanges(changes, "Environment is up to date")
+}
+
+// ManifestApply converges environment to the manifest: creates missing containers, port mappings, proxy domains
+// and VXLAN tunnels, updates changed ones and removes port mappings and proxy hosts of the environment not present in manifest.
+// Containers and other objects not mentioned in manifest are never touched, so applying the same manifest again changes nothing.
+// Container which differs from manifest in template or network is destroyed and cloned again only if recreate is set,
+// otherwise nothing is applied.
+func ManifestApply(file string, recreate bool) {
+	changes := manifestChanges(readManifest(file), recreate)
+	var conflicts []string
+	for _, c := range changes {
+		if c.Operation == "conflict" {
+			conflicts = append(conflicts, c.Name+" ("+c.Detail+")")
+		}
+	}
+	if len(conflicts) > 0 {
+		log.Error("Containers differ from manifest: " + strings.Join(conflicts, ", ") + ", use --recreate to destroy and clone them again")
+	}
+	for _, c := range changes {
+		log.Info(strings.Title(c.Operation) + " " + c.Kind + " " + c.Name)
+		log.Check(log.ErrorLevel, strings.Title(c.Operation)+" "+c.Kind+" "+c.Name, c.apply())
+	}
+	printChanges(changes, "Environment is up to date")
+}
+
+// ManifestDestroy removes all objects described by the manifest
+func ManifestDestroy(file string) {
+	m := readManifest(file)
+	var changes []ManifestChange
+
+	ports := mapped()
+	for _, p := range m.Ports {
+		if e := findPort(ports, p); e != nil {
+			changes = append(changes, removePort(*e))
+		}
+	}
+	for _, p := range m.Proxies {
+		if vlan := p.Vlan; isVlanExist(vlan) {
+			changes = append(changes, ManifestChange{Kind: "proxy", Name: vlan, Operation: "delete", Detail: getDomain(vlan),
+				apply: func() error { return ProxyDel(vlan, "", true) }})
+		}
+	}
+	existing := make(map[string]bool)
+	for _, t := range vxlanTunnels() {
+		existing[t.Name] = true
+	}
+	for _, v := range m.Vxlan {
+		if name := v.Name; existing[name] {
+			changes = append(changes, ManifestChange{Kind: "vxlan", Name: name, Operation: "delete",
+				apply: func() error { net.DelIface(name); return nil }})
+		}
+	}
+	for i := len(m.Containers) - 1; i >= 0; i-- {
+		if name := m.Containers[i].Name; container.IsContainer(name) {
+			changes = append(changes, ManifestChange{Kind: "container", Name: name, Operation: "delete",
+				apply: func() error { return LxcDestroy(name, false) }})
+		}
+	}
+
+	for _, c := range changes {
+		log.Info("Delete " + c.Kind + " " + c.Name)
+		log.Check(log.ErrorLevel, "Delete "+c.Kind+" "+c.Name, c.apply())
+	}
+	printChanges(changes, "Nothing to destroy")
+}
+
+func printChanges(changes []ManifestChange, empty string) {
+	if changes == nil {
+		changes = []ManifestChange{}
+	}
+	printResult(changes, func(w io.Writer) {
+		if len(changes) == 0 {
+			fmt.Fprintln(w, empty)
+			return
+		}
+		for _, c := range changes {
+			line := fmt.Sprintf("%-8s %-10s %s", c.Operation, c.Kind, c.Name)
+			if len(c.Detail) > 0 {
+				line += " (" + c.Detail + ")"
+			}
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+// manifestChanges compares manifest with current state of LXC containers, database, nginx configuration and OVS
+// and returns changes in order of their application
+func manifestChanges(m *manifest, recreate bool) (changes []ManifestChange) {
+	ips := make(map[string]bool)
+	for _, c := range m.Containers {
+		changes = append(changes, containerChanges(c, recreate)...)
+		if len(c.IP) > 0 {
+			ips[strings.Split(c.IP, "/")[0]] = true
+		}
+	}
+
+	tunnels := make(map[string]VxlanTunnelInfo)
+	for _, t := range vxlanTunnels() {
+		tunnels[t.Name] = t
+	}
+	for _, v := range m.Vxlan {
+		v := v
+		create := func() error { tunnelCreate(v.Name, v.Remote, v.Vlan, v.VNI); return nil }
+		if t, ok := tunnels[v.Name]; !ok {
+			changes = append(changes, ManifestChange{Kind: "vxlan", Name: v.Name, Operation: "create",
+				Detail: v.Remote + " vlan " + v.Vlan, apply: create})
+		} else if t.RemoteIP != v.Remote || t.Vlan != v.Vlan || t.VNI != v.VNI {
+			changes = append(changes, ManifestChange{Kind: "vxlan", Name: v.Name, Operation: "replace",
+				Detail: v.Remote + " vlan " + v.Vlan, apply: func() error { net.DelIface(v.Name); return create() }})
+		}
+	}
+
+	for _, p := range m.Proxies {
+		changes = append(changes, proxyChanges(p)...)
+	}
+
+	ports := mapped()
+	matched := make(map[PortMap]bool)
+	for _, p := range m.Ports {
+		p := p
+		if e := findPort(ports, p); e != nil {
+			matched[*e] = true
+			continue
+		}
+		changes = append(changes, ManifestChange{Kind: "port", Name: p.Protocol + " " + p.Internal, Operation: "create",
+			Detail: strings.TrimSpace(p.External + " " + p.Domain), apply: func() error {
+				_, err := MapPort(p.Protocol, p.Internal, p.External, p.Policy, p.Domain, p.Cert, false, false, false)
+				return err
+			}})
+	}
+	// mappings to containers of the environment which are not in manifest anymore
+	for _, e := range ports {
+		if !matched[e] && ips[strings.Split(e.Internal, ":")[0]] {
+			changes = append(changes, removePort(e))
+		}
+	}
+	return
+}
+
+// containerChanges returns changes of container, its hostname and quotas.
+// Container is replaced on mismatch only if recreate is set, otherwise the mismatch is returned as a conflict.
+func containerChanges(c manifestContainer, recreate bool) (changes []ManifestChange) {
+	addr := strings.TrimSpace(c.IP + " " + c.Vlan)
+	clone := func() error { return LxcClone(c.Template, c.Name, c.Env, addr, "", "") }
+
+	exists := container.IsContainer(c.Name)
+	if !exists {
+		changes = append(changes, ManifestChange{Kind: "container", Name: c.Name, Operation: "create",
+			Detail: strings.TrimSpace(c.Template + " " + addr), apply: clone})
+	} else if reason := containerMismatch(c); len(reason) > 0 && recreate {
+		changes = append(changes, ManifestChange{Kind: "container", Name: c.Name, Operation: "replace",
+			Detail: reason, apply: func() error {
+				if err := LxcDestroy(c.Name, false); err != nil {
+					return err
+				}
+				return clone()
+			}})
+		exists = false
+	} else if len(reason) > 0 {
+		changes = append(changes, ManifestChange{Kind: "container", Name: c.Name, Operation: "conflict", Detail: reason})
+	}
+
+	if len(c.Hostname) > 0 {
+		hostname, _ := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, c.Name, "rootfs/etc/hostname"))
+		if !exists || strings.TrimSpace(string(hostname)) != c.Hostname {
+			changes = append(changes, ManifestChange{Kind: "hostname", Name: c.Name, Operation: "update",
+				Detail: c.Hostname, apply: func() error { LxcHostname(c.Name, c.Hostname); return nil }})
+		}
+	}
+
+	var resources []string
+	for res := range c.Quota {
+		resources = append(resources, res)
+	}
+	sort.Strings(resources)
+	for _, res := range resources {
+		res, size := res, c.Quota[res]
+		if quota, err := quotaValue(c.Name, res, ""); exists && err == nil && quota == size {
+			continue
+		}
+		changes = append(changes, ManifestChange{Kind: "quota", Name: c.Name, Operation: "update",
+			Detail: res + " " + size, apply: func() error {
+				_, err := quotaValue(c.Name, res, size)
+				return err
+			}})
+	}
+	return
+}
+
+// containerMismatch returns difference of existing container and its manifest which requires container to be recreated.
+// Network is compared only if manifest defines it.
+func containerMismatch(c manifestContainer) string {
+	ref := strings.Split(parent(c.Name), ":")
+	if want := strings.Split(c.Template, ":"); want[0] != ref[0] || (len(want) == 3 && c.Template != strings.Join(ref, ":")) {
+		return "template " + strings.Join(ref, ":") + " -> " + c.Template
+	}
+	if len(c.IP) == 0 {
+		return ""
+	}
+	meta, err := db.INSTANCE.ContainerByName(c.Name)
+	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	if ip := strings.Split(c.IP, "/")[0]; meta["ip"] != ip || meta["vlan"] != c.Vlan {
+		return "network " + strings.TrimSpace(meta["ip"]+" "+meta["vlan"]) + " -> " + strings.TrimSpace(ip+" "+c.Vlan)
+	}
+	return ""
+}
+
+// proxyChanges returns changes of proxy domain and its hosts
+func proxyChanges(p manifestProxy) (changes []ManifestChange) {
+	exists := isVlanExist(p.Vlan)
+	add := func() error { return ProxyAdd(p.Vlan, p.Domain, "", p.Policy, p.File) }
+	if !exists {
+		changes = append(changes, ManifestChange{Kind: "proxy", Name: p.Vlan, Operation: "create", Detail: p.Domain, apply: add})
+	} else if domain := getDomain(p.Vlan); domain != p.Domain {
+		changes = append(changes, ManifestChange{Kind: "proxy", Name: p.Vlan, Operation: "replace",
+			Detail: domain + " -> " + p.Domain, apply: func() error {
+				if err := ProxyDel(p.Vlan, "", true); err != nil {
+					return err
+				}
+				return add()
+			}})
+		exists = false
+	}
+
+	var current []string
+	if exists {
+		current = proxyNodes(p.Vlan)
+	}
+	for _, host := range p.Hosts {
+		if !contains(current, host) {
+			host := host
+			changes = append(changes, ManifestChange{Kind: "proxy", Name: p.Vlan, Operation: "update",
+				Detail: "add host " + host, apply: func() error { return ProxyAdd(p.Vlan, "", host, "", "") }})
+		}
+	}
+	for _, host := range current {
+		if !contains(p.Hosts, host) {
+			host := host
+			changes = append(changes, ManifestChange{Kind: "proxy", Name: p.Vlan, Operation: "update",
+				Detail: "delete host " + host, apply: func() error { return ProxyDel(p.Vlan, host, false) }})
+		}
+	}
+	return
+}
+
+// mapped returns all existing port mappings with external sockets as stored in database
+func mapped() (list []PortMap) {
+	lines, err := mapList("")
+	log.Check(log.ErrorLevel, "Reading port mappings", err)
+	for _, v := range lines {
+		if f := strings.Split(v, "\t"); len(f) >= 3 {
+			m := PortMap{Protocol: f[0], External: f[1], Internal: f[2]}
+			if len(f) > 3 {
+				m.Domain = f[3]
+			}
+			list = append(list, m)
+		}
+	}
+	return
+}
+
+// findPort returns existing mapping matching manifest, external port is compared only if it is defined
+func findPort(list []PortMap, p manifestPort) *PortMap {
+	for i, e := range list {
+		if e.Protocol != p.Protocol || e.Internal != p.Internal {
+			continue
+		}
+		if (p.Protocol == "http" || p.Protocol == "https") && e.Domain != p.Domain {
+			continue
+		}
+		if port := p.External[strings.LastIndex(p.External, ":")+1:]; len(port) > 0 && !strings.HasSuffix(e.External, ":"+port) {
+			continue
+		}
+		return &list[i]
+	}
+	return nil
+}
+
+func removePort(e PortMap) ManifestChange {
+	return ManifestChange{Kind: "port", Name: e.Protocol + " " + e.Internal, Operation: "delete",
+		Detail: strings.TrimSpace(e.External + " " + e.Domain), apply: func() error {
+			_, err := MapPort(e.Protocol, e.Internal, e.External, "", e.Domain, "", false, true, false)
+			return err
+		}}
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+func TestReadManifest(t *testing.T) {
+	f, err := ioutil.TempFile("", "manifest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`containers:
+  - {name: web1, template: nginx, ip: 10.10.1.2/24, vlan: 100, quota: {cpu: 50}}
+ports:
+  - {protocol: http, internal: "10.10.1.2:80", external: 8080, domain: example.com}
+proxies:
+  - {vlan: 100, domain: example.com, hosts: ["10.10.1.2:80"]}
+`)
+	f.Close()
+
+	want := &manifest{
+		Containers: []manifestContainer{{Name: "web1", Template: "nginx", IP: "10.10.1.2/24", Vlan: "100", Quota: map[string]string{"cpu": "50"}}},
+		Ports:      []manifestPort{{Protocol: "http", Internal: "10.10.1.2:80", External: "8080", Domain: "example.com"}},
+		Proxies:    []manifestProxy{{Vlan: "100", Domain: "example.com", Hosts: []string{"10.10.1.2:80"}}},
+	}
+	if got := readManifest(f.Name()); !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest %+v, want %+v", got, want)
+	}
+}
+
+func TestFindPort(t *testing.T) {
+	list := []PortMap{
+		{Protocol: "tcp", External: "0.0.0.0:2222", Internal: "10.10.1.2:22"},
+		{Protocol: "http", External: "0.0.0.0:80", Internal: "10.10.1.2:80", Domain: "example.com"},
+	}
+	tests := []struct {
+		port manifestPort
+		want int
+	}{
+		{manifestPort{Protocol: "tcp", Internal: "10.10.1.2:22"}, 0},
+		{manifestPort{Protocol: "tcp", Internal: "10.10.1.2:22", External: "2222"}, 0},
+		{manifestPort{Protocol: "tcp", Internal: "10.10.1.2:22", External: "0.0.0.0:2222"}, 0},
+		{manifestPort{Protocol: "tcp", Internal: "10.10.1.2:22", External: "22222"}, -1},
+		{manifestPort{Protocol: "udp", Internal: "10.10.1.2:22"}, -1},
+		{manifestPort{Protocol: "http", Internal: "10.10.1.2:80", Domain: "example.com"}, 1},
+		{manifestPort{Protocol: "http", Internal: "10.10.1.2:80", Domain: "example.org"}, -1},
+	}
+	for _, tt := range tests {
+		got := findPort(list, tt.port)
+		if (tt.want == -1 && got != nil) || (tt.want != -1 && got != &list[tt.want]) {
+			t.Errorf("findPort(%+v) = %+v", tt.port, got)
+		}
+	}
+}
+
+func TestContainerChanges(t *testing.T) {
+	fs.SetDriver(fs.NewFake())
+
+	c := manifestContainer{Name: "web1", Template: "nginx", IP: "10.10.1.2/24", Vlan: "100", Hostname: "web"}
+	changes := containerChanges(c, false)
+	var got []ManifestChange
+	for _, ch := range changes {
+		if ch.apply == nil {
+			t.Errorf("change %+v can't be applied", ch)
+		}
+		ch.apply = nil
+		got = append(got, ch)
+	}
+	want := []ManifestChange{
+		{Kind: "container", Name: "web1", Operation: "create", Detail: "nginx 10.10.1.2/24 100"},
+		// hostname of container created by manifest is always set
+		{Kind: "hostname", Name: "web1", Operation: "update", Detail: "web"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes of missing container %+v, want %+v", got, want)
+	}
+}
+
+func TestPrintChanges(t *testing.T) {
+	out := stdout(t, func() { printChanges(nil, "Nothing to apply") })
+	if out != "Nothing to apply\n" {
+		t.Errorf("no changes printed as %q", out)
+	}
+	out = stdout(t, func() {
+		printChanges([]ManifestChange{{Kind: "quota", Name: "web1", Operation: "update", Detail: "cpu 50"}}, "")
+	})
+	if want := "update   quota      web1 (cpu 50)\n"; out != want {
+		t.Errorf("changes printed as %q, want %q", out, want)
+	}
+}
@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -24,17 +26,41 @@ import (
 var (
 	nginxInc = path.Join(config.Agent.DataPrefix, "nginx/nginx-includes")
 )
+
+// PortMap describes mapping of container socket to Resource Host socket
+type PortMap struct {
+	Protocol string `json:"protocol"`
+	External string `json:"external"`
+	Internal string `json:"internal"`
+	Domain   string `json:"domain,omitempty"`
+}
+
 // MapPort exposes internal container ports to sockExt RH interface. It supports udp, tcp, http(s) protocols and other reverse proxy features
-func MapPort(protocol, sockInt, sockExt, policy, domain, cert string, list, remove, sslbcknd bool) {
+func MapPort(protocol, sockInt, sockExt, policy, domain, cert string, list, remove, sslbcknd bool) (Output, error) {
 	if list {
-		for _, v := range mapList(protocol) {
-			fmt.Println(v)
+		lines, err := mapList(protocol)
+		if err != nil {
+			return Output{}, err
 		}
-		return
+		result := []PortMap{}
+		for _, v := range lines {
+			if f := strings.Split(v, "\t"); len(f) >= 3 {
+				m := PortMap{Protocol: f[0], External: f[1], Internal: f[2]}
+				if len(f) > 3 {
+					m.Domain = f[3]
+				}
+				result = append(result, m)
+			}
+		}
+		return Output{Result: result, table: func(w io.Writer) {
+			for _, v := range lines {
+				fmt.Fprintln(w, v)
+			}
+		}}, nil
 	}
 
 	if protocol != "tcp" && protocol != "udp" && protocol != "http" && protocol != "https" {
-		log.Error("Unsupported protocol \"" + protocol + "\"")
+		return Output{}, usage("Unsupported protocol \"" + protocol + "\"")
 	} else if protocol == "tcp" || protocol == "udp" {
 		domain = protocol
 	}
@@ -43,91 +69,132 @@ func MapPort(protocol, sockInt, sockExt, policy, domain, cert string, list, remo
 		sockExt = "0.0.0.0:" + sockExt
 	}
 
+	var out Output
+	var err error
 	switch {
 	case (protocol == "http" || protocol == "https") && len(domain) == 0:
-		log.Error("\"-d domain\" is mandatory for http protocol")
+		return out, usage("\"-d domain\" is mandatory for http protocol")
 	case remove:
-		mapRemove(protocol, sockExt, domain, sockInt)
+		err = mapRemove(protocol, sockExt, domain, sockInt)
 	case protocol == "https" && (len(cert) == 0 || !gpg.ValidatePem(cert)):
-		log.Error("\"-c certificate\" is missing or invalid pem file")
+		return out, usage("\"-c certificate\" is missing or invalid pem file")
 	case len(sockInt) != 0 && !ovs.ValidSocket(sockInt):
-		log.Error("Invalid internal socket \"" + sockInt + "\"")
+		return out, usage("Invalid internal socket \"" + sockInt + "\"")
 	case (strings.HasSuffix(sockExt, ":8443") || strings.HasSuffix(sockExt, ":8444") || strings.HasSuffix(sockExt, ":8086")) &&
 		sockInt != "10.10.10.1:"+strings.Split(sockExt, ":")[1]:
-		log.Error("Reserved system ports")
+		return out, usage("Reserved system ports")
 	case len(sockInt) != 0:
+		out, err = mapAdd(protocol, sockInt, sockExt, policy, domain, cert, sslbcknd)
+	case len(policy) != 0:
+		err = balanceMethod(protocol, sockExt, domain, policy)
+	}
+	if err != nil {
+		return Output{}, err
+	}
+	return out, restart()
+}
 
-		var mapping = protocol + domain + sockInt + sockExt
-		var lock lockfile.Lockfile
-		var err error
-		for lock, err = lockSubutai(mapping + ".map"); err != nil; lock, err = lockSubutai(mapping + ".map") {
-			time.Sleep(time.Second * 1)
-		}
-		defer lock.Unlock()
+// mapAdd adds container socket to the mapping, creating it if it doesn't exist, and returns the mapping
+func mapAdd(protocol, sockInt, sockExt, policy, domain, cert string, sslbcknd bool) (Output, error) {
+	var mapping = protocol + domain + sockInt + sockExt
+	var lock lockfile.Lockfile
+	var err error
+	for lock, err = lockSubutai(mapping + ".map"); err != nil; lock, err = lockSubutai(mapping + ".map") {
+		time.Sleep(time.Second * 1)
+	}
+	defer lock.Unlock()
 
-		// check sockExt port and create nginx config
-		if portIsNew(protocol, sockInt, domain, &sockExt) {
-			newConfig(protocol, sockExt, domain, cert, sslbcknd)
+	// check sockExt port and create nginx config
+	isNew, err := portIsNew(protocol, sockInt, domain, &sockExt)
+	if err != nil {
+		return Output{}, err
+	}
+	if isNew {
+		if err = newConfig(protocol, sockExt, domain, cert, sslbcknd); err != nil {
+			return Output{}, err
 		}
+	}
 
-		// add containers to backend
-		addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
-			"#Add new host here", "	server "+sockInt+";", false)
+	// add containers to backend
+	if err = addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
+		"#Add new host here", "	server "+sockInt+";", false); err != nil {
+		return Output{}, err
+	}
 
-		// save information to database
-		saveMapToDB(protocol, sockExt, domain, sockInt)
-		containerMapToDB(protocol, sockExt, domain, sockInt)
-		balanceMethod(protocol, sockExt, domain, policy)
+	// save information to database
+	if err = saveMapToDB(protocol, sockExt, domain, sockInt); err != nil {
+		return Output{}, err
+	}
+	if err = containerMapToDB(protocol, sockExt, domain, sockInt); err != nil {
+		return Output{}, err
+	}
+	if err = balanceMethod(protocol, sockExt, domain, policy); err != nil {
+		return Output{}, err
+	}
 
-		if socket := strings.Split(sockExt, ":"); socket[0] == "0.0.0.0" {
-			log.Info(ovs.GetIp() + ":" + socket[1])
-		} else {
-			log.Info(sockExt)
-		}
-	case len(policy) != 0:
-		balanceMethod(protocol, sockExt, domain, policy)
+	external := sockExt
+	if socket := strings.Split(sockExt, ":"); socket[0] == "0.0.0.0" {
+		external = ovs.GetIp() + ":" + socket[1]
+	}
+	m := PortMap{Protocol: protocol, External: external, Internal: sockInt}
+	if protocol == "http" || protocol == "https" {
+		m.Domain = domain
 	}
-	restart()
+	return Output{Result: m, table: func(io.Writer) { log.Info(external) }}, nil
 }
 
-func mapList(protocol string) (list []string) {
+func mapList(protocol string) (list []string, err error) {
 	switch protocol {
 	case "tcp", "udp", "http", "https":
-		l, err := db.INSTANCE.PortmapList(protocol)
-		log.Check(log.ErrorLevel, "Reading port mappings from db", err)
-		list = l
+		if list, err = db.INSTANCE.PortmapList(protocol); err != nil {
+			return nil, errors.New("Reading port mappings from db: " + err.Error())
+		}
 	default:
 		for _, v := range []string{"tcp", "udp", "http", "https"} {
 			l, err := db.INSTANCE.PortmapList(v)
-			if !log.Check(log.ErrorLevel, "Reading port mappings from db", err) {
-				list = append(list, l...)
+			if err != nil {
+				return nil, errors.New("Reading port mappings from db: " + err.Error())
 			}
+			list = append(list, l...)
 		}
 	}
 	return
 }
 
-func mapRemove(protocol, sockExt, domain, sockInt string) {
+func mapRemove(protocol, sockExt, domain, sockInt string) error {
 	log.Debug("Removing mapping: " + protocol + " " + sockExt + " " + domain + " " + sockInt)
 
-	if sockInt != "" && deletePortMap(protocol, sockExt, domain, sockInt) > 0 {
-		if strings.Contains(sockInt, ":") {
-			sockInt = sockInt + ";"
-		} else {
-			sockInt = sockInt + ":"
+	if sockInt != "" {
+		left, err := deletePortMap(protocol, sockExt, domain, sockInt)
+		if err != nil {
+			return err
 		}
-		addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
-			"server "+sockInt, " ", true)
-	} else {
-		if deletePortMap(protocol, sockExt, domain, "") == 0 {
-			deletePortMap(protocol, sockExt, "", "")
+		if left > 0 {
+			if strings.Contains(sockInt, ":") {
+				sockInt = sockInt + ";"
+			} else {
+				sockInt = sockInt + ":"
+			}
+			return addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
+				"server "+sockInt, " ", true)
 		}
-		os.Remove(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"))
-		if protocol == "https" {
-			os.Remove(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".key"))
-			os.Remove(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".crt"))
+	}
+
+	left, err := deletePortMap(protocol, sockExt, domain, "")
+	if err != nil {
+		return err
+	}
+	if left == 0 {
+		if _, err = deletePortMap(protocol, sockExt, "", ""); err != nil {
+			return err
 		}
 	}
+	os.Remove(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"))
+	if protocol == "https" {
+		os.Remove(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".key"))
+		os.Remove(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".crt"))
+	}
+	return nil
 }
 
 func isFree(protocol, sockExt string) bool {
@@ -153,87 +220,123 @@ func random(min, max int) int {
 	return rand.Intn(max-min) + min
 }
 
-func portIsNew(protocol, sockInt, domain string, sockExt *string) bool {
+func portIsNew(protocol, sockInt, domain string, sockExt *string) (bool, error) {
 	socket := strings.Split(*sockExt, ":")
 	if len(socket) > 1 && socket[1] != "" {
 		if port, err := strconv.Atoi(socket[1]); err != nil || port < 1000 || port > 65536 {
 			if !(strings.Contains(protocol, "http") && (port == 80 || port == 443)) {
-				log.Error("Port number in \"external\" should be integer in range of 1000-65536")
+				return false, usage("Port number in \"external\" should be integer in range of 1000-65536")
 			}
 		}
 		if isFree(protocol, *sockExt) {
-			return true
+			return true, nil
 		}
 
-		if !checkPort(protocol, *sockExt, "", "") && socket[1] != "80" {
-			log.Error("Port is busy")
-		} else if checkPort(protocol, *sockExt, domain, sockInt) {
-			log.Error("Mapping already exists")
+		mapped, err := checkPort(protocol, *sockExt, "", "")
+		if err != nil {
+			return false, err
 		}
-		return !checkPort(protocol, *sockExt, domain, "")
+		if !mapped && socket[1] != "80" {
+			return false, errors.New("Port is busy")
+		}
+		if exists, err := checkPort(protocol, *sockExt, domain, sockInt); err != nil {
+			return false, err
+		} else if exists {
+			return false, errors.New("Mapping already exists")
+		}
+		exists, err := checkPort(protocol, *sockExt, domain, "")
+		return !exists, err
 	}
 	for port := strconv.Itoa(random(1000, 65536)); isFree(protocol, socket[0]+":"+port); port = strconv.Itoa(random(1000, 65536)) {
 		*sockExt = socket[0] + ":" + port
-		return true
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
-func newConfig(protocol, sockExt, domain, cert string, sslbcknd bool) {
+func newConfig(protocol, sockExt, domain, cert string, sslbcknd bool) error {
 	log.Check(log.WarnLevel, "Creating nginx include folder",
 		os.MkdirAll(path.Join(nginxInc, protocol), 0755))
 	conf := path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf")
+	upstream := strings.Replace(sockExt, ":", "-", -1) + "-" + domain
 
+	// lines of config template replaced with mapping values
+	var lines [][]string
 	switch protocol {
 	case "https":
-		log.Check(log.ErrorLevel, "Creating certificate dirs", os.MkdirAll(webSslPath, 0755))
-		fs.Copy(path.Join(conftmpl, "vhost-ssl.example"), conf)
-		addLine(conf, "return 301 https://$host$request_uri;  # enforce https", "	    return 301 https://$host:"+strings.Split(sockExt, ":")[1]+"$request_uri;  # enforce https", true)
-		addLine(conf, "listen	443;", "	listen "+sockExt+";", true)
-		addLine(conf, "server_name DOMAIN;", "	server_name "+domain+";", true)
+		if err := os.MkdirAll(webSslPath, 0755); err != nil {
+			return errors.New("Creating certificate dirs: " + err.Error())
+		}
+		if err := fs.Copy(path.Join(conftmpl, "vhost-ssl.example"), conf); err != nil {
+			return err
+		}
+		lines = [][]string{
+			{"return 301 https://$host$request_uri;  # enforce https", "	    return 301 https://$host:" + strings.Split(sockExt, ":")[1] + "$request_uri;  # enforce https"},
+			{"listen	443;", "	listen " + sockExt + ";"},
+			{"server_name DOMAIN;", "	server_name " + domain + ";"},
+		}
 		if sslbcknd {
-			addLine(conf, "proxy_pass http://DOMAIN-upstream/;", "	proxy_pass https://https-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+";", true)
+			lines = append(lines, []string{"proxy_pass http://DOMAIN-upstream/;", "	proxy_pass https://https-" + upstream + ";"})
 		} else {
-			addLine(conf, "proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://https-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+";", true)
+			lines = append(lines, []string{"proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://https-" + upstream + ";"})
 		}
-		addLine(conf, "upstream DOMAIN-upstream {", "upstream https-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+" {", true)
+		lines = append(lines, []string{"upstream DOMAIN-upstream {", "upstream https-" + upstream + " {"})
 
 		crt, key := gpg.ParsePem(cert)
 		log.Check(log.WarnLevel, "Writing certificate body", ioutil.WriteFile(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".crt"), crt, 0644))
 		log.Check(log.WarnLevel, "Writing key body", ioutil.WriteFile(path.Join(webSslPath, "https-"+sockExt+"-"+domain+".key"), key, 0644))
 
-		addLine(conf, "ssl_certificate "+path.Join(webSslPath, "UNIXDATE.crt;"),
-			"ssl_certificate "+path.Join(webSslPath, "https-"+sockExt+"-"+domain+".crt;"), true)
-		addLine(conf, "ssl_certificate_key "+path.Join(webSslPath, "UNIXDATE.key;"),
-			"ssl_certificate_key "+path.Join(webSslPath, "https-"+sockExt+"-"+domain+".key;"), true)
+		lines = append(lines,
+			[]string{"ssl_certificate " + path.Join(webSslPath, "UNIXDATE.crt;"),
+				"ssl_certificate " + path.Join(webSslPath, "https-"+sockExt+"-"+domain+".crt;")},
+			[]string{"ssl_certificate_key " + path.Join(webSslPath, "UNIXDATE.key;"),
+				"ssl_certificate_key " + path.Join(webSslPath, "https-"+sockExt+"-"+domain+".key;")})
 	case "http":
-		fs.Copy(path.Join(conftmpl, "vhost.example"), conf)
-		addLine(conf, "listen 	80;", "	listen "+sockExt+";", true)
-		addLine(conf, "return 301 http://$host$request_uri;", "	    return 301 http://$host:"+strings.Split(sockExt, ":")[1]+"$request_uri;", true)
-		addLine(conf, "server_name DOMAIN;", "	server_name "+domain+";", true)
-		addLine(conf, "proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://http-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+";", true)
-		addLine(conf, "upstream DOMAIN-upstream {", "upstream http-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+" {", true)
+		if err := fs.Copy(path.Join(conftmpl, "vhost.example"), conf); err != nil {
+			return err
+		}
+		lines = [][]string{
+			{"listen 	80;", "	listen " + sockExt + ";"},
+			{"return 301 http://$host$request_uri;", "	    return 301 http://$host:" + strings.Split(sockExt, ":")[1] + "$request_uri;"},
+			{"server_name DOMAIN;", "	server_name " + domain + ";"},
+			{"proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://http-" + upstream + ";"},
+			{"upstream DOMAIN-upstream {", "upstream http-" + upstream + " {"},
+		}
 		if !strings.HasSuffix(sockExt, ":80") {
-			httpRedirect(sockExt, domain)
+			lines = append(lines, []string{"#redirect placeholder", httpRedirect(sockExt, domain)})
 		}
 	case "tcp":
-		fs.Copy(path.Join(conftmpl, "stream.example"), conf)
-		addLine(conf, "listen PORT;", "	listen "+sockExt+";", true)
+		if err := fs.Copy(path.Join(conftmpl, "stream.example"), conf); err != nil {
+			return err
+		}
+		lines = [][]string{{"listen PORT;", "	listen " + sockExt + ";"}}
 	case "udp":
-		fs.Copy(path.Join(conftmpl, "stream.example"), conf)
-		addLine(conf, "listen PORT;", "	listen "+sockExt+" udp;", true)
+		if err := fs.Copy(path.Join(conftmpl, "stream.example"), conf); err != nil {
+			return err
+		}
+		lines = [][]string{{"listen PORT;", "	listen " + sockExt + " udp;"}}
+	}
+	lines = append(lines,
+		[]string{"server localhost:81;", " "},
+		[]string{"upstream PROTO-PORT {", "upstream " + protocol + "-" + upstream + " {"},
+		[]string{"proxy_pass PROTO-PORT;", "	proxy_pass " + protocol + "-" + upstream + ";"})
+
+	for _, line := range lines {
+		if err := addLine(conf, line[0], line[1], true); err != nil {
+			return err
+		}
 	}
-	addLine(conf, "server localhost:81;", " ", true)
-	addLine(conf, "upstream PROTO-PORT {", "upstream "+protocol+"-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+" {", true)
-	addLine(conf, "proxy_pass PROTO-PORT;", "	proxy_pass "+protocol+"-"+strings.Replace(sockExt, ":", "-", -1)+"-"+domain+";", true)
+	return nil
 }
 
-func balanceMethod(protocol, sockExt, domain, policy string) {
+func balanceMethod(protocol, sockExt, domain, policy string) error {
 	replaceString := "upstream " + protocol + "-" + strings.Replace(sockExt, ":", "-", -1) + "-" + domain + " {"
 	replace := false
 
-	if !checkPort(protocol, sockExt, domain, "") {
-		log.Error("Port is not mapped")
+	if mapped, err := checkPort(protocol, sockExt, domain, ""); err != nil {
+		return err
+	} else if !mapped {
+		return errors.New("Port is not mapped")
 	}
 	switch policy {
 	case "round-robin", "round_robin":
@@ -245,69 +348,84 @@ func balanceMethod(protocol, sockExt, domain, policy string) {
 		} else {
 			policy = policy + " header"
 			log.Warn("This policy is not supported in http upstream")
-			return
+			return nil
 		}
 	case "hash":
 		policy = policy + " $remote_addr"
 	case "ip_hash":
 		if protocol != "http" {
 			log.Warn("ip_hash policy allowed only for http protocol")
-			return
+			return nil
 		}
 	default:
 		log.Debug("Unsupported balancing method \"" + policy + "\", ignoring")
-		return
+		return nil
 	}
 
 	p, err := db.INSTANCE.GetMapMethod(protocol, sockExt, domain)
-	log.Check(log.ErrorLevel, "Reading port mapping from db", err)
+	if err != nil {
+		return errors.New("Reading port mapping from db: " + err.Error())
+	}
 
 	if len(p) != 0 && p != policy {
 		replaceString = "; #policy"
 		replace = true
 	} else if p == policy {
-		return
+		return nil
+	}
+	if err = db.INSTANCE.SetMapMethod(protocol, sockExt, domain, policy); err != nil {
+		return errors.New("Saving map method: " + err.Error())
 	}
-	log.Check(log.ErrorLevel, "Saving map method", db.INSTANCE.SetMapMethod(protocol, sockExt, domain, policy))
 
-	addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
+	return addLine(path.Join(nginxInc, protocol, sockExt+"-"+domain+".conf"),
 		replaceString, "	"+policy+"; #policy", replace)
 }
 
-func httpRedirect(sockExt, domain string) {
-	var redirect = `server {
+// httpRedirect returns server block redirecting plain http requests of the domain to the mapped port
+func httpRedirect(sockExt, domain string) string {
+	return `server {
 	    listen      80; #redirect
     	server_name ` + domain + `;
     	return 301 http://$host:` + strings.Split(sockExt, ":")[1] + `$request_uri;
 }`
-
-	addLine(path.Join(nginxInc, "http", sockExt+"-"+domain+".conf"),
-		"#redirect placeholder", redirect, true)
-
 }
 
-func saveMapToDB(protocol, sockExt, domain, sockInt string) {
-	if !checkPort(protocol, sockExt, domain, sockInt) {
-		log.Check(log.ErrorLevel, "Saving port map to database", db.INSTANCE.PortMapSet(protocol, sockExt, domain, sockInt))
+func saveMapToDB(protocol, sockExt, domain, sockInt string) error {
+	exists, err := checkPort(protocol, sockExt, domain, sockInt)
+	if err != nil || exists {
+		return err
+	}
+	if err = db.INSTANCE.PortMapSet(protocol, sockExt, domain, sockInt); err != nil {
+		return errors.New("Saving port map to database: " + err.Error())
 	}
+	return nil
 }
 
-func containerMapToDB(protocol, sockExt, domain, sockInt string) {
+func containerMapToDB(protocol, sockExt, domain, sockInt string) error {
 	list, err := db.INSTANCE.ContainerByKey("ip", strings.Split(sockInt, ":")[0])
-	log.Check(log.ErrorLevel, "Reading container metadata from db", err)
+	if err != nil {
+		return errors.New("Reading container metadata from db: " + err.Error())
+	}
 	for _, name := range list {
-		log.Check(log.ErrorLevel, "Saving port mapping to db", db.INSTANCE.ContainerMapping(name, protocol, sockExt, domain, sockInt))
+		if err = db.INSTANCE.ContainerMapping(name, protocol, sockExt, domain, sockInt); err != nil {
+			return errors.New("Saving port mapping to db: " + err.Error())
+		}
 	}
+	return nil
 }
 
-func checkPort(protocol, external, domain, internal string) bool {
+func checkPort(protocol, external, domain, internal string) (bool, error) {
 	res, err := db.INSTANCE.PortInMap(protocol, external, domain, internal)
-	log.Check(log.ErrorLevel, "Checking port mapping in db", err)
-	return res
+	if err != nil {
+		return false, errors.New("Checking port mapping in db: " + err.Error())
+	}
+	return res, nil
 }
 
-func deletePortMap(protocol, sockExt, domain, sockInt string) int {
+func deletePortMap(protocol, sockExt, domain, sockInt string) (int, error) {
 	left, err := db.INSTANCE.PortMapDelete(protocol, sockExt, domain, sockInt)
-	log.Check(log.ErrorLevel, "Removing port mapping from db", err)
-	return left
+	if err != nil {
+		return 0, errors.New("Removing port mapping from db: " + err.Error())
+	}
+	return left, nil
 }
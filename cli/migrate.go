@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/agent/migration"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// size of archive chunk sent in one request
+const migrationChunk = 8 * 1024 * 1024
+
+// LxcMigrate moves Subutai container to another Resource Host running Subutai agent with the same migration secret.
+//
+// Migration consists of two steps. First, full backup of the running container is transferred and restored on the remote host.
+// Then the container is frozen, incremental backup with changes made during the first step is transferred,
+// and container is stopped locally and started on the remote host. Network configuration, metadata, port mappings and GPG keys
+// are moved along with container data. The local container is destroyed once the remote host confirms the restore.
+// If any step fails, the local container is resumed or started again and partial copy is destroyed on the remote host.
+func LxcMigrate(name, remote string) {
+	if !container.IsContainer(name) {
+		log.Error("Container " + name + " not found")
+	}
+
+	_, err := migration.Request(remote, "begin", name, nil, nil)
+	log.Check(log.ErrorLevel, "Starting migration to "+remote, err)
+
+	var frozen, stopped bool
+	fail := func(msg string, err error) {
+		if frozen {
+			log.Check(log.WarnLevel, "Unfreezing container", container.Unfreeze(name))
+		}
+		if stopped {
+			log.Check(log.WarnLevel, "Starting container", container.Start(name))
+		}
+		_, abort := migration.Request(remote, "abort", name, nil, nil)
+		log.Check(log.WarnLevel, "Removing partial copy of "+name+" from "+remote, abort)
+		log.Error(msg + ": " + err.Error())
+	}
+
+	dir := path.Join(config.Agent.CacheDir, "migrate")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		fail("Creating migration directory", err)
+	}
+
+	log.Info("Sending " + name + " to " + remote)
+	archive, err := backup(name, true, dir)
+	if err != nil {
+		fail("Creating initial copy", err)
+	}
+	if err = sendArchive(remote, name, archive, false, false); err != nil {
+		fail("Sending initial copy", err)
+	}
+
+	running := container.State(name) == "RUNNING"
+	if running {
+		if err = container.Freeze(name); err != nil {
+			fail("Freezing container", err)
+		}
+		frozen = true
+	}
+
+	log.Info("Sending final changes of " + name)
+	if archive, err = backup(name, false, dir); err != nil {
+		fail("Creating final changes", err)
+	}
+
+	if running {
+		if err = container.Stop(name, false); err != nil {
+			os.Remove(archive)
+			fail("Stopping container "+name, err)
+		}
+		frozen, stopped = false, true
+	}
+
+	if err = sendArchive(remote, name, archive, running, true); err != nil {
+		fail("Sending final changes", err)
+	}
+
+	log.Check(log.ErrorLevel, "Destroying migrated container "+name, LxcDestroy(name, false))
+
+	log.Info(name + " migrated to " + remote)
+}
+
+// sendArchive uploads backup archive to remote agent in chunks and waits for its restore,
+// restore of final archive completes migration on remote agent
+func sendArchive(remote, name, archive string, start, final bool) error {
+	defer os.Remove(archive)
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	file := filepath.Base(archive)
+	buf := make([]byte, migrationChunk)
+	var offset int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			if _, err := migration.Request(remote, "upload", name, map[string]string{
+				"File": file, "Offset": strconv.FormatInt(offset, 10)}, buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+			log.Debug("Sent " + strconv.FormatInt(offset, 10) + " bytes of " + file)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if _, err = migration.Request(remote, "restore", name, map[string]string{
+		"File": file, "Start": strconv.FormatBool(start), "Final": strconv.FormatBool(final)}, nil); err != nil {
+		return err
+	}
+
+	for {
+		time.Sleep(time.Second * 5)
+
+		out, err := migration.Request(remote, "status", name, nil, nil)
+		if err != nil {
+			return err
+		}
+		var job migration.Job
+		if err = json.Unmarshal(out, &job); err != nil {
+			return err
+		}
+		switch job.Status {
+		case "done":
+			return nil
+		case "failed":
+			return errors.New("Restore failed on " + remote + ": " + job.Output)
+		case "running":
+			log.Debug("Waiting for restore of " + file + " on " + remote)
+		default:
+			return errors.New("Unexpected restore status on " + remote + ": " + job.Status)
+		}
+	}
+}
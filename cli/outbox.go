@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+// OutboxMessage describes message waiting for delivery, times are unix timestamps
+type OutboxMessage struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	Attempts int    `json:"attempts"`
+	Created  int64  `json:"created"`
+	Next     int64  `json:"next"`
+	Dead     string `json:"dead,omitempty"`
+}
+
+// LxcOutbox shows responses and heartbeats waiting for delivery to Management server and dead letters,
+// which were rejected by Management server, with the reason.
+// Option `-f` makes the agent retry delivery of pending messages and dead letters immediately, `-p` discards all messages.
+func LxcOutbox(flush, purge bool) {
+	list, err := db.INSTANCE.OutboxList()
+	log.Check(log.ErrorLevel, "Reading outbox", err)
+
+	switch {
+	case purge:
+		for _, item := range list {
+			log.Check(log.ErrorLevel, "Removing message from outbox", db.INSTANCE.OutboxDel(item["id"]))
+		}
+		log.Info(strconv.Itoa(len(list)) + " messages removed from outbox")
+	case flush:
+		for _, item := range list {
+			log.Check(log.ErrorLevel, "Updating outbox message", db.INSTANCE.OutboxUpdate(item["id"], map[string]string{"next": "0", "dead": ""}))
+		}
+		log.Info(strconv.Itoa(len(list)) + " messages scheduled for delivery")
+	default:
+		result := []OutboxMessage{}
+		for _, item := range list {
+			attempts, _ := strconv.Atoi(item["attempts"])
+			created, _ := strconv.ParseInt(item["created"], 10, 64)
+			next, _ := strconv.ParseInt(item["next"], 10, 64)
+			result = append(result, OutboxMessage{Kind: item["kind"], Key: item["key"], Attempts: attempts, Created: created,
+				Next: next, Dead: item["dead"]})
+		}
+		printResult(result, func(w io.Writer) {
+			fmt.Fprintf(w, "%-10s %-50s %-9s %-20s %-20s %s\n", "KIND", "KEY", "ATTEMPTS", "CREATED", "NEXT ATTEMPT", "DEAD LETTER")
+			for _, item := range list {
+				next, dead := unixTime(item["next"]), "-"
+				if len(item["dead"]) > 0 {
+					next, dead = "-", item["dead"]
+				}
+				fmt.Fprintf(w, "%-10s %-50s %-9s %-20s %-20s %s\n", item["kind"], item["key"], item["attempts"], unixTime(item["created"]), next, dead)
+			}
+		})
+	}
+}
+
+// unixTime formats unix timestamp string as local time
+func unixTime(ts string) string {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04:05")
+}
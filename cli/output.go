@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/subutai-io/agent/log"
+)
+
+// outputFormat is a format of command results: table, json or yaml
+var outputFormat = "table"
+
+// Result is an outcome of CLI operation executed by the daemon:
+// typed result of the operation, its human readable output and error with exit code of CLI process.
+type Result struct {
+	Result interface{}    `json:"result,omitempty"`
+	Output string         `json:"output,omitempty"`
+	Error  *log.ExitError `json:"error,omitempty"`
+}
+
+// Output is a typed result of CLI operation with function printing it in human readable form
+type Output struct {
+	Result interface{}
+	table  func(w io.Writer)
+}
+
+// Print prints output of operation in selected format
+func (o Output) Print() {
+	if o.table != nil {
+		printResult(o.Result, o.table)
+	}
+}
+
+// Text returns human readable form of the output
+func (o Output) Text() string {
+	if o.table == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	o.table(&buf)
+	return buf.String()
+}
+
+// NewResult converts output and error of operation to result returned by the daemon
+func NewResult(out Output, err error) Result {
+	res := Result{Result: out.Result, Output: out.Text()}
+	if err != nil {
+		res.Error = ExitError(err)
+	}
+	return res
+}
+
+// ExitError returns error of operation with exit code of CLI process, ExitFailure unless the error has its own code
+func ExitError(err error) *log.ExitError {
+	if e, ok := err.(*log.ExitError); ok {
+		return e
+	}
+	return &log.ExitError{Code: log.ExitFailure, Message: err.Error()}
+}
+
+// usage returns error of operation called with invalid arguments
+func usage(msg string) error {
+	return &log.ExitError{Code: log.ExitUsage, Message: msg}
+}
+
+// PrintResult prints result of operation executed by the daemon in selected format and returns its error
+func PrintResult(res Result) error {
+	if res.Error != nil {
+		return res.Error
+	}
+	if outputFormat == "table" {
+		fmt.Print(res.Output)
+	} else if res.Result != nil {
+		printResult(res.Result, nil)
+	}
+	return nil
+}
+
+// SetOutput sets format of command results. In json and yaml modes the result is printed to standard output
+// as a single document while log messages and errors are written to standard error as structured records.
+func SetOutput(format string) error {
+	switch format {
+	case "", "table":
+		outputFormat = "table"
+	case "json", "yaml":
+		outputFormat = format
+		log.Format(format)
+	default:
+		return errors.New("Unsupported output format \"" + format + "\", use json, yaml or table")
+	}
+	return nil
+}
+
+// printResult prints command result in selected format, table function prints it in human readable form to the writer
+func printResult(result interface{}, table func(w io.Writer)) {
+	var data []byte
+	var err error
+	switch outputFormat {
+	case "json":
+		data, err = json.MarshalIndent(result, "", "  ")
+	case "yaml":
+		// converting through JSON keeps field names same in both formats
+		var doc interface{}
+		if data, err = json.Marshal(result); err == nil {
+			if err = yaml.Unmarshal(data, &doc); err == nil {
+				data, err = yaml.Marshal(doc)
+			}
+		}
+	default:
+		table(os.Stdout)
+		return
+	}
+	log.Check(log.ErrorLevel, "Formatting command result", err)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/subutai-io/agent/log"
+)
+
+// stdout returns what function prints to standard output
+func stdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestPrintResult(t *testing.T) {
+	defer func(f string) { outputFormat = f }(outputFormat)
+
+	type item struct {
+		Name  string `json:"name"`
+		State string `json:"state,omitempty"`
+	}
+	result := []item{{Name: "foo", State: "RUNNING"}, {Name: "bar"}}
+	table := func(w io.Writer) {
+		for _, i := range result {
+			fmt.Fprintf(w, "%-4s %s\n", i.Name, i.State)
+		}
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "foo  RUNNING\nbar  \n"},
+		{"json", "[\n  {\n    \"name\": \"foo\",\n    \"state\": \"RUNNING\"\n  },\n  {\n    \"name\": \"bar\"\n  }\n]\n"},
+		// field names are the same as in JSON
+		{"yaml", "- name: foo\n  state: RUNNING\n- name: bar\n\n"},
+	}
+	for _, tt := range tests {
+		outputFormat = tt.format
+		if got := stdout(t, func() { printResult(result, table) }); got != tt.want {
+			t.Errorf("%s output %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	defer func(f string) { outputFormat = f }(outputFormat)
+
+	for _, format := range []string{"xml", "JSON", "text"} {
+		if err := SetOutput(format); err == nil {
+			t.Errorf("unsupported format %q is accepted", format)
+		}
+	}
+	for _, format := range []string{"", "table"} {
+		if err := SetOutput(format); err != nil || outputFormat != "table" {
+			t.Errorf("SetOutput(%q): format %q, error %v", format, outputFormat, err)
+		}
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	out := Output{Result: []string{"foo"}, table: func(w io.Writer) { fmt.Fprintln(w, "foo") }}
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, 0},
+		{"failure", errors.New("Container foo not found"), log.ExitFailure},
+		{"usage", usage("Missing container name"), log.ExitUsage},
+	}
+	for _, tt := range tests {
+		res := NewResult(out, tt.err)
+		if res.Output != "foo\n" {
+			t.Errorf("%s: output %q, want \"foo\\n\"", tt.name, res.Output)
+		}
+		if tt.err == nil {
+			if res.Error != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, res.Error)
+			}
+			continue
+		}
+		if res.Error == nil || res.Error.Code != tt.code || res.Error.Message != tt.err.Error() {
+			t.Errorf("%s: error %+v, want code %d and message %q", tt.name, res.Error, tt.code, tt.err)
+		}
+		// error of operation executed by the daemon is returned to CLI instead of printing the result
+		if err := PrintResult(res); err != res.Error {
+			t.Errorf("%s: PrintResult returned %v", tt.name, err)
+		}
+	}
+	if got := (Output{}).Text(); got != "" {
+		t.Errorf("empty output has text %q", got)
+	}
+}
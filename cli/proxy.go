@@ -1,7 +1,9 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -30,12 +32,12 @@ var (
 // It can also accept SSL certificates in .pem file format and install it for a domain.
 
 // ProxyAdd checks input args and perform required operations to configure reverse proxy
-func ProxyAdd(vlan, domain, node, policy, cert string) {
+func ProxyAdd(vlan, domain, node, policy, cert string) error {
 	if vlan == "" {
-		log.Error("Please specify VLAN")
+		return usage("Please specify VLAN")
 	} else if domain != "" {
 		if isVlanExist(vlan) {
-			log.Error("Domain already exists")
+			return errors.New("Domain already exists")
 		}
 		if crt := strings.Split(cert, ":"); len(crt) > 1 && container.LxcInstanceExists(crt[0]) {
 			if !strings.HasPrefix(crt[1], "/opt/") && !strings.HasPrefix(crt[1], "/var/") && !strings.HasPrefix(crt[1], "/home/") {
@@ -43,69 +45,81 @@ func ProxyAdd(vlan, domain, node, policy, cert string) {
 			}
 			cert = path.Join(config.Agent.LxcPrefix, crt[0], strings.Join(crt[1:], ":"))
 		}
-		addDomain(vlan, domain, cert)
+		if err := addDomain(vlan, domain, cert); err != nil {
+			return err
+		}
+		var err error
 		switch policy {
 		case "rr":
-			setPolicy(vlan, "")
+			err = setPolicy(vlan, "")
 		case "lb":
-			setPolicy(vlan, "least_conn;")
+			err = setPolicy(vlan, "least_conn;")
 		case "hash":
-			setPolicy(vlan, "ip_hash;")
+			err = setPolicy(vlan, "ip_hash;")
+		}
+		if err != nil {
+			return err
 		}
-		restart()
+		return restart()
 	} else if node != "" {
 		if isNodeExist(vlan, node) {
-			log.Error("Node is already in domain")
+			return errors.New("Node is already in domain")
+		}
+		if err := addNode(vlan, node); err != nil {
+			return err
 		}
-		addNode(vlan, node)
-		restart()
+		return restart()
 	}
+	return nil
 }
 
 // ProxyDel checks what need to be removed - domain or node and pass args to required functions
-func ProxyDel(vlan, node string, domain bool) {
+func ProxyDel(vlan, node string, domain bool) error {
 	if isVlanExist(vlan) {
 		if domain && node == "" {
-			delDomain(vlan)
+			if err := delDomain(vlan); err != nil {
+				return err
+			}
 		}
 		if node != "" {
-			delNode(vlan, node)
+			if err := delNode(vlan, node); err != nil {
+				return err
+			}
 		}
-		restart()
+		return restart()
 	}
-	// else {
-	// 	os.Exit(1)
-	// }
+	return nil
 }
 
-// ProxyCheck exits with 0 code if domain or node is exists in specified vlan, otherwise exitcode is 1
-func ProxyCheck(vlan, node string, domain bool) {
+// ProxyCheck returns domain of specified vlan or checks if node is in the domain,
+// it fails if the domain or node doesn't exist
+func ProxyCheck(vlan, node string, domain bool) (Output, error) {
 	if vlan != "" && domain {
-		if d := getDomain(vlan); d != "" {
-			fmt.Println(d)
-			os.Exit(0)
-		} else {
-			os.Exit(1)
+		d := getDomain(vlan)
+		if d == "" {
+			return Output{}, errors.New("Domain is not configured on vlan " + vlan)
 		}
+		return Output{Result: map[string]string{"domain": d}, table: func(w io.Writer) { fmt.Fprintln(w, d) }}, nil
 	} else if vlan != "" && node != "" {
-		if isNodeExist(vlan, node) {
-			log.Info("Node is in domain")
-			os.Exit(0)
-		} else {
-			log.Info("Node is not in domain")
-			os.Exit(1)
+		if !isNodeExist(vlan, node) {
+			return Output{}, errors.New("Node is not in domain")
 		}
+		log.Info("Node is in domain")
 	}
+	return Output{}, nil
 }
 
 // restart reloads nginx process
-func restart() {
+func restart() error {
 	out, err := exec.Command("service", "subutai-nginx", "reload").CombinedOutput()
-	log.Check(log.FatalLevel, "Reloading nginx "+string(out), err)
+	if err != nil {
+		return errors.New("Reloading nginx " + string(out) + ": " + err.Error())
+	}
+	return nil
 }
 
 // addDomain creates new domain config from pattern and adjusts it
-func addDomain(vlan, domain, cert string) {
+func addDomain(vlan, domain, cert string) error {
 	if _, err := os.Stat(confinc); os.IsNotExist(err) {
 		err := os.MkdirAll(confinc, 0755)
 		if err != nil {
@@ -113,57 +127,70 @@ func addDomain(vlan, domain, cert string) {
 		}
 	}
 	vlanConf := path.Join(confinc, vlan+".conf")
+	// lines of config template replaced with domain values
+	var lines [][]string
 	if cert != "" && gpg.ValidatePem(cert) {
 		currentDT := strconv.Itoa(int(time.Now().Unix()))
 
 		if _, err := os.Stat(webSslPath); os.IsNotExist(err) {
 			err := os.MkdirAll(webSslPath, 0755)
 			if err != nil {
-				log.Info("Cannot create ssl directory " + webSslPath)
-				os.Exit(1)
+				return errors.New("Cannot create ssl directory " + webSslPath)
 			}
 		}
 
-		fs.Copy(path.Join(conftmpl, "vhost-ssl.example"), vlanConf)
+		if err := fs.Copy(path.Join(conftmpl, "vhost-ssl.example"), vlanConf); err != nil {
+			return err
+		}
 		crt, key := gpg.ParsePem(cert)
 		err := ioutil.WriteFile(path.Join(webSslPath, currentDT+".crt"), crt, 0644)
 		if err != nil {
-			log.Info("Cannot create crt file " + path.Join(webSslPath, currentDT+".crt"))
-			os.Exit(1)
+			return errors.New("Cannot create crt file " + path.Join(webSslPath, currentDT+".crt"))
 		}
 		err = ioutil.WriteFile(path.Join(webSslPath, currentDT+".key"), key, 0644)
 		if err != nil {
-			log.Info("Cannot create key file " + path.Join(webSslPath, currentDT+".key"))
-			os.Exit(1)
+			return errors.New("Cannot create key file " + path.Join(webSslPath, currentDT+".key"))
+		}
+		lines = [][]string{
+			{"ssl_certificate " + path.Join(webSslPath, "UNIXDATE.crt;"),
+				"	ssl_certificate " + path.Join(webSslPath, currentDT+".crt;")},
+			{"ssl_certificate_key " + path.Join(webSslPath, "UNIXDATE.key;"),
+				"	ssl_certificate_key " + path.Join(webSslPath, currentDT+".key;")},
+		}
+	} else if err := fs.Copy(path.Join(conftmpl, "vhost.example"), vlanConf); err != nil {
+		return err
+	}
+	lines = append(lines,
+		[]string{"upstream DOMAIN-upstream {", "upstream " + domain + "-upstream {"},
+		[]string{"server_name DOMAIN;", "	server_name " + domain + ";"},
+		[]string{"proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://" + domain + "-upstream/;"})
+
+	for _, line := range lines {
+		if err := addLine(vlanConf, line[0], line[1], true); err != nil {
+			return err
 		}
-		addLine(vlanConf, "ssl_certificate "+path.Join(webSslPath, "UNIXDATE.crt;"),
-			"	ssl_certificate "+path.Join(webSslPath, currentDT+".crt;"), true)
-		addLine(vlanConf, "ssl_certificate_key "+path.Join(webSslPath, "UNIXDATE.key;"),
-			"	ssl_certificate_key "+path.Join(webSslPath, currentDT+".key;"), true)
-	} else {
-		fs.Copy(path.Join(conftmpl, "vhost.example"), vlanConf)
 	}
-	addLine(vlanConf, "upstream DOMAIN-upstream {", "upstream "+domain+"-upstream {", true)
-	addLine(vlanConf, "server_name DOMAIN;", "	server_name "+domain+";", true)
-	addLine(vlanConf, "proxy_pass http://DOMAIN-upstream/;", "	proxy_pass http://"+domain+"-upstream/;", true)
+	return nil
 }
 
 // addNode adds configuration lines to domain configuration
-func addNode(vlan, node string) {
+func addNode(vlan, node string) error {
 	vlanConf := path.Join(confinc, vlan+".conf")
 
-	delLine(vlanConf, "server localhost:81;")
-	addLine(vlanConf, "#Add new host here", "	server "+node+"; #$node", false)
+	if err := delLine(vlanConf, "server localhost:81;"); err != nil {
+		return err
+	}
+	return addLine(vlanConf, "#Add new host here", "	server "+node+"; #$node", false)
 }
 
 // delDomain removes domain configuration file and all related stuff
-func delDomain(vlan string) {
+func delDomain(vlan string) error {
 	vlanConf := path.Join(confinc, vlan+".conf")
 
 	// get and remove cert files
 	f, err := ioutil.ReadFile(vlanConf)
 	if err != nil {
-		log.Fatal("Cannot read nginx virtualhost file:" + vlanConf)
+		return errors.New("Cannot read nginx virtualhost file:" + vlanConf)
 	}
 	lines := strings.Split(string(f), "\n")
 	for _, v := range lines {
@@ -176,17 +203,23 @@ func delDomain(vlan string) {
 	}
 
 	os.Remove(vlanConf)
+	return nil
 }
 
 // delNode removes node configuration entries from domain config
-func delNode(vlan, node string) {
+func delNode(vlan, node string) error {
 	vlanConf := path.Join(confinc, vlan+".conf")
 
-	delLine(vlanConf, "server "+node+"; #$node")
-	delLine(vlanConf, "server "+node+": #$node")
+	if err := delLine(vlanConf, "server "+node+"; #$node"); err != nil {
+		return err
+	}
+	if err := delLine(vlanConf, "server "+node+": #$node"); err != nil {
+		return err
+	}
 	if nodeCount(vlan) == 0 {
-		addLine(vlanConf, "#Add new host here", "   server localhost:81;", false)
+		return addLine(vlanConf, "#Add new host here", "   server localhost:81;", false)
 	}
+	return nil
 }
 
 // getDomain returns domain name assigned to specified vlan
@@ -217,7 +250,21 @@ func isVlanExist(vlan string) bool {
 
 // isNodeExist is true if specified node belongs to vlan, otherwise it is false
 func isNodeExist(vlan, node string) bool {
-	return addLine(path.Join(confinc, vlan+".conf"), "server "+node+";", "", false)
+	return hasLine(path.Join(confinc, vlan+".conf"), "server "+node+";")
+}
+
+// proxyNodes returns nodes assigned to domain on specified vlan
+func proxyNodes(vlan string) (nodes []string) {
+	f, err := ioutil.ReadFile(path.Join(confinc, vlan+".conf"))
+	if err != nil {
+		return
+	}
+	for _, v := range strings.Split(string(f), "\n") {
+		if line := strings.Fields(v); strings.Contains(v, "#$node") && len(line) > 1 {
+			nodes = append(nodes, strings.TrimRight(line[1], ";:"))
+		}
+	}
+	return
 }
 
 // nodeCount returns the number of nodes assigned to domain on specified vlan
@@ -231,42 +278,49 @@ func nodeCount(vlan string) int {
 }
 
 // setPolicy configures load balance policy for domain on specified vlan
-func setPolicy(vlan, policy string) {
+func setPolicy(vlan, policy string) error {
 	vlanConf := path.Join(confinc, vlan+".conf")
-	delLine(vlanConf, "ip_hash;")
-	delLine(vlanConf, "least_time header;")
-	addLine(vlanConf, "#Add new host here", "	"+policy, false)
+	if err := delLine(vlanConf, "ip_hash;"); err != nil {
+		return err
+	}
+	if err := delLine(vlanConf, "least_time header;"); err != nil {
+		return err
+	}
+	return addLine(vlanConf, "#Add new host here", "	"+policy, false)
+}
+
+// hasLine checks if line exists in specified file
+func hasLine(path, line string) bool {
+	f, err := ioutil.ReadFile(path)
+	return !log.Check(log.DebugLevel, "Cannot read file "+path, err) && strings.Contains(string(f), line)
 }
 
-// addLine adds, removes, replaces and checks if line exists in specified file
-func addLine(path, after, line string, replace bool) bool {
+// addLine adds, removes or replaces line in specified file
+func addLine(path, after, line string, replace bool) error {
 	f, err := ioutil.ReadFile(path)
 	if !log.Check(log.DebugLevel, "Cannot read file "+path, err) {
 		lines := strings.Split(string(f), "\n")
 		for k, v := range lines {
 			if strings.Contains(v, after) {
-				if line != "" {
-					if replace {
-						log.Debug("Replacing " + lines[k] + " with " + line)
-						lines[k] = line
-					} else {
-						log.Debug("Adding " + line + " after " + lines[k])
-						lines[k] = after + "\n" + line
-					}
+				if replace {
+					log.Debug("Replacing " + lines[k] + " with " + line)
+					lines[k] = line
 				} else {
-					return true
+					log.Debug("Adding " + line + " after " + lines[k])
+					lines[k] = after + "\n" + line
 				}
 			}
 		}
 		str := strings.Join(lines, "\n")
-		log.Check(log.FatalLevel, "Writing new proxy config",
-			ioutil.WriteFile(path, []byte(str), 0744))
+		if err = ioutil.WriteFile(path, []byte(str), 0744); err != nil {
+			return errors.New("Writing new proxy config: " + err.Error())
+		}
 	}
-	return false
+	return nil
 }
 
 // delLine removes specified line from file
-func delLine(path, line string) {
+func delLine(path, line string) error {
 	var lines2 []string
 	f, err := ioutil.ReadFile(path)
 	if !log.Check(log.DebugLevel, "Reading config "+path, err) {
@@ -278,7 +332,9 @@ func delLine(path, line string) {
 			}
 		}
 		str := strings.Join(lines2, "\n")
-		log.Check(log.FatalLevel, "Writing new proxy config",
-			ioutil.WriteFile(path, []byte(str), 0744))
+		if err = ioutil.WriteFile(path, []byte(str), 0744); err != nil {
+			return errors.New("Writing new proxy config: " + err.Error())
+		}
 	}
+	return nil
 }
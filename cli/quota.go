@@ -1,39 +1,145 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/subutai-io/agent/lib/container"
-	"github.com/subutai-io/agent/lib/fs"
-	"github.com/subutai-io/agent/log"
 )
 
+// QuotaInfo describes resource quota of container and its warning and critical alert thresholds in percents
+type QuotaInfo struct {
+	Quota     string      `json:"quota"`
+	Threshold json.Number `json:"threshold"`
+	Critical  json.Number `json:"critical,omitempty"`
+	// Reservation is a disk space guaranteed to container or its partition, Gb
+	Reservation string `json:"reservation,omitempty"`
+}
+
 // LxcQuota function controls container's quotas and thresholds. Available resources:
 //	cpu, %
 //	cpuset, available cores
 //	ram, Mb
 //	network, Kbps
+//	disk, Gb
 //	rootfs/home/var/opt, Gb
+//	io.rbps/io.wbps, disk read/write bandwidth, MB/s, not supported on zfs storage
+//	io.riops/io.wiops, disk read/write operations, IOPS, not supported on zfs storage
+// Disk quota limits the whole container including its snapshots, while partition quotas count only data referenced
+// by the partition itself, so snapshots don't eat the space available inside the container.
+// Disk space may be guaranteed to container or its partition by reservation, Gb.
 // The threshold value represents a percentage for each resource. Once resource consumption exceeds this threshold it triggers an alert.
+// Critical level may follow the warning one after colon, e.g. "80:95". Alert is raised when usage stays over the level
+// for the window set in agent configuration and cleared when usage falls below the level by hysteresis.
 // The clone operation, sets no quotas and thresholds for new containers; quotas need to be configured with quota command after a clone operation.
-func LxcQuota(name, res, size, threshold string) {
+// All arguments are validated before any of them is applied.
+func LxcQuota(name, res, size, threshold, reserve string) (Output, error) {
+	if err := checkQuota(res, size, threshold, reserve); err != nil {
+		return Output{}, usage(err.Error())
+	}
 	if len(threshold) > 0 {
-		setQuotaThreshold(name, res, threshold)
+		if err := setQuotaThreshold(name, res, threshold); err != nil {
+			return Output{}, err
+		}
+	}
+	quota, err := quotaValue(name, res, size)
+	if err != nil {
+		return Output{}, err
+	}
+	alert, critical := getQuotaThreshold(name, res)
+	info := QuotaInfo{Quota: quota, Threshold: json.Number(alert), Critical: json.Number(critical)}
+	if isDisk(res) {
+		if info.Reservation, err = reserveValue(name, res, reserve); err != nil {
+			return Output{}, err
+		}
+	}
+
+	return Output{Result: info, table: func(w io.Writer) {
+		out := `{"quota":"` + quota + `", "threshold":` + alert
+		if len(critical) > 0 {
+			out += `, "critical":` + critical
+		}
+		if isDisk(res) {
+			out += `, "reservation":"` + info.Reservation + `"`
+		}
+		fmt.Fprintln(w, out + "}")
+	}}, nil
+}
+
+var cpusetFormat = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+// checkQuota validates resource and values of quota, threshold and reservation, empty values are not changed
+func checkQuota(res, size, threshold, reserve string) error {
+	switch res {
+	case "cpu", "cpuset", "ram", "network", "io.rbps", "io.wbps", "io.riops", "io.wiops":
+		if len(reserve) > 0 {
+			return errors.New("Reservation is supported for disk and partitions only")
+		}
+	default:
+		if !isDisk(res) {
+			return errors.New("Unsupported resource " + res)
+		}
+	}
+	if res == "cpuset" {
+		if len(size) > 0 && !cpusetFormat.MatchString(size) {
+			return errors.New("Invalid cpuset " + size + ", expected list of cores, e.g. 0-1,3")
+		}
+	} else if !isSize(size) {
+		return errors.New("Invalid " + res + " quota " + size)
+	}
+	if !isSize(reserve) {
+		return errors.New("Invalid " + res + " reservation " + reserve)
+	}
+	if len(threshold) > 0 {
+		if len(thresholdKey(res)) == 0 {
+			return errors.New("Threshold is not supported for " + res)
+		}
+		return checkThreshold(threshold)
 	}
+	return nil
+}
+
+// isSize checks if value is empty or non-negative integer
+func isSize(value string) bool {
+	n, err := strconv.Atoi(value)
+	return len(value) == 0 || err == nil && n >= 0
+}
+
+// isDisk checks if resource is a disk space of the whole container or its partition
+func isDisk(res string) bool {
+	if res == "disk" {
+		return true
+	}
+	for _, partition := range container.Partitions {
+		if res == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaValue sets quota of the resource if size is not empty and returns its current value
+func quotaValue(name, res, size string) (string, error) {
 	quota := "0"
-	alert := getQuotaThreshold(name, res)
 	switch res {
 	case "network":
 		quota = container.QuotaNet(name, size)
-	case "disk":
-		if len(size) > 0 {
-			vs, _ := strconv.Atoi(size)
-			fs.SetQuota(name, vs)
+	case "disk", "rootfs", "home", "var", "opt":
+		vr, err := container.QuotaDisk(name, res, size)
+		if err != nil {
+			return "", errors.New("Setting " + res + " quota: " + err.Error())
+		}
+		quota = strconv.Itoa(vr)
+	case "io.rbps", "io.wbps", "io.riops", "io.wiops":
+		vr, err := container.QuotaIO(name, strings.TrimPrefix(res, "io."), size)
+		if err != nil {
+			return "", errors.New("Setting " + res + " quota: " + err.Error())
 		}
-		vr, _ := fs.GetQuota(name)
-		//convert bytes to GB
-		vr /= 1024 * 1024 * 1024
 		quota = strconv.Itoa(vr)
 	case "cpuset":
 		quota = container.QuotaCPUset(name, size)
@@ -46,30 +152,71 @@ func LxcQuota(name, res, size, threshold string) {
 	if quota == "none" {
 		quota = "0"
 	}
+	return quota, nil
+}
 
-	fmt.Println(`{"quota":"` + quota + `", "threshold":` + alert + `}`)
+// reserveValue sets disk space reservation of the resource if size is not empty and returns its current value
+func reserveValue(name, res, size string) (string, error) {
+	vr, err := container.ReserveDisk(name, res, size)
+	if err != nil {
+		return "", errors.New("Setting " + res + " reservation: " + err.Error())
+	}
+	return strconv.Itoa(vr), nil
+}
+
+// thresholdKey returns container config item of the resource alert threshold, empty if resource has no alerts
+func thresholdKey(resource string) string {
+	switch {
+	case resource == "cpu" || resource == "ram" || resource == "disk" || resource == "network":
+		return "subutai.alert." + resource
+	case strings.HasPrefix(resource, "io."):
+		return "subutai.alert.io"
+	case isDisk(resource):
+		return "subutai.alert.disk." + resource
+	}
+	return ""
 }
 
-// setQuotaThreshold sets threshold for quota alerts
-func setQuotaThreshold(name, resource, size string) {
-	if resource == "rootfs" || resource == "var" || resource == "opt" || resource == "home" {
-		container.SetContainerConf(name, [][]string{{"subutai.alert.disk." + resource, size}})
-		return
-	} else if resource == "cpu" || resource == "ram" {
-		container.SetContainerConf(name, [][]string{{"subutai.alert." + resource, size}})
-		return
+// checkThreshold validates threshold for quota alerts, either "<warning>" or "<warning>:<critical>"
+func checkThreshold(size string) error {
+	var levels []int
+	for _, level := range strings.Split(size, ":") {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 {
+			return errors.New("Invalid threshold " + size)
+		}
+		levels = append(levels, n)
+	}
+	if len(levels) > 2 || len(levels) == 2 && levels[1] < levels[0] {
+		return errors.New("Invalid threshold " + size + ", critical level should follow the warning one, e.g. 80:95")
 	}
-	log.Fatal("Failed to set threshold for " + resource)
+	return nil
 }
 
-// getQuotaThreshold gets threshold of quota alerts
-func getQuotaThreshold(name, resource string) string {
-	res := "subutai.alert.disk." + resource
-	if resource == "cpu" || resource == "ram" {
-		res = "subutai.alert." + resource
+// setQuotaThreshold sets threshold for quota alerts, either "<warning>" or "<warning>:<critical>"
+func setQuotaThreshold(name, resource, size string) error {
+	key := thresholdKey(resource)
+	if len(key) == 0 {
+		return usage("Threshold is not supported for " + resource)
+	}
+	if err := checkThreshold(size); err != nil {
+		return usage(err.Error())
+	}
+	return container.SetContainerConf(name, [][]string{{key, size}})
+}
+
+// getQuotaThreshold gets warning and critical thresholds of quota alerts, critical is empty if not set
+func getQuotaThreshold(name, resource string) (warning, critical string) {
+	key := thresholdKey(resource)
+	if len(key) == 0 {
+		return "0", ""
+	}
+	levels := strings.SplitN(container.GetProperty(name, key), ":", 2)
+	if len(levels[0]) == 0 {
+		return "0", ""
 	}
-	if size := container.GetProperty(name, res); len(size) > 0 {
-		return size
+	if len(levels) == 2 {
+		return levels[0], levels[1]
 	}
-	return "0"
+	return levels[0], ""
 }
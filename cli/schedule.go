@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// Schedule describes automatic snapshot schedule of container
+type Schedule struct {
+	Container string          `json:"container"`
+	Policy    string          `json:"policy,omitempty"`
+	Classes   []ScheduleClass `json:"classes"`
+}
+
+// ScheduleClass describes state of automatic snapshots of single class, next is empty if snapshot is due
+type ScheduleClass struct {
+	Class string `json:"class"`
+	Keep  int    `json:"keep"`
+	Count int    `json:"count"`
+	Last  string `json:"last,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+// LxcSchedule shows or sets automatic snapshot schedule of Subutai container.
+// Schedule is a comma separated list of "class:keep" pairs, where class is one of hourly, daily, weekly or monthly
+// and keep is a number of snapshots of this class to retain, e.g. "hourly:24,daily:7". Schedule "none" disables automatic snapshots.
+// Snapshots are taken and pruned by the agent daemon.
+func LxcSchedule(name, spec string) {
+	if !container.IsContainer(name) {
+		log.Error("Container " + name + " not found")
+	}
+
+	if len(spec) > 0 {
+		log.Check(log.ErrorLevel, "Setting snapshot schedule", container.SetSnapshotPolicy(name, spec))
+	}
+
+	current := container.SnapshotPolicy(name)
+	policy, err := container.ParseSnapshotPolicy(current)
+	log.Check(log.ErrorLevel, "Parsing snapshot schedule", err)
+
+	result := Schedule{Container: name, Policy: current, Classes: []ScheduleClass{}}
+	var classes []string
+	for class := range policy {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return container.SnapshotIntervals[classes[i]] < container.SnapshotIntervals[classes[j]]
+	})
+
+	for _, class := range classes {
+		_, created, err := container.AutoSnapshots(name, class)
+		log.Check(log.ErrorLevel, "Listing "+class+" snapshots", err)
+
+		item := ScheduleClass{Class: class, Keep: policy[class], Count: len(created)}
+		if len(created) > 0 {
+			t := created[len(created)-1]
+			item.Last = t.Local().Format("2006-01-02 15:04:05")
+			item.Next = t.Add(container.SnapshotIntervals[class]).Local().Format("2006-01-02 15:04:05")
+		}
+		result.Classes = append(result.Classes, item)
+	}
+
+	printResult(result, func(w io.Writer) {
+		if len(result.Classes) == 0 {
+			fmt.Fprintln(w, "No snapshot schedule for " + name)
+			return
+		}
+		fmt.Fprintln(w, "Schedule: " + current)
+		fmt.Fprintf(w, "%-10s %-6s %-6s %-22s %-22s\n", "CLASS", "KEEP", "COUNT", "LAST", "NEXT")
+		for _, c := range result.Classes {
+			last, next := c.Last, c.Next
+			if len(last) == 0 {
+				last, next = "-", "due"
+			}
+			fmt.Fprintf(w, "%-10s %-6s %-6s %-22s %-22s\n", c.Class, strconv.Itoa(c.Keep), strconv.Itoa(c.Count), last, next)
+		}
+	})
+}
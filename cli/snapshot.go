@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// SnapshotInfo describes snapshot of Subutai container, sizes are in bytes
+type SnapshotInfo struct {
+	Label   string `json:"label"`
+	Created string `json:"created,omitempty"`
+	Size    int64  `json:"size"`
+	Used    int64  `json:"used"`
+}
+
+// LxcSnapshot manages point-in-time snapshots of Subutai container. Supported operations:
+//	create, snapshots rootfs, home, var and opt partitions atomically; label is generated if omitted
+//	list, shows existing snapshots with creation time and size
+//	rollback, reverts stopped container to the snapshot, snapshots taken after it are removed
+//	delete, removes the snapshot; snapshots which templates or containers were cloned from are kept
+func LxcSnapshot(name, operation, label string) {
+	if !container.IsContainer(name) && !(container.IsTemplate(name) && (operation == "list" || operation == "delete")) {
+		log.Error("Container " + name + " not found")
+	}
+
+	switch operation {
+	case "create":
+		if len(label) == 0 {
+			label = time.Now().Format("20060102-150405")
+		}
+		log.Check(log.ErrorLevel, "Creating snapshot", container.Snapshot(name, label))
+		log.Info("Snapshot " + name + "@" + label + " created")
+	case "rollback":
+		if len(label) == 0 {
+			log.Error("Please specify snapshot label")
+		}
+		log.Check(log.ErrorLevel, "Rolling back to snapshot", container.RollbackSnapshot(name, label))
+		log.Info(name + " rolled back to snapshot " + label)
+	case "delete":
+		if len(label) == 0 {
+			log.Error("Please specify snapshot label")
+		}
+		log.Check(log.ErrorLevel, "Removing snapshot", container.RemoveSnapshot(name, label))
+		log.Info("Snapshot " + name + "@" + label + " removed")
+	case "list", "":
+		snapshots, err := container.Snapshots(name)
+		log.Check(log.ErrorLevel, "Listing snapshots", err)
+		list := []SnapshotInfo{}
+		for _, s := range snapshots {
+			size, _ := strconv.ParseInt(s["size"], 10, 64)
+			used, _ := strconv.ParseInt(s["used"], 10, 64)
+			list = append(list, SnapshotInfo{Label: s["label"], Created: s["created"], Size: size, Used: used})
+		}
+		printResult(list, func(w io.Writer) {
+			fmt.Fprintf(w, "%-30s %-26s %-12s %-12s\n", "LABEL", "CREATED", "SIZE", "USED")
+			for _, s := range snapshots {
+				fmt.Fprintf(w, "%-30s %-26s %-12s %-12s\n", s["label"], s["created"], humanSize(s["size"]), humanSize(s["used"]))
+			}
+		})
+	default:
+		log.Error("Unknown snapshot operation " + operation)
+	}
+}
+
+// humanSize converts size in bytes to human readable form
+func humanSize(bytes string) string {
+	size, err := strconv.ParseFloat(bytes, 64)
+	if err != nil {
+		return "-"
+	}
+	units := []string{"B", "K", "M", "G", "T"}
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+	return strconv.FormatFloat(size, 'f', 1, 64) + units[i]
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"time"
 
 	"github.com/subutai-io/agent/lib/container"
@@ -9,7 +10,7 @@ import (
 
 // LxcStart starts a Subutai container and checks if container state changed to "running" or "starting".
 // If state is not changing for 60 seconds, then the "start" operation is considered to have failed.
-func LxcStart(name string) {
+func LxcStart(name string) error {
 	if container.LxcInstanceExists(name) && container.State(name) == "STOPPED" {
 		startErr := container.Start(name)
 		for i := 0; i < 60 && startErr != nil; i++ {
@@ -18,8 +19,9 @@ func LxcStart(name string) {
 			time.Sleep(time.Second)
 		}
 		if startErr != nil {
-			log.Error(name + " start failed")
+			return errors.New(name + " start failed")
 		}
 		log.Info(name + " started")
 	}
+	return nil
 }
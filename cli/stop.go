@@ -1,12 +1,14 @@
 package cli
 
 import (
+	"errors"
+
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
 )
 
 // LxcStop stops a Subutai container with an additional state check.
-func LxcStop(name string) {
+func LxcStop(name string) error {
 	if container.LxcInstanceExists(name) && container.State(name) == "RUNNING" {
 		stopErr := container.Stop(name, true)
 		for i := 0; i < 60 && stopErr != nil; i++ {
@@ -14,8 +16,9 @@ func LxcStop(name string) {
 			stopErr = container.Stop(name, true)
 		}
 		if stopErr != nil {
-			log.Error(name + " stop failed")
+			return errors.New(name + " stop failed")
 		}
 		log.Info(name + " stopped")
 	}
+	return nil
 }
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subutai-io/agent/lib/cgroup"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// TopEntry is a resource usage of container or its process over sampling interval.
+// CPU is in percents of single core, RSS in bytes, disk I/O and network rates in bytes per second.
+type TopEntry struct {
+	Name   string  `json:"name"`
+	PID    int     `json:"pid,omitempty"`
+	CPU    float64 `json:"cpu"`
+	RSS    int     `json:"rss"`
+	Read   int     `json:"read"`
+	Write  int     `json:"write"`
+	NetIn  int     `json:"netIn"`
+	NetOut int     `json:"netOut"`
+}
+
+// topSample is a snapshot of cumulative counters, CPU time is in USER_HZ ticks
+type topSample struct {
+	name          string
+	pid           int
+	ticks         int
+	rss           int
+	read, write   int
+	netIn, netOut int
+}
+
+// Top shows resource usage of running containers, or processes of the container, sampled over delay in seconds.
+// Numbers are read from the same cgroup counters used by alerts and metrics collection.
+// Table output is refreshed until interrupted or number of iterations is reached, json and yaml print single sample.
+func Top(name string, processes bool, delay, iterations int) {
+	if processes && len(name) == 0 {
+		log.Exit(log.ExitUsage, "Usage: subutai top -p <container>")
+	}
+	if len(name) > 0 && container.State(name) != "RUNNING" {
+		log.Error("Container " + name + " is not running")
+	}
+	if delay <= 0 {
+		delay = 1
+	}
+	if outputFormat != "table" {
+		iterations = 1
+	}
+
+	sample := func() map[string]topSample {
+		if processes {
+			return sampleProcesses(name)
+		}
+		return sampleContainers(name)
+	}
+
+	prev, start := sample(), time.Now()
+	for i := 0; iterations <= 0 || i < iterations; i++ {
+		time.Sleep(time.Duration(delay) * time.Second)
+		next, now := sample(), time.Now()
+		entries := topEntries(prev, next, now.Sub(start).Seconds())
+		prev, start = next, now
+
+		printResult(entries, func(w io.Writer) {
+			if iterations != 1 {
+				fmt.Fprint(w, "\033[H\033[2J")
+			}
+			printTop(w, entries, processes)
+		})
+	}
+}
+
+// topEntries converts difference of counters to rates, entries are ordered by CPU usage
+func topEntries(prev, next map[string]topSample, seconds float64) []TopEntry {
+	entries := []TopEntry{}
+	for key, n := range next {
+		p, ok := prev[key]
+		if !ok || seconds <= 0 {
+			continue
+		}
+		entry := TopEntry{
+			Name:   n.name,
+			CPU:    float64(int(float64(n.ticks-p.ticks)/seconds*10)) / 10,
+			RSS:    n.rss,
+			Read:   int(float64(n.read-p.read) / seconds),
+			Write:  int(float64(n.write-p.write) / seconds),
+			NetIn:  int(float64(n.netIn-p.netIn) / seconds),
+			NetOut: int(float64(n.netOut-p.netOut) / seconds),
+			PID:    n.pid,
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CPU != entries[j].CPU {
+			return entries[i].CPU > entries[j].CPU
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+func printTop(out io.Writer, entries []TopEntry, processes bool) {
+	w := new(tabwriter.Writer)
+	w.Init(out, 0, 8, 1, '\t', 0)
+	if processes {
+		fmt.Fprintln(w, "PID\tCOMMAND\tCPU%\tRSS\tREAD/s\tWRITE/s")
+	} else {
+		fmt.Fprintln(w, "CONTAINER\tCPU%\tRSS\tREAD/s\tWRITE/s\tNET IN/s\tNET OUT/s")
+	}
+	for _, e := range entries {
+		usage := strconv.FormatFloat(e.CPU, 'f', 1, 64) + "\t" + humanSize(strconv.Itoa(e.RSS)) + "\t" +
+			humanSize(strconv.Itoa(e.Read)) + "\t" + humanSize(strconv.Itoa(e.Write))
+		if processes {
+			fmt.Fprintln(w, strconv.Itoa(e.PID)+"\t"+e.Name+"\t"+usage)
+		} else {
+			fmt.Fprintln(w, e.Name+"\t"+usage+"\t"+humanSize(strconv.Itoa(e.NetIn))+"\t"+humanSize(strconv.Itoa(e.NetOut)))
+		}
+	}
+	w.Flush()
+}
+
+// sampleContainers reads counters of running containers from their cgroups and host side network interfaces
+func sampleContainers(name string) map[string]topSample {
+	list := cgroup.Containers()
+	if len(name) > 0 {
+		list = []string{name}
+	}
+
+	samples := make(map[string]topSample)
+	for _, c := range list {
+		s := topSample{name: c}
+		user, system, err := cgroup.CPUTime(c)
+		if err != nil {
+			continue
+		}
+		s.ticks = user + system
+		if stat, err := cgroup.MemoryStat(c); err == nil {
+			s.rss = stat["rss"]
+		}
+		if io, err := cgroup.IO(c); err == nil {
+			s.read, s.write = io.ReadBytes, io.WriteBytes
+		}
+		// host side of veth pair is used, same as in metrics collection
+		if nic := container.GetProperty(c, "lxc.network.veth.pair"); len(nic) > 0 {
+			s.netIn = readCounter(path.Join("/sys/class/net", nic, "statistics/rx_bytes"))
+			s.netOut = readCounter(path.Join("/sys/class/net", nic, "statistics/tx_bytes"))
+		}
+		samples[c] = s
+	}
+	return samples
+}
+
+// sampleProcesses reads counters of container processes from /proc, samples are keyed by PID
+func sampleProcesses(name string) map[string]topSample {
+	pids, err := cgroup.Processes(name)
+	log.Check(log.ErrorLevel, "Getting processes of container "+name, err)
+
+	samples := make(map[string]topSample)
+	for _, pid := range pids {
+		dir := path.Join("/proc", strconv.Itoa(pid))
+		stat, err := ioutil.ReadFile(path.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		// command is in parentheses and may contain spaces, so fields are counted after it
+		s := string(stat)
+		open, end := strings.Index(s, "("), strings.LastIndex(s, ")")
+		if open == -1 || end < open {
+			continue
+		}
+		f := strings.Fields(s[end+1:])
+		if len(f) < 22 {
+			continue
+		}
+		utime, _ := strconv.Atoi(f[11])
+		stime, _ := strconv.Atoi(f[12])
+		rss, _ := strconv.Atoi(f[21])
+
+		sample := topSample{name: s[open+1 : end], pid: pid, ticks: utime + stime, rss: rss * os.Getpagesize()}
+		if io, err := ioutil.ReadFile(path.Join(dir, "io")); err == nil {
+			for _, line := range strings.Split(string(io), "\n") {
+				if kv := strings.Fields(line); len(kv) == 2 {
+					switch kv[0] {
+					case "read_bytes:":
+						sample.read, _ = strconv.Atoi(kv[1])
+					case "write_bytes:":
+						sample.write, _ = strconv.Atoi(kv[1])
+					}
+				}
+			}
+		}
+		samples[strconv.Itoa(pid)] = sample
+	}
+	return samples
+}
+
+func readCounter(file string) int {
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return value
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopEntries(t *testing.T) {
+	prev := map[string]topSample{
+		"foo": {name: "foo", ticks: 100, read: 0, write: 1000, netIn: 2048, netOut: 0},
+		"bar": {name: "bar", ticks: 50},
+		"old": {name: "old", ticks: 10},
+	}
+	next := map[string]topSample{
+		"foo": {name: "foo", ticks: 300, rss: 4096, read: 2048, write: 1000, netIn: 4096, netOut: 1024},
+		"bar": {name: "bar", ticks: 250},
+		"baz": {name: "baz", ticks: 1000},
+	}
+	want := []TopEntry{
+		{Name: "bar", CPU: 100},
+		{Name: "foo", CPU: 100, RSS: 4096, Read: 1024, NetIn: 1024, NetOut: 512},
+	}
+	// new containers have no rates yet, stopped ones are dropped
+	if got := topEntries(prev, next, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("topEntries = %+v, want %+v", got, want)
+	}
+	if got := topEntries(prev, next, 3); got[0].CPU != 66.6 {
+		t.Errorf("CPU usage %v, want truncated to 66.6", got[0].CPU)
+	}
+	if got := topEntries(prev, next, 0); len(got) != 0 {
+		t.Errorf("rates of empty interval %+v", got)
+	}
+}
+
+func TestPrintTop(t *testing.T) {
+	entries := []TopEntry{{Name: "foo", PID: 100, CPU: 12.5, RSS: 2048, Read: 1024, NetIn: 512}}
+
+	var out bytes.Buffer
+	printTop(&out, entries, false)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "CONTAINER") ||
+		strings.Join(strings.Fields(lines[1]), " ") != "foo 12.5 2.0K 1.0K 0.0B 512.0B 0.0B" {
+		t.Errorf("containers printed as %q", out.String())
+	}
+
+	out.Reset()
+	printTop(&out, entries, true)
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "PID") ||
+		strings.Join(strings.Fields(lines[1]), " ") != "100 foo 12.5 2.0K 1.0K 0.0B" {
+		t.Errorf("processes printed as %q", out.String())
+	}
+}
+
+func TestReadCounter(t *testing.T) {
+	f, err := ioutil.TempFile("", "counter-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("12345\n")
+	f.Close()
+
+	if got := readCounter(f.Name()); got != 12345 {
+		t.Errorf("readCounter = %d, want 12345", got)
+	}
+	if got := readCounter(f.Name() + ".missing"); got != 0 {
+		t.Errorf("missing counter = %d, want 0", got)
+	}
+}
@@ -2,7 +2,9 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os/exec"
@@ -25,10 +27,26 @@ import (
 // Subutai tunnels have a continuous state checking mechanism which keeps opened tunnels alive and closes outdated tunnels to keep the system network connections clean.
 // This mechanism may re-create a tunnel if it was dropped unintentionally (system reboot, network interruption, etc.), but newly created tunnels will have different "entrance" address.
 
+// Tunnel describes SSH tunnel opened to local socket
+type Tunnel struct {
+	Remote string `json:"remote"`
+	Local  string `json:"local"`
+	TTL    string `json:"ttl"`
+}
+
 // TunAdd adds tunnel to specified network socket
-func TunAdd(socket, timeout string) {
+func TunAdd(socket, timeout string) (Output, error) {
+	t, err := tunAdd(socket, timeout)
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Result: t, table: func(w io.Writer) { fmt.Fprintln(w, t.Remote) }}, nil
+}
+
+// tunAdd opens new or updates existing tunnel
+func tunAdd(socket, timeout string) (Tunnel, error) {
 	if len(socket) == 0 {
-		log.Error("Please specify socket")
+		return Tunnel{}, usage("Please specify socket")
 	}
 
 	if len(strings.Split(socket, ":")) == 1 {
@@ -38,68 +56,90 @@ func TunAdd(socket, timeout string) {
 	if item := getTunnel(socket); item != nil {
 		if len(timeout) > 0 {
 			tout, err := strconv.Atoi(timeout)
-			log.Check(log.ErrorLevel, "Converting timeout to int", err)
+			if err != nil {
+				return Tunnel{}, usage("Invalid timeout " + timeout)
+			}
 			item["ttl"] = strconv.Itoa(int(time.Now().Unix()) + tout)
 		} else {
 			item["ttl"] = "-1"
 		}
-		log.Check(log.ErrorLevel, "Updating tunnel entry", db.INSTANCE.AddTunEntry(item))
-		fmt.Println(item["remote"])
-		return
+		if err := db.INSTANCE.AddTunEntry(item); err != nil {
+			return Tunnel{}, errors.New("Updating tunnel entry: " + err.Error())
+		}
+		return Tunnel{Remote: item["remote"], Local: socket, TTL: item["ttl"]}, nil
+	}
+
+	ttl := "-1"
+	if len(timeout) > 0 {
+		tout, err := strconv.Atoi(timeout)
+		if err != nil {
+			return Tunnel{}, usage("Invalid timeout " + timeout)
+		}
+		ttl = strconv.Itoa(int(time.Now().Unix()) + tout)
 	}
 
 	log.Check(log.WarnLevel, "Setting key permissions", os.Chmod(path.Join(config.Agent.DataPrefix, "ssh.pem"), 0600))
 
-	args, tunsrv := getArgs(socket)
+	args, tunsrv, err := getArgs(socket)
+	if err != nil {
+		return Tunnel{}, err
+	}
 
 	log.Debug("Executing command ssh " + strings.Join(args, " "))
 
 	cmd := exec.Command("ssh", args...)
 
 	stderr, _ := cmd.StderrPipe()
-	log.Check(log.FatalLevel, "Creating SSH tunnel to "+socket, cmd.Start())
+	if err = cmd.Start(); err != nil {
+		return Tunnel{}, errors.New("Creating SSH tunnel to " + socket + ": " + err.Error())
+	}
 	r := bufio.NewReader(stderr)
 	line, _, err := r.ReadLine()
-	log.Check(log.FatalLevel, "Reading tunnel output pipe", err)
 	for i := 0; err == nil && i < 10; i++ {
 		log.Debug("Ssh tunnel output: \n" + string(line))
 		if strings.Contains(string(line), "Allocated port") {
 			port := strings.Fields(string(line))
-			fmt.Println(tunsrv + ":" + port[2])
 			tunnel := map[string]string{
 				"pid":    strconv.Itoa(cmd.Process.Pid),
 				"local":  socket,
 				"remote": tunsrv + ":" + port[2],
-				"ttl":    "-1",
-			}
-			if len(timeout) > 0 {
-				tout, err := strconv.Atoi(timeout)
-				log.Check(log.ErrorLevel, "Converting timeout to int", err)
-				tunnel["ttl"] = strconv.Itoa(int(time.Now().Unix()) + tout)
+				"ttl":    ttl,
 			}
 			log.Check(log.WarnLevel, "Adding new tunnel entry", db.INSTANCE.AddTunEntry(tunnel))
-			return
+			return Tunnel{Remote: tunnel["remote"], Local: socket, TTL: tunnel["ttl"]}, nil
 		}
 		time.Sleep(1 * time.Second)
 		line, _, err = r.ReadLine()
 	}
-	log.Error("Cannot get tunnel port")
+	if err != nil {
+		return Tunnel{}, errors.New("Reading tunnel output pipe: " + err.Error())
+	}
+	return Tunnel{}, errors.New("Cannot get tunnel port")
 }
 
 // TunList performs tunnel check and shows "alive" tunnels
-func TunList() {
-	TunCheck()
+func TunList() (Output, error) {
+	if err := TunCheck(); err != nil {
+		return Output{}, err
+	}
 
 	list, err := db.INSTANCE.GetTunList()
-	if !log.Check(log.WarnLevel, "Reading tunnel list from db", err) {
+	if err != nil {
+		return Output{}, errors.New("Reading tunnel list from db: " + err.Error())
+	}
+	result := []Tunnel{}
+	for _, item := range list {
+		result = append(result, Tunnel{Remote: item["remote"], Local: item["local"], TTL: item["ttl"]})
+	}
+	return Output{Result: result, table: func(w io.Writer) {
 		for _, item := range list {
-			fmt.Printf("%s\t%s\t%s\n", item["remote"], item["local"], item["ttl"])
+			fmt.Fprintf(w, "%s\t%s\t%s\n", item["remote"], item["local"], item["ttl"])
 		}
-	}
+	}}, nil
 }
 
 // TunDel removes tunnel entry from list and kills running tunnel process
-func TunDel(socket string, pid ...string) {
+func TunDel(socket string, pid ...string) error {
 	list, err := db.INSTANCE.GetTunList()
 	if !log.Check(log.WarnLevel, "Reading tunnel list from db", err) {
 		for _, item := range list {
@@ -108,44 +148,58 @@ func TunDel(socket string, pid ...string) {
 				f, err := ioutil.ReadFile("/proc/" + item["pid"] + "/cmdline")
 				if err == nil && strings.Contains(string(f), item["local"]) {
 					pid, err := strconv.Atoi(item["pid"])
-					log.Check(log.FatalLevel, "Converting pid to int", err)
-					log.Check(log.FatalLevel, "Killing tunnel process", syscall.Kill(pid, 15))
+					if err != nil {
+						return errors.New("Converting pid to int: " + err.Error())
+					}
+					if err = syscall.Kill(pid, 15); err != nil {
+						return errors.New("Killing tunnel process: " + err.Error())
+					}
 				}
 			}
 		}
 	}
+	return nil
 }
 
 // TunCheck reads list, checks tunnel ttl, its state and then adds or removes required tunnels
-func TunCheck() {
+func TunCheck() error {
 	list, err := db.INSTANCE.GetTunList()
 	if !log.Check(log.WarnLevel, "Reading tunnel list from db", err) {
 		for _, item := range list {
 			ttl, err := strconv.Atoi(item["ttl"])
-			log.Check(log.ErrorLevel, "Checking tunnel "+item["local"]+" ttl", err)
+			if err != nil {
+				return errors.New("Checking tunnel " + item["local"] + " ttl: " + err.Error())
+			}
 			if ttl <= int(time.Now().Unix()) && ttl != -1 {
-				TunDel(item["local"], item["pid"])
+				if err = TunDel(item["local"], item["pid"]); err != nil {
+					return err
+				}
 			} else if !tunOpen(item["remote"], item["local"]) {
-				TunDel(item["local"], item["pid"])
+				if err = TunDel(item["local"], item["pid"]); err != nil {
+					return err
+				}
 				newttl := ""
 				if ttl-int(time.Now().Unix()) > 0 {
 					newttl = strconv.Itoa(ttl - int(time.Now().Unix()))
 				}
-				TunAdd(item["local"], newttl)
+				if _, err = tunAdd(item["local"], newttl); err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
 }
 
 // getArgs builds command line to execute in system
-func getArgs(socket string) ([]string, string) {
-	var tunsrv string
-	var args []string
+func getArgs(socket string) ([]string, string, error) {
 	cdn, err := net.LookupIP("ssh." + path.Join(config.CDN.URL))
-	log.Check(log.ErrorLevel, "Resolving nearest tunnel node address", err)
-	tunsrv = cdn[0].String()
-	args = []string{"-i", path.Join(config.Agent.DataPrefix, "ssh.pem"), "-N", "-p", "8022", "-R", "0:" + socket, "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "tunnel@" + tunsrv}
-	return args, tunsrv
+	if err != nil {
+		return nil, "", errors.New("Resolving nearest tunnel node address: " + err.Error())
+	}
+	tunsrv := cdn[0].String()
+	args := []string{"-i", path.Join(config.Agent.DataPrefix, "ssh.pem"), "-N", "-p", "8022", "-R", "0:" + socket, "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "tunnel@" + tunsrv}
+	return args, tunsrv, nil
 }
 
 // tunOpen checks tunnel sockets state to define if tunnel is alive
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 
@@ -35,8 +36,27 @@ func tunnelCreate(tunnel, addr, vlan, vni string) {
 	log.Check(log.FatalLevel, "MakeVNIMap set port: ", exec.Command("ovs-vsctl", "--if-exists", "set", "port", tunnel, "tag="+vlan).Run())
 }
 
+// VxlanTunnelInfo describes VXLAN tunnel in list output
+type VxlanTunnelInfo struct {
+	Name     string `json:"name"`
+	RemoteIP string `json:"remoteIp"`
+	Vlan     string `json:"vlan"`
+	VNI      string `json:"vni"`
+}
+
 //tunnelList prints a list of existing VXLAN tunnels
 func tunnelList() {
+	list := vxlanTunnels()
+	printResult(list, func(w io.Writer) {
+		for _, t := range list {
+			fmt.Fprintln(w, t.Name, t.RemoteIP, t.Vlan, t.VNI)
+		}
+	})
+}
+
+// vxlanTunnels returns existing VXLAN tunnels parsed from OVS configuration
+func vxlanTunnels() []VxlanTunnelInfo {
+	list := []VxlanTunnelInfo{}
 	ret, err := exec.Command("ovs-vsctl", "show").CombinedOutput()
 	log.Check(log.FatalLevel, "Getting OVS interfaces list", err)
 	ports := strings.Split(string(ret), "\n")
@@ -48,7 +68,8 @@ func tunnelList() {
 			addr := strings.Fields(port)
 			vni := strings.Trim(strings.Trim(addr[1], "{key="), "\",")
 			ip := strings.Trim(strings.Trim(addr[2], "remote_ip="), "\",")
-			fmt.Println(tunnel, ip, tag, vni)
+			list = append(list, VxlanTunnelInfo{Name: tunnel, RemoteIP: ip, Vlan: tag, VNI: vni})
 		}
 	}
+	return list
 }
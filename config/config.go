@@ -17,6 +17,7 @@ type agentConfig struct {
 	GpgUser     string
 	LxcPrefix   string
 	Dataset     string
+	Storage     string
 	DataPrefix  string
 	CacheDir    string
 	GpgPassword string
@@ -30,12 +31,31 @@ type managementConfig struct {
 	RestPublicKey string
 	Fingerprint   string
 	Allowinsecure bool
+	Stream        bool
 }
 
 type influxdbConfig struct {
-	Db   string
-	User string
-	Pass string
+	Enabled    bool
+	Db         string
+	User       string
+	Pass       string
+	BufferSize int
+	BufferAge  int
+}
+type prometheusConfig struct {
+	Enabled bool
+	Listen  string
+}
+type alertConfig struct {
+	Window     int
+	Hysteresis int
+}
+type notifyConfig struct {
+	Webhook []string
+	Retries int
+	Timeout int
+	Syslog  bool
+	Exec    string
 }
 type cdnConfig struct {
 	Allowinsecure bool
@@ -43,11 +63,18 @@ type cdnConfig struct {
 	SSLport       string
 	Kurjun        string
 }
+type migrationConfig struct {
+	Secret string
+}
 type configFile struct {
 	Agent      agentConfig
 	Management managementConfig
 	Influxdb   influxdbConfig
+	Prometheus prometheusConfig
+	Alert      alertConfig
+	Notify     notifyConfig
 	CDN        cdnConfig
+	Migration  migrationConfig
 }
 
 const defaultConfig = `
@@ -59,6 +86,7 @@ const defaultConfig = `
 	dataPrefix = /var/lib/subutai/
 	lxcPrefix = /var/lib/lxc/
     dataset = subutai/fs
+    storage = zfs
     cacheDir = /var/cache/subutai
 
 	[management]
@@ -68,6 +96,7 @@ const defaultConfig = `
 	secret = secret
 	restPublicKey = /rest/v1/security/keyman/getpublickeyring
 	allowinsecure = true
+	stream = false
 
     [cdn]
     url = cdn.subutai.io
@@ -75,9 +104,29 @@ const defaultConfig = `
     allowinsecure = false
 
 	[influxdb]
+	enabled = true
 	user = root
 	pass = root
 	db = metrics
+	bufferSize = 50
+	bufferAge = 60
+
+	[prometheus]
+	enabled = false
+	listen = :9273
+
+	[alert]
+	window = 0
+	hysteresis = 5
+
+	[notify]
+	retries = 3
+	timeout = 10
+	syslog = false
+	exec =
+
+	[migration]
+	secret =
 
 `
 
@@ -89,8 +138,17 @@ var (
 	Management managementConfig
 	// Influxdb describes configuration options for InluxDB server
 	Influxdb influxdbConfig
+	// Prometheus describes configuration options for built-in Prometheus metrics endpoint
+	Prometheus prometheusConfig
+	// Alert describes how long usage should stay over threshold to raise an alert, minutes,
+	// and how far below threshold it should fall to clear the alert, percents
+	Alert alertConfig
+	// Notify describes local channels notified on alert raise and clear: webhook URLs, syslog and executable hook
+	Notify notifyConfig
 	// CDN url and port
 	CDN cdnConfig
+	// Migration describes shared secret used to authenticate container migration between Resource Hosts
+	Migration migrationConfig
 )
 
 func init() {
@@ -116,8 +174,12 @@ func init() {
 	}
 	Agent = config.Agent
 	Influxdb = config.Influxdb
+	Prometheus = config.Prometheus
+	Alert = config.Alert
+	Notify = config.Notify
 	Management = config.Management
 	CDN = config.CDN
+	Migration = config.Migration
 
 	CDN.Kurjun = "https://" + path.Join(CDN.URL) + ":" + CDN.SSLport + "/kurjun/rest"
 
@@ -151,6 +213,16 @@ func SaveDefaultConfig(conf string) error {
 			return err
 		}
 		for j := 0; j < c.Field(i).NumField(); j++ {
+			// multi-valued variable is written as a line per value
+			if field := c.Field(i).Field(j); field.Kind() == reflect.Slice {
+				for k := 0; k < field.Len() && err == nil; k++ {
+					_, err = fmt.Fprintln(w, c.Field(i).Type().Field(j).Name, "=", field.Index(k).Interface())
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			_, err = fmt.Fprintln(w, c.Field(i).Type().Field(j).Name, "=", c.Field(i).Field(j).Interface())
 			if err != nil {
 				return err
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/boltdb/bolt"
@@ -9,7 +10,6 @@ import (
 	"path"
 	"time"
 	"github.com/subutai-io/agent/lib/fs"
-	"github.com/subutai-io/agent/log"
 )
 
 type Db struct {
@@ -22,20 +22,29 @@ var (
 	containers = []byte("containers")
 	templates  = []byte("templates")
 	portmap    = []byte("portmap")
+	snapshots  = []byte("snapshots")
+	outbox     = []byte("outbox")
+	alerts     = []byte("alerts")
+	audit      = []byte("audit")
 	dbPath     = path.Join(config.Agent.DataPrefix, "agent.db")
 )
 
-func initDb() {
+func initDb() error {
 	if !fs.FileExists(dbPath) {
 		//open and close db to create a proper db file
 		db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: false})
-		log.Check(log.ErrorLevel, "Creating database", err)
+		if err != nil {
+			return err
+		}
 		db.Close()
 	}
+	return nil
 }
 
 func openDb(readOnly bool) (*bolt.DB, error) {
-	initDb()
+	if err := initDb(); err != nil {
+		return nil, err
+	}
 
 	boltDB, err := bolt.Open(dbPath,
 		0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: readOnly})
@@ -530,3 +539,296 @@ func (i *Db) PortmapList(protocol string) (list []string, err error) {
 	}
 	return list, err
 }
+
+// SnapshotAdd stores metadata of container snapshot identified by label.
+func (i *Db) SnapshotAdd(name, label string, options map[string]string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists(snapshots); err == nil {
+				if b, err = b.CreateBucketIfNotExists([]byte(name)); err == nil {
+					if b, err = b.CreateBucketIfNotExists([]byte(label)); err == nil {
+						for k, v := range options {
+							if err = b.Put([]byte(k), []byte(v)); err != nil {
+								return err
+							}
+						}
+					}
+				}
+			}
+			return err
+		})
+	}
+	return err
+}
+
+// SnapshotDel removes metadata of container snapshot. Empty label removes metadata of all container snapshots.
+func (i *Db) SnapshotDel(name, label string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(snapshots); b != nil {
+				if len(label) == 0 {
+					if b.Bucket([]byte(name)) != nil {
+						return b.DeleteBucket([]byte(name))
+					}
+				} else if b = b.Bucket([]byte(name)); b != nil && b.Bucket([]byte(label)) != nil {
+					return b.DeleteBucket([]byte(label))
+				}
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// SnapshotList returns metadata of all snapshots of container. Snapshot label is returned as "label" key.
+func (i *Db) SnapshotList(name string) (list []map[string]string, err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(true); err == nil {
+		defer instance.Close()
+		instance.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(snapshots); b != nil {
+				if b = b.Bucket([]byte(name)); b != nil {
+					b.ForEach(func(k, v []byte) error {
+						if c := b.Bucket(k); c != nil {
+							item := map[string]string{"label": string(k)}
+							c.ForEach(func(kk, vv []byte) error {
+								item[string(kk)] = string(vv)
+								return nil
+							})
+							list = append(list, item)
+						}
+						return nil
+					})
+				}
+			}
+			return nil
+		})
+	}
+	return list, err
+}
+
+// OutboxAdd appends message to persistent outbox keeping insertion order.
+// Message with the same key is added only once, unless replace is set, in which case previous message with this key is removed.
+func (i *Db) OutboxAdd(key string, replace bool, options map[string]string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			var b, keys, items *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists(outbox); err != nil {
+				return err
+			}
+			if keys, err = b.CreateBucketIfNotExists([]byte("keys")); err != nil {
+				return err
+			}
+			if items, err = b.CreateBucketIfNotExists([]byte("items")); err != nil {
+				return err
+			}
+			if id := keys.Get([]byte(key)); id != nil {
+				if !replace {
+					return nil
+				}
+				if items.Bucket(id) != nil {
+					if err = items.DeleteBucket(id); err != nil {
+						return err
+					}
+				}
+			}
+			var n uint64
+			if n, err = items.NextSequence(); err != nil {
+				return err
+			}
+			// zero padded id keeps items sorted by insertion order
+			id := []byte(fmt.Sprintf("%020d", n))
+			if b, err = items.CreateBucket(id); err != nil {
+				return err
+			}
+			b.Put([]byte("key"), []byte(key))
+			for k, v := range options {
+				if err = b.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+			return keys.Put([]byte(key), id)
+		})
+	}
+	return err
+}
+
+// OutboxUpdate sets options of outbox message
+func (i *Db) OutboxUpdate(id string, options map[string]string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(outbox); b != nil {
+				if b = b.Bucket([]byte("items")); b != nil {
+					if b = b.Bucket([]byte(id)); b != nil {
+						for k, v := range options {
+							if err = b.Put([]byte(k), []byte(v)); err != nil {
+								return err
+							}
+						}
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// OutboxDel removes message from outbox
+func (i *Db) OutboxDel(id string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(outbox); b != nil {
+				if items := b.Bucket([]byte("items")); items != nil {
+					if item := items.Bucket([]byte(id)); item != nil {
+						if keys := b.Bucket([]byte("keys")); keys != nil && string(keys.Get(item.Get([]byte("key")))) == id {
+							keys.Delete(item.Get([]byte("key")))
+						}
+						return items.DeleteBucket([]byte(id))
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// OutboxList returns outbox messages in insertion order, each item contains its "id"
+func (i *Db) OutboxList() (list []map[string]string, err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(true); err == nil {
+		defer instance.Close()
+		instance.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(outbox); b != nil {
+				if b = b.Bucket([]byte("items")); b != nil {
+					b.ForEach(func(k, v []byte) error {
+						item := map[string]string{"id": string(k)}
+						if c := b.Bucket(k); c != nil {
+							c.ForEach(func(kk, vv []byte) error {
+								item[string(kk)] = string(vv)
+								return nil
+							})
+						}
+						list = append(list, item)
+						return nil
+					})
+				}
+			}
+			return nil
+		})
+	}
+	return list, err
+}
+
+// AlertSet saves state of container resource alert, e.g. key "foo/cpu"
+func (i *Db) AlertSet(key string, options map[string]string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists(alerts); err == nil {
+				if b.Bucket([]byte(key)) != nil {
+					if err = b.DeleteBucket([]byte(key)); err != nil {
+						return err
+					}
+				}
+				if b, err = b.CreateBucket([]byte(key)); err == nil {
+					for k, v := range options {
+						if err = b.Put([]byte(k), []byte(v)); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return err
+		})
+	}
+	return err
+}
+
+// AlertDel removes state of container resource alert
+func (i *Db) AlertDel(key string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(alerts); b != nil && b.Bucket([]byte(key)) != nil {
+				return b.DeleteBucket([]byte(key))
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// AlertList returns saved states of alerts by their keys
+func (i *Db) AlertList() (list map[string]map[string]string, err error) {
+	list = make(map[string]map[string]string)
+	var instance *bolt.DB
+	if instance, err = openDb(true); err == nil {
+		defer instance.Close()
+		instance.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(alerts); b != nil {
+				b.ForEach(func(k, v []byte) error {
+					if c := b.Bucket(k); c != nil {
+						item := make(map[string]string)
+						c.ForEach(func(kk, vv []byte) error {
+							item[string(kk)] = string(vv)
+							return nil
+						})
+						list[string(k)] = item
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	return list, err
+}
+
+// AuditHeadSet saves sequence number and hash of the last audit record, so removed or rewritten records can be detected
+func (i *Db) AuditHeadSet(seq, hash string) (err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(false); err == nil {
+		defer instance.Close()
+		return instance.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists(audit); err == nil {
+				if err = b.Put([]byte("seq"), []byte(seq)); err == nil {
+					err = b.Put([]byte("hash"), []byte(hash))
+				}
+			}
+			return err
+		})
+	}
+	return err
+}
+
+// AuditHead returns sequence number and hash of the last audit record, empty if nothing was recorded
+func (i *Db) AuditHead() (seq, hash string, err error) {
+	var instance *bolt.DB
+	if instance, err = openDb(true); err == nil {
+		defer instance.Close()
+		instance.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket(audit); b != nil {
+				seq, hash = string(b.Get([]byte("seq"))), string(b.Get([]byte("hash")))
+			}
+			return nil
+		})
+	}
+	return seq, hash, err
+}
@@ -0,0 +1,255 @@
+// Package audit keeps append-only hash-chained log of commands executed by request of Management server
+// and of CLI commands changing Resource Host state
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+// Entry is a single audit record. Hash covers all other fields including hash of previous record,
+// so changing or removing any record breaks the chain. Sequence number and hash of the last record are also kept
+// in agent database, so removed tail or the whole chain recalculated after changes are detected too.
+type Entry struct {
+	Seq       int64  `json:"seq"`
+	Time      string `json:"time"`
+	Source    string `json:"source"`
+	User      string `json:"user"`
+	Action    string `json:"action"`
+	Container string `json:"container,omitempty"`
+	CommandID string `json:"commandId,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Result    string `json:"result,omitempty"`
+	ExitCode  string `json:"exitCode"`
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash"`
+}
+
+// File returns location of the audit log
+func File() string {
+	return path.Join(config.Agent.DataPrefix, "audit.log")
+}
+
+// Write appends entry to the audit log setting its sequence number, time and hashes.
+// Log file is locked while writing, so the agent and CLI commands may write concurrently.
+// New entry follows the last record saved in database rather than the last line of the file,
+// so records removed from the file are not hidden by following ones.
+func Write(e Entry) error {
+	f, err := os.OpenFile(File(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	line, err := lastLine(f)
+	if err != nil {
+		return err
+	}
+	seq, prev, err := db.INSTANCE.AuditHead()
+	if err != nil {
+		return errors.New("Reading audit chain head: " + err.Error())
+	}
+	e.Seq, e.Prev = 1, prev
+	if len(seq) > 0 {
+		if e.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil {
+			return errors.New("Audit chain head is broken: " + err.Error())
+		}
+		e.Seq++
+	} else if len(line) > 0 {
+		// log written before the head was kept in database
+		var last Entry
+		if err = json.Unmarshal(line, &last); err != nil {
+			return errors.New("Last audit record is broken: " + err.Error())
+		}
+		e.Seq, e.Prev = last.Seq+1, last.Hash
+	}
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Hash = hash(e)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return db.INSTANCE.AuditHeadSet(strconv.FormatInt(e.Seq, 10), e.Hash)
+}
+
+// Command records CLI command which changes container or host. Returned function records successful completion
+// and should be deferred, while failed command is recorded with exit code 1 on exit by error.
+// e.g. defer audit.Command("destroy", name, os.Args[1:])()
+func Command(action, container string, args []string) func() {
+	e := Entry{Source: "cli", User: caller(), Action: action, Container: container, Command: strings.Join(mask(action, args), " ")}
+	done := false
+	log.RegisterExitHandler(func() {
+		if !done {
+			done = true
+			e.ExitCode = "1"
+			log.Check(log.WarnLevel, "Writing audit record", Write(e))
+		}
+	})
+	return func() {
+		if !done {
+			done = true
+			e.ExitCode = "0"
+			log.Check(log.WarnLevel, "Writing audit record", Write(e))
+		}
+	}
+}
+
+// Step records command executed in-process on behalf of another CLI command, e.g. batch step or its rollback.
+// Result describes what was done, e.g. "rollback: destroy foo".
+func Step(action, container string, args []string, result, exitCode string) error {
+	return Write(Entry{Source: "cli", User: caller(), Action: action, Container: container,
+		Command: strings.Join(mask(action, args), " "), Result: result, ExitCode: exitCode})
+}
+
+// Read returns audit records in the order they were written
+func Read() ([]Entry, error) {
+	f, err := os.Open(File())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var e Entry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return list, errors.New("Line " + strconv.Itoa(n) + " is not valid audit record: " + err.Error())
+		}
+		list = append(list, e)
+	}
+	return list, scanner.Err()
+}
+
+// Verify checks integrity of the audit log chain and its last record against the head saved in database,
+// it returns number of verified records
+func Verify() (int, error) {
+	list, err := Read()
+	if err != nil {
+		return 0, err
+	}
+	prev := ""
+	for i, e := range list {
+		switch {
+		case e.Seq != int64(i+1):
+			return i, errors.New("Record " + strconv.Itoa(i+1) + " has sequence number " + strconv.FormatInt(e.Seq, 10) + ", records were removed or reordered")
+		case e.Prev != prev:
+			return i, errors.New("Record " + strconv.FormatInt(e.Seq, 10) + " does not follow previous record")
+		case e.Hash != hash(e):
+			return i, errors.New("Record " + strconv.FormatInt(e.Seq, 10) + " was modified")
+		}
+		prev = e.Hash
+	}
+
+	seq, head, err := db.INSTANCE.AuditHead()
+	if err != nil {
+		return len(list), errors.New("Reading audit chain head: " + err.Error())
+	}
+	if len(seq) == 0 {
+		return len(list), nil
+	}
+	last := Entry{}
+	if len(list) > 0 {
+		last = list[len(list)-1]
+	}
+	switch {
+	case strconv.FormatInt(last.Seq, 10) != seq:
+		return len(list), errors.New("Log ends with record " + strconv.FormatInt(last.Seq, 10) + " while the last written record is " + seq + ", records were removed")
+	case last.Hash != head:
+		return len(list), errors.New("Record " + seq + " differs from the last written record, the log was rewritten")
+	}
+	return len(list), nil
+}
+
+// hash calculates SHA256 of the entry with empty hash field
+func hash(e Entry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns last non-empty line of the file reading it backwards
+func lastLine(f *os.File) ([]byte, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var line []byte
+	buf := make([]byte, 4096)
+	for offset := size; offset > 0; {
+		n := int64(len(buf))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		if _, err = f.ReadAt(buf[:n], offset); err != nil {
+			return nil, err
+		}
+		line = append(append([]byte{}, buf[:n]...), line...)
+		trimmed := strings.TrimRight(string(line), "\n")
+		if i := strings.LastIndex(trimmed, "\n"); i >= 0 {
+			return []byte(trimmed[i+1:]), nil
+		}
+	}
+	return []byte(strings.TrimRight(string(line), "\n")), nil
+}
+
+// caller returns name of the user running CLI command, including the user who called sudo
+func caller() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != name {
+		name = sudo + " as " + name
+	}
+	return name
+}
+
+// mask hides values of options carrying secrets
+func mask(action string, args []string) []string {
+	secret := map[string]bool{"--secret": true, "-secret": true, "--token": true, "-token": true}
+	if action == "clone" {
+		secret["-s"], secret["-t"] = true, true
+	}
+	list := make([]string, len(args))
+	hide := false
+	for i, arg := range args {
+		list[i] = arg
+		if hide {
+			list[i] = "***"
+		} else if j := strings.Index(arg, "="); j > 0 && secret[arg[:j]] {
+			list[i] = arg[:j] + "=***"
+		}
+		hide = secret[arg]
+	}
+	return list
+}
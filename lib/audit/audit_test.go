@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		action string
+		args   []string
+		want   []string
+	}{
+		{"clone", []string{"clone", "debian", "foo", "-s", "secret", "-t", "token"},
+			[]string{"clone", "debian", "foo", "-s", "***", "-t", "***"}},
+		{"clone", []string{"clone", "debian", "foo", "--secret=secret", "--token", "token"},
+			[]string{"clone", "debian", "foo", "--secret=***", "--token", "***"}},
+		// short options of other commands are not secrets, e.g. "quota -s" sets the quota
+		{"quota", []string{"quota", "foo", "ram", "-s", "1024"}, []string{"quota", "foo", "ram", "-s", "1024"}},
+		{"import", []string{"import", "debian", "-token=token"}, []string{"import", "debian", "-token=***"}},
+		{"destroy", nil, []string{}},
+	}
+	for _, tt := range tests {
+		if got := mask(tt.action, tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mask(%s, %v) = %v, want %v", tt.action, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	e := Entry{Seq: 1, Time: "2018-01-02T15:04:05Z", Source: "cli", User: "root", Action: "destroy", Container: "foo", ExitCode: "0"}
+	h := hash(e)
+	e.Hash = h
+	if hash(e) != h {
+		t.Error("hash depends on hash field")
+	}
+
+	changes := []func(e *Entry){
+		func(e *Entry) { e.Seq = 2 },
+		func(e *Entry) { e.Time = "2018-01-02T15:04:06Z" },
+		func(e *Entry) { e.User = "ubuntu" },
+		func(e *Entry) { e.Container = "bar" },
+		func(e *Entry) { e.ExitCode = "1" },
+		func(e *Entry) { e.Prev = h },
+	}
+	for i, change := range changes {
+		modified := e
+		change(&modified)
+		if hash(modified) == h {
+			t.Errorf("change %d of record doesn't change its hash", i)
+		}
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"", ""},
+		{"first\n", "first"},
+		{"first\nsecond", "second"},
+		{"first\nsecond\n\n", "second"},
+		// lines longer than read buffer are joined from several reads
+		{"first\n" + long + "\n", long},
+		{long + "\n" + "last\n", "last"},
+	}
+	for i, tt := range tests {
+		f, err := ioutil.TempFile("", "audit-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		if _, err = f.WriteString(tt.content); err != nil {
+			t.Fatal(err)
+		}
+		got, err := lastLine(f)
+		f.Close()
+		if err != nil || string(got) != tt.want {
+			t.Errorf("case %d: lastLine = %.20q, %v, want %.20q", i, got, err, tt.want)
+		}
+	}
+}
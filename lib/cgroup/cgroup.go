@@ -0,0 +1,369 @@
+// Package cgroup reads resource usage and limits of Subutai containers from control groups
+// and translates quota settings to items of cgroup hierarchy used by the host.
+// Legacy (v1) hierarchy has separate trees for each controller, e.g. /sys/fs/cgroup/memory/lxc/<name>,
+// unified (v2) hierarchy has single tree, e.g. /sys/fs/cgroup/lxc.payload.<name>, with different item names.
+// Hybrid hosts mount unified hierarchy without controllers next to legacy ones, so they are handled as legacy.
+package cgroup
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Modes of cgroup hierarchy
+const (
+	Legacy  = "legacy"
+	Hybrid  = "hybrid"
+	Unified = "unified"
+)
+
+// cgroup2Magic is a filesystem type of unified hierarchy returned by statfs
+const cgroup2Magic = 0x63677270
+
+var (
+	root = "/sys/fs/cgroup"
+	mode = detect(root)
+)
+
+// IOKinds are kinds of disk I/O limits: rbps and wbps are read and write bandwidth in bytes per second,
+// riops and wiops are read and write operations per second
+var IOKinds = []string{"rbps", "wbps", "riops", "wiops"}
+
+// ioItems are legacy blkio throttling items for each kind of I/O limit
+var ioItems = map[string]string{
+	"rbps":  "blkio.throttle.read_bps_device",
+	"wbps":  "blkio.throttle.write_bps_device",
+	"riops": "blkio.throttle.read_iops_device",
+	"wiops": "blkio.throttle.write_iops_device",
+}
+
+func detect(root string) string {
+	var st syscall.Statfs_t
+	if syscall.Statfs(root, &st) == nil && st.Type == cgroup2Magic {
+		return Unified
+	}
+	if syscall.Statfs(path.Join(root, "unified"), &st) == nil && st.Type == cgroup2Magic {
+		return Hybrid
+	}
+	return Legacy
+}
+
+// Mode returns cgroup hierarchy mode of the host
+func Mode() string {
+	return mode
+}
+
+func unified() bool {
+	return mode == Unified
+}
+
+// dir returns cgroup directory of container for controller
+func dir(name, controller string) string {
+	if unified() {
+		for _, d := range []string{"lxc.payload." + name, "lxc.payload/" + name} {
+			if _, err := os.Stat(path.Join(root, d)); err == nil {
+				return path.Join(root, d)
+			}
+		}
+		return path.Join(root, "lxc", name)
+	}
+	return path.Join(root, controller, "lxc", name)
+}
+
+// Containers returns names of containers which have cgroups, i.e. running ones
+func Containers() (list []string) {
+	if !unified() {
+		files, _ := ioutil.ReadDir(path.Join(root, "cpu", "lxc"))
+		for _, f := range files {
+			if f.IsDir() {
+				list = append(list, f.Name())
+			}
+		}
+		return
+	}
+
+	files, _ := ioutil.ReadDir(root)
+	for _, f := range files {
+		if f.IsDir() && strings.HasPrefix(f.Name(), "lxc.payload.") {
+			list = append(list, strings.TrimPrefix(f.Name(), "lxc.payload."))
+		}
+	}
+	for _, parent := range []string{"lxc.payload", "lxc"} {
+		files, _ = ioutil.ReadDir(path.Join(root, parent))
+		for _, f := range files {
+			if f.IsDir() {
+				list = append(list, f.Name())
+			}
+		}
+	}
+	return
+}
+
+// Processes returns PIDs of all processes of container, including ones in nested cgroups
+func Processes(name string) (list []int, err error) {
+	err = filepath.Walk(dir(name, "cpu"), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "cgroup.procs" {
+			return err
+		}
+		out, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Fields(string(out)) {
+			if pid, err := strconv.Atoi(line); err == nil {
+				list = append(list, pid)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Memory returns memory usage and limit of container in bytes, limit is 0 if there is none
+func Memory(name string) (usage, limit int, err error) {
+	d := dir(name, "memory")
+	if unified() {
+		if usage, err = read(path.Join(d, "memory.current")); err == nil {
+			limit, err = read(path.Join(d, "memory.max"))
+		}
+		return
+	}
+
+	if usage, err = read(path.Join(d, "memory.usage_in_bytes")); err != nil {
+		return
+	}
+	if limit, err = read(path.Join(d, "memory.limit_in_bytes")); err != nil {
+		return
+	}
+	// unlimited container inherits the limit of the whole hierarchy
+	if stat, err := readStat(path.Join(root, "memory", "memory.stat")); err == nil && limit == stat["hierarchical_memory_limit"] {
+		limit = 0
+	}
+	return
+}
+
+// MemoryStat returns page cache ("cache") and anonymous memory ("rss") of container in bytes
+func MemoryStat(name string) (map[string]int, error) {
+	stat, err := readStat(path.Join(dir(name, "memory"), "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	if unified() {
+		return map[string]int{"cache": stat["file"], "rss": stat["anon"]}, nil
+	}
+	return map[string]int{"cache": stat["cache"], "rss": stat["rss"]}, nil
+}
+
+// CPUQuota returns CFS bandwidth quota and period of container in microseconds, quota is -1 if there is no limit
+func CPUQuota(name string) (quota, period int, err error) {
+	d := dir(name, "cpu")
+	if unified() {
+		out, err := ioutil.ReadFile(path.Join(d, "cpu.max"))
+		if err != nil {
+			return 0, 0, err
+		}
+		f := strings.Fields(string(out))
+		if len(f) != 2 {
+			return 0, 0, errors.New("Unexpected value of cpu.max: " + string(out))
+		}
+		if period, err = strconv.Atoi(f[1]); err != nil || f[0] == "max" {
+			return -1, period, err
+		}
+		quota, err = strconv.Atoi(f[0])
+		return quota, period, err
+	}
+
+	if quota, err = read(path.Join(d, "cpu.cfs_quota_us")); err == nil {
+		period, err = read(path.Join(d, "cpu.cfs_period_us"))
+	}
+	return
+}
+
+// CPUTime returns user and system CPU time consumed by container in USER_HZ ticks (1/100 s)
+func CPUTime(name string) (user, system int, err error) {
+	if unified() {
+		stat, err := readStat(path.Join(dir(name, "cpu"), "cpu.stat"))
+		return stat["user_usec"] / 10000, stat["system_usec"] / 10000, err
+	}
+	stat, err := readStat(path.Join(dir(name, "cpuacct"), "cpuacct.stat"))
+	return stat["user"], stat["system"], err
+}
+
+// IOStat is a cumulative disk I/O of container on all devices
+type IOStat struct {
+	ReadBytes  int
+	WriteBytes int
+	ReadOps    int
+	WriteOps   int
+}
+
+// IO returns bytes and operations read and written by container
+func IO(name string) (stat IOStat, err error) {
+	if unified() {
+		out, err := ioutil.ReadFile(path.Join(dir(name, "io"), "io.stat"))
+		if err != nil {
+			return stat, err
+		}
+		// each line is a device with "key=value" counters, e.g. "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0"
+		for _, line := range strings.Split(string(out), "\n") {
+			for _, field := range strings.Fields(line) {
+				kv := strings.Split(field, "=")
+				if len(kv) != 2 {
+					continue
+				}
+				value, _ := strconv.Atoi(kv[1])
+				switch kv[0] {
+				case "rbytes":
+					stat.ReadBytes += value
+				case "wbytes":
+					stat.WriteBytes += value
+				case "rios":
+					stat.ReadOps += value
+				case "wios":
+					stat.WriteOps += value
+				}
+			}
+		}
+		return stat, nil
+	}
+
+	d := dir(name, "blkio")
+	if stat.ReadBytes, stat.WriteBytes, err = blkio(path.Join(d, "blkio.throttle.io_service_bytes")); err == nil {
+		stat.ReadOps, stat.WriteOps, err = blkio(path.Join(d, "blkio.throttle.io_serviced"))
+	}
+	return
+}
+
+// Setting is a cgroup item of container with its value in terms of host hierarchy
+type Setting struct {
+	Item  string
+	Value string
+}
+
+// Key returns LXC config key of the setting
+func (s Setting) Key() string {
+	if unified() {
+		return "lxc.cgroup2." + s.Item
+	}
+	return "lxc.cgroup." + s.Item
+}
+
+// MemoryLimit returns setting of container memory limit in bytes
+func MemoryLimit(bytes int) Setting {
+	if unified() {
+		return Setting{Item: "memory.max", Value: strconv.Itoa(bytes)}
+	}
+	return Setting{Item: "memory.limit_in_bytes", Value: strconv.Itoa(bytes)}
+}
+
+// CPULimit returns setting of container CFS bandwidth quota and period in microseconds, negative quota removes the limit
+func CPULimit(quota, period int) Setting {
+	if unified() {
+		value := "max"
+		if quota >= 0 {
+			value = strconv.Itoa(quota)
+		}
+		return Setting{Item: "cpu.max", Value: value + " " + strconv.Itoa(period)}
+	}
+	if quota < 0 {
+		quota = -1
+	}
+	return Setting{Item: "cpu.cfs_quota_us", Value: strconv.Itoa(quota)}
+}
+
+// CPUSet returns setting of cores available to container
+func CPUSet(cpus string) Setting {
+	return Setting{Item: "cpuset.cpus", Value: cpus}
+}
+
+// IOLimit returns setting of disk I/O limit of container on device "major:minor", 0 removes the limit
+func IOLimit(device, kind string, value int) Setting {
+	if unified() {
+		limit := "max"
+		if value > 0 {
+			limit = strconv.Itoa(value)
+		}
+		return Setting{Item: "io.max", Value: device + " " + kind + "=" + limit}
+	}
+	return Setting{Item: ioItems[kind], Value: device + " " + strconv.Itoa(value)}
+}
+
+// ParseIOLimit returns I/O limit of the kind from setting value, false if the value is not a limit of this kind
+func ParseIOLimit(kind, value string) (int, bool) {
+	f := strings.Fields(value)
+	if len(f) != 2 {
+		return 0, false
+	}
+	if !unified() {
+		limit, err := strconv.Atoi(f[1])
+		return limit, err == nil
+	}
+	if !strings.HasPrefix(f[1], kind+"=") {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(strings.TrimPrefix(f[1], kind+"="))
+	return limit, err == nil || f[1] == kind+"=max"
+}
+
+// read returns numeric value of cgroup item, "max" means there is no limit and is returned as 0
+func read(file string) (int, error) {
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	if value := strings.TrimSpace(string(out)); value != "max" {
+		return strconv.Atoi(value)
+	}
+	return 0, nil
+}
+
+// readStat returns values of flat keyed cgroup file, e.g. memory.stat
+func readStat(file string) (map[string]int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.Fields(scanner.Text()); len(line) == 2 {
+			if value, err := strconv.Atoi(line[1]); err == nil {
+				stat[line[0]] = value
+			}
+		}
+	}
+	return stat, scanner.Err()
+}
+
+// blkio sums "Read" and "Write" lines of legacy blkio statistics of all devices
+func blkio(file string) (read, write int, err error) {
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(line); len(f) == 3 {
+			value, err := strconv.Atoi(f[2])
+			if err != nil {
+				return 0, 0, err
+			}
+			switch f[1] {
+			case "Read":
+				read += value
+			case "Write":
+				write += value
+			}
+		}
+	}
+	return
+}
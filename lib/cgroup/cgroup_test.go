@@ -0,0 +1,71 @@
+package cgroup
+
+import "testing"
+
+func TestIOLimit(t *testing.T) {
+	defer func(m string) { mode = m }(mode)
+
+	tests := []struct {
+		mode  string
+		kind  string
+		value int
+		want  Setting
+	}{
+		{Legacy, "rbps", 1048576, Setting{Item: "blkio.throttle.read_bps_device", Value: "8:0 1048576"}},
+		{Legacy, "wiops", 0, Setting{Item: "blkio.throttle.write_iops_device", Value: "8:0 0"}},
+		{Hybrid, "wbps", 100, Setting{Item: "blkio.throttle.write_bps_device", Value: "8:0 100"}},
+		{Unified, "riops", 500, Setting{Item: "io.max", Value: "8:0 riops=500"}},
+		{Unified, "wbps", 0, Setting{Item: "io.max", Value: "8:0 wbps=max"}},
+	}
+	for _, tt := range tests {
+		mode = tt.mode
+		if got := IOLimit("8:0", tt.kind, tt.value); got != tt.want {
+			t.Errorf("%s IOLimit(%s, %d) = %+v, want %+v", tt.mode, tt.kind, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseIOLimit(t *testing.T) {
+	defer func(m string) { mode = m }(mode)
+
+	tests := []struct {
+		mode  string
+		kind  string
+		value string
+		limit int
+		ok    bool
+	}{
+		{Legacy, "rbps", "8:0 1048576", 1048576, true},
+		{Legacy, "rbps", "8:0", 0, false},
+		{Legacy, "rbps", "8:0 fast", 0, false},
+		{Unified, "rbps", "8:0 rbps=1048576", 1048576, true},
+		{Unified, "rbps", "8:0 rbps=max", 0, true},
+		{Unified, "rbps", "8:0 wbps=100", 0, false},
+		{Unified, "riops", "8:0 rbps=100", 0, false},
+		{Unified, "wiops", "8:0 wiops=", 0, false},
+		{Unified, "wiops", "", 0, false},
+	}
+	for _, tt := range tests {
+		mode = tt.mode
+		limit, ok := ParseIOLimit(tt.kind, tt.value)
+		if limit != tt.limit || ok != tt.ok {
+			t.Errorf("%s ParseIOLimit(%s, %q) = %d, %v, want %d, %v", tt.mode, tt.kind, tt.value, limit, ok, tt.limit, tt.ok)
+		}
+	}
+}
+
+func TestIOLimitRoundTrip(t *testing.T) {
+	defer func(m string) { mode = m }(mode)
+
+	for _, m := range []string{Legacy, Unified} {
+		mode = m
+		for _, kind := range IOKinds {
+			for _, value := range []int{0, 1, 1048576} {
+				limit, ok := ParseIOLimit(kind, IOLimit("253:1", kind, value).Value)
+				if !ok || limit != value {
+					t.Errorf("%s %s limit %d parsed back as %d, %v", m, kind, value, limit, ok)
+				}
+			}
+		}
+	}
+}
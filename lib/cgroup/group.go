@@ -0,0 +1,158 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parent is a group of transient control groups created for Resource Host commands
+const parent = "subutai"
+
+// Group is a transient control group created for Resource Host command to enforce its limits.
+// On legacy hierarchy group has a directory under "subutai" group of each controller it uses,
+// on unified hierarchy it is a single directory with controllers enabled for the parent group.
+type Group struct {
+	name string
+	// group directories by controller
+	dirs map[string]string
+}
+
+// NewGroup returns group with the name, its directories are created when settings are applied
+func NewGroup(name string) *Group {
+	return &Group{name: name, dirs: make(map[string]string)}
+}
+
+// Set applies setting to the group
+func (g *Group) Set(s Setting) error {
+	dir, err := g.dir(s.controller())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, s.Item), []byte(s.Value), 0644)
+}
+
+// dir creates group directory for controller
+func (g *Group) dir(controller string) (string, error) {
+	if d, ok := g.dirs[controller]; ok {
+		return d, nil
+	}
+
+	d := path.Join(root, controller, parent, g.name)
+	if unified() {
+		d = path.Join(root, parent, g.name)
+		if err := os.MkdirAll(path.Dir(d), 0755); err != nil {
+			return "", err
+		}
+		// controller must be enabled for children of every ancestor
+		for _, p := range []string{root, path.Dir(d)} {
+			if err := enable(p, controller); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return "", err
+	}
+	g.dirs[controller] = d
+	return d, nil
+}
+
+func enable(dir, controller string) error {
+	enabled, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	for _, c := range strings.Fields(string(enabled)) {
+		if c == controller {
+			return nil
+		}
+	}
+	return ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+}
+
+// Procs returns "cgroup.procs" files of the group, process joins the group by writing its pid to each of them
+func (g *Group) Procs() (list []string) {
+	seen := make(map[string]bool)
+	for _, d := range g.dirs {
+		if !seen[d] {
+			seen[d] = true
+			list = append(list, path.Join(d, "cgroup.procs"))
+		}
+	}
+	return
+}
+
+// OOMKills returns number of group processes killed by OOM killer, false if memory is not limited
+// or kernel does not count OOM kills
+func (g *Group) OOMKills() (int, bool) {
+	d, ok := g.dirs["memory"]
+	if !ok {
+		return 0, false
+	}
+	file := path.Join(d, "memory.oom_control")
+	if unified() {
+		file = path.Join(d, "memory.events")
+	}
+	stat, err := readStat(file)
+	if err != nil {
+		return 0, false
+	}
+	kills, ok := stat["oom_kill"]
+	return kills, ok
+}
+
+// MemoryPeak returns maximum memory usage of the group in bytes
+func (g *Group) MemoryPeak() (int, error) {
+	d, ok := g.dirs["memory"]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	if unified() {
+		return read(path.Join(d, "memory.peak"))
+	}
+	return read(path.Join(d, "memory.max_usage_in_bytes"))
+}
+
+// Remove deletes group directories. Group may be busy for a moment after the last process exit.
+func (g *Group) Remove() (err error) {
+	for controller, d := range g.dirs {
+		for i := 0; i < 10; i++ {
+			if err = os.Remove(d); err == nil || os.IsNotExist(err) {
+				err = nil
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			return err
+		}
+		delete(g.dirs, controller)
+	}
+	return nil
+}
+
+// controller returns name of controller owning setting item, e.g. "memory" for "memory.max"
+func (s Setting) controller() string {
+	return strings.SplitN(s.Item, ".", 2)[0]
+}
+
+// CPUBandwidth returns settings of CFS bandwidth quota and period in microseconds.
+// Unlike CPULimit, it sets period on legacy hierarchy too instead of relying on its default value.
+func CPUBandwidth(quota, period int) []Setting {
+	if unified() {
+		return []Setting{CPULimit(quota, period)}
+	}
+	return []Setting{{Item: "cpu.cfs_period_us", Value: strconv.Itoa(period)}, CPULimit(quota, period)}
+}
+
+// NoSwap returns setting which prevents group with memory limit from using swap beyond the limit
+func NoSwap(memory int) Setting {
+	if unified() {
+		return Setting{Item: "memory.swap.max", Value: "0"}
+	}
+	return Setting{Item: "memory.memsw.limit_in_bytes", Value: strconv.Itoa(memory)}
+}
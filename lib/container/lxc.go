@@ -15,6 +15,7 @@ import (
 
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/cgroup"
 	"github.com/subutai-io/agent/lib/fs"
 	"github.com/subutai-io/agent/lib/net"
 	"github.com/subutai-io/agent/log"
@@ -77,7 +78,11 @@ func LxcInstanceExists(name string) bool {
 func State(name string) (state string) {
 	if c, err := lxc.NewContainer(name, config.Agent.LxcPrefix); err == nil {
 		defer lxc.Release(c)
-		return c.State().String()
+		if state := c.State(); state != lxc.FROZEN || !throttled(name) {
+			return state.String()
+		}
+		// container paused for a moment by disk I/O throttling is running for its users
+		return lxc.RUNNING.String()
 	}
 	return "UNKNOWN"
 }
@@ -111,8 +116,7 @@ func AddMetadata(name string, meta map[string]string) error {
 	if !LxcInstanceExists(name) {
 		return errors.New("Container does not exists")
 	}
-	log.Check(log.ErrorLevel, "Writing container data to database", db.INSTANCE.ContainerAdd(name, meta))
-	return nil
+	return db.INSTANCE.ContainerAdd(name, meta)
 }
 
 // Start starts the Subutai container.
@@ -154,6 +158,54 @@ func Stop(name string, addMetadata bool) error {
 	return nil
 }
 
+// Freeze suspends all processes of the Subutai container until Unfreeze, disk I/O throttling doesn't resume it.
+func Freeze(name string) error {
+	unlock, err := lockFreezer(name)
+	if log.Check(log.DebugLevel, "Locking container freezer", err) {
+		return err
+	}
+	defer unlock()
+
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if log.Check(log.DebugLevel, "Creating container object", err) {
+		return err
+	}
+	defer lxc.Release(c)
+
+	if err = mark(name, frozenMark, true); err != nil {
+		return err
+	}
+	if c.State() == lxc.FROZEN && marked(name, throttledMark) {
+		// paused by throttling already, now it is kept frozen by the mark
+		return mark(name, throttledMark, false)
+	}
+	if err = c.Freeze(); err != nil {
+		mark(name, frozenMark, false)
+	}
+	return err
+}
+
+// Unfreeze resumes processes of the frozen Subutai container.
+func Unfreeze(name string) error {
+	unlock, err := lockFreezer(name)
+	if log.Check(log.DebugLevel, "Locking container freezer", err) {
+		return err
+	}
+	defer unlock()
+
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if log.Check(log.DebugLevel, "Creating container object", err) {
+		return err
+	}
+	defer lxc.Release(c)
+
+	if err = c.Unfreeze(); err != nil {
+		return err
+	}
+	mark(name, throttledMark, false)
+	return mark(name, frozenMark, false)
+}
+
 func Restart(name string) error {
 	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
 
@@ -236,27 +288,34 @@ func DestroyContainer(name string) error {
 
 	log.Check(log.DebugLevel, "Shutting down lxc", c.Shutdown(time.Second*120))
 
-	for i := 1; fs.RemoveDataset(name, true) != nil && i < 3; i++ {
+	err = fs.RemoveDataset(name, true)
+	for i := 1; err != nil && i < 3; i++ {
 		time.Sleep(time.Second * time.Duration(i*5))
+		err = fs.RemoveDataset(name, true)
+	}
+	if err != nil {
+		return err
 	}
-
-	log.Check(log.ErrorLevel, "Removing container", err)
 
 	log.Check(log.WarnLevel, "Deleting container metadata entry", db.INSTANCE.ContainerDel(name))
 
+	log.Check(log.WarnLevel, "Deleting container snapshot metadata", db.INSTANCE.SnapshotDel(name, ""))
+
 	return nil
 }
 
-func DestroyTemplate(name string) {
+// DestroyTemplate deletes the Subutai template and its cached metadata.
+func DestroyTemplate(name string) error {
 	if !IsTemplate(name) {
-		log.Error("Template " + name + " not found")
+		return errors.New("Template " + name + " not found")
 	}
 
-	err := fs.RemoveDataset(name, true)
-
-	log.Check(log.ErrorLevel, "Removing template", err)
+	if err := fs.RemoveDataset(name, true); err != nil {
+		return err
+	}
 
 	DeleteTemplateInfoFromCache(name)
+	return nil
 }
 
 func DeleteTemplateInfoFromCache(name string) {
@@ -286,19 +345,27 @@ func Clone(parent, child string) error {
 	parentParts := strings.Split(parent, ":")
 
 	//create parent dataset
-	fs.CreateDataset(child)
+	if err := fs.CreateDataset(child); err != nil {
+		return err
+	}
 
 	//create partitions
-	fs.CloneSnapshot(parent+"/rootfs@now", child+"/rootfs")
-	fs.CloneSnapshot(parent+"/home@now", child+"/home")
-	fs.CloneSnapshot(parent+"/var@now", child+"/var")
-	fs.CloneSnapshot(parent+"/opt@now", child+"/opt")
+	for _, partition := range Partitions {
+		if err := fs.CloneSnapshot(parent+"/"+partition+"@now", child+"/"+partition); err != nil {
+			return err
+		}
+	}
 
 	for _, file := range []string{"config", "fstab", "packages"} {
-		fs.Copy(path.Join(config.Agent.LxcPrefix, parent, file), path.Join(config.Agent.LxcPrefix, child, file))
+		if err := fs.Copy(path.Join(config.Agent.LxcPrefix, parent, file), path.Join(config.Agent.LxcPrefix, child, file)); err != nil {
+			return err
+		}
 	}
 
-	mac := Mac()
+	mac, err := Mac()
+	if err != nil {
+		return err
+	}
 	SetContainerConf(child, [][]string{
 		//{"lxc.network.script.up", "/usr/sbin/subutai-create-interface"}, //must be in template
 		{"lxc.network.hwaddr", mac},
@@ -310,7 +377,7 @@ func Clone(parent, child string) error {
 		{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "home") + " home none bind,rw 0 0"},
 		{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "opt") + " opt none bind,rw 0 0"},
 		{"lxc.mount.entry", path.Join(config.Agent.LxcPrefix, child, "var") + " var none bind,rw 0 0"},
-		{"lxc.rootfs.backend", "zfs"}, //must be in template
+		{"lxc.rootfs.backend", fs.Backend()}, //must be in template
 		{"lxc.utsname", child},
 	})
 
@@ -331,12 +398,13 @@ func QuotaRAM(name string, size ...string) int {
 	i, err := strconv.Atoi(size[0])
 	log.Check(log.DebugLevel, "Parsing quota size", err)
 	if i > 0 {
-		log.Check(log.DebugLevel, "Setting memory limit", c.SetMemoryLimit(lxc.ByteSize(i*1024*1024)))
-		SetContainerConf(name, [][]string{{"lxc.cgroup.memory.limit_in_bytes", size[0] + "M"}})
+		limit := cgroup.MemoryLimit(i * 1024 * 1024)
+		log.Check(log.DebugLevel, "Setting memory limit", c.SetCgroupItem(limit.Item, limit.Value))
+		SetContainerConf(name, [][]string{{limit.Key(), limit.Value}})
 	}
-	limit, err := c.MemoryLimit()
+	_, limit, err := cgroup.Memory(name)
 	log.Check(log.DebugLevel, "Getting memory limit of container: "+name, err)
-	return int(limit / 1024 / 1024)
+	return limit / 1024 / 1024
 }
 
 // QuotaCPU sets container CPU limitation and return current value in percents.
@@ -373,15 +441,17 @@ func QuotaCPU(name string, size ...string) int {
 	}
 
 	if size[0] != "" && State(name) == "RUNNING" {
-		value := strconv.Itoa(int(float32(cfsPeriod) * float32(runtime.NumCPU()) * quota / 100))
-		log.Check(log.DebugLevel, "Setting cpu.cfs_quota_us", c.SetCgroupItem("cpu.cfs_quota_us", value))
+		limit := cgroup.CPULimit(int(float32(cfsPeriod)*float32(runtime.NumCPU())*quota/100), cfsPeriod)
+		log.Check(log.DebugLevel, "Setting "+limit.Item, c.SetCgroupItem(limit.Item, limit.Value))
 
-		SetContainerConf(name, [][]string{{"lxc.cgroup.cpu.cfs_quota_us", value}})
+		SetContainerConf(name, [][]string{{limit.Key(), limit.Value}})
 	}
 
-	result, err := strconv.Atoi(c.CgroupItem("cpu.cfs_quota_us")[0])
-	log.Check(log.DebugLevel, "Parsing quota size", err)
-	return result * 100 / cfsPeriod / runtime.NumCPU()
+	result, period, err := cgroup.CPUQuota(name)
+	if log.Check(log.DebugLevel, "Getting cpu quota of container: "+name, err) || result < 0 {
+		return 0
+	}
+	return result * 100 / period / runtime.NumCPU()
 }
 
 // QuotaCPUset sets particular cores that can be used by the Subutai container.
@@ -392,8 +462,9 @@ func QuotaCPUset(name string, size ...string) string {
 	}
 	log.Check(log.DebugLevel, "Looking for container: "+name, err)
 	if size[0] != "" {
-		log.Check(log.DebugLevel, "Setting cpuset.cpus", c.SetCgroupItem("cpuset.cpus", size[0]))
-		SetContainerConf(name, [][]string{{"lxc.cgroup.cpuset.cpus", size[0]}})
+		cpus := cgroup.CPUSet(size[0])
+		log.Check(log.DebugLevel, "Setting cpuset.cpus", c.SetCgroupItem(cpus.Item, cpus.Value))
+		SetContainerConf(name, [][]string{{cpus.Key(), cpus.Value}})
 	}
 	return c.CgroupItem("cpuset.cpus")[0]
 }
@@ -412,6 +483,141 @@ func QuotaNet(name string, size ...string) string {
 	return net.RateLimit(nic, size[0])
 }
 
+// diskLimit returns dataset and its space limit property for container disk resource.
+// The "disk" resource is the whole container including snapshots, so its quota is "quota" of container dataset.
+// Partitions are limited by "refquota", so their snapshots don't eat the space available to the container user.
+func diskLimit(name, resource string) (dataset, property string) {
+	if resource == "disk" {
+		return name, fs.Quota
+	}
+	return path.Join(name, resource), fs.RefQuota
+}
+
+// QuotaDisk sets disk quota of the Subutai container ("disk") or its partition (rootfs, home, var, opt) in GB.
+// If quota size argument is missing, it's just return current value.
+func QuotaDisk(name, resource string, size ...string) (int, error) {
+	dataset, property := diskLimit(name, resource)
+	return diskSpace(dataset, property, size...)
+}
+
+// ReserveDisk guarantees disk space for the Subutai container ("disk") or its partition (rootfs, home, var, opt) in GB.
+// If reservation size argument is missing, it's just return current value.
+func ReserveDisk(name, resource string, size ...string) (int, error) {
+	dataset, _ := diskLimit(name, resource)
+	return diskSpace(dataset, fs.Reservation, size...)
+}
+
+// DiskLimits returns disk quotas and reservations of the Subutai container and its partitions in GB, keyed by resource
+func DiskLimits(name string) (quota, reservation map[string]int) {
+	quota, reservation = make(map[string]int), make(map[string]int)
+	for _, resource := range append([]string{"disk"}, Partitions...) {
+		dataset, property := diskLimit(name, resource)
+		if d, err := fs.GetDataset(dataset); err == nil {
+			quota[resource], reservation[resource] = d.Limit(property)/fs.GB, d.Reservation/fs.GB
+		}
+	}
+	return
+}
+
+func diskSpace(dataset, property string, size ...string) (int, error) {
+	if len(size) > 0 && len(size[0]) > 0 {
+		gb, err := strconv.Atoi(size[0])
+		if err != nil {
+			return 0, err
+		}
+		if err = fs.SetLimit(dataset, property, gb); err != nil {
+			return 0, err
+		}
+	}
+	limit, err := fs.GetLimit(dataset, property)
+	return limit / fs.GB, err
+}
+
+// QuotaIO sets disk I/O throttling of the Subutai container on all disks of the storage and returns current value.
+// Bandwidth (rbps, wbps) is set in MB/s, operations (riops, wiops) in IOPS, 0 removes the limit.
+// The limit is persisted in container config, so it is applied on every start.
+// If quota size argument is missing, it's just return current value.
+// ZFS issues disk I/O from its own kernel threads, which are not charged to container cgroup, so on zfs storage
+// the limit is kept in "subutai.io.<kind>" config item and enforced by agent, which pauses container doing I/O
+// over the limit, see Throttle.
+func QuotaIO(name, kind string, size ...string) (int, error) {
+	known := false
+	for _, k := range cgroup.IOKinds {
+		known = known || k == kind
+	}
+	if !known {
+		return 0, errors.New("Unknown I/O quota " + kind)
+	}
+	unit := 1
+	if strings.HasSuffix(kind, "bps") {
+		unit = 1024 * 1024
+	}
+	if fs.Backend() == "zfs" {
+		if len(size) > 0 && len(size[0]) > 0 {
+			value, err := strconv.Atoi(size[0])
+			if err != nil {
+				return 0, err
+			}
+			limit := ""
+			if value > 0 {
+				limit = strconv.Itoa(value * unit)
+			}
+			if err = SetContainerConf(name, [][]string{{ioLimitItem + kind, limit}}); err != nil {
+				return 0, err
+			}
+		}
+		limit, _ := strconv.Atoi(GetProperty(name, ioLimitItem+kind))
+		return limit / unit, nil
+	}
+
+	key := cgroup.IOLimit("", kind, 0).Key()
+	limit := func(value string) bool {
+		_, ok := cgroup.ParseIOLimit(kind, value)
+		return ok
+	}
+
+	if len(size) > 0 && len(size[0]) > 0 {
+		value, err := strconv.Atoi(size[0])
+		if err != nil {
+			return 0, err
+		}
+		devices, err := fs.StorageDevices()
+		if err != nil {
+			return 0, err
+		}
+
+		c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+		if err != nil {
+			return 0, err
+		}
+		defer lxc.Release(c)
+
+		var rules []string
+		running := State(name) == "RUNNING"
+		for _, dev := range devices {
+			rule := cgroup.IOLimit(dev, kind, value*unit)
+			if running {
+				if err = c.SetCgroupItem(rule.Item, rule.Value); err != nil {
+					return 0, errors.New("Setting " + rule.Item + " of running container: " + err.Error())
+				}
+			}
+			if value > 0 {
+				rules = append(rules, rule.Value)
+			}
+		}
+		if err = setContainerConfList(name, key, limit, rules); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, value := range getContainerConfList(name, key) {
+		if bytes, ok := cgroup.ParseIOLimit(kind, value); ok {
+			return bytes / unit, nil
+		}
+	}
+	return 0, nil
+}
+
 // SetContainerConf sets any parameter in the configuration file of the Subutai container.
 //TODO use the new lxc config type
 func SetContainerConf(container string, conf [][]string) error {
@@ -447,6 +653,39 @@ func SetContainerConf(container string, conf [][]string) error {
 	return ioutil.WriteFile(confPath, []byte(newconf), 0644)
 }
 
+// setContainerConfList replaces lines of config item with lines for each of the values,
+// e.g. I/O cgroup items have separate lines for each device. Only lines with values matching the filter are replaced.
+func setContainerConfList(container, item string, filter func(string) bool, values []string) error {
+	confPath := path.Join(config.Agent.LxcPrefix, container, "config")
+	out, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+
+	newconf := ""
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.Trim(kv[0], " ") != item || !filter(strings.Trim(kv[1], " ")) {
+			newconf = newconf + line + "\n"
+		}
+	}
+	for _, value := range values {
+		newconf = newconf + item + " = " + value + "\n"
+	}
+	return ioutil.WriteFile(confPath, []byte(newconf), 0644)
+}
+
+// getContainerConfList returns values of all lines of config item
+func getContainerConfList(container, item string) (values []string) {
+	out, _ := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, container, "config"))
+	for _, line := range strings.Split(string(out), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && strings.Trim(kv[0], " ") == item {
+			values = append(values, strings.Trim(kv[1], " "))
+		}
+	}
+	return
+}
+
 // GetConfigItem return any parameter from the configuration file of the Subutai container.
 func GetConfigItem(path, item string) string {
 	if cfg, err := os.Open(path); err == nil {
@@ -529,6 +768,16 @@ func SetStaticNet(name string) {
 	log.Check(log.WarnLevel, "Setting internal eth0 interface to manual", err)
 }
 
+// SetDynamicNet reverts SetStaticNet, so container without IP address gets it by DHCP.
+func SetDynamicNet(name string) {
+	data, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/network/interfaces"))
+	log.Check(log.WarnLevel, "Opening /etc/network/interfaces", err)
+
+	err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/network/interfaces"),
+		[]byte(strings.Replace(string(data), "inet manual", "inet dhcp", 1)), 0644)
+	log.Check(log.WarnLevel, "Setting internal eth0 interface to dhcp", err)
+}
+
 // DisableSSHPwd disabling SSH password access to the Subutai container.
 func DisableSSHPwd(name string) {
 	input, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, name, "/rootfs/etc/ssh/sshd_config"))
@@ -549,7 +798,7 @@ func DisableSSHPwd(name string) {
 }
 
 // Mac function generates random mac address for LXC containers
-func Mac() string {
+func Mac() (string, error) {
 
 	usedMacs := make(map[string]bool)
 	for _, cont := range Containers() {
@@ -562,17 +811,19 @@ func Mac() string {
 
 	buf := make([]byte, 6)
 
-	_, err := rand.Read(buf)
-	log.Check(log.ErrorLevel, "Generating random mac", err)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
 
 	mac := fmt.Sprintf("00:16:3e:%02x:%02x:%02x", buf[3], buf[4], buf[5])
 	for usedMacs[mac] {
 
-		_, err := rand.Read(buf)
-		log.Check(log.ErrorLevel, "Generating random mac", err)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
 
 		mac = fmt.Sprintf("00:16:3e:%02x:%02x:%02x", buf[3], buf[4], buf[5])
 	}
 
-	return mac
+	return mac, nil
 }
@@ -0,0 +1,261 @@
+package container
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/log"
+)
+
+// Partitions lists datasets which together form the Subutai container filesystem.
+var Partitions = []string{"rootfs", "home", "var", "opt"}
+
+// SnapshotExists checks if snapshot with specified label exists for the Subutai container.
+func SnapshotExists(name, label string) bool {
+	return fs.DatasetExists(name + "/rootfs@" + label)
+}
+
+// Snapshot atomically snapshots all partitions of the Subutai container and stores snapshot metadata in database.
+func Snapshot(name, label string) error {
+	if !IsContainer(name) {
+		return errors.New("Container " + name + " not found")
+	}
+	if len(label) == 0 || strings.ContainsAny(label, "@/ \t") {
+		return errors.New("Invalid snapshot label \"" + label + "\"")
+	}
+	if SnapshotExists(name, label) {
+		return errors.New("Snapshot " + name + "@" + label + " already exists")
+	}
+
+	if err := fs.CreateSnapshotRecursive(name, label); err != nil {
+		return err
+	}
+
+	size := 0
+	for _, partition := range Partitions {
+		referenced, err := fs.GetSizeProperty(name+"/"+partition+"@"+label, "referenced")
+		if !log.Check(log.DebugLevel, "Getting size of snapshot "+name+"/"+partition+"@"+label, err) {
+			size += referenced
+		}
+	}
+
+	return db.INSTANCE.SnapshotAdd(name, label, map[string]string{
+		"created": time.Now().Format(time.RFC3339),
+		"size":    strconv.Itoa(size),
+	})
+}
+
+// Snapshots returns all snapshots of the Subutai container ordered by creation time.
+// Snapshots created outside of "snapshot" command (e.g. "now" snapshot made by export) are returned without metadata.
+func Snapshots(name string) ([]map[string]string, error) {
+	labels, err := fs.ListSnapshots(name + "/rootfs")
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := db.INSTANCE.SnapshotList(name)
+	log.Check(log.WarnLevel, "Reading snapshot metadata from db", err)
+
+	var list []map[string]string
+	for _, label := range labels {
+		item := map[string]string{"label": label}
+		for _, m := range meta {
+			if m["label"] == label {
+				item = m
+				break
+			}
+		}
+
+		used := 0
+		for _, partition := range Partitions {
+			if u, err := fs.GetSizeProperty(name+"/"+partition+"@"+label, "used"); err == nil {
+				used += u
+			}
+		}
+		item["used"] = strconv.Itoa(used)
+
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// RollbackSnapshot reverts all partitions of stopped Subutai container to the state saved in snapshot.
+// Snapshots taken after the specified one are removed, including ones of container dataset itself,
+// which is not rolled back to keep container config. Every partition is checked before any of them is touched,
+// so the container is not left with partitions from different snapshots.
+func RollbackSnapshot(name, label string) error {
+	if !IsContainer(name) {
+		return errors.New("Container " + name + " not found")
+	}
+	if !SnapshotExists(name, label) {
+		return errors.New("Snapshot " + name + "@" + label + " not found")
+	}
+	if state := State(name); state != "STOPPED" {
+		return errors.New("Container " + name + " is " + state + ", please stop it before rollback")
+	}
+
+	later := make(map[string]bool)
+	for _, partition := range Partitions {
+		labels, err := fs.ListSnapshots(name + "/" + partition)
+		if err != nil {
+			return err
+		}
+		i := 0
+		for i < len(labels) && labels[i] != label {
+			i++
+		}
+		if i == len(labels) {
+			return errors.New("Snapshot " + name + "/" + partition + "@" + label + " not found")
+		}
+		for _, l := range labels[i+1:] {
+			clones, err := fs.SnapshotClones(name + "/" + partition + "@" + l)
+			if err != nil {
+				return err
+			}
+			if len(clones) > 0 {
+				return errors.New("Snapshot " + name + "@" + l + " taken after " + label + " has dependent datasets: " +
+					strings.Join(clones, ", "))
+			}
+			later[l] = true
+		}
+	}
+
+	for _, partition := range Partitions {
+		if err := fs.RollbackSnapshot(name + "/" + partition + "@" + label); err != nil {
+			return err
+		}
+	}
+	// otherwise the labels could not be used again, since recursive snapshot is taken of container dataset too
+	for l := range later {
+		if fs.DatasetExists(name + "@" + l) {
+			if err := fs.RemoveDataset(name+"@"+l, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	syncSnapshotMetadata(name)
+
+	return nil
+}
+
+// RemoveSnapshot destroys snapshot of all partitions of the Subutai container.
+// Snapshots which have dependent clones (e.g. "now" snapshot of template) are never removed.
+func RemoveSnapshot(name, label string) error {
+	if !SnapshotExists(name, label) {
+		return errors.New("Snapshot " + name + "@" + label + " not found")
+	}
+	if label == "now" && IsTemplate(name) {
+		return errors.New("Snapshot " + name + "@now is used for cloning containers from template")
+	}
+
+	for _, partition := range Partitions {
+		clones, err := fs.SnapshotClones(name + "/" + partition + "@" + label)
+		if err != nil {
+			return err
+		}
+		if len(clones) > 0 {
+			return errors.New("Snapshot " + name + "@" + label + " has dependent datasets: " + strings.Join(clones, ", "))
+		}
+	}
+
+	if err := fs.RemoveDataset(name+"@"+label, true); err != nil {
+		return err
+	}
+
+	return db.INSTANCE.SnapshotDel(name, label)
+}
+
+// syncSnapshotMetadata removes metadata of snapshots which do not exist anymore
+func syncSnapshotMetadata(name string) {
+	meta, err := db.INSTANCE.SnapshotList(name)
+	if log.Check(log.WarnLevel, "Reading snapshot metadata from db", err) {
+		return
+	}
+	for _, m := range meta {
+		if !SnapshotExists(name, m["label"]) {
+			log.Check(log.WarnLevel, "Removing snapshot metadata "+name+"@"+m["label"], db.INSTANCE.SnapshotDel(name, m["label"]))
+		}
+	}
+}
+
+// SnapshotIntervals lists supported snapshot schedule classes and their periods.
+var SnapshotIntervals = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// ParseSnapshotPolicy parses snapshot schedule in form "class:keep[,class:keep]", e.g. "hourly:24,daily:7".
+// Empty string or "none" means no schedule.
+func ParseSnapshotPolicy(spec string) (map[string]int, error) {
+	policy := make(map[string]int)
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return policy, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid snapshot schedule \"" + item + "\", expected class:keep")
+		}
+		if _, ok := SnapshotIntervals[parts[0]]; !ok {
+			return nil, errors.New("Unknown snapshot schedule class \"" + parts[0] + "\"")
+		}
+		keep, err := strconv.Atoi(parts[1])
+		if err != nil || keep < 1 {
+			return nil, errors.New("Invalid snapshot retention \"" + parts[1] + "\"")
+		}
+		policy[parts[0]] = keep
+	}
+	return policy, nil
+}
+
+// SnapshotPolicy returns snapshot schedule of the Subutai container stored in database.
+func SnapshotPolicy(name string) string {
+	meta, err := db.INSTANCE.ContainerByName(name)
+	log.Check(log.DebugLevel, "Reading container metadata from db", err)
+	return meta["snapshot.policy"]
+}
+
+// SetSnapshotPolicy validates and stores snapshot schedule of the Subutai container in database.
+func SetSnapshotPolicy(name, spec string) error {
+	if _, err := ParseSnapshotPolicy(spec); err != nil {
+		return err
+	}
+	if spec == "none" {
+		spec = ""
+	}
+	return AddMetadata(name, map[string]string{"snapshot.policy": strings.TrimSpace(spec)})
+}
+
+// AutoSnapshotLabel returns label of scheduled snapshot of specified class taken at time t, e.g. "auto-hourly-20180102-150405".
+func AutoSnapshotLabel(class string, t time.Time) string {
+	return "auto-" + class + "-" + t.UTC().Format("20060102-150405")
+}
+
+// AutoSnapshots returns labels and creation times of scheduled snapshots of specified class ordered from oldest to newest.
+func AutoSnapshots(name, class string) (labels []string, created []time.Time, err error) {
+	all, err := fs.ListSnapshots(name + "/rootfs")
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := "auto-" + class + "-"
+	for _, label := range all {
+		if !strings.HasPrefix(label, prefix) {
+			continue
+		}
+		t, err := time.Parse("20060102-150405", strings.TrimPrefix(label, prefix))
+		if err != nil {
+			continue
+		}
+		labels = append(labels, label)
+		created = append(created, t)
+	}
+	return labels, created, nil
+}
@@ -0,0 +1,124 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/lib/fs"
+)
+
+// fakeStorage replaces storage with fake one holding container "foo", template "debian" and container "bar"
+// cloned from "cloned" snapshot of "foo", so snapshot checks are done without touching real datasets
+func fakeStorage(t *testing.T) *fs.Fake {
+	f := fs.NewFake()
+	fs.SetDriver(f)
+	for _, name := range []string{"foo", "debian"} {
+		if err := f.Create(name); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range Partitions {
+			if err := f.Create(name + "/" + p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Snapshot(name, "now", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SetReadOnly("debian/rootfs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Snapshot("foo", "cloned", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Clone("foo/home@cloned", "bar/home"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSnapshotChecks(t *testing.T) {
+	fakeStorage(t)
+
+	tests := []struct {
+		op    string
+		apply func() error
+		err   string
+	}{
+		{"snapshot of missing container", func() error { return Snapshot("baz", "a") }, "not found"},
+		{"snapshot of template", func() error { return Snapshot("debian", "a") }, "not found"},
+		{"empty label", func() error { return Snapshot("foo", "") }, "Invalid snapshot label"},
+		{"label with slash", func() error { return Snapshot("foo", "a/b") }, "Invalid snapshot label"},
+		{"label with at", func() error { return Snapshot("foo", "a@b") }, "Invalid snapshot label"},
+		{"label with space", func() error { return Snapshot("foo", "a b") }, "Invalid snapshot label"},
+		{"existing label", func() error { return Snapshot("foo", "now") }, "already exists"},
+		{"rollback of missing container", func() error { return RollbackSnapshot("baz", "now") }, "not found"},
+		{"rollback to missing snapshot", func() error { return RollbackSnapshot("foo", "a") }, "not found"},
+		{"remove missing snapshot", func() error { return RemoveSnapshot("foo", "a") }, "not found"},
+		{"remove snapshot of template", func() error { return RemoveSnapshot("debian", "now") }, "used for cloning"},
+		// only one partition has a clone, it is enough to keep the snapshot
+		{"remove snapshot with clones", func() error { return RemoveSnapshot("foo", "cloned") }, "bar/home"},
+	}
+	for _, tt := range tests {
+		if err := tt.apply(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.op, err, tt.err)
+		}
+	}
+	if !SnapshotExists("foo", "cloned") {
+		t.Error("snapshot with clones is removed")
+	}
+}
+
+func TestParseSnapshotPolicy(t *testing.T) {
+	tests := []struct {
+		spec string
+		want map[string]int
+		err  bool
+	}{
+		{"", map[string]int{}, false},
+		{"none", map[string]int{}, false},
+		{"hourly:24", map[string]int{"hourly": 24}, false},
+		{"hourly:24, daily:7,monthly:1", map[string]int{"hourly": 24, "daily": 7, "monthly": 1}, false},
+		{"yearly:1", nil, true},
+		{"daily", nil, true},
+		{"daily:0", nil, true},
+		{"daily:x", nil, true},
+		{"daily:1:2", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSnapshotPolicy(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseSnapshotPolicy(%q): unexpected error %v", tt.spec, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSnapshotPolicy(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestAutoSnapshots(t *testing.T) {
+	f := fakeStorage(t)
+	first := time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC)
+	second := first.Add(time.Hour)
+	for _, label := range []string{AutoSnapshotLabel("hourly", first), "manual", AutoSnapshotLabel("daily", first),
+		AutoSnapshotLabel("hourly", second), "auto-hourly-broken"} {
+		if err := f.Snapshot("foo", label, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	labels, created, err := AutoSnapshots("foo", "hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// snapshots of other classes and labels which only look like scheduled ones are skipped
+	if want := []string{"auto-hourly-20180102-150405", "auto-hourly-20180102-160405"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+	if want := []time.Time{first, second}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created = %v, want %v", created, want)
+	}
+}
@@ -0,0 +1,140 @@
+package container
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/cgroup"
+	"github.com/subutai-io/agent/lib/fs"
+
+	"gopkg.in/lxc/go-lxc.v2"
+)
+
+// Freezer of container is shared by Freeze, e.g. during migration, and disk I/O throttling on zfs storage,
+// which pauses container for short periods. Marks in container directory tell who has frozen the container,
+// so throttling never resumes a container frozen by Freeze and a container paused by agent which was restarted
+// is resumed on the next start.
+const (
+	frozenMark    = ".frozen"
+	throttledMark = ".throttled"
+)
+
+// ioLimitItem is container config item of disk I/O limit on zfs storage in bytes or operations per second,
+// e.g. "subutai.io.wbps = 10485760"
+const ioLimitItem = "subutai.io."
+
+// lockFreezer takes exclusive lock of container freezer, which is held across processes, e.g. CLI and agent daemon
+func lockFreezer(name string) (unlock func(), err error) {
+	lock, err := os.OpenFile(path.Join(config.Agent.LxcPrefix, name, ".freezer"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return func() { lock.Close() }, nil
+}
+
+func marked(name, mark string) bool {
+	_, err := os.Stat(path.Join(config.Agent.LxcPrefix, name, mark))
+	return err == nil
+}
+
+func mark(name, mark string, set bool) error {
+	file := path.Join(config.Agent.LxcPrefix, name, mark)
+	if set {
+		f, err := os.Create(file)
+		if err == nil {
+			f.Close()
+		}
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// throttled checks if container is paused to keep its disk I/O within limits
+func throttled(name string) bool {
+	return marked(name, throttledMark) && !marked(name, frozenMark)
+}
+
+// Throttle pauses or resumes processes of running container to keep its disk I/O within limits.
+// Containers frozen by Freeze are neither paused nor resumed.
+func Throttle(name string, pause bool) error {
+	unlock, err := lockFreezer(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if marked(name, frozenMark) || pause == marked(name, throttledMark) {
+		return nil
+	}
+
+	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
+	if err != nil {
+		return err
+	}
+	defer lxc.Release(c)
+
+	if !pause {
+		if c.State() == lxc.FROZEN {
+			err = c.Unfreeze()
+		}
+		if err != nil {
+			return err
+		}
+		return mark(name, throttledMark, false)
+	}
+	if c.State() != lxc.RUNNING {
+		return nil
+	}
+	if err = mark(name, throttledMark, true); err != nil {
+		return err
+	}
+	if err = c.Freeze(); err != nil {
+		mark(name, throttledMark, false)
+	}
+	return err
+}
+
+// IOLimits returns disk I/O limits of container on zfs storage by kind, in bytes or operations per second
+func IOLimits(name string) map[string]int {
+	limits := make(map[string]int)
+	for _, kind := range cgroup.IOKinds {
+		if limit, _ := strconv.Atoi(GetProperty(name, ioLimitItem+kind)); limit > 0 {
+			limits[kind] = limit
+		}
+	}
+	return limits
+}
+
+// IO returns bytes and operations read and written by container. ZFS issues disk I/O from its own kernel threads,
+// so on zfs storage it is a sum of I/O of container datasets instead of container cgroup statistics.
+func IO(name string) (cgroup.IOStat, error) {
+	if fs.Backend() != "zfs" {
+		return cgroup.IO(name)
+	}
+	stats, err := fs.DatasetsIO()
+	return SumIO(stats, name), err
+}
+
+// SumIO returns I/O of container from statistics of all datasets
+func SumIO(stats map[string]fs.IOStat, name string) (stat cgroup.IOStat) {
+	for dataset, s := range stats {
+		if strings.HasPrefix(dataset, name+"/") {
+			stat.ReadBytes += s.ReadBytes
+			stat.WriteBytes += s.WriteBytes
+			stat.ReadOps += s.ReadOps
+			stat.WriteOps += s.WriteOps
+		}
+	}
+	return
+}
@@ -0,0 +1,381 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/lib/exec"
+)
+
+// dir stores containers as plain directories in LXC directory, so the agent runs on hosts without ZFS.
+// Snapshots and clones are full copies (reflinks where filesystem supports them), incremental streams are sent in full.
+// Datasets, their read-only flags and snapshots are tracked in the state directory:
+//	<state>/datasets/<dataset>/             dataset marker, ".readonly" file marks read-only dataset
+//	<state>/snapshots/<dataset>/@<label>/   snapshot content
+// Streams are tar archives with single "@<label>" directory. Quotas and reservations are not supported.
+type dir struct {
+	root  string
+	state string
+}
+
+func (d *dir) Backend() string {
+	return "dir"
+}
+
+func (d *dir) data(dataset string) string {
+	return path.Join(d.root, dataset)
+}
+
+func (d *dir) marker(dataset string) string {
+	return path.Join(d.state, "datasets", dataset)
+}
+
+func (d *dir) snapshot(snapshot string) string {
+	dataset, label := splitSnapshot(snapshot)
+	return path.Join(d.state, "snapshots", dataset, "@"+label)
+}
+
+// children returns names of child datasets
+func (d *dir) children(dataset string) (list []string) {
+	entries, _ := ioutil.ReadDir(d.marker(dataset))
+	for _, e := range entries {
+		if e.IsDir() {
+			list = append(list, path.Join(dataset, e.Name()))
+		}
+	}
+	return
+}
+
+// copy copies content of directory excluding entries with names in skip list
+func (d *dir) copy(src, dst string, skip ...string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if contains(skip, e.Name()) {
+			continue
+		}
+		if out, err := exec.Execute("cp", "-a", "--reflink=auto", path.Join(src, e.Name()), dst+"/"); err != nil {
+			return errors.New("Copying " + path.Join(src, e.Name()) + " " + out)
+		}
+	}
+	return nil
+}
+
+// clear removes content of directory excluding entries with names in skip list
+func (d *dir) clear(dir string, skip ...string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !contains(skip, e.Name()) {
+			if err := os.RemoveAll(path.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// names returns base names of child datasets, they are excluded from snapshots of parent dataset
+func (d *dir) names(dataset string) (list []string) {
+	for _, c := range d.children(dataset) {
+		list = append(list, path.Base(c))
+	}
+	return
+}
+
+func (d *dir) Create(dataset string) error {
+	if d.Exists(dataset) {
+		return errors.New("Dataset " + dataset + " already exists")
+	}
+	if err := os.MkdirAll(d.data(dataset), 0755); err != nil {
+		return err
+	}
+	return os.MkdirAll(d.marker(dataset), 0755)
+}
+
+func (d *dir) Clone(snapshot, dataset string) error {
+	if !d.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	if err := d.Create(dataset); err != nil {
+		return err
+	}
+	return d.copy(d.snapshot(snapshot), d.data(dataset))
+}
+
+func (d *dir) Exists(name string) bool {
+	if strings.Contains(name, "@") {
+		return FileExists(d.snapshot(name))
+	}
+	return FileExists(d.marker(name))
+}
+
+func (d *dir) Remove(name string, recursive bool) error {
+	if !d.Exists(name) {
+		return errors.New("Dataset " + name + " not found")
+	}
+	if dataset, label := splitSnapshot(name); len(label) > 0 {
+		if recursive {
+			for _, c := range d.children(dataset) {
+				if d.Exists(c + "@" + label) {
+					if err := d.Remove(c+"@"+label, true); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return os.RemoveAll(d.snapshot(name))
+	}
+
+	if children := d.children(name); len(children) > 0 && !recursive {
+		return errors.New("Dataset " + name + " has children")
+	}
+	if labels, _ := d.Snapshots(name); len(labels) > 0 && !recursive {
+		return errors.New("Dataset " + name + " has snapshots")
+	}
+	for _, p := range []string{d.data(name), d.marker(name), path.Join(d.state, "snapshots", name)} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dir) Snapshot(dataset, label string, recursive bool) error {
+	if !d.Exists(dataset) {
+		return errors.New("Dataset " + dataset + " not found")
+	} else if d.Exists(dataset + "@" + label) {
+		return errors.New("Snapshot " + dataset + "@" + label + " already exists")
+	}
+	if err := d.copy(d.data(dataset), d.snapshot(dataset+"@"+label), d.names(dataset)...); err != nil {
+		os.RemoveAll(d.snapshot(dataset + "@" + label))
+		return err
+	}
+	touch(d.snapshot(dataset + "@" + label))
+	if recursive {
+		for _, c := range d.children(dataset) {
+			if err := d.Snapshot(c, label, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *dir) Rollback(snapshot string) error {
+	if !d.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	dataset, label := splitSnapshot(snapshot)
+	labels, err := d.Snapshots(dataset)
+	if err != nil {
+		return err
+	}
+	for i := len(labels) - 1; i >= 0 && labels[i] != label; i-- {
+		if err := os.RemoveAll(d.snapshot(dataset + "@" + labels[i])); err != nil {
+			return err
+		}
+	}
+	if err := d.clear(d.data(dataset), d.names(dataset)...); err != nil {
+		return err
+	}
+	return d.copy(d.snapshot(snapshot), d.data(dataset))
+}
+
+func (d *dir) Snapshots(dataset string) ([]string, error) {
+	entries, err := ioutil.ReadDir(path.Join(d.state, "snapshots", dataset))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// modification time of snapshot directory is its creation time
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+
+	var labels []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "@") {
+			labels = append(labels, strings.TrimPrefix(e.Name(), "@"))
+		}
+	}
+	return labels, nil
+}
+
+func (d *dir) Clones(snapshot string) ([]string, error) {
+	// clones are independent copies
+	return nil, nil
+}
+
+func (d *dir) Send(from, snapshot, file string) error {
+	if !d.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	_, label := splitSnapshot(snapshot)
+	src := d.snapshot(snapshot)
+	out, err := exec.Execute("tar", "-cpf", file, "-C", path.Dir(src), "@"+label)
+	if err != nil {
+		return errors.New("Sending " + snapshot + " > " + file + " " + out)
+	}
+	return nil
+}
+
+func (d *dir) Receive(dataset, file string, force bool) error {
+	if err := os.MkdirAll(d.state, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(d.state, "receive-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if out, err := exec.Execute("tar", "-xpf", file, "-C", tmp); err != nil {
+		return errors.New("Receiving " + file + " " + out)
+	}
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "@") {
+		return errors.New("Invalid stream " + file)
+	}
+	label := strings.TrimPrefix(entries[0].Name(), "@")
+
+	if d.Exists(dataset) {
+		if !force {
+			return errors.New("Dataset " + dataset + " already exists")
+		}
+		if err := d.clear(d.data(dataset), d.names(dataset)...); err != nil {
+			return err
+		}
+		os.RemoveAll(d.snapshot(dataset + "@" + label))
+	} else if err := d.Create(dataset); err != nil {
+		return err
+	}
+
+	snapshot := d.snapshot(dataset + "@" + label)
+	if err := os.MkdirAll(path.Dir(snapshot), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path.Join(tmp, entries[0].Name()), snapshot); err != nil {
+		return err
+	}
+	touch(snapshot)
+	return d.copy(snapshot, d.data(dataset))
+}
+
+func (d *dir) SetLimit(dataset, property string, bytes int) error {
+	return ErrNotSupported
+}
+
+func (d *dir) Usage(dataset string) (int, error) {
+	return du(d.data(dataset))
+}
+
+func (d *dir) Size(name, property string) (int, error) {
+	// snapshot is a full copy, so its used and referenced sizes are the same
+	if strings.Contains(name, "@") {
+		return du(d.snapshot(name))
+	}
+	return du(d.data(name))
+}
+
+func (d *dir) ReadOnly(dataset string) bool {
+	return FileExists(path.Join(d.marker(dataset), ".readonly"))
+}
+
+func (d *dir) SetReadOnly(dataset string) error {
+	if !d.Exists(dataset) {
+		return errors.New("Dataset " + dataset + " not found")
+	}
+	return ioutil.WriteFile(path.Join(d.marker(dataset), ".readonly"), nil, 0644)
+}
+
+func (d *dir) Space() (used, available int, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(d.root, &st); err != nil {
+		return
+	}
+	used = int(st.Blocks-st.Bfree) * int(st.Bsize)
+	available = int(st.Bavail) * int(st.Bsize)
+	return
+}
+
+// Devices returns disk of filesystem holding LXC directory, virtual filesystems have no disk
+func (d *dir) Devices() ([]string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(d.root, &st); err != nil {
+		return nil, err
+	}
+	if major(st.Dev) == 0 {
+		return nil, errors.New("LXC directory " + d.root + " is not on a block device")
+	}
+	return []string{disk(st.Dev)}, nil
+}
+
+// IO is not accounted per directory, processes writing to dir storage are charged for disk I/O in their cgroups
+func (d *dir) IO() (map[string]IOStat, error) {
+	return nil, errors.New("I/O is not accounted per dataset on dir storage")
+}
+
+// List returns all datasets, used space is counted for each of them, so it is slow for large trees
+func (d *dir) List() (map[string]Dataset, error) {
+	_, available, err := d.Space()
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string]Dataset)
+	var walk func(dataset string) error
+	walk = func(dataset string) error {
+		for _, c := range d.children(dataset) {
+			used, err := du(d.data(c))
+			if err != nil {
+				return err
+			}
+			list[c] = Dataset{Name: c, Used: used, Referenced: used, Available: available, ReadOnly: d.ReadOnly(c)}
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return list, walk("")
+}
+
+// touch sets modification time of snapshot directory to current time, it orders snapshots by creation
+func touch(dir string) {
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+}
+
+// du returns size of directory in bytes
+func du(dir string) (int, error) {
+	out, err := exec.Execute("du", "-sb", dir)
+	if err != nil {
+		return -1, errors.New("Getting size of " + dir + " " + out)
+	}
+	if fields := strings.Fields(out); len(fields) > 0 {
+		return strconv.Atoi(fields[0])
+	}
+	return -1, errors.New("Failed to parse size of " + dir + " from " + out)
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package fs
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Fake is in-memory storage driver for unit tests, it keeps dataset names, snapshots, quotas and flags only.
+// Streams written by Send contain snapshot label, so Receive creates snapshot with the same label.
+// Usage and I/O of datasets may be set in Used and Stats maps.
+type Fake struct {
+	sync.Mutex
+	Datasets  map[string]bool
+	Snaps     map[string][]string
+	Origins   map[string]string
+	Limits    map[string]map[string]int
+	Used      map[string]int
+	Readonly  map[string]bool
+	Available int
+	Disks     []string
+	Stats     map[string]IOStat
+}
+
+// NewFake returns empty fake storage driver
+func NewFake() *Fake {
+	return &Fake{
+		Datasets: map[string]bool{},
+		Snaps:    map[string][]string{},
+		Origins:  map[string]string{},
+		Limits:   map[string]map[string]int{},
+		Used:     map[string]int{},
+		Readonly: map[string]bool{},
+	}
+}
+
+func (f *Fake) Backend() string {
+	return "dir"
+}
+
+func (f *Fake) Create(dataset string) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.Datasets[dataset] {
+		return errors.New("Dataset " + dataset + " already exists")
+	}
+	f.Datasets[dataset] = true
+	return nil
+}
+
+func (f *Fake) Clone(snapshot, dataset string) error {
+	if !f.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	if err := f.Create(dataset); err != nil {
+		return err
+	}
+	f.Lock()
+	f.Origins[dataset] = snapshot
+	f.Unlock()
+	return nil
+}
+
+func (f *Fake) Exists(name string) bool {
+	f.Lock()
+	defer f.Unlock()
+	if dataset, label := splitSnapshot(name); len(label) > 0 {
+		return contains(f.Snaps[dataset], label)
+	}
+	return f.Datasets[name]
+}
+
+func (f *Fake) Remove(name string, recursive bool) error {
+	if !f.Exists(name) {
+		return errors.New("Dataset " + name + " not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	dataset, label := splitSnapshot(name)
+	var affected []string
+	for ds := range f.Datasets {
+		if ds == dataset {
+			affected = append(affected, ds)
+		} else if strings.HasPrefix(ds, dataset+"/") {
+			if !recursive && len(label) == 0 {
+				return errors.New("Dataset " + dataset + " has children")
+			} else if recursive {
+				affected = append(affected, ds)
+			}
+		}
+	}
+	for _, ds := range affected {
+		if len(label) > 0 {
+			f.Snaps[ds] = remove(f.Snaps[ds], label)
+			continue
+		}
+		delete(f.Datasets, ds)
+		delete(f.Snaps, ds)
+		delete(f.Origins, ds)
+		delete(f.Limits, ds)
+		delete(f.Readonly, ds)
+	}
+	return nil
+}
+
+func (f *Fake) Snapshot(dataset, label string, recursive bool) error {
+	if f.Exists(dataset + "@" + label) {
+		return errors.New("Snapshot " + dataset + "@" + label + " already exists")
+	}
+	f.Lock()
+	defer f.Unlock()
+	if !f.Datasets[dataset] {
+		return errors.New("Dataset " + dataset + " not found")
+	}
+	for ds := range f.Datasets {
+		if ds == dataset || (recursive && strings.HasPrefix(ds, dataset+"/")) {
+			f.Snaps[ds] = append(f.Snaps[ds], label)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Rollback(snapshot string) error {
+	if !f.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+	dataset, label := splitSnapshot(snapshot)
+	labels := f.Snaps[dataset]
+	for i, l := range labels {
+		if l == label {
+			f.Snaps[dataset] = labels[:i+1]
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Snapshots(dataset string) ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if !f.Datasets[dataset] {
+		return nil, errors.New("Dataset " + dataset + " not found")
+	}
+	return append([]string{}, f.Snaps[dataset]...), nil
+}
+
+func (f *Fake) Clones(snapshot string) (list []string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	for ds, origin := range f.Origins {
+		if origin == snapshot {
+			list = append(list, ds)
+		}
+	}
+	return
+}
+
+func (f *Fake) Send(from, snapshot, file string) error {
+	if !f.Exists(snapshot) {
+		return errors.New("Snapshot " + snapshot + " not found")
+	}
+	_, label := splitSnapshot(snapshot)
+	return ioutil.WriteFile(file, []byte(label), 0644)
+}
+
+func (f *Fake) Receive(dataset, file string, force bool) error {
+	label, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if f.Exists(dataset) && !force {
+		return errors.New("Dataset " + dataset + " already exists")
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.Datasets[dataset] = true
+	if !contains(f.Snaps[dataset], string(label)) {
+		f.Snaps[dataset] = append(f.Snaps[dataset], string(label))
+	}
+	return nil
+}
+
+func (f *Fake) SetLimit(dataset, property string, bytes int) error {
+	if !f.Exists(dataset) {
+		return errors.New("Dataset " + dataset + " not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.Limits[dataset] == nil {
+		f.Limits[dataset] = map[string]int{}
+	}
+	f.Limits[dataset][property] = bytes
+	return nil
+}
+
+func (f *Fake) Usage(dataset string) (int, error) {
+	return f.Size(dataset, "used")
+}
+
+func (f *Fake) Size(name, property string) (int, error) {
+	if !f.Exists(name) {
+		return -1, errors.New("Dataset " + name + " not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+	return f.Used[name], nil
+}
+
+func (f *Fake) SetReadOnly(dataset string) error {
+	if !f.Exists(dataset) {
+		return errors.New("Dataset " + dataset + " not found")
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.Readonly[dataset] = true
+	return nil
+}
+
+func (f *Fake) ReadOnly(dataset string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.Readonly[strings.TrimSuffix(dataset, "/")]
+}
+
+func (f *Fake) Space() (used, available int, err error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, u := range f.Used {
+		used += u
+	}
+	return used, f.Available, nil
+}
+
+func (f *Fake) List() (map[string]Dataset, error) {
+	f.Lock()
+	defer f.Unlock()
+	list := make(map[string]Dataset)
+	for ds := range f.Datasets {
+		list[ds] = Dataset{Name: ds, Used: f.Used[ds], Referenced: f.Used[ds], Available: f.Available,
+			Quota: f.Limits[ds][Quota], RefQuota: f.Limits[ds][RefQuota], Reservation: f.Limits[ds][Reservation],
+			ReadOnly: f.Readonly[ds], Origin: f.Origins[ds]}
+	}
+	return list, nil
+}
+
+func (f *Fake) Devices() ([]string, error) {
+	return f.Disks, nil
+}
+
+func (f *Fake) IO() (map[string]IOStat, error) {
+	f.Lock()
+	defer f.Unlock()
+	stats := make(map[string]IOStat)
+	for ds, stat := range f.Stats {
+		stats[ds] = stat
+	}
+	return stats, nil
+}
+
+func remove(list []string, item string) (result []string) {
+	for _, v := range list {
+		if v != item {
+			result = append(result, v)
+		}
+	}
+	return
+}
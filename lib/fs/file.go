@@ -13,25 +13,39 @@ import (
 )
 
 // Copy creates a copy of passed "source" file to "dest" file
-func Copy(source string, dest string) {
+func Copy(source string, dest string) error {
 	sf, err := os.Open(source)
-	log.Check(log.FatalLevel, "Opening file "+source, err)
+	if err != nil {
+		return err
+	}
 	defer sf.Close()
 
 	df, err := os.Create(dest)
-	log.Check(log.FatalLevel, "Creating file "+dest, err)
+	if err != nil {
+		return err
+	}
 	defer df.Close()
 
 	_, err = io.Copy(df, sf)
-	log.Check(log.FatalLevel, "Copying file "+source+" to "+dest, err)
+	return err
 }
 
 // Tar function creates archive file of specified folder
 func Tar(folder, file string) {
+	log.Check(log.FatalLevel, "Packing file "+folder, Pack(folder, file))
+}
+
+// Pack saves contents of folder to tar.gz archive
+func Pack(folder, file string) error {
 	archive := new(archivex.TarFile)
-	archive.Create(file)
-	log.Check(log.FatalLevel, "Packing file "+folder, archive.AddAll(folder, false))
-	archive.Close()
+	if err := archive.Create(file); err != nil {
+		return err
+	}
+	if err := archive.AddAll(folder, false); err != nil {
+		archive.Close()
+		return err
+	}
+	return archive.Close()
 }
 
 func FileExists(name string) bool {
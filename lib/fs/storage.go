@@ -0,0 +1,417 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// Driver is a storage backend of containers and templates.
+// Datasets are named relative to the root of the storage, e.g. "foo/rootfs", snapshots are named "foo/rootfs@label".
+// Each container and template is a dataset with rootfs, home, var and opt child datasets.
+type Driver interface {
+	// Backend returns value of lxc.rootfs.backend for containers on this storage
+	Backend() string
+	// Create creates empty dataset
+	Create(dataset string) error
+	// Clone creates dataset from snapshot
+	Clone(snapshot, dataset string) error
+	// Exists checks if dataset or snapshot exists
+	Exists(name string) bool
+	// Remove removes dataset or snapshot, recursive removes all children and snapshots
+	Remove(name string, recursive bool) error
+	// Snapshot creates snapshot of dataset, recursive takes snapshots of all children atomically
+	Snapshot(dataset, label string, recursive bool) error
+	// Rollback restores dataset from snapshot, removing all snapshots taken after it
+	Rollback(snapshot string) error
+	// Snapshots returns labels of dataset snapshots ordered by creation time
+	Snapshots(dataset string) ([]string, error)
+	// Clones returns datasets depending on snapshot
+	Clones(snapshot string) ([]string, error)
+	// Send saves snapshot to stream file, incremental from snapshot "from" if it is not empty
+	Send(from, snapshot, file string) error
+	// Receive restores dataset and its snapshot from stream file, force rolls back existing dataset
+	Receive(dataset, file string, force bool) error
+	// SetLimit sets Quota, RefQuota or Reservation of dataset in bytes, 0 removes the limit
+	SetLimit(dataset, property string, bytes int) error
+	// Usage returns space used by dataset in bytes
+	Usage(dataset string) (int, error)
+	// Size returns "used" or "referenced" space of dataset or snapshot in bytes
+	Size(name, property string) (int, error)
+	// SetReadOnly makes dataset read-only
+	SetReadOnly(dataset string) error
+	// ReadOnly checks if dataset is read-only
+	ReadOnly(dataset string) bool
+	// Space returns used and available space of the storage in bytes
+	Space() (used, available int, err error)
+	// List returns properties of all datasets by names
+	List() (map[string]Dataset, error)
+	// Devices returns "major:minor" numbers of block devices holding the storage
+	Devices() ([]string, error)
+	// IO returns cumulative I/O of all datasets by names, if the storage accounts it per dataset
+	IO() (map[string]IOStat, error)
+}
+
+// drivers are storage backends available in "storage" option of [agent] config section
+var drivers = map[string]func() Driver{
+	"zfs": func() Driver { return &zfs{root: config.Agent.Dataset} },
+	"dir": func() Driver { return &dir{root: config.Agent.LxcPrefix, state: path.Join(config.Agent.DataPrefix, "storage")} },
+}
+
+var driver Driver
+
+func init() {
+	name := config.Agent.Storage
+	if len(name) == 0 {
+		name = "zfs"
+	}
+	create, ok := drivers[name]
+	if !ok {
+		log.Error("Unknown storage driver \"" + name + "\", use zfs or dir")
+	}
+	driver = create()
+}
+
+// SetDriver replaces storage driver, e.g. with Fake one in tests
+func SetDriver(d Driver) {
+	driver = d
+	invalidate()
+}
+
+// Backend returns value of lxc.rootfs.backend for containers on selected storage
+func Backend() string {
+	return driver.Backend()
+}
+
+// Error describes failed storage operation
+type Error struct {
+	// Op describes the operation, e.g. "Creating zfs dataset"
+	Op string
+	// Name is a dataset or snapshot name
+	Name string
+	// Output is an output of failed command, if any
+	Output string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op + " " + e.Name
+	if len(strings.TrimSpace(e.Output)) > 0 {
+		msg += ": " + strings.TrimSpace(e.Output)
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// NotFound checks if operation failed because dataset or snapshot does not exist
+func (e *Error) NotFound() bool {
+	return strings.Contains(e.Output, "does not exist") || os.IsNotExist(e.Err)
+}
+
+// ErrNotSupported is returned by storage drivers for operations they cannot perform
+var ErrNotSupported = errors.New("Operation is not supported by storage driver")
+
+// Space limits of dataset
+const (
+	// Quota limits space used by dataset together with its children and snapshots
+	Quota = "quota"
+	// RefQuota limits space referenced by dataset itself, so its snapshots do not eat the quota
+	RefQuota = "refquota"
+	// Reservation guarantees space for dataset together with its children and snapshots
+	Reservation = "reservation"
+)
+
+// GB is a number of bytes in gigabyte, disk quotas are set in GB
+const GB = 1024 * 1024 * 1024
+
+// Dataset describes properties of dataset, sizes are in bytes, 0 quota or reservation means there is none
+type Dataset struct {
+	Name        string `json:"name"`
+	Used        int    `json:"used"`
+	Available   int    `json:"available"`
+	Referenced  int    `json:"referenced"`
+	Quota       int    `json:"quota"`
+	RefQuota    int    `json:"refquota"`
+	Reservation int    `json:"reservation"`
+	ReadOnly    bool   `json:"readonly"`
+	Origin      string `json:"origin,omitempty"`
+}
+
+// IOStat is a cumulative I/O issued to dataset by its users, e.g. processes of container
+type IOStat struct {
+	ReadBytes  int
+	WriteBytes int
+	ReadOps    int
+	WriteOps   int
+}
+
+// Limit returns value of Quota, RefQuota or Reservation of dataset in bytes
+func (d Dataset) Limit(property string) int {
+	switch property {
+	case Quota:
+		return d.Quota
+	case RefQuota:
+		return d.RefQuota
+	case Reservation:
+		return d.Reservation
+	}
+	return 0
+}
+
+// datasetsTTL is a time for which dataset properties are served from cache
+const datasetsTTL = 15 * time.Second
+
+// cache keeps properties of all datasets, it is shared by alert and monitor loops of the daemon
+// and dropped on every change made through this package
+var cache struct {
+	sync.Mutex
+	list    map[string]Dataset
+	updated time.Time
+}
+
+func invalidate() {
+	cache.Lock()
+	cache.list = nil
+	cache.Unlock()
+}
+
+// changed drops cache of dataset properties if operation succeeded and returns its error
+func changed(err error) error {
+	if err == nil {
+		invalidate()
+	}
+	return err
+}
+
+// Datasets returns properties of all datasets by names, the list is cached for a short time
+func Datasets() (map[string]Dataset, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.list != nil && time.Since(cache.updated) < datasetsTTL {
+		return cache.list, nil
+	}
+	list, err := driver.List()
+	if err != nil {
+		return nil, err
+	}
+	cache.list, cache.updated = list, time.Now()
+	return list, nil
+}
+
+// cached returns properties of dataset if they are in cache, unlike GetDataset it never lists datasets,
+// which is slow for large trees on dir storage
+func cached(name string) (Dataset, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.list == nil || time.Since(cache.updated) >= datasetsTTL {
+		return Dataset{}, false
+	}
+	d, ok := cache.list[strings.TrimSuffix(name, "/")]
+	return d, ok
+}
+
+// GetDataset returns cached properties of dataset
+func GetDataset(name string) (Dataset, error) {
+	name = strings.TrimSuffix(name, "/")
+	list, err := Datasets()
+	if err != nil {
+		return Dataset{}, err
+	}
+	if d, ok := list[name]; ok {
+		return d, nil
+	}
+	return Dataset{}, &Error{Op: "Getting properties of dataset", Name: name, Err: os.ErrNotExist}
+}
+
+// Checks if dataset is readonly using cached properties, storage is queried only on cache miss
+// e.g. IsDatasetReadOnly("debian-stretch")
+func IsDatasetReadOnly(dataset string) bool {
+	if d, ok := cached(dataset); ok {
+		return d.ReadOnly
+	}
+	return driver.ReadOnly(dataset)
+}
+
+// Sets dataset readonly
+// e.g. SetDatasetReadOnly("debian-stretch")
+func SetDatasetReadOnly(dataset string) error {
+	return changed(driver.SetReadOnly(dataset))
+}
+
+// Checks if dataset exists
+// e.g. DatasetExists("foo")
+func DatasetExists(dataset string) bool {
+	return driver.Exists(dataset)
+}
+
+// Removes dataset or snapshot.
+// Parameter "recursive" allows to remove all children.
+// If snapshot is to be removed, "dataset" parameter must be in form "dataset@snapshotName"
+func RemoveDataset(dataset string, recursive bool) error {
+	err := driver.Remove(dataset, recursive)
+	log.Check(log.WarnLevel, "Removing dataset/snapshot "+dataset, err)
+	return changed(err)
+}
+
+// Creates dataset
+// e.g. CreateDataset("debian-stretch")
+func CreateDataset(dataset string) error {
+	return changed(driver.Create(dataset))
+}
+
+// Receives delta file to dataset
+// e.g. ReceiveStream("foo/rootfs", "/tmp/rootfs.delta")
+func ReceiveStream(dataset string, delta string) error {
+	return changed(driver.Receive(dataset, delta, false))
+}
+
+// Receives delta file to dataset, rolling it back to the most recent snapshot first
+// e.g. ReceiveStreamForce("foo/rootfs", "/tmp/rootfs.delta")
+func ReceiveStreamForce(dataset string, delta string) error {
+	return changed(driver.Receive(dataset, delta, true))
+}
+
+// Saves full stream of snapshot to delta file
+// e.g. SendFullStream("foo/rootfs@backup-20180102-150405", "/tmp/rootfs.delta")
+func SendFullStream(snapshot, delta string) error {
+	return driver.Send("", snapshot, delta)
+}
+
+// Saves incremental stream to delta file
+// e.g. SendStream("debian-stretch/rootfs@now", "foo/rootfs@now", "/tmp/rootfs.delta")
+func SendStream(snapshotFrom, snapshotTo, delta string) error {
+	return driver.Send(snapshotFrom, snapshotTo, delta)
+}
+
+// Creates snapshot
+// e.g. CreateSnapshot("foo/rootfs@now")
+func CreateSnapshot(snapshot string) error {
+	dataset, label := splitSnapshot(snapshot)
+	return changed(driver.Snapshot(dataset, label, false))
+}
+
+// Creates snapshot of dataset and all its children atomically
+// e.g. CreateSnapshotRecursive("foo", "before-upgrade")
+func CreateSnapshotRecursive(dataset, label string) error {
+	return changed(driver.Snapshot(dataset, label, true))
+}
+
+// Rolls dataset back to snapshot, removing all snapshots taken after it
+// e.g. RollbackSnapshot("foo/rootfs@before-upgrade")
+func RollbackSnapshot(snapshot string) error {
+	return changed(driver.Rollback(snapshot))
+}
+
+// Returns list of snapshot labels of dataset ordered by creation time
+// e.g. ListSnapshots("foo/rootfs")
+func ListSnapshots(dataset string) ([]string, error) {
+	return driver.Snapshots(dataset)
+}
+
+// Returns list of datasets cloned from snapshot
+// e.g. SnapshotClones("debian-stretch/rootfs@now")
+func SnapshotClones(snapshot string) ([]string, error) {
+	return driver.Clones(snapshot)
+}
+
+// Returns "used" or "referenced" size of dataset or snapshot in bytes
+// e.g. GetSizeProperty("foo/rootfs@now", "referenced")
+func GetSizeProperty(dataset, property string) (int, error) {
+	return driver.Size(dataset, property)
+}
+
+// Clones snapshot to dataset
+// e.g. CloneSnapshot("debian-stretch/rootfs@now", "foo/rootfs")
+func CloneSnapshot(snapshot, dataset string) error {
+	return changed(driver.Clone(snapshot, dataset))
+}
+
+// Sets dataset quota in GB
+// e.g. SetQuota("foo", 10)
+func SetQuota(dataset string, quotaInGb int) error {
+	return SetLimit(dataset, Quota, quotaInGb)
+}
+
+// Sets Quota, RefQuota or Reservation of dataset in GB, 0 removes the limit
+// e.g. SetLimit("foo/rootfs", fs.RefQuota, 10)
+func SetLimit(dataset, property string, sizeInGb int) error {
+	return changed(driver.SetLimit(dataset, property, sizeInGb*GB))
+}
+
+// Returns Quota, RefQuota or Reservation of dataset in bytes, 0 if no limit set
+// e.g. GetLimit("foo/rootfs", fs.RefQuota)
+func GetLimit(dataset, property string) (int, error) {
+	d, err := GetDataset(dataset)
+	return d.Limit(property), err
+}
+
+// Returns dataset quota in bytes, 0 if no quota set
+// e.g. GetQuota("foo")
+func GetQuota(dataset string) (int, error) {
+	d, err := GetDataset(dataset)
+	return d.Quota, err
+}
+
+//Returns dataset disk usage in bytes
+func DatasetDiskUsage(dataset string) (int, error) {
+	d, err := GetDataset(dataset)
+	return d.Used, err
+}
+
+// Returns used and available space of the storage in bytes
+func DiskSpace() (used, available int, err error) {
+	return driver.Space()
+}
+
+// Returns "major:minor" numbers of disks holding the storage, e.g. for I/O throttling of containers
+func StorageDevices() ([]string, error) {
+	return driver.Devices()
+}
+
+// Returns cumulative I/O of all datasets, e.g. for I/O throttling of containers on storage
+// which issues disk I/O from its own threads, so it is not accounted in cgroups of containers
+func DatasetsIO() (map[string]IOStat, error) {
+	return driver.IO()
+}
+
+// disk returns "major:minor" number of block device, partition is resolved to the disk it belongs to,
+// since I/O throttling works for whole disks only
+func disk(dev uint64) string {
+	number := strconv.FormatUint(major(dev), 10) + ":" + strconv.FormatUint(dev&0xff|(dev>>12)&^0xff, 10)
+	if _, err := os.Stat(path.Join("/sys/dev/block", number, "partition")); err != nil {
+		return number
+	}
+	// /sys/dev/block entry is a symlink to the partition directory nested in the disk one,
+	// so it must be resolved before going up, path.Join would drop ".." lexically
+	partition, err := filepath.EvalSymlinks(path.Join("/sys/dev/block", number))
+	if err != nil {
+		return number
+	}
+	if parent, err := ioutil.ReadFile(filepath.Join(filepath.Dir(partition), "dev")); err == nil {
+		return strings.TrimSpace(string(parent))
+	}
+	return number
+}
+
+// major returns major number of device, 0 is used by virtual filesystems
+func major(dev uint64) uint64 {
+	return (dev>>8)&0xfff | (dev>>32)&^0xfff
+}
+
+// splitSnapshot splits snapshot name to dataset and label
+func splitSnapshot(snapshot string) (string, string) {
+	if i := strings.LastIndex(snapshot, "@"); i != -1 {
+		return snapshot[:i], snapshot[i+1:]
+	}
+	return snapshot, ""
+}
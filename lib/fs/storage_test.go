@@ -0,0 +1,237 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// testDrivers returns drivers checked against the same expectations, dir driver works in temporary directory
+func testDrivers(t *testing.T) (map[string]Driver, func()) {
+	tmp, err := ioutil.TempDir("", "storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Driver{
+		"dir":  &dir{root: path.Join(tmp, "lxc"), state: path.Join(tmp, "state")},
+		"fake": NewFake(),
+	}, func() { os.RemoveAll(tmp) }
+}
+
+func TestDriverSnapshots(t *testing.T) {
+	drivers, cleanup := testDrivers(t)
+	defer cleanup()
+
+	for name, d := range drivers {
+		for _, ds := range []string{"foo", "foo/rootfs"} {
+			if err := d.Create(ds); err != nil {
+				t.Fatalf("%s: Create(%s): %v", name, ds, err)
+			}
+		}
+		if err := d.Create("foo"); err == nil {
+			t.Errorf("%s: existing dataset is created again", name)
+		}
+		for _, label := range []string{"a", "b", "c"} {
+			if err := d.Snapshot("foo", label, true); err != nil {
+				t.Fatalf("%s: Snapshot(foo, %s): %v", name, label, err)
+			}
+		}
+		if err := d.Snapshot("foo", "a", false); err == nil {
+			t.Errorf("%s: existing snapshot is taken again", name)
+		}
+		if err := d.Snapshot("bar", "a", false); err == nil {
+			t.Errorf("%s: snapshot of missing dataset is taken", name)
+		}
+
+		tests := []struct {
+			op      string
+			apply   func() error
+			dataset string
+			want    []string
+		}{
+			{"recursive snapshot", nil, "foo/rootfs", []string{"a", "b", "c"}},
+			{"rollback", func() error { return d.Rollback("foo/rootfs@a") }, "foo/rootfs", []string{"a"}},
+			{"rollback keeps other datasets", nil, "foo", []string{"a", "b", "c"}},
+			{"recursive remove", func() error { return d.Remove("foo@b", true) }, "foo", []string{"a", "c"}},
+		}
+		for _, tt := range tests {
+			if tt.apply != nil {
+				if err := tt.apply(); err != nil {
+					t.Fatalf("%s: %s: %v", name, tt.op, err)
+				}
+			}
+			if got, err := d.Snapshots(tt.dataset); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: %s: Snapshots(%s) = %v, %v, want %v", name, tt.op, tt.dataset, got, err, tt.want)
+			}
+		}
+
+		if err := d.Remove("foo", false); err == nil {
+			t.Errorf("%s: dataset with children is removed non-recursively", name)
+		}
+		if err := d.Remove("foo", true); err != nil {
+			t.Errorf("%s: Remove(foo): %v", name, err)
+		}
+		for _, ds := range []string{"foo", "foo/rootfs", "foo@a"} {
+			if d.Exists(ds) {
+				t.Errorf("%s: %s exists after recursive remove", name, ds)
+			}
+		}
+	}
+}
+
+func TestDriverSendReceive(t *testing.T) {
+	drivers, cleanup := testDrivers(t)
+	defer cleanup()
+
+	for name, d := range drivers {
+		stream := path.Join(os.TempDir(), "stream-"+name)
+		defer os.Remove(stream)
+
+		if err := d.Create("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Snapshot("foo", "now", false); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Send("", "foo@missing", stream); err == nil {
+			t.Errorf("%s: missing snapshot is sent", name)
+		}
+		if err := d.Send("", "foo@now", stream); err != nil {
+			t.Fatalf("%s: Send: %v", name, err)
+		}
+
+		if err := d.Receive("bar", stream, false); err != nil {
+			t.Fatalf("%s: Receive: %v", name, err)
+		}
+		if !d.Exists("bar") || !d.Exists("bar@now") {
+			t.Errorf("%s: received dataset or its snapshot is missing", name)
+		}
+		if err := d.Receive("bar", stream, false); err == nil {
+			t.Errorf("%s: existing dataset is received without force", name)
+		}
+		if err := d.Receive("bar", stream, true); err != nil {
+			t.Errorf("%s: Receive with force: %v", name, err)
+		}
+	}
+}
+
+func TestDriverReadOnly(t *testing.T) {
+	drivers, cleanup := testDrivers(t)
+	defer cleanup()
+
+	for name, d := range drivers {
+		if err := d.SetReadOnly("foo"); err == nil {
+			t.Errorf("%s: missing dataset is made read-only", name)
+		}
+		if err := d.Create("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if d.ReadOnly("foo") {
+			t.Errorf("%s: new dataset is read-only", name)
+		}
+		if err := d.SetReadOnly("foo"); err != nil {
+			t.Fatalf("%s: SetReadOnly: %v", name, err)
+		}
+		if !d.ReadOnly("foo") {
+			t.Errorf("%s: dataset is not read-only", name)
+		}
+	}
+}
+
+func TestDirContent(t *testing.T) {
+	drivers, cleanup := testDrivers(t)
+	defer cleanup()
+	d := drivers["dir"].(*dir)
+
+	write := func(dataset, content string) {
+		if err := ioutil.WriteFile(path.Join(d.data(dataset), "file"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(dataset string) string {
+		data, _ := ioutil.ReadFile(path.Join(d.data(dataset), "file"))
+		return string(data)
+	}
+
+	for _, ds := range []string{"foo", "foo/rootfs"} {
+		if err := d.Create(ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("foo/rootfs", "child")
+	write("foo", "before")
+	if err := d.Snapshot("foo", "a", false); err != nil {
+		t.Fatal(err)
+	}
+	// snapshot of parent dataset doesn't include content of its children
+	if _, err := os.Stat(path.Join(d.snapshot("foo@a"), "rootfs")); !os.IsNotExist(err) {
+		t.Error("child dataset is copied to snapshot of parent")
+	}
+
+	write("foo", "after")
+	if err := d.Rollback("foo@a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("foo"); got != "before" {
+		t.Errorf("content after rollback = %q, want \"before\"", got)
+	}
+	if got := read("foo/rootfs"); got != "child" {
+		t.Errorf("content of child dataset after rollback of parent = %q, want \"child\"", got)
+	}
+
+	if err := d.Clone("foo@a", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("bar"); got != "before" {
+		t.Errorf("content of clone = %q, want \"before\"", got)
+	}
+	if list, _ := d.List(); list["bar"].Used == 0 {
+		t.Errorf("used space of clone is not counted: %+v", list["bar"])
+	}
+}
+
+func TestIsDatasetReadOnly(t *testing.T) {
+	f := NewFake()
+	SetDriver(f)
+	for _, d := range []string{"debian", "debian/rootfs", "foo", "foo/rootfs"} {
+		if err := f.Create(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetDatasetReadOnly("debian/rootfs"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dataset string
+		want    bool
+	}{
+		{"debian/rootfs", true},
+		{"debian/rootfs/", true},
+		{"foo/rootfs", false},
+		{"bar/rootfs", false},
+	}
+	// the same answers are expected from storage on cache miss and from cached properties
+	for _, warm := range []bool{false, true} {
+		if warm {
+			if _, err := Datasets(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tt := range tests {
+			if got := IsDatasetReadOnly(tt.dataset); got != tt.want {
+				t.Errorf("IsDatasetReadOnly(%q) with warm cache %v = %v, want %v", tt.dataset, warm, got, tt.want)
+			}
+		}
+	}
+
+	// flag changed bypassing the package is not seen until cache expires
+	f.Lock()
+	f.Readonly["foo/rootfs"] = true
+	f.Unlock()
+	if IsDatasetReadOnly("foo/rootfs") {
+		t.Error("IsDatasetReadOnly queried storage instead of cached properties")
+	}
+}
@@ -1,198 +1,296 @@
 package fs
 
 import (
+	"io/ioutil"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"github.com/subutai-io/agent/log"
+	"syscall"
+
 	"github.com/subutai-io/agent/lib/exec"
-	"strconv"
-	"github.com/pkg/errors"
-	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
 )
 
-var zfsRootDataset string
+// zfs stores containers in datasets of ZFS pool under root dataset, mounted to LXC directory.
+// Properties are read in scripted mode with exact values, e.g. "zfs get -Hp", so no human readable sizes are parsed.
+type zfs struct {
+	root string
+}
+
+// zfsProperties are columns of "zfs list" output parsed to Dataset
+const zfsProperties = "name,used,avail,refer,quota,refquota,reservation,readonly,origin"
 
-func init() {
-	zfsRootDataset = config.Agent.Dataset
+func (z *zfs) Backend() string {
+	return "zfs"
 }
 
-// Checks if dataset is readonly
-// e.g. IsDatasetReadOnly("debian-stretch")
-func IsDatasetReadOnly(dataset string) bool {
-	out, _ := exec.ExecuteWithBash(
-		"zfs get readonly -H " + path.Join(zfsRootDataset, dataset) + " | awk '{print $3}' ")
+func (z *zfs) ReadOnly(dataset string) bool {
+	out, err := z.get(dataset, "readonly")
 	log.Debug("Getting zfs dataset " + dataset + " readonly property " + out)
-	return strings.TrimSpace(out) == "on"
+	return err == nil && out == "on"
 }
 
-// Sets dataset readonly
-// e.g. SetDatasetReadOnly("debian-stretch")
-func SetDatasetReadOnly(dataset string) {
-	out, err := exec.Execute("zfs", "set", "readonly=on", path.Join(zfsRootDataset, dataset))
-	log.Check(log.FatalLevel, "Setting zfs dataset "+dataset+" readonly "+out, err)
+func (z *zfs) SetReadOnly(dataset string) error {
+	return z.run("Setting readonly property of zfs dataset", dataset, "set", "readonly=on", path.Join(z.root, dataset))
 }
 
-// Checks if dataset exists
-// e.g. DatasetExists("foo")
-func DatasetExists(dataset string) bool {
-	out, err := exec.Execute("zfs", "list", "-H", path.Join(zfsRootDataset, dataset))
+func (z *zfs) Exists(dataset string) bool {
+	out, err := exec.Execute("zfs", "list", "-H", "-o", "name", path.Join(z.root, dataset))
 	log.Debug("Checking zfs dataset " + dataset + " existence " + out)
 	return err == nil
 }
 
-// Removes dataset or snapshot.
-// Parameter "recursive" allows to remove all children.
-// If snapshot is to be removed, "dataset" parameter must be in form "dataset@snapshotName"
-func RemoveDataset(dataset string, recursive bool) error {
+func (z *zfs) Remove(dataset string, recursive bool) error {
 	args := []string{"destroy"}
 	if recursive {
 		args = append(args, "-r")
 	}
-	args = append(args, path.Join(zfsRootDataset, dataset))
-	out, err := exec.Execute("zfs", args...)
-	log.Check(log.WarnLevel, "Removing zfs dataset/snapshot "+dataset+" "+out, err)
-	if err != nil {
-		return errors.New("Removing zfs dataset/snapshot " + dataset + " " + out)
+	args = append(args, path.Join(z.root, dataset))
+	return z.run("Removing zfs dataset/snapshot", dataset, args...)
+}
+
+func (z *zfs) Create(dataset string) error {
+	return z.run("Creating zfs dataset", dataset, "create", path.Join(z.root, dataset))
+}
+
+func (z *zfs) Receive(dataset, delta string, force bool) error {
+	flag := ""
+	if force {
+		flag = "-F "
+	}
+	if out, err := exec.ExecuteWithBash("zfs receive " + flag + path.Join(z.root, dataset) + " < " + delta); err != nil {
+		return &Error{Op: "Receiving zfs stream from " + delta + " to", Name: dataset, Output: out, Err: err}
 	}
 	return nil
 }
 
-// Creates dataset
-// e.g. CreateDataset("debian-stretch")
-func CreateDataset(dataset string) {
-	out, err := exec.Execute("zfs", "create", path.Join(zfsRootDataset, dataset))
-	log.Check(log.FatalLevel, "Creating zfs dataset "+dataset+" "+out, err)
+func (z *zfs) Send(from, snapshot, delta string) error {
+	cmd := "zfs send "
+	if len(from) > 0 {
+		cmd += "-i " + path.Join(z.root, from) + " "
+	}
+	if out, err := exec.ExecuteWithBash(cmd + path.Join(z.root, snapshot) + " > " + delta); err != nil {
+		return &Error{Op: "Sending zfs stream to " + delta + " of", Name: snapshot, Output: out, Err: err}
+	}
+	return nil
 }
 
-// Receives delta file to dataset
-// e.g. ReceiveStream("foo/rootfs", "/tmp/rootfs.delta")
-func ReceiveStream(dataset string, delta string) {
-	out, err := exec.ExecuteWithBash("zfs receive " + path.Join(zfsRootDataset, dataset) + " < " + delta)
-	log.Check(log.FatalLevel, "Receving zfs stream from "+delta+" to "+dataset+" "+out, err)
+func (z *zfs) Snapshot(dataset, label string, recursive bool) error {
+	args := []string{"snapshot"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	args = append(args, path.Join(z.root, dataset)+"@"+label)
+	return z.run("Creating zfs snapshot", dataset+"@"+label, args...)
 }
 
-// Saves incremental stream to delta file
-// e.g. SendStream("debian-stretch/rootfs@now", "foo/rootfs@now", "/tmp/rootfs.delta")
-func SendStream(snapshotFrom, snapshotTo, delta string) {
-	out, err := exec.ExecuteWithBash("zfs send -i " + path.Join(zfsRootDataset, snapshotFrom) +
-		" " + path.Join(zfsRootDataset, snapshotTo) + " > " + delta)
-	log.Check(log.FatalLevel, "Sending zfs stream from "+snapshotFrom+" to "+snapshotTo+" > "+delta+" "+out, err)
+func (z *zfs) Rollback(snapshot string) error {
+	return z.run("Rolling back zfs snapshot", snapshot, "rollback", "-r", path.Join(z.root, snapshot))
 }
 
-// Creates snapshot
-// e.g. CreateSnapshot("foo/rootfs@now")
-func CreateSnapshot(snapshot string) {
-	out, err := exec.Execute("zfs", "snapshot", path.Join(zfsRootDataset, snapshot))
-	log.Check(log.FatalLevel, "Creating zfs snapshot "+snapshot+" "+out, err)
-}
+func (z *zfs) Snapshots(dataset string) ([]string, error) {
+	out, err := exec.Execute("zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-s", "creation",
+		"-d", "1", path.Join(z.root, dataset))
+	if err != nil {
+		return nil, &Error{Op: "Listing zfs snapshots of", Name: dataset, Output: out, Err: err}
+	}
 
-// Clones snapshot to dataset
-// e.g. CloneSnapshot("debian-stretch/rootfs@now", "foo/rootfs")
-func CloneSnapshot(snapshot, dataset string) {
-	out, err := exec.Execute("zfs", "clone", path.Join(zfsRootDataset, snapshot),
-		path.Join(zfsRootDataset, dataset))
-	log.Check(log.FatalLevel, "Cloning zfs snapshot "+snapshot+" to "+dataset+" "+out, err)
+	var labels []string
+	for _, line := range strings.Split(out, "\n") {
+		if parts := strings.Split(strings.TrimSpace(line), "@"); len(parts) == 2 {
+			labels = append(labels, parts[1])
+		}
+	}
+	return labels, nil
 }
 
-// Sets dataset quota in GB
-// e.g. SetQuota("foo", 10)
-func SetQuota(dataset string, quotaInGb int) {
-	out, err := exec.Execute("zfs", "set", "quota="+strconv.Itoa(quotaInGb)+"G", path.Join(zfsRootDataset, dataset))
-	log.Check(log.ErrorLevel, "Setting quota "+strconv.Itoa(quotaInGb)+"G to "+dataset+" "+out, err)
+func (z *zfs) Clones(snapshot string) ([]string, error) {
+	out, err := z.get(snapshot, "clones")
+	if err != nil {
+		return nil, err
+	}
+
+	var clones []string
+	if out != "" && out != "-" {
+		for _, clone := range strings.Split(out, ",") {
+			clones = append(clones, strings.TrimPrefix(clone, z.root+"/"))
+		}
+	}
+	return clones, nil
 }
 
-// Returns dataset quota in bytes, 0 if no quota set
-// e.g. GetQuota("foo")
-func GetQuota(dataset string) (int, error) {
-	out, err := exec.Execute("zfs", "get", "quota", path.Join(zfsRootDataset, dataset))
+func (z *zfs) Size(dataset, property string) (int, error) {
+	out, err := z.get(dataset, property)
 	if err != nil {
 		return -1, err
 	}
+	return size(out)
+}
 
-	lines := strings.Split(out, "\n")
-
-	if len(lines) > 1 {
-		//skip header
-		fields := strings.Fields(lines[1])
+func (z *zfs) Clone(snapshot, dataset string) error {
+	return z.run("Cloning zfs snapshot "+snapshot+" to", dataset, "clone", path.Join(z.root, snapshot),
+		path.Join(z.root, dataset))
+}
 
-		if len(fields) > 3 {
+func (z *zfs) SetLimit(dataset, property string, bytes int) error {
+	value := "none"
+	if bytes > 0 {
+		value = strconv.Itoa(bytes)
+	}
+	return z.run("Setting "+property+" of zfs dataset", dataset, "set", property+"="+value, path.Join(z.root, dataset))
+}
 
-			if fields[2] == "none" {
-				return 0, nil
-			}
+func (z *zfs) Usage(dataset string) (int, error) {
+	return z.Size(dataset, "used")
+}
 
-			bytes, err := ConvertToBytes(fields[2])
+func (z *zfs) Space() (used, available int, err error) {
+	out, err := exec.Execute("zfs", "list", "-Hp", "-o", "used,avail", z.root)
+	if err != nil {
+		return 0, 0, &Error{Op: "Getting space of zfs dataset", Name: z.root, Output: out, Err: err}
+	}
+	fields := strings.Split(strings.TrimSpace(out), "\t")
+	if len(fields) != 2 {
+		return 0, 0, &Error{Op: "Parsing space of zfs dataset", Name: z.root, Output: out}
+	}
+	if used, err = size(fields[0]); err == nil {
+		available, err = size(fields[1])
+	}
+	return
+}
 
-			log.Debug("Quota ", bytes)
+// Devices returns disks of all vdevs in the pool of root dataset
+func (z *zfs) Devices() ([]string, error) {
+	pool := strings.Split(z.root, "/")[0]
+	out, err := exec.Execute("zpool", "list", "-vHP", pool)
+	if err != nil {
+		return nil, &Error{Op: "Listing devices of zfs pool", Name: pool, Output: out, Err: err}
+	}
 
-			if err != nil {
-				return -1, err
+	var devices []string
+	for _, line := range strings.Split(out, "\n") {
+		var st syscall.Stat_t
+		if f := strings.Fields(line); len(f) > 0 && strings.HasPrefix(f[0], "/dev/") && syscall.Stat(f[0], &st) == nil {
+			if dev := disk(st.Rdev); !contains(devices, dev) {
+				devices = append(devices, dev)
 			}
-
-			return bytes, nil
-		} else {
-			return -1, errors.New("Failed to parse quota from " + out)
 		}
-	} else {
-		return -1, errors.New("Failed to parse quota from " + out)
 	}
+	return devices, nil
 }
 
-//Returns dataset disk usage in bytes
-func DatasetDiskUsage(dataset string) (int, error) {
-
-	out, err := exec.Execute("zfs", "list", path.Join(zfsRootDataset, dataset))
+// IO reads I/O statistics kept by ZFS for every mounted dataset of the pool in objset kstats,
+// e.g. /proc/spl/kstat/zfs/subutai/objset-0x36
+func (z *zfs) IO() (map[string]IOStat, error) {
+	pool := strings.Split(z.root, "/")[0]
+	files, err := filepath.Glob(path.Join("/proc/spl/kstat/zfs", pool, "objset-*"))
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
-	line := strings.Split(out, "\n")
-
-	if len(line) > 1 {
-		fields := strings.Fields(line[1])
-
-		if len(fields) > 1 {
-
-			val, err := ConvertToBytes(fields[1])
-			if err != nil {
-				return -1, err
-			}
-
-			return val, nil
+	stats := make(map[string]IOStat)
+	for _, file := range files {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			// objset is gone with unmounted dataset
+			continue
+		}
+		if name, stat, ok := parseObjset(string(out)); ok && strings.HasPrefix(name, z.root+"/") {
+			stats[strings.TrimPrefix(name, z.root+"/")] = stat
 		}
 	}
-
-	return -1, errors.New("Failed to parse disk usage from " + out)
+	return stats, nil
 }
 
-func ConvertToBytes(input string) (int, error) {
-	input = strings.ToUpper(strings.TrimSpace(input))
+// parseObjset parses objset kstat of dataset, which is a table of "name type data" lines, e.g. "nwritten 4 1048576".
+// Kstats of objsets are available since ZFS on Linux 0.8.
+func parseObjset(out string) (name string, stat IOStat, ok bool) {
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		if f[0] == "dataset_name" {
+			name = strings.Join(f[2:], " ")
+			continue
+		}
+		value, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		switch f[0] {
+		case "nread":
+			stat.ReadBytes = value
+		case "nwritten":
+			stat.WriteBytes = value
+		case "reads":
+			stat.ReadOps = value
+		case "writes":
+			stat.WriteOps = value
+		}
+	}
+	return name, stat, len(name) > 0
+}
 
-	multiplier := 1
-	value := input
+// List reads properties of all datasets under root dataset with single command
+func (z *zfs) List() (map[string]Dataset, error) {
+	out, err := exec.Execute("zfs", "list", "-Hp", "-r", "-t", "filesystem", "-o", zfsProperties, z.root)
+	if err != nil {
+		return nil, &Error{Op: "Listing zfs datasets of", Name: z.root, Output: out, Err: err}
+	}
+	return z.parse(out)
+}
 
-	if len(input) > 1 {
+// parse converts scripted "zfs list" output with zfsProperties columns to datasets with names relative to root dataset
+func (z *zfs) parse(out string) (map[string]Dataset, error) {
+	list := make(map[string]Dataset)
+	for _, line := range strings.Split(out, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) != strings.Count(zfsProperties, ",")+1 {
+			return nil, &Error{Op: "Parsing zfs list output", Output: line}
+		}
 
-		unit := input[len(input)-1]
-		value = input[:len(input)-1]
-		switch unit {
-		case 'K':
-			multiplier = 1024
-		case 'M':
-			multiplier = 1024 * 1024
-		case 'G':
-			multiplier = 1024 * 1024 * 1024
-		case 'T':
-			multiplier = 1024 * 1024 * 1024 * 1024
-		case 'P':
-			multiplier = 1024 * 1024 * 1024 * 1024 * 1024
-		case 'E':
-			multiplier = 1024 * 1024 * 1024 * 1024 * 1024 * 1024
+		name := strings.TrimPrefix(strings.TrimPrefix(f[0], z.root), "/")
+		d := Dataset{Name: name, ReadOnly: f[7] == "on"}
+		if f[8] != "-" {
+			d.Origin = strings.TrimPrefix(f[8], z.root+"/")
+		}
+		for i, v := range []*int{&d.Used, &d.Available, &d.Referenced, &d.Quota, &d.RefQuota, &d.Reservation} {
+			var err error
+			if *v, err = size(f[i+1]); err != nil {
+				return nil, &Error{Op: "Parsing zfs properties of", Name: name, Output: line, Err: err}
+			}
 		}
+		list[name] = d
+	}
+	return list, nil
+}
 
+// get returns exact value of dataset or snapshot property
+func (z *zfs) get(name, property string) (string, error) {
+	out, err := exec.Execute("zfs", "get", "-Hp", "-o", "value", property, path.Join(z.root, name))
+	if err != nil {
+		return "", &Error{Op: "Getting zfs property " + property + " of", Name: name, Output: out, Err: err}
 	}
+	return strings.TrimSpace(out), nil
+}
 
-	num, err := strconv.ParseFloat(value, 64)
-	res := float64(multiplier) * num
-	return int(res), err
+// run executes zfs command and returns Error with its output if it fails
+func (z *zfs) run(op, name string, args ...string) error {
+	if out, err := exec.Execute("zfs", args...); err != nil {
+		return &Error{Op: op, Name: name, Output: out, Err: err}
+	}
+	return nil
+}
+
+// size parses exact size value, "-" and "none" mean there is no value
+func size(value string) (int, error) {
+	if value == "-" || value == "none" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
 }
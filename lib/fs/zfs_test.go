@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZfsParse(t *testing.T) {
+	z := &zfs{root: "subutai/fs"}
+	tests := []struct {
+		name string
+		out  string
+		want map[string]Dataset
+		err  bool
+	}{
+		{name: "empty", out: "", want: map[string]Dataset{}},
+		{
+			name: "root and container",
+			out: "subutai/fs\t1024\t2048\t512\t0\t0\t0\toff\t-\n" +
+				"subutai/fs/foo\t100\t2048\t50\t10737418240\t-\tnone\toff\t-\n",
+			want: map[string]Dataset{
+				"":    {Name: "", Used: 1024, Available: 2048, Referenced: 512},
+				"foo": {Name: "foo", Used: 100, Available: 2048, Referenced: 50, Quota: 10737418240},
+			},
+		},
+		{
+			name: "read-only template and clone",
+			out: "subutai/fs/debian/rootfs\t300\t2048\t300\t0\t0\t0\ton\t-\n\n" +
+				"subutai/fs/foo/rootfs\t10\t2048\t300\t0\t5368709120\t1073741824\toff\tsubutai/fs/debian/rootfs@now\n",
+			want: map[string]Dataset{
+				"debian/rootfs": {Name: "debian/rootfs", Used: 300, Available: 2048, Referenced: 300, ReadOnly: true},
+				"foo/rootfs": {Name: "foo/rootfs", Used: 10, Available: 2048, Referenced: 300, RefQuota: 5368709120,
+					Reservation: 1073741824, Origin: "debian/rootfs@now"},
+			},
+		},
+		{name: "missing column", out: "subutai/fs/foo\t100\t2048\t50\t0\t0\t0\toff\n", err: true},
+		{name: "not a number", out: "subutai/fs/foo\t1.5K\t2048\t50\t0\t0\t0\toff\t-\n", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := z.parse(tt.out)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseObjset(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		ds   string
+		want IOStat
+		ok   bool
+	}{
+		{
+			name: "mounted dataset",
+			out: "34 1 0x01 7 2160 5183424853 3089541393214\n" +
+				"name                            type data\n" +
+				"dataset_name                    7    subutai/fs/foo/rootfs\n" +
+				"writes                          4    12\n" +
+				"nwritten                        4    1048576\n" +
+				"reads                           4    3\n" +
+				"nread                           4    4096\n" +
+				"nunlinks                        4    0\n",
+			ds:   "subutai/fs/foo/rootfs",
+			want: IOStat{ReadBytes: 4096, WriteBytes: 1048576, ReadOps: 3, WriteOps: 12},
+			ok:   true,
+		},
+		{
+			name: "dataset name with spaces",
+			out:  "dataset_name 7 subutai/fs/my data\nnread 4 10\n",
+			ds:   "subutai/fs/my data",
+			want: IOStat{ReadBytes: 10},
+			ok:   true,
+		},
+		{name: "no dataset name", out: "nread 4 10\nnwritten 4 20\n", ok: false},
+		{name: "empty", out: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		ds, stat, ok := parseObjset(tt.out)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if tt.ok && (ds != tt.ds || stat != tt.want) {
+			t.Errorf("%s: got %q %+v, want %q %+v", tt.name, ds, stat, tt.ds, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -57,7 +58,7 @@ func GetPk(name string) string {
 	log.Check(log.WarnLevel, "Getting public key", err)
 	if len(stdout) == 0 {
 		log.Warn("GPG key for RH not found. Creating new.")
-		GenerateKey(name)
+		log.Check(log.WarnLevel, "Generating key", GenerateKey(name))
 	}
 	return string(stdout)
 }
@@ -100,7 +101,7 @@ func EncryptWrapper(user, recipient string, message []byte, args ...string) ([]b
 
 // GenerateKey generates GPG-key for Subutai Agent.
 // This key used for encrypting messages for Subutai Agent.
-func GenerateKey(name string) {
+func GenerateKey(name string) error {
 	thePath := path.Join(config.Agent.LxcPrefix , name)
 	email := name + "@subutai.io"
 	pass := config.Agent.GpgPassword
@@ -112,8 +113,8 @@ func GenerateKey(name string) {
 		pass = config.Agent.GpgPassword
 	}
 	conf, err := os.Create(thePath + "/defaults")
-	if log.Check(log.FatalLevel, "Writing default key ident", err) {
-		return
+	if err != nil {
+		return err
 	}
 	_, err = conf.WriteString("%echo Generating default keys\n" +
 		"Key-Type: RSA\n" +
@@ -149,6 +150,7 @@ func GenerateKey(name string) {
 			}
 		}
 	}
+	return nil
 }
 
 // GetFingerprint returns fingerprint of the Subutai container.
@@ -175,20 +177,23 @@ func GetFingerprint(email string) string {
 	return ""
 }
 
-func getMngKey(c string) {
+func getMngKey(c string) error {
 	client := utils.GetClient(config.Management.Allowinsecure, 5)
 	resp, err := client.Get("https://" + path.Join(config.Management.Host) + ":" + config.Management.Port + config.Management.RestPublicKey)
-	log.Check(log.FatalLevel, "Getting Management public key", err)
+	if err != nil {
+		return err
+	}
 
 	defer utils.Close(resp)
 
-	if body, err := ioutil.ReadAll(resp.Body); err == nil {
-		err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix,c,"mgn.key"), body, 0644)
-		log.Check(log.FatalLevel, "Writing Management public key", err)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
+	return ioutil.WriteFile(path.Join(config.Agent.LxcPrefix,c,"mgn.key"), body, 0644)
 }
 
-func parseKeyID(s string) string {
+func parseKeyID(s string) (string, error) {
 	var id string
 
 	line := strings.Split(s, "\n")
@@ -202,23 +207,24 @@ func parseKeyID(s string) string {
 		}
 	}
 	if len(id) == 0 {
-		log.Fatal("Key id parsing error")
+		return "", errors.New("Key id parsing error")
 	}
-	return id
+	return id, nil
 }
 
-func writeData(c, t, n, m string) {
+func writeData(c, t, n, m string) error {
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix,c,"stdin.txt.asc"), os.Remove(path.Join(config.Agent.LxcPrefix,c,"stdin.txt.asc")))
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix,c,"stdin.txt"), os.Remove(path.Join(config.Agent.LxcPrefix,c,"stdin.txt")))
 
 	token := []byte(t + "\n" + GetFingerprint(c) + "\n" + n + m)
-	err := ioutil.WriteFile(path.Join(config.Agent.LxcPrefix,c,"stdin.txt"), token, 0644)
-	log.Check(log.FatalLevel, "Writing Management public key", err)
+	return ioutil.WriteFile(path.Join(config.Agent.LxcPrefix,c,"stdin.txt"), token, 0644)
 }
 
-func sendData(c string) {
+func sendData(c string) error {
 	asc, err := os.Open(path.Join(config.Agent.LxcPrefix,c,"stdin.txt.asc"))
-	log.Check(log.FatalLevel, "Reading encrypted stdin.txt.asc", err)
+	if err != nil {
+		return err
+	}
 	defer asc.Close()
 
 	client := utils.TLSConfig()
@@ -226,41 +232,57 @@ func sendData(c string) {
 	resp, err := client.Post("https://"+path.Join(config.Management.Host)+":8444/rest/v1/registration/verify/container-token", "text/plain", asc)
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix,c,"stdin.txt.asc"), os.Remove(path.Join(config.Agent.LxcPrefix,c,"stdin.txt.asc")))
 	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix,c,"stdin.txt"), os.Remove(path.Join(config.Agent.LxcPrefix,c,"stdin.txt")))
-	log.Check(log.FatalLevel, "Sending registration request to management", err)
+	if err != nil {
+		return err
+	}
 	defer utils.Close(resp)
 	if resp.StatusCode != 200 && resp.StatusCode != 202 {
-		log.Error("Failed to exchange GPG Public Keys. StatusCode: " + resp.Status)
+		return errors.New("Failed to exchange GPG Public Keys. StatusCode: " + resp.Status)
 	}
-
+	return nil
 }
 
 // ExchageAndEncrypt installing the Management server GPG public key to the container keyring.
 // Sending container's GPG public key to the Management server. It require encrypting and singing message
 // received from the Management server.
-func ExchageAndEncrypt(c, t string) {
+func ExchageAndEncrypt(c, t string) error {
 	var impout, expout, imperr, experr bytes.Buffer
 
-	getMngKey(c)
+	if err := getMngKey(c); err != nil {
+		return errors.New("Getting Management public key: " + err.Error())
+	}
 
 	impkey := exec.Command(GPG, "-v", "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix,c,"public.pub"), "--import", path.Join(config.Agent.LxcPrefix,c,"mgn.key"))
 	impkey.Stdout = &impout
 	impkey.Stderr = &imperr
-	err := impkey.Run()
-	log.Check(log.FatalLevel, "Importing Management public key to keyring", err)
+	if err := impkey.Run(); err != nil {
+		return errors.New("Importing Management public key to keyring: " + err.Error())
+	}
 
-	id := parseKeyID(imperr.String())
+	id, err := parseKeyID(imperr.String())
+	if err != nil {
+		return err
+	}
 	expkey := exec.Command(GPG, "--no-default-keyring", "--keyring",path.Join(config.Agent.LxcPrefix,c,"public.pub") , "--export", "--armor", c+"@subutai.io")
 	expkey.Stdout = &expout
 	expkey.Stderr = &experr
-	err = expkey.Run()
-	log.Check(log.FatalLevel, "Exporting armomred key", err)
+	if err = expkey.Run(); err != nil {
+		return errors.New("Exporting armomred key: " + err.Error())
+	}
 
-	writeData(c, t, expout.String(), experr.String())
+	if err = writeData(c, t, expout.String(), experr.String()); err != nil {
+		return errors.New("Writing Management public key: " + err.Error())
+	}
 
 	err = exec.Command(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix,c,"public.pub"), "--trust-model", "always", "--armor", "-r", id, "--encrypt", path.Join(config.Agent.LxcPrefix,c,"stdin.txt")).Run()
-	log.Check(log.FatalLevel, "Encrypting stdin.txt", err)
+	if err != nil {
+		return errors.New("Encrypting stdin.txt: " + err.Error())
+	}
 
-	sendData(c)
+	if err = sendData(c); err != nil {
+		return errors.New("Sending registration request to management: " + err.Error())
+	}
+	return nil
 }
 
 // ValidatePem checks if OpenSSL x509 certificate valid.
@@ -283,21 +305,27 @@ func ParsePem(cert string) (crt, key []byte) {
 }
 //todo move to CDN related package
 // KurjunUserPK gets user's public GPG-key from Kurjun.
-func KurjunUserPK(owner string) []string {
-	utils.CheckCDN()
+func KurjunUserPK(owner string) ([]string, error) {
+	if err := utils.CheckCDN(); err != nil {
+		return nil, err
+	}
 
 	var keys []string
 	kurjun := utils.GetClient(config.CDN.Allowinsecure, 15)
 	response, err := kurjun.Get(config.CDN.Kurjun + "/auth/keys?user=" + owner)
-	log.Check(log.FatalLevel, "Getting owner public key", err)
+	if err != nil {
+		return nil, err
+	}
 	defer utils.Close(response)
 
 	key, err := ioutil.ReadAll(response.Body)
-	log.Check(log.FatalLevel, "Reading key body", err)
+	if err != nil {
+		return nil, err
+	}
 	if json.Unmarshal(key, &keys) == nil {
-		return keys
+		return keys, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // VerifySignature check if signature retrieved from Kurjun is valid.
@@ -11,14 +11,18 @@ import (
 )
 
 // MngInit performs initial operations for SS Management deployment
-func MngInit(templateRef string) {
-	container.Clone(templateRef, "management")
+func MngInit(templateRef string) error {
+	if err := container.Clone(templateRef, "management"); err != nil {
+		return err
+	}
 
 	container.SetContainerUID("management")
 	container.SetContainerConf("management", [][]string{
 		{"lxc.network.veth.pair", "management"},
 	})
-	gpg.GenerateKey("management")
+	if err := gpg.GenerateKey("management"); err != nil {
+		return err
+	}
 	container.SetApt("management")
 	container.SetDNS("management")
 	container.AddMetadata("management", map[string]string{"ip": "10.10.10.1"})
@@ -32,13 +36,16 @@ func MngInit(templateRef string) {
 	log.Check(log.WarnLevel, "Exposing port 8086",
 		exec.Command("subutai", "map", "tcp", "-i", "10.10.10.1:8086", "-e", "8086").Run())
 
-	log.Check(log.ErrorLevel, "Writing container data to database", db.INSTANCE.ContainerAdd("management", map[string]string{"ip": "10.10.10.1"}))
+	if err := db.INSTANCE.ContainerAdd("management", map[string]string{"ip": "10.10.10.1"}); err != nil {
+		return err
+	}
 
 	log.Info("********************")
 	log.Info("Subutai Management UI will be shortly available at https://" + net.GetIp() + ":8443")
 	log.Info("login: admin")
 	log.Info("password: secret")
 	log.Info("********************")
+	return nil
 }
 
 // MngDel removes Management network interfaces, resets dhcp client
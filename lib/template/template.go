@@ -8,26 +8,29 @@ import (
 )
 
 // Install deploys downloaded and unpacked templates to the system
-func Install(templateName string) {
+func Install(templateName string) error {
 
 	pathToDecompressedTemplate := path.Join(config.Agent.CacheDir, templateName)
 
 	// create parent dataset
-	fs.CreateDataset(templateName)
-
-	// create partitions
-	fs.ReceiveStream(templateName+"/rootfs", path.Join(pathToDecompressedTemplate, "deltas", "rootfs.delta"))
-	fs.ReceiveStream(templateName+"/home", path.Join(pathToDecompressedTemplate, "deltas", "home.delta"))
-	fs.ReceiveStream(templateName+"/var", path.Join(pathToDecompressedTemplate, "deltas", "var.delta"))
-	fs.ReceiveStream(templateName+"/opt", path.Join(pathToDecompressedTemplate, "deltas", "opt.delta"))
+	if err := fs.CreateDataset(templateName); err != nil {
+		return err
+	}
 
-	// set partitions as read-only
-	fs.SetDatasetReadOnly(templateName + "/rootfs")
-	fs.SetDatasetReadOnly(templateName + "/home")
-	fs.SetDatasetReadOnly(templateName + "/var")
-	fs.SetDatasetReadOnly(templateName + "/opt")
+	// create partitions and set them as read-only
+	for _, partition := range []string{"rootfs", "home", "var", "opt"} {
+		if err := fs.ReceiveStream(templateName+"/"+partition, path.Join(pathToDecompressedTemplate, "deltas", partition+".delta")); err != nil {
+			return err
+		}
+		if err := fs.SetDatasetReadOnly(templateName + "/" + partition); err != nil {
+			return err
+		}
+	}
 
 	for _, file := range []string{"config", "fstab", "packages"} {
-		fs.Copy(path.Join(pathToDecompressedTemplate, file), path.Join(config.Agent.LxcPrefix, templateName, file))
+		if err := fs.Copy(path.Join(pathToDecompressedTemplate, file), path.Join(config.Agent.LxcPrefix, templateName, file)); err != nil {
+			return err
+		}
 	}
+	return nil
 }
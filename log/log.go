@@ -2,6 +2,7 @@
 package log
 
 import (
+	"io"
 	"log/syslog"
 	"os"
 	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
@@ -21,6 +22,15 @@ var (
 	FatalLevel = logrus.FatalLevel
 	// PanicLevel level, highest level of severity.
 	PanicLevel = logrus.PanicLevel
+
+	structured bool
+)
+
+const (
+	// ExitFailure is exit code of failed command
+	ExitFailure = 1
+	// ExitUsage is exit code of command called with invalid arguments
+	ExitUsage = 2
 )
 
 func init() {
@@ -63,6 +73,28 @@ func Check(level logrus.Level, msg string, err error) bool {
 	return false
 }
 
+// Format switches log messages to structured "json" or "yaml" records written to standard error,
+// so standard output contains only command results. Any other format keeps human readable text.
+func Format(format string) {
+	switch format {
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05"})
+	case "yaml":
+		logrus.SetFormatter(&yamlFormatter{})
+	default:
+		return
+	}
+	structured = true
+	logrus.SetOutput(os.Stderr)
+}
+
+// Output sets destination of log messages and returns previous one
+func Output(w io.Writer) io.Writer {
+	prev := logrus.StandardLogger().Out
+	logrus.SetOutput(w)
+	return prev
+}
+
 // Level sets output level
 func Level(level logrus.Level) {
 	logrus.SetLevel(level)
@@ -82,9 +114,35 @@ func Fatal(msg ...interface{}) {
 
 // Error stops process after showing error message.
 func Error(msg ...interface{}) {
+	Exit(ExitFailure, msg...)
+}
+
+// Exit stops process with exit code after showing error message.
+// In structured output mode the message is an object with "level", "msg", "time" and "code" fields.
+func Exit(code int, msg ...interface{}) {
 	logrus.SetOutput(os.Stderr)
-	logrus.Error(msg...)
-	os.Exit(1)
+	if structured {
+		logrus.WithField("code", code).Error(msg...)
+	} else {
+		logrus.Error(msg...)
+	}
+	logrus.Exit(code)
+}
+
+// ExitError is an error of operation with exit code of the process, e.g. ExitUsage for invalid arguments.
+// Operations return it instead of stopping the process, so they may be executed by the daemon as well.
+type ExitError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ExitError) Error() string {
+	return e.Message
+}
+
+// RegisterExitHandler adds handler called before process exits on error or fatal message.
+func RegisterExitHandler(handler func()) {
+	logrus.RegisterExitHandler(handler)
 }
 
 // Warn keeps process working after showing warning message.
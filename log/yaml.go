@@ -0,0 +1,36 @@
+package log
+
+import (
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlFormatter writes each log entry as separate YAML document
+type yamlFormatter struct{}
+
+func (f *yamlFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	fields := yaml.MapSlice{
+		{Key: "level", Value: entry.Level.String()},
+		{Key: "msg", Value: entry.Message},
+		{Key: "time", Value: entry.Time.Format("2006-01-02 15:04:05")},
+	}
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := entry.Data[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields = append(fields, yaml.MapItem{Key: k, Value: v})
+	}
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("---\n"), data...), nil
+}
@@ -0,0 +1,27 @@
+package log
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestYamlFormatter(t *testing.T) {
+	entry := &logrus.Entry{
+		Level:   logrus.ErrorLevel,
+		Message: "Cloning the container: failed",
+		Time:    time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC),
+		Data:    logrus.Fields{"error": errors.New("exit status 1"), "code": 1},
+	}
+	out, err := (&yamlFormatter{}).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fixed fields go first, the rest are sorted, errors are written as messages
+	want := "---\nlevel: error\nmsg: 'Cloning the container: failed'\ntime: \"2018-01-02 15:04:05\"\ncode: 1\nerror: exit status 1\n"
+	if string(out) != want {
+		t.Errorf("Format() = %q, want %q", out, want)
+	}
+}
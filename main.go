@@ -7,7 +7,9 @@ import (
 	"os"
 
 	"github.com/subutai-io/agent/agent"
+	"github.com/subutai-io/agent/agent/api"
 	"github.com/subutai-io/agent/cli"
+	"github.com/subutai-io/agent/lib/audit"
 	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/log"
 
@@ -72,6 +74,54 @@ func loadManagementIp() {
 	}
 }
 
+// remote executes command by the agent daemon through local API socket if "--api" option is set.
+// Positional arguments are passed as parameters with specified names, options are passed by their long names.
+func remote(c *gcli.Context, args ...string) bool {
+	if !c.GlobalBool("api") {
+		return false
+	}
+	params := make(map[string]interface{})
+	for i, name := range args {
+		params[name] = c.Args().Get(i)
+	}
+	for _, f := range c.Command.Flags {
+		switch flag := f.(type) {
+		case gcli.StringFlag:
+			name := strings.TrimSpace(strings.Split(flag.Name, ",")[0])
+			params[name] = c.String(name)
+		case gcli.BoolFlag:
+			name := strings.TrimSpace(strings.Split(flag.Name, ",")[0])
+			params[name] = c.Bool(name)
+		}
+	}
+	res, err := api.Request(strings.Replace(c.Command.FullName(), " ", "/", -1), params)
+	log.Check(log.ErrorLevel, "Executing command by the agent daemon", err)
+	check(cli.PrintResult(res))
+	return true
+}
+
+// check exits with exit code of failed operation, CLI operations return errors instead of exiting
+// so they may be executed by the daemon as well
+func check(err error) {
+	if err != nil {
+		e := cli.ExitError(err)
+		log.Exit(e.Code, e.Message)
+	}
+}
+
+// show prints output of operation or exits with its error
+func show(out cli.Output, err error) {
+	check(err)
+	out.Print()
+}
+
+// usage shows help of the command called with missing arguments and exits with usage error code
+func usage(c *gcli.Context) {
+	c.App.Writer = os.Stderr
+	gcli.ShowSubcommandHelp(c)
+	log.Exit(log.ExitUsage, "Missing arguments for \""+c.Command.Name+"\" command")
+}
+
 func main() {
 	app := gcli.NewApp()
 	app.Name = "Subutai"
@@ -85,34 +135,70 @@ func main() {
 
 	app.Flags = []gcli.Flag{gcli.BoolFlag{
 		Name:  "d",
-		Usage: "debug mode"}}
+		Usage: "debug mode"}, gcli.BoolFlag{
+		Name:  "api",
+		Usage: "execute command by the agent daemon"}, gcli.StringFlag{
+		Name:  "output",
+		Value: "table",
+		Usage: "output format of command results (table|json|yaml)"}}
 
 	app.Before = func(c *gcli.Context) error {
 		if c.IsSet("d") {
 			log.Level(log.DebugLevel)
 		}
+		if err := cli.SetOutput(c.String("output")); err != nil {
+			log.Exit(log.ExitUsage, err.Error())
+		}
 		return nil
 	}
 
 	app.Commands = []gcli.Command{{
+		Name: "apply", Usage: "converge environment to manifest",
+		Flags: []gcli.Flag{
+			gcli.StringFlag{Name: "file, f", Usage: "environment manifest in YAML format"},
+			gcli.BoolFlag{Name: "recreate", Usage: "destroy and clone again containers which differ from manifest in template or network"}},
+		Action: func(c *gcli.Context) error {
+			if c.String("f") != "" {
+				defer audit.Command("apply", "", os.Args[1:])()
+				cli.ManifestApply(c.String("f"), c.Bool("recreate"))
+			} else {
+				usage(c)
+			}
+			return nil
+		}}, {
+
 		Name: "attach", Usage: "attach to Subutai container",
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
 				cli.LxcAttach(c.Args().Get(0), c.Args().Tail())
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
 
+		Name: "audit", Usage: "show audit log of executed commands",
+		Flags: []gcli.Flag{
+			gcli.StringFlag{Name: "container, c", Usage: "records of the container only"},
+			gcli.StringFlag{Name: "id, i", Usage: "records of the command id only"},
+			gcli.StringFlag{Name: "start, s", Usage: "start time"},
+			gcli.StringFlag{Name: "end, e", Usage: "end time"},
+			gcli.BoolFlag{Name: "verify, v", Usage: "verify integrity of audit log"}},
+		Action: func(c *gcli.Context) error {
+			cli.LxcAudit(c.String("c"), c.String("i"), c.String("s"), c.String("e"), c.Bool("v"))
+			return nil
+		}}, {
+
 		Name: "batch", Usage: "batch commands execution",
 		Flags: []gcli.Flag{
-			gcli.StringFlag{Name: "json, j", Usage: "JSON string with commands"}},
+			gcli.StringFlag{Name: "json, j", Usage: "JSON string with commands"},
+			gcli.BoolFlag{Name: "dry-run", Usage: "validate commands without executing them"},
+			gcli.BoolFlag{Name: "continue-on-error", Usage: "execute remaining commands after failure without rollback"}},
 		Action: func(c *gcli.Context) error {
 			if c.String("j") != "" {
-				cli.Batch(c.String("j"))
+				cli.Batch(c.String("j"), c.Bool("dry-run"), c.Bool("continue-on-error"))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -125,9 +211,13 @@ func main() {
 			gcli.StringFlag{Name: "secret, s", Usage: "Console secret"}},
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" && c.Args().Get(1) != "" {
-				cli.LxcClone(c.Args().Get(0), c.Args().Get(1), c.String("e"), c.String("i"), c.String("s"), c.String("t"))
+				if remote(c, "template", "name") {
+					return nil
+				}
+				defer audit.Command("clone", c.Args().Get(1), os.Args[1:])()
+				check(cli.LxcClone(c.Args().Get(0), c.Args().Get(1), c.String("e"), c.String("i"), c.String("s"), c.String("t")))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -135,9 +225,10 @@ func main() {
 		Name: "cleanup", Usage: "clean Subutai environment",
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
-				cli.LxcDestroy(c.Args().Get(0), true)
+				defer audit.Command("cleanup", "", os.Args[1:])()
+				check(cli.LxcDestroy(c.Args().Get(0), true))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -147,7 +238,7 @@ func main() {
 			if c.Args().Get(0) != "" {
 				cli.Prune(c.Args().Get(0))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -160,9 +251,12 @@ func main() {
 		},
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
+				if c.String("o") != "" {
+					defer audit.Command("config", c.Args().Get(0), os.Args[1:])()
+				}
 				cli.LxcConfig(c.Args().Get(0), c.String("o"), c.String("k"), c.String("v"))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -177,16 +271,81 @@ func main() {
 		Name: "destroy", Usage: "destroy Subutai container/template",
 		Flags: []gcli.Flag{
 			gcli.BoolFlag{Name: "template, t", Usage: "destroy template"},
+			gcli.StringFlag{Name: "file, f", Usage: "destroy environment described by manifest"},
 		},
 		Action: func(c *gcli.Context) error {
-			if c.Args().Get(0) != "" {
+			if c.String("f") != "" {
+				defer audit.Command("destroy", "", os.Args[1:])()
+				cli.ManifestDestroy(c.String("f"))
+			} else if c.Args().Get(0) != "" {
+				if remote(c, "name") {
+					return nil
+				}
+				defer audit.Command("destroy", c.Args().Get(0), os.Args[1:])()
 				if c.Bool("t") {
-					cli.LxcDestroyTemplate(c.Args().Get(0))
+					check(cli.LxcDestroyTemplate(c.Args().Get(0)))
 				} else {
-					cli.LxcDestroy(c.Args().Get(0), false)
+					check(cli.LxcDestroy(c.Args().Get(0), false))
 				}
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
+			}
+			return nil
+		}}, {
+
+		Name: "diff", Usage: "show changes required to converge environment to manifest",
+		Flags: []gcli.Flag{
+			gcli.StringFlag{Name: "file, f", Usage: "environment manifest in YAML format"},
+			gcli.BoolFlag{Name: "recreate", Usage: "show containers which differ from manifest as replaced"}},
+		Action: func(c *gcli.Context) error {
+			if c.String("f") != "" {
+				cli.ManifestDiff(c.String("f"), c.Bool("recreate"))
+			} else {
+				usage(c)
+			}
+			return nil
+		}}, {
+
+		Name: "backup", Usage: "backup Subutai container to archive",
+		Flags: []gcli.Flag{
+			gcli.BoolFlag{Name: "full, f", Usage: "make full backup instead of incremental"},
+			gcli.StringFlag{Name: "dir, d", Usage: "directory to save backup archive"}},
+		Action: func(c *gcli.Context) error {
+			if c.Args().Get(0) != "" {
+				cli.LxcBackup(c.Args().Get(0), c.Bool("f"), c.String("d"))
+			} else {
+				usage(c)
+			}
+			return nil
+		}}, {
+
+		Name: "outbox", Usage: "show messages pending delivery to Management server",
+		Flags: []gcli.Flag{
+			gcli.BoolFlag{Name: "flush, f", Usage: "retry delivery of pending messages and dead letters immediately"},
+			gcli.BoolFlag{Name: "purge, p", Usage: "discard all messages"}},
+		Action: func(c *gcli.Context) error {
+			cli.LxcOutbox(c.Bool("f"), c.Bool("p"))
+			return nil
+		}}, {
+
+		Name: "migrate", Usage: "migrate Subutai container to another Resource Host",
+		Action: func(c *gcli.Context) error {
+			if c.Args().Get(0) != "" && c.Args().Get(1) != "" {
+				cli.LxcMigrate(c.Args().Get(0), c.Args().Get(1))
+			} else {
+				usage(c)
+			}
+			return nil
+		}}, {
+
+		Name: "restore", Usage: "restore Subutai container from backup archive",
+		Flags: []gcli.Flag{
+			gcli.BoolFlag{Name: "stopped, s", Usage: "do not start restored container"}},
+		Action: func(c *gcli.Context) error {
+			if c.Args().Get(0) != "" {
+				cli.LxcRestore(c.Args().Get(0), c.Args().Get(1), c.Bool("s"))
+			} else {
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -205,7 +364,7 @@ func main() {
 				cli.LxcExport(c.Args().Get(0), c.String("n"), c.String("v"), c.String("s"),
 					c.String("t"), c.String("d"), c.Bool("p"), c.Bool("l"))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -216,9 +375,9 @@ func main() {
 			gcli.BoolFlag{Name: "local, l", Usage: "prefer to use local template archive"}},
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
-				cli.LxcImport(c.Args().Get(0), c.String("t"), c.Bool("l"))
+				check(cli.LxcImport(c.Args().Get(0), c.String("t"), c.Bool("l")))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -232,7 +391,7 @@ func main() {
 		Name: "hostname", Usage: "Set hostname of container or host",
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) == "" {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			} else if len(c.Args().Get(1)) != 0 {
 				cli.LxcHostname(c.Args().Get(0), c.Args().Get(1))
 			} else {
@@ -248,7 +407,10 @@ func main() {
 			gcli.BoolFlag{Name: "info, i", Usage: "detailed container info"},
 			gcli.BoolFlag{Name: "parent, p", Usage: "with parent"}},
 		Action: func(c *gcli.Context) error {
-			cli.LxcList(c.Args().Get(0), c.Bool("c"), c.Bool("t"), c.Bool("i"), c.Bool("p"))
+			if remote(c, "name") {
+				return nil
+			}
+			show(cli.LxcList(c.Args().Get(0), c.Bool("c"), c.Bool("t"), c.Bool("i"), c.Bool("p")))
 			return nil
 		}}, {
 
@@ -265,9 +427,15 @@ func main() {
 		},
 		Action: func(c *gcli.Context) error {
 			if len(c.Args()) > 0 || c.NumFlags() > 0 {
-				cli.MapPort(c.Args().Get(0), c.String("i"), c.String("e"), c.String("p"), c.String("d"), c.String("c"), c.Bool("l"), c.Bool("r"), c.Bool("sslbackend"))
+				if remote(c, "protocol") {
+					return nil
+				}
+				if !c.Bool("l") {
+					defer audit.Command("map", "", os.Args[1:])()
+				}
+				show(cli.MapPort(c.Args().Get(0), c.String("i"), c.String("e"), c.String("p"), c.String("d"), c.String("c"), c.Bool("l"), c.Bool("r"), c.Bool("sslbackend")))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -280,7 +448,7 @@ func main() {
 			if c.Args().Get(0) != "" {
 				cli.HostMetrics(c.Args().Get(0), c.String("s"), c.String("e"))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -297,7 +465,11 @@ func main() {
 					gcli.StringFlag{Name: "policy, p", Usage: "set load balance policy (rr|lb|hash)"},
 					gcli.StringFlag{Name: "file, f", Usage: "specify pem certificate file"}},
 				Action: func(c *gcli.Context) error {
-					cli.ProxyAdd(c.Args().Get(0), c.String("d"), c.String("h"), c.String("p"), c.String("f"))
+					if remote(c, "vlan") {
+						return nil
+					}
+					defer audit.Command("proxy", "", os.Args[1:])()
+					check(cli.ProxyAdd(c.Args().Get(0), c.String("d"), c.String("h"), c.String("p"), c.String("f")))
 					return nil
 				},
 			},
@@ -309,7 +481,11 @@ func main() {
 					gcli.BoolFlag{Name: "domain, d", Usage: "delete domain from vlan"},
 					gcli.StringFlag{Name: "host, h", Usage: "delete host from domain on vlan"}},
 				Action: func(c *gcli.Context) error {
-					cli.ProxyDel(c.Args().Get(0), c.String("h"), c.Bool("d"))
+					if remote(c, "vlan") {
+						return nil
+					}
+					defer audit.Command("proxy", "", os.Args[1:])()
+					check(cli.ProxyDel(c.Args().Get(0), c.String("h"), c.Bool("d")))
 					return nil
 				},
 			},
@@ -321,7 +497,7 @@ func main() {
 					gcli.BoolFlag{Name: "domain, d", Usage: "check domains on vlan"},
 					gcli.StringFlag{Name: "host, h", Usage: "check hosts on vlan"}},
 				Action: func(c *gcli.Context) error {
-					cli.ProxyCheck(c.Args().Get(0), c.String("h"), c.Bool("d"))
+					show(cli.ProxyCheck(c.Args().Get(0), c.String("h"), c.Bool("d")))
 					return nil
 				},
 			},
@@ -329,10 +505,39 @@ func main() {
 
 		Name: "quota", Usage: "set quotas for Subutai container",
 		Flags: []gcli.Flag{
-			gcli.StringFlag{Name: "set, s", Usage: "set quota for the specified resource type (cpu, cpuset, ram, disk, network)"},
-			gcli.StringFlag{Name: "threshold, t", Usage: "set alert threshold"}},
+			gcli.StringFlag{Name: "set, s", Usage: "set quota for the specified resource type (cpu, cpuset, ram, disk, rootfs, home, var, opt, network, io.rbps, io.wbps, io.riops, io.wiops)"},
+			gcli.StringFlag{Name: "threshold, t", Usage: "set alert threshold, optionally with critical level, e.g. 80:95"},
+			gcli.StringFlag{Name: "reserve, r", Usage: "guarantee disk space in GB to container (disk) or its partition"}},
 		Action: func(c *gcli.Context) error {
-			cli.LxcQuota(c.Args().Get(0), c.Args().Get(1), c.String("s"), c.String("t"))
+			if remote(c, "name", "resource") {
+				return nil
+			}
+			if c.String("s") != "" || c.String("t") != "" || c.String("r") != "" {
+				defer audit.Command("quota", c.Args().Get(0), os.Args[1:])()
+			}
+			show(cli.LxcQuota(c.Args().Get(0), c.Args().Get(1), c.String("s"), c.String("t"), c.String("r")))
+			return nil
+		}}, {
+
+		Name: "schedule", Usage: "show or set automatic snapshot schedule of Subutai container",
+		Flags: []gcli.Flag{
+			gcli.StringFlag{Name: "set, s", Usage: "snapshot schedule and retention, e.g. \"hourly:24,daily:7\" or \"none\""}},
+		Action: func(c *gcli.Context) error {
+			if c.Args().Get(0) != "" {
+				cli.LxcSchedule(c.Args().Get(0), c.String("s"))
+			} else {
+				usage(c)
+			}
+			return nil
+		}}, {
+
+		Name: "snapshot", Usage: "create, list, rollback or delete snapshots of Subutai container",
+		Action: func(c *gcli.Context) error {
+			if c.Args().Get(0) != "" {
+				cli.LxcSnapshot(c.Args().Get(0), c.Args().Get(1), c.Args().Get(2))
+			} else {
+				usage(c)
+			}
 			return nil
 		}}, {
 
@@ -345,9 +550,12 @@ func main() {
 		Name: "start", Usage: "start Subutai container",
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
-				cli.LxcStart(c.Args().Get(0))
+				if remote(c, "name") {
+					return nil
+				}
+				check(cli.LxcStart(c.Args().Get(0)))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -355,9 +563,12 @@ func main() {
 		Name: "stop", Usage: "stop Subutai container",
 		Action: func(c *gcli.Context) error {
 			if c.Args().Get(0) != "" {
-				cli.LxcStop(c.Args().Get(0))
+				if remote(c, "name") {
+					return nil
+				}
+				check(cli.LxcStop(c.Args().Get(0)))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
@@ -367,11 +578,21 @@ func main() {
 			if c.Args().Get(0) != "" {
 				cli.LxcRestart(c.Args().Get(0))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {
 
+		Name: "top", Usage: "show resource usage of running containers or processes of container",
+		Flags: []gcli.Flag{
+			gcli.BoolFlag{Name: "processes, p", Usage: "show processes of the container"},
+			gcli.IntFlag{Name: "delay, d", Value: 2, Usage: "sampling interval in seconds"},
+			gcli.IntFlag{Name: "iterations, n", Usage: "number of refreshes, unlimited by default"}},
+		Action: func(c *gcli.Context) error {
+			cli.Top(c.Args().Get(0), c.Bool("p"), c.Int("d"), c.Int("n"))
+			return nil
+		}}, {
+
 		Name: "tunnel", Usage: "SSH tunnel management",
 		Subcommands: []gcli.Command{
 			{
@@ -380,25 +601,34 @@ func main() {
 				Flags: []gcli.Flag{
 					gcli.BoolFlag{Name: "global, g", Usage: "create tunnel to global proxy"}},
 				Action: func(c *gcli.Context) error {
-					cli.TunAdd(c.Args().Get(0), c.Args().Get(1))
+					if remote(c, "socket", "timeout") {
+						return nil
+					}
+					show(cli.TunAdd(c.Args().Get(0), c.Args().Get(1)))
 					return nil
 				}}, {
 				Name:  "del",
 				Usage: "delete tunnel",
 				Action: func(c *gcli.Context) error {
-					cli.TunDel(c.Args().Get(0))
+					if remote(c, "socket") {
+						return nil
+					}
+					check(cli.TunDel(c.Args().Get(0)))
 					return nil
 				}}, {
 				Name:  "list",
 				Usage: "list active ssh tunnels",
 				Action: func(c *gcli.Context) error {
-					cli.TunList()
+					if remote(c) {
+						return nil
+					}
+					show(cli.TunList())
 					return nil
 				}}, {
 				Name:  "check",
 				Usage: "check active ssh tunnels",
 				Action: func(c *gcli.Context) error {
-					cli.TunCheck()
+					check(cli.TunCheck())
 					return nil
 				}},
 		}}, {
@@ -410,7 +640,7 @@ func main() {
 			if c.Args().Get(0) != "" {
 				cli.Update(c.Args().Get(0), c.Bool("c"))
 			} else {
-				gcli.ShowSubcommandHelp(c)
+				usage(c)
 			}
 			return nil
 		}}, {